@@ -251,8 +251,8 @@ func TestErrorCases(t *testing.T) {
 		require.NoError(t, err)
 		defer resp.Body.Close()
 
-		// Should return an error because the repo already exists
-		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		// Should be a conflict because the repo already exists
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
 	})
 
 	// Test 3: Access a repository that doesn't exist
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/internal/api/middleware"
+	"github.com/labbs/git-server-s3/pkg/common"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/rs/zerolog"
+)
+
+// authorize reports whether the authenticated user of the request has at
+// least role on repoPath, answering 403 otherwise. Everything is allowed when
+// access control is disabled (access is nil).
+func authorize(ctx *fiber.Ctx, logger zerolog.Logger, access *rbac.Store, repoPath string, role rbac.Role) bool {
+	if access == nil {
+		return true
+	}
+
+	identity := middleware.Identity(ctx)
+	if access.Can(identity, repoPath, role) {
+		return true
+	}
+
+	event := logger.Warn().Str("repo", repoPath).Stringer("role", role)
+	if identity != nil {
+		event = event.Str("user", identity.Username)
+	}
+	event.Msg("Access denied")
+
+	_ = ctx.Status(fiber.StatusForbidden).SendString("access denied")
+	return false
+}
+
+// authorizeRepository returns the repository of a request on an
+// /api/repos/{repo}/... route, after checking that the user has at least role
+// on it and that it exists, answering 403 or 404 otherwise
+func authorizeRepository(ctx *fiber.Ctx, logger zerolog.Logger, str storage.GitRepositoryStorage, access *rbac.Store, role rbac.Role) (string, bool) {
+	repoPath := common.NormalizeRepoPath(ctx.Params("+"))
+	if !common.ValidRepoPath(repoPath) {
+		_ = ctx.Status(fiber.StatusNotFound).SendString("repository not found")
+		return "", false
+	}
+	if !authorize(ctx, logger, access, repoPath, role) {
+		return "", false
+	}
+	if !str.RepositoryExists(repoPath) {
+		_ = ctx.Status(fiber.StatusNotFound).SendString("repository not found")
+		return "", false
+	}
+	return repoPath, true
+}
@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/internal/api/middleware"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/rs/zerolog"
+)
+
+// AccessController handles HTTP requests managing the access control: the
+// grants of roles to users and teams, and the members of the teams.
+//
+// Users manage the grants of the resources they administer, site admins
+// manage the teams.
+type AccessController struct {
+	Logger zerolog.Logger // Logger for request logging and error reporting
+	Access *rbac.Store    // Grants and teams
+}
+
+// ListGrants handles GET requests listing the grants on the resources the
+// user administers.
+//
+// Response: 200 OK with JSON array of grants
+func (c *AccessController) ListGrants(ctx *fiber.Ctx) error {
+	identity := middleware.Identity(ctx)
+
+	grants := []rbac.Grant{}
+	for _, grant := range c.Access.Grants() {
+		if c.Access.Role(identity, grant.Resource) >= rbac.Admin {
+			grants = append(grants, grant)
+		}
+	}
+	return ctx.JSON(grants)
+}
+
+// SetGrant handles POST requests granting a role to a user or a team on a
+// repository ("team/project"), a namespace ("team/*") or every repository ("*").
+// An existing grant of the subject on the resource is replaced.
+//
+// Request body: {"user": "alice", "resource": "team/*", "role": "write"} or {"team": "devs", ...}
+// Response: 201 Created with the grant
+func (c *AccessController) SetGrant(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "SetGrant").Logger()
+
+	var grant rbac.Grant
+	if err := ctx.BodyParser(&grant); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	resource, err := rbac.NormalizeResource(grant.Resource)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if !c.authorizeResource(ctx, logger, resource) {
+		return nil
+	}
+
+	grant, err = c.Access.SetGrant(grant)
+	if errors.Is(err, rbac.ErrInvalidGrant) {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to save grant")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to save grant")
+	}
+
+	logger.Info().
+		Str("user", grant.User).
+		Str("team", grant.Team).
+		Str("resource", grant.Resource).
+		Stringer("role", grant.Role).
+		Msg("Grant saved")
+	return ctx.Status(fiber.StatusCreated).JSON(grant)
+}
+
+// RemoveGrant handles DELETE requests removing the grant of a user or team
+// on a resource.
+//
+// Query parameters: user or team, resource
+// Response: 204 No Content, 404 Not Found if there is no such grant
+func (c *AccessController) RemoveGrant(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "RemoveGrant").Logger()
+
+	grant := rbac.Grant{
+		User:     ctx.Query("user"),
+		Team:     ctx.Query("team"),
+		Resource: ctx.Query("resource"),
+	}
+	resource, err := rbac.NormalizeResource(grant.Resource)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if !c.authorizeResource(ctx, logger, resource) {
+		return nil
+	}
+
+	err = c.Access.RemoveGrant(grant)
+	if errors.Is(err, rbac.ErrNotFound) {
+		return ctx.Status(fiber.StatusNotFound).SendString("grant not found")
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to remove grant")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to remove grant")
+	}
+
+	logger.Info().
+		Str("user", grant.User).
+		Str("team", grant.Team).
+		Str("resource", resource).
+		Msg("Grant removed")
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// ListTeams handles GET requests listing the teams and their members.
+//
+// Response: 200 OK with JSON object of the members by team name
+func (c *AccessController) ListTeams(ctx *fiber.Ctx) error {
+	if !c.authorizeSiteAdmin(ctx) {
+		return nil
+	}
+	return ctx.JSON(c.Access.Teams())
+}
+
+// SetTeam handles PUT requests creating or replacing a team.
+//
+// Request body: {"members": ["alice", "bob"]}
+// Response: 204 No Content
+func (c *AccessController) SetTeam(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "SetTeam").Logger()
+
+	if !c.authorizeSiteAdmin(ctx) {
+		return nil
+	}
+
+	var req struct {
+		Members []string `json:"members"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	if err := c.Access.SetTeam(ctx.Params("name"), req.Members); err != nil {
+		logger.Error().Err(err).Msg("Failed to save team")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to save team")
+	}
+
+	logger.Info().Str("team", ctx.Params("name")).Int("members", len(req.Members)).Msg("Team saved")
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// DeleteTeam handles DELETE requests removing a team and its grants.
+//
+// Response: 204 No Content, 404 Not Found if there is no such team
+func (c *AccessController) DeleteTeam(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "DeleteTeam").Logger()
+
+	if !c.authorizeSiteAdmin(ctx) {
+		return nil
+	}
+
+	err := c.Access.DeleteTeam(ctx.Params("name"))
+	if errors.Is(err, rbac.ErrNotFound) {
+		return ctx.Status(fiber.StatusNotFound).SendString("team not found")
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to delete team")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to delete team")
+	}
+
+	logger.Info().Str("team", ctx.Params("name")).Msg("Team deleted")
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// authorizeResource checks that the user administers resource, answering 403 otherwise
+func (c *AccessController) authorizeResource(ctx *fiber.Ctx, logger zerolog.Logger, resource string) bool {
+	if c.Access.Role(middleware.Identity(ctx), resource) >= rbac.Admin {
+		return true
+	}
+	logger.Warn().Str("resource", resource).Msg("Access denied")
+	_ = ctx.Status(fiber.StatusForbidden).SendString("access denied")
+	return false
+}
+
+// authorizeSiteAdmin checks that the user is a site admin, answering 403 otherwise
+func (c *AccessController) authorizeSiteAdmin(ctx *fiber.Ctx) bool {
+	if c.Access.IsSiteAdmin(middleware.Identity(ctx)) {
+		return true
+	}
+	_ = ctx.Status(fiber.StatusForbidden).SendString("access denied")
+	return false
+}
@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/internal/api/middleware"
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupAccessTestApp returns an app with access control, where root is a site
+// admin and alice and bob have the token "<username>-token"
+func setupAccessTestApp(t *testing.T) (*fiber.App, *rbac.Store) {
+	t.Helper()
+
+	dir := t.TempDir()
+	var tokensFile strings.Builder
+	for _, user := range []string{"root", "alice", "bob"} {
+		tokensFile.WriteString(user + " " + auth.HashToken(user+"-token") + "\n")
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tokens"), []byte(tokensFile.String()), 0600))
+	tokens, err := auth.LoadTokenStore(filepath.Join(dir, "tokens"))
+	require.NoError(t, err)
+	access, err := rbac.Load(filepath.Join(dir, "grants.json"), []string{"root"})
+	require.NoError(t, err)
+
+	config.Storage.Local.Path = t.TempDir()
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	require.NoError(t, str.CreateRepository("team/project.git"))
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use(middleware.Auth(middleware.AuthConfig{Logger: zerolog.Nop(), Tokens: tokens}))
+
+	ac := &AccessController{Logger: zerolog.Nop(), Access: access}
+	app.Get("/api/grants", ac.ListGrants)
+	app.Post("/api/grants", ac.SetGrant)
+	app.Delete("/api/grants", ac.RemoveGrant)
+	app.Get("/api/teams", ac.ListTeams)
+	app.Put("/api/teams/:name", ac.SetTeam)
+	app.Delete("/api/teams/:name", ac.DeleteTeam)
+
+	rc := &RepoController{Logger: zerolog.Nop(), Storage: str, Access: access}
+	app.Post("/api/repo", rc.CreateRepo)
+	app.Get("/api/repos", rc.ListRepos)
+
+	gc := &GitController{Logger: zerolog.Nop(), Storage: str, Access: access}
+	app.Get("/+/info/refs", gc.InfoRefs)
+
+	return app, access
+}
+
+// accessRequest sends a request authenticated as user
+func accessRequest(t *testing.T, app *fiber.App, user, method, target, body string) (int, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	req.SetBasicAuth(user, user+"-token")
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(data)
+}
+
+func TestAccessController_Grants(t *testing.T) {
+	app, access := setupAccessTestApp(t)
+
+	// Only admins of the resource can grant roles on it
+	status, _ := accessRequest(t, app, "alice", fiber.MethodPost, "/api/grants", `{"user":"alice","resource":"team/*","role":"admin"}`)
+	assert.Equal(t, fiber.StatusForbidden, status)
+
+	status, body := accessRequest(t, app, "root", fiber.MethodPost, "/api/grants", `{"user":"alice","resource":"team/*","role":"admin"}`)
+	require.Equal(t, fiber.StatusCreated, status, body)
+
+	// alice administers team/*, she can grant bob a role on a repository below it
+	status, body = accessRequest(t, app, "alice", fiber.MethodPost, "/api/grants", `{"user":"bob","resource":"team/project","role":"read"}`)
+	require.Equal(t, fiber.StatusCreated, status, body)
+	var grant rbac.Grant
+	require.NoError(t, json.Unmarshal([]byte(body), &grant))
+	assert.Equal(t, rbac.Grant{User: "bob", Resource: "team/project.git", Role: rbac.Read}, grant)
+
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPost, "/api/grants", `{"user":"bob","resource":"*","role":"read"}`)
+	assert.Equal(t, fiber.StatusForbidden, status)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPost, "/api/grants", `{"user":"bob","resource":"team/project","role":"owner"}`)
+	assert.Equal(t, fiber.StatusBadRequest, status)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPost, "/api/grants", `{"resource":"team/project","role":"read"}`)
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	// bob only sees the grants on the resources he administers
+	status, body = accessRequest(t, app, "bob", fiber.MethodGet, "/api/grants", "")
+	require.Equal(t, fiber.StatusOK, status)
+	assert.JSONEq(t, `[]`, body)
+	status, body = accessRequest(t, app, "alice", fiber.MethodGet, "/api/grants", "")
+	require.Equal(t, fiber.StatusOK, status)
+	var grants []rbac.Grant
+	require.NoError(t, json.Unmarshal([]byte(body), &grants))
+	assert.Len(t, grants, 2)
+
+	status, _ = accessRequest(t, app, "bob", fiber.MethodDelete, "/api/grants?user=bob&resource=team/project", "")
+	assert.Equal(t, fiber.StatusForbidden, status)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodDelete, "/api/grants?user=bob&resource=team/project", "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodDelete, "/api/grants?user=bob&resource=team/project", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+	assert.Len(t, access.Grants(), 1)
+}
+
+func TestAccessController_Teams(t *testing.T) {
+	app, access := setupAccessTestApp(t)
+
+	status, _ := accessRequest(t, app, "alice", fiber.MethodPut, "/api/teams/devs", `{"members":["alice"]}`)
+	assert.Equal(t, fiber.StatusForbidden, status)
+
+	status, _ = accessRequest(t, app, "root", fiber.MethodPut, "/api/teams/devs", `{"members":["alice","bob"]}`)
+	require.Equal(t, fiber.StatusNoContent, status)
+	status, body := accessRequest(t, app, "root", fiber.MethodGet, "/api/teams", "")
+	require.Equal(t, fiber.StatusOK, status)
+	assert.JSONEq(t, `{"devs":["alice","bob"]}`, body)
+
+	_, err := access.SetGrant(rbac.Grant{Team: "devs", Resource: "team/*", Role: rbac.Write})
+	require.NoError(t, err)
+	assert.True(t, access.Can(&auth.Identity{Username: "bob"}, "team/project", rbac.Write))
+
+	status, _ = accessRequest(t, app, "root", fiber.MethodDelete, "/api/teams/devs", "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+	status, _ = accessRequest(t, app, "root", fiber.MethodDelete, "/api/teams/devs", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+	assert.False(t, access.Can(&auth.Identity{Username: "bob"}, "team/project", rbac.Write))
+}
+
+func TestAccessControl_Enforcement(t *testing.T) {
+	app, access := setupAccessTestApp(t)
+	_, err := access.SetGrant(rbac.Grant{User: "alice", Resource: "team/project", Role: rbac.Read})
+	require.NoError(t, err)
+
+	// Reading requires the read role, pushing the write role
+	status, _ := accessRequest(t, app, "alice", fiber.MethodGet, "/team/project.git/info/refs?service=git-upload-pack", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodGet, "/team/project.git/info/refs?service=git-receive-pack", "")
+	assert.Equal(t, fiber.StatusForbidden, status)
+	status, _ = accessRequest(t, app, "bob", fiber.MethodGet, "/team/project.git/info/refs?service=git-upload-pack", "")
+	assert.Equal(t, fiber.StatusForbidden, status)
+
+	// Repositories are listed only to the users who can read them
+	status, body := accessRequest(t, app, "alice", fiber.MethodGet, "/api/repos", "")
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Contains(t, body, "team/project.git")
+	status, body = accessRequest(t, app, "bob", fiber.MethodGet, "/api/repos", "")
+	require.Equal(t, fiber.StatusOK, status)
+	assert.NotContains(t, body, "team/project.git")
+
+	// Creating a repository requires the admin role
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPost, "/api/repo", `{"name":"team/new"}`)
+	assert.Equal(t, fiber.StatusForbidden, status)
+	_, err = access.SetGrant(rbac.Grant{User: "alice", Resource: "team/*", Role: rbac.Admin})
+	require.NoError(t, err)
+	status, body = accessRequest(t, app, "alice", fiber.MethodPost, "/api/repo", `{"name":"team/new"}`)
+	assert.Equal(t, fiber.StatusCreated, status, body)
+}
@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/pkg/browse"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultPerPage is the number of commits of a page of the log when not given
+	DefaultPerPage = 30
+	// MaxPerPage is the maximum number of commits of a page of the log
+	MaxPerPage = 100
+)
+
+// BrowseController handles the read-only HTTP requests browsing the
+// references, commits, trees and files of a repository, without cloning it.
+// It requires read access to the repository. Revisions are branches, tags,
+// full reference names or commit hashes, HEAD when omitted.
+type BrowseController struct {
+	Logger  zerolog.Logger               // Logger for request logging and error reporting
+	Storage storage.GitRepositoryStorage // Storage backend of the repositories
+	Access  *rbac.Store                  // Access control, nil when disabled
+}
+
+// ListBranches handles GET requests listing the branches of a repository.
+//
+// Response: 200 OK with JSON array of branches and the commits at their tips
+func (c *BrowseController) ListBranches(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "ListBranches").Logger()
+
+	st, ok := c.storer(ctx, logger)
+	if !ok {
+		return nil
+	}
+	branches, err := browse.Branches(st)
+	if err != nil {
+		return c.fail(ctx, logger, err, "failed to list branches")
+	}
+	return ctx.JSON(branches)
+}
+
+// ListTags handles GET requests listing the tags of a repository.
+//
+// Response: 200 OK with JSON array of tags and the objects they point to
+func (c *BrowseController) ListTags(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "ListTags").Logger()
+
+	st, ok := c.storer(ctx, logger)
+	if !ok {
+		return nil
+	}
+	tags, err := browse.Tags(st)
+	if err != nil {
+		return c.fail(ctx, logger, err, "failed to list tags")
+	}
+	return ctx.JSON(tags)
+}
+
+// GetCommit handles GET requests showing a commit with its parents.
+//
+// Response: 200 OK with the commit, 404 Not Found if there is no such revision
+func (c *BrowseController) GetCommit(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "GetCommit").Logger()
+
+	st, ok := c.storer(ctx, logger)
+	if !ok {
+		return nil
+	}
+	commit, err := browse.GetCommit(st, ctx.Params("sha"))
+	if err != nil {
+		return c.fail(ctx, logger, err, "failed to read commit")
+	}
+	return ctx.JSON(commit)
+}
+
+// ListCommits handles GET requests listing the commits reachable from a
+// revision, the most recent first, a page at a time. The Link header holds
+// the URL of the next page, if any.
+//
+// Query: ?ref=main&page=1&per_page=30
+// Response: 200 OK with JSON array of commits, 404 Not Found if there is no such revision
+func (c *BrowseController) ListCommits(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "ListCommits").Logger()
+
+	page, err := strconv.Atoi(ctx.Query("page", "1"))
+	if err != nil || page < 1 {
+		return ctx.Status(fiber.StatusBadRequest).SendString("invalid page")
+	}
+	perPage, err := strconv.Atoi(ctx.Query("per_page", strconv.Itoa(DefaultPerPage)))
+	if err != nil || perPage < 1 || perPage > MaxPerPage {
+		return ctx.Status(fiber.StatusBadRequest).SendString("invalid per_page, expected 1 to " + strconv.Itoa(MaxPerPage))
+	}
+
+	st, ok := c.storer(ctx, logger)
+	if !ok {
+		return nil
+	}
+	commits, more, err := browse.Log(st, ctx.Query("ref"), (page-1)*perPage, perPage)
+	if err != nil {
+		return c.fail(ctx, logger, err, "failed to list commits")
+	}
+
+	if more {
+		query := url.Values{}
+		for key, value := range ctx.Queries() {
+			query.Set(key, value)
+		}
+		query.Set("page", strconv.Itoa(page+1))
+		query.Set("per_page", strconv.Itoa(perPage))
+		ctx.Set(fiber.HeaderLink, `<`+ctx.Path()+"?"+query.Encode()+`>; rel="next"`)
+	}
+	return ctx.JSON(commits)
+}
+
+// GetTree handles GET requests listing a directory at a revision, the root
+// directory when the path is omitted.
+//
+// Query: ?ref=main&path=docs
+// Response: 200 OK with JSON array of entries, 404 Not Found if there is no such revision or directory
+func (c *BrowseController) GetTree(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "GetTree").Logger()
+
+	st, ok := c.storer(ctx, logger)
+	if !ok {
+		return nil
+	}
+	entries, err := browse.Tree(st, ctx.Query("ref"), ctx.Query("path"))
+	if err != nil {
+		return c.fail(ctx, logger, err, "failed to read tree")
+	}
+	return ctx.JSON(entries)
+}
+
+// GetRaw handles GET requests returning the content of a file at a revision.
+//
+// Query: ?ref=main&path=docs/README.md
+// Response: 200 OK with the raw content, 404 Not Found if there is no such revision or file
+func (c *BrowseController) GetRaw(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "GetRaw").Logger()
+
+	st, ok := c.storer(ctx, logger)
+	if !ok {
+		return nil
+	}
+	r, size, err := browse.Blob(st, ctx.Query("ref"), ctx.Query("path"))
+	if err != nil {
+		return c.fail(ctx, logger, err, "failed to read file")
+	}
+
+	ctx.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	ctx.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	// fasthttp closes the reader once sent
+	return ctx.SendStream(r, int(size))
+}
+
+// storer checks read access to the repository of the request and returns its
+// storer. It sends the error response and returns false otherwise.
+func (c *BrowseController) storer(ctx *fiber.Ctx, logger zerolog.Logger) (storer.Storer, bool) {
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Read)
+	if !ok {
+		return nil, false
+	}
+	st, err := c.Storage.GetStorer(repoPath)
+	if err != nil {
+		logger.Error().Err(err).Str("repo", repoPath).Msg("Failed to open repository")
+		_ = ctx.Status(fiber.StatusInternalServerError).SendString("failed to open repository")
+		return nil, false
+	}
+	return st, true
+}
+
+// fail sends the response of a browsing error: 404 for a missing revision,
+// path or object, 500 otherwise
+func (c *BrowseController) fail(ctx *fiber.Ctx, logger zerolog.Logger, err error, msg string) error {
+	if errors.Is(err, browse.ErrNotFound) {
+		return ctx.Status(fiber.StatusNotFound).SendString(err.Error())
+	}
+	logger.Error().Err(err).Str("repo", ctx.Params("+")).Msg(msg)
+	return ctx.Status(fiber.StatusInternalServerError).SendString(msg)
+}
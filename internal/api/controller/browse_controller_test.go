@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/pkg/browse"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrowseController(t *testing.T) {
+	app, access := setupAccessTestApp(t)
+
+	// setupAccessTestApp configured the storage path
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	require.NoError(t, storage.InitRepository(str, "team/docs.git", storage.InitOptions{Readme: true}))
+
+	bc := &BrowseController{Logger: zerolog.Nop(), Storage: str, Access: access}
+	app.Get("/api/repos/+/branches", bc.ListBranches)
+	app.Get("/api/repos/+/tags", bc.ListTags)
+	app.Get("/api/repos/+/commits", bc.ListCommits)
+	app.Get("/api/repos/+/commits/:sha", bc.GetCommit)
+	app.Get("/api/repos/+/tree", bc.GetTree)
+	app.Get("/api/repos/+/raw", bc.GetRaw)
+
+	_, err := access.SetGrant(rbac.Grant{User: "alice", Resource: "team/docs", Role: rbac.Read})
+	require.NoError(t, err)
+
+	// Browsing requires read access
+	status, _ := accessRequest(t, app, "bob", fiber.MethodGet, "/api/repos/team/docs/branches", "")
+	assert.Equal(t, fiber.StatusForbidden, status)
+
+	status, body := accessRequest(t, app, "alice", fiber.MethodGet, "/api/repos/team/docs/branches", "")
+	require.Equal(t, fiber.StatusOK, status)
+	var branches []browse.Branch
+	require.NoError(t, json.Unmarshal([]byte(body), &branches))
+	require.Len(t, branches, 1)
+	assert.Equal(t, "main", branches[0].Name)
+	assert.True(t, branches[0].Default)
+
+	status, body = accessRequest(t, app, "alice", fiber.MethodGet, "/api/repos/team/docs/tags", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.JSONEq(t, `[]`, body)
+
+	status, body = accessRequest(t, app, "alice", fiber.MethodGet, "/api/repos/team/docs/commits/"+branches[0].Commit, "")
+	require.Equal(t, fiber.StatusOK, status)
+	var commit browse.Commit
+	require.NoError(t, json.Unmarshal([]byte(body), &commit))
+	assert.Equal(t, "Initial commit\n", commit.Message)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodGet, "/api/repos/team/docs/commits/missing", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+
+	status, body = accessRequest(t, app, "alice", fiber.MethodGet, "/api/repos/team/docs/commits?ref=main&per_page=1", "")
+	require.Equal(t, fiber.StatusOK, status)
+	var commits []browse.Commit
+	require.NoError(t, json.Unmarshal([]byte(body), &commits))
+	assert.Equal(t, []browse.Commit{commit}, commits)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodGet, "/api/repos/team/docs/commits?per_page=1000", "")
+	assert.Equal(t, fiber.StatusBadRequest, status)
+	// The log of an empty repository has no revision
+	status, _ = accessRequest(t, app, "root", fiber.MethodGet, "/api/repos/team/project/commits", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+
+	status, body = accessRequest(t, app, "alice", fiber.MethodGet, "/api/repos/team/docs/tree", "")
+	require.Equal(t, fiber.StatusOK, status)
+	var entries []browse.TreeEntry
+	require.NoError(t, json.Unmarshal([]byte(body), &entries))
+	require.Len(t, entries, 1)
+	assert.Equal(t, "README.md", entries[0].Path)
+
+	status, body = accessRequest(t, app, "alice", fiber.MethodGet, "/api/repos/team/docs/raw?ref=main&path=README.md", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, "# docs\n", body)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodGet, "/api/repos/team/docs/raw?path=missing.md", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+}
+
+func TestBrowseController_Pagination(t *testing.T) {
+	app, _ := setupAccessTestApp(t)
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	require.NoError(t, storage.InitRepository(str, "docs.git", storage.InitOptions{Readme: true}))
+	st, err := str.GetStorer("docs.git")
+	require.NoError(t, err)
+	head, err := browse.Resolve(st, "main")
+	require.NoError(t, err)
+
+	// Two more commits on main
+	hashes := []plumbing.Hash{head.Hash}
+	for i := range 2 {
+		signature := object.Signature{Name: "Alice", Email: "alice@example.com", When: head.Committer.When.Add(time.Duration(i+1) * time.Minute)}
+		commit := &object.Commit{Author: signature, Committer: signature, Message: "Edit\n", TreeHash: head.TreeHash, ParentHashes: []plumbing.Hash{hashes[i]}}
+		obj := st.NewEncodedObject()
+		require.NoError(t, commit.Encode(obj))
+		hash, err := st.SetEncodedObject(obj)
+		require.NoError(t, err)
+		hashes = append(hashes, hash)
+	}
+	require.NoError(t, st.SetReference(plumbing.NewHashReference(plumbing.Main, hashes[2])))
+
+	bc := &BrowseController{Logger: zerolog.Nop(), Storage: str}
+	app.Get("/api/repos/+/commits", bc.ListCommits)
+
+	req := httptest.NewRequest(fiber.MethodGet, "/api/repos/docs/commits?ref=main&per_page=2", nil)
+	req.SetBasicAuth("root", "root-token")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, `</api/repos/docs/commits?page=2&per_page=2&ref=main>; rel="next"`, resp.Header.Get(fiber.HeaderLink))
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var commits []browse.Commit
+	require.NoError(t, json.Unmarshal(data, &commits))
+	require.Len(t, commits, 2)
+	assert.Equal(t, hashes[2].String(), commits[0].SHA)
+	assert.Equal(t, []string{hashes[1].String()}, commits[0].Parents)
+
+	status, body := accessRequest(t, app, "root", fiber.MethodGet, "/api/repos/docs/commits?ref=main&per_page=2&page=2", "")
+	require.Equal(t, fiber.StatusOK, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &commits))
+	require.Len(t, commits, 1)
+	assert.Equal(t, head.Hash.String(), commits[0].SHA)
+}
@@ -2,12 +2,19 @@
 package controller
 
 import (
-	"bytes"
-	"context"
+	"errors"
+	"io"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/internal/api/middleware"
+	"github.com/labbs/git-server-s3/pkg/autocreate"
 	"github.com/labbs/git-server-s3/pkg/common"
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/labbs/git-server-s3/pkg/metrics"
+	"github.com/labbs/git-server-s3/pkg/protocolv2"
+	"github.com/labbs/git-server-s3/pkg/rbac"
 	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/tracing"
 	"github.com/rs/zerolog"
 
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
@@ -17,8 +24,12 @@ import (
 // It implements the server-side of the Git Smart HTTP transport protocol,
 // supporting both upload-pack (clone/fetch) and receive-pack (push) operations.
 type GitController struct {
-	Logger  zerolog.Logger               // Logger for request logging and error reporting
-	Storage storage.GitRepositoryStorage // Storage backend for Git repository operations
+	Logger      zerolog.Logger               // Logger for request logging and error reporting
+	Storage     storage.GitRepositoryStorage // Storage backend for Git repository operations
+	MaxBodySize int64                        // Maximum size of a request body in bytes, 0 for no limit
+	Access      *rbac.Store                  // Access control, nil when disabled
+	AutoCreate  *autocreate.Creator          // Creation of missing repositories on push, nil to reject them
+	Hooks       *hooks.Runner                // Pre-receive and post-receive hooks, nil for none
 }
 
 // InfoRefs handles GET requests to /{repo}/info/refs endpoint.
@@ -32,7 +43,7 @@ type GitController struct {
 func (gc *GitController) InfoRefs(ctx *fiber.Ctx) error {
 	logger := gc.Logger.With().Str("event", "InfoRefs").Logger()
 
-	logger.Debug().Str("repo", ctx.Params("repo")).Send()
+	logger.Debug().Str("repo", ctx.Params("+")).Send()
 
 	service := ctx.Query("service")
 	if service != "git-upload-pack" && service != "git-receive-pack" {
@@ -46,14 +57,40 @@ func (gc *GitController) InfoRefs(ctx *fiber.Ctx) error {
 		return ctx.SendStatus(fiber.StatusNotFound)
 	}
 
+	// Pushing requires write access, the advertisement is the first step
+	role := rbac.Read
+	if service == "git-receive-pack" {
+		role = rbac.Write
+	}
+	if !authorize(ctx, logger, gc.Access, repoPath, role) {
+		return nil
+	}
+
+	if service == "git-receive-pack" && !gc.Storage.RepositoryExists(common.NormalizeRepoPath(repoPath)) {
+		if !gc.createOnPush(ctx, logger, common.NormalizeRepoPath(repoPath)) {
+			return nil
+		}
+	}
+
 	// Get the go-git transport server for this repository
-	srv, ep, err := common.GetTransportServer(repoPath, gc.Storage)
+	srv, ep, err := common.GetTransportServer(ctx.UserContext(), repoPath, gc.Storage)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to get transport server")
 		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to get transport server")
 	}
 
 	ctx.Set("Cache-Control", "no-cache")
+
+	// Protocol v2 clients list the references they need with ls-refs, the
+	// advertisement only carries the capabilities
+	if service == "git-upload-pack" && protocolv2.IsRequested(ctx.Get("Git-Protocol")) {
+		ctx.Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		if err := protocolv2.AdvertiseCapabilities(ctx.Response().BodyWriter()); err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		return nil
+	}
+
 	switch service {
 	case "git-upload-pack":
 		ctx.Set("Content-Type", "application/x-git-upload-pack-advertisement")
@@ -73,7 +110,7 @@ func (gc *GitController) InfoRefs(ctx *fiber.Ctx) error {
 		}
 	case "git-receive-pack":
 		ctx.Set("Content-Type", "application/x-git-receive-pack-advertisement")
-		sess, err := srv.NewReceivePackSession(ep, nil)
+		sess, err := common.NewReceivePackSession(ctx.UserContext(), repoPath, gc.Storage)
 		if err != nil {
 			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
@@ -91,11 +128,40 @@ func (gc *GitController) InfoRefs(ctx *fiber.Ctx) error {
 	return nil
 }
 
+// createOnPush creates the missing repository of a push following the
+// auto-create policy. A rejection is sent as a git error in place of the
+// reference advertisement, so that the client shows it.
+func (gc *GitController) createOnPush(ctx *fiber.Ctx, logger zerolog.Logger, repoPath string) bool {
+	err := gc.AutoCreate.Create(middleware.Identity(ctx), repoPath)
+	if err == nil {
+		logger.Info().Str("repo", repoPath).Msg("Repository created on push")
+		return true
+	}
+	if !errors.Is(err, autocreate.ErrRejected) {
+		logger.Error().Err(err).Str("repo", repoPath).Msg("Failed to create repository on push")
+		_ = ctx.Status(fiber.StatusInternalServerError).SendString("failed to create repository")
+		return false
+	}
+
+	logger.Warn().Err(err).Str("repo", repoPath).Msg("Repository creation on push rejected")
+	ctx.Set("Content-Type", "application/x-git-receive-pack-advertisement")
+	ctx.Set("Cache-Control", "no-cache")
+	w := ctx.Response().BodyWriter()
+	if err := common.WriteServiceAdvertisement(w, "git-receive-pack"); err != nil {
+		_ = ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		return false
+	}
+	if err := common.WriteError(w, err.Error()); err != nil {
+		_ = ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	return false
+}
+
 // HandleUploadPack handles POST requests to /{repo}/git-upload-pack endpoint.
 // This handles the actual data transfer for clone and fetch operations.
 // It processes the client's wants/haves and sends back the requested pack data.
 //
-// Request body: Git pack protocol request (binary)
+// Request body: Git pack protocol request (binary, optionally gzip encoded), streamed
 // Response: Git pack protocol response with requested objects
 func (gc *GitController) HandleUploadPack(c *fiber.Ctx) error {
 	logger := gc.Logger.With().Str("event", "HandleUploadPack").Logger()
@@ -109,13 +175,26 @@ func (gc *GitController) HandleUploadPack(c *fiber.Ctx) error {
 
 	logger.Debug().Str("repoPath", repoPath).Msg("Handling upload-pack request")
 
+	if !authorize(c, logger, gc.Access, repoPath, rbac.Read) {
+		return nil
+	}
+
+	if protocolv2.IsRequested(c.Get("Git-Protocol")) {
+		return gc.handleUploadPackV2(c, logger, repoPath)
+	}
+
 	// Get the go-git transport server for this repository
-	srv, ep, err := common.GetTransportServer(repoPath, gc.Storage)
+	srv, ep, err := common.GetTransportServer(c.UserContext(), repoPath, gc.Storage)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to get transport server")
 		return err
 	}
 
+	// Measured once the repository is known to exist, so that requests for
+	// arbitrary paths do not create metric series
+	op := metrics.StartOperation("git-upload-pack", common.NormalizeRepoPath(repoPath), metrics.ProtocolHTTP)
+	defer op.Done()
+
 	// Create an upload pack session
 	sess, err := srv.NewUploadPackSession(ep, nil)
 	if err != nil {
@@ -123,15 +202,26 @@ func (gc *GitController) HandleUploadPack(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 
+	body, err := requestBody(c, gc.MaxBodySize)
+	if err != nil {
+		return gc.bodyError(c, logger, err)
+	}
+	defer body.Close()
+
 	// Decode the upload pack request from the client
 	req := packp.NewUploadPackRequest()
-	if err := req.Decode(bytes.NewReader(c.Body())); err != nil {
+	if err := req.Decode(op.Reader(body)); err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			return gc.bodyError(c, logger, err)
+		}
 		logger.Error().Err(err).Msg("Failed to decode upload pack request")
 		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
 	}
 
 	logger.Debug().Msg("Calling UploadPack")
-	resp, err := sess.UploadPack(context.Background(), req)
+	spanCtx, span := tracing.Start(c.UserContext(), "UploadPack", tracing.Repository(common.NormalizeRepoPath(repoPath)))
+	resp, err := sess.UploadPack(spanCtx, req)
+	tracing.End(span, err)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to execute upload pack")
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
@@ -140,7 +230,10 @@ func (gc *GitController) HandleUploadPack(c *fiber.Ctx) error {
 
 	c.Set("Content-Type", "application/x-git-upload-pack-result")
 	logger.Debug().Msg("Encoding response")
-	if err := resp.Encode(c.Response().BodyWriter()); err != nil {
+	_, span = tracing.Start(c.UserContext(), "SendPackfile", tracing.Repository(common.NormalizeRepoPath(repoPath)))
+	err = resp.Encode(op.Writer(c.Response().BodyWriter()))
+	tracing.End(span, err)
+	if err != nil {
 		logger.Error().Err(err).Msg("Failed to encode upload pack response")
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
@@ -149,11 +242,54 @@ func (gc *GitController) HandleUploadPack(c *fiber.Ctx) error {
 	return nil
 }
 
+// handleUploadPackV2 serves the protocol v2 command (ls-refs or fetch) sent in
+// the request body.
+func (gc *GitController) handleUploadPackV2(c *fiber.Ctx, logger zerolog.Logger, repoPath string) error {
+	if !gc.Storage.RepositoryExists(common.NormalizeRepoPath(repoPath)) {
+		logger.Warn().Str("repoPath", repoPath).Msg("Repository not found")
+		return fiber.NewError(fiber.StatusNotFound, "repository not found")
+	}
+
+	op := metrics.StartOperation("git-upload-pack", common.NormalizeRepoPath(repoPath), metrics.ProtocolHTTP)
+	defer op.Done()
+
+	st, err := gc.Storage.GetStorer(repoPath)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get storer")
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	st = storage.WithContext(c.UserContext(), st)
+
+	body, err := requestBody(c, gc.MaxBodySize)
+	if err != nil {
+		return gc.bodyError(c, logger, err)
+	}
+	defer body.Close()
+
+	c.Set("Content-Type", "application/x-git-upload-pack-result")
+	err = protocolv2.ServeCommand(c.UserContext(), st, op.Reader(body), op.Writer(c.Response().BodyWriter()))
+	switch {
+	case err == nil || errors.Is(err, io.EOF):
+		logger.Debug().Msg("Upload pack completed successfully")
+		return nil
+	case errors.Is(err, errBodyTooLarge):
+		return gc.bodyError(c, logger, err)
+	case errors.Is(err, protocolv2.ErrInvalidRequest):
+		logger.Error().Err(err).Msg("Failed to decode upload pack request")
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	default:
+		// The error has been reported to the client in the response
+		logger.Error().Err(err).Msg("Failed to execute upload pack")
+		return nil
+	}
+}
+
 // HandleReceivePack handles POST requests to /{repo}/git-receive-pack endpoint.
 // This handles the actual data transfer for push operations.
 // It processes the client's reference updates and pack data, then sends back a status report.
 //
-// Request body: Git pack protocol request with reference updates and pack data (binary)
+// Request body: Git pack protocol request with reference updates and pack data (binary,
+// optionally gzip encoded). The body is streamed so that the pack data is never held in memory.
 // Response: Git pack protocol status report indicating success/failure of each reference update
 func (gc *GitController) HandleReceivePack(c *fiber.Ctx) error {
 	logger := gc.Logger.With().Str("event", "HandleReceivePack").Logger()
@@ -167,39 +303,56 @@ func (gc *GitController) HandleReceivePack(c *fiber.Ctx) error {
 
 	logger.Debug().Str("repoPath", repoPath).Msg("Handling receive-pack request")
 
-	// Get the go-git transport server for this repository
-	srv, ep, err := common.GetTransportServer(repoPath, gc.Storage)
+	if !authorize(c, logger, gc.Access, repoPath, rbac.Write) {
+		return nil
+	}
+
+	// Create a receive pack session, reference updates are checked against
+	// the old values sent by the client
+	sess, err := common.NewReceivePackSession(c.UserContext(), repoPath, gc.Storage)
 	if err != nil {
-		logger.Error().Err(err).Msg("Failed to get transport server")
+		logger.Error().Err(err).Msg("Failed to create receive pack session")
 		return err
 	}
 
-	// Create a receive pack session
-	sess, err := srv.NewReceivePackSession(ep, nil)
+	// Measured once the repository is known to exist
+	op := metrics.StartOperation("git-receive-pack", common.NormalizeRepoPath(repoPath), metrics.ProtocolHTTP)
+	defer op.Done()
+	sess.Hooks = gc.Hooks
+	sess.Pusher = middleware.Identity(c)
+
+	body, err := requestBody(c, gc.MaxBodySize)
 	if err != nil {
-		logger.Error().Err(err).Msg("Failed to create receive pack session")
-		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		return gc.bodyError(c, logger, err)
 	}
+	defer body.Close()
 
-	// Decode the reference update request from the client
+	// Decode the reference update request from the client, the pack data
+	// that follows the commands is read by the session as it is stored
 	req := packp.NewReferenceUpdateRequest()
-	if err := req.Decode(bytes.NewReader(c.Body())); err != nil {
+	if err := req.Decode(op.Reader(body)); err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			return gc.bodyError(c, logger, err)
+		}
 		logger.Error().Err(err).Msg("Failed to decode receive pack request")
 		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
 	}
 
 	// Process the receive pack request and generate a status report
-	report, err := sess.ReceivePack(context.Background(), req)
+	report, err := sess.ReceivePack(c.UserContext(), req)
+	if errors.Is(err, errBodyTooLarge) {
+		return gc.bodyError(c, logger, err)
+	}
 	c.Set("Content-Type", "application/x-git-receive-pack-result")
 	if err != nil {
 		logger.Error().Err(err).Msg("Receive pack failed")
 		// Even if there was an error, we still need to send the report
-		_ = report.Encode(c.Response().BodyWriter())
+		_ = sess.WriteResult(op.Writer(c.Response().BodyWriter()), report)
 		return nil
 	}
 
-	// Encode and send the status report back to the client
-	if err := report.Encode(c.Response().BodyWriter()); err != nil {
+	// Send the output of the hooks and the status report back to the client
+	if err := sess.WriteResult(op.Writer(c.Response().BodyWriter()), report); err != nil {
 		logger.Error().Err(err).Msg("Failed to encode receive pack report")
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
@@ -207,3 +360,17 @@ func (gc *GitController) HandleReceivePack(c *fiber.Ctx) error {
 	logger.Debug().Msg("Receive pack completed successfully")
 	return nil
 }
+
+// bodyError answers a request whose body could not be read: 413 when it
+// exceeds MaxBodySize, 400 otherwise (e.g. invalid gzip data).
+func (gc *GitController) bodyError(c *fiber.Ctx, logger zerolog.Logger, err error) error {
+	if errors.Is(err, errBodyTooLarge) {
+		logger.Warn().Int64("max_body_size", gc.MaxBodySize).Msg("Request body too large")
+		// The rest of the body is not read, the connection cannot be reused
+		c.Context().SetConnectionClose()
+		return c.Status(fiber.StatusRequestEntityTooLarge).SendString(err.Error())
+	}
+
+	logger.Error().Err(err).Msg("Failed to read request body")
+	return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+}
@@ -0,0 +1,308 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/autocreate"
+	"github.com/labbs/git-server-s3/pkg/metrics"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupGitTestApp(t *testing.T, maxBodySize int64) (*fiber.App, *local.LocalStorage) {
+	t.Helper()
+
+	config.Storage.Local.Path = t.TempDir()
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	require.NoError(t, storage.InitRepository(str, "repo.git", storage.InitOptions{Readme: true}))
+
+	// A small body limit makes fasthttp stream every request body
+	app := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+		StreamRequestBody:     true,
+		BodyLimit:             1024,
+	})
+
+	gc := &GitController{Logger: zerolog.Nop(), Storage: str, MaxBodySize: maxBodySize}
+	app.Get("/+/info/refs", gc.InfoRefs)
+	app.Post("/+/git-upload-pack", gc.HandleUploadPack)
+	app.Post("/+/git-receive-pack", gc.HandleReceivePack)
+
+	return app, str
+}
+
+// newPushRequest returns a receive-pack request creating refs/heads/feature on
+// a new root commit holding a random blob of the given size.
+func newPushRequest(t *testing.T, size int) ([]byte, plumbing.Hash) {
+	t.Helper()
+
+	st := memory.NewStorage()
+	repo, err := git.Init(st, memfs.New())
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	content := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(content)
+	f, err := wt.Filesystem.Create("data.bin")
+	require.NoError(t, err)
+	_, err = f.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = wt.Add("data.bin")
+	require.NoError(t, err)
+
+	commit, err := wt.Commit("add data", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0)},
+	})
+	require.NoError(t, err)
+
+	var hashes []plumbing.Hash
+	iter, err := st.IterEncodedObjects(plumbing.AnyObject)
+	require.NoError(t, err)
+	require.NoError(t, iter.ForEach(func(obj plumbing.EncodedObject) error {
+		hashes = append(hashes, obj.Hash())
+		return nil
+	}))
+
+	var pack bytes.Buffer
+	_, err = packfile.NewEncoder(&pack, st, false).Encode(hashes, 10)
+	require.NoError(t, err)
+
+	req := packp.NewReferenceUpdateRequest()
+	require.NoError(t, req.Capabilities.Set(capability.ReportStatus))
+	req.Commands = []*packp.Command{{Name: "refs/heads/feature", Old: plumbing.ZeroHash, New: commit}}
+	req.Packfile = io.NopCloser(&pack)
+
+	var body bytes.Buffer
+	require.NoError(t, req.Encode(&body))
+	return body.Bytes(), commit
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestHandleReceivePack_StreamedPush(t *testing.T) {
+	app, str := setupGitTestApp(t, 0)
+	body, commit := newPushRequest(t, 256<<10)
+
+	req := httptest.NewRequest(fiber.MethodPost, "/repo.git/git-receive-pack", bytes.NewReader(body))
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	st, err := str.GetStorer("repo.git")
+	require.NoError(t, err)
+	ref, err := st.Reference("refs/heads/feature")
+	require.NoError(t, err)
+	assert.Equal(t, commit, ref.Hash())
+}
+
+func TestHandleReceivePack_GzipBody(t *testing.T) {
+	app, str := setupGitTestApp(t, 0)
+	body, commit := newPushRequest(t, 4<<10)
+
+	req := httptest.NewRequest(fiber.MethodPost, "/repo.git/git-receive-pack", bytes.NewReader(gzipped(t, body)))
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	st, err := str.GetStorer("repo.git")
+	require.NoError(t, err)
+	ref, err := st.Reference("refs/heads/feature")
+	require.NoError(t, err)
+	assert.Equal(t, commit, ref.Hash())
+}
+
+func TestHandleReceivePack_BodyTooLarge(t *testing.T) {
+	app, str := setupGitTestApp(t, 64<<10)
+	body, _ := newPushRequest(t, 256<<10)
+
+	// Rejected from the Content-Length header
+	req := httptest.NewRequest(fiber.MethodPost, "/repo.git/git-receive-pack", bytes.NewReader(body))
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// Rejected while decompressing, random data does not compress
+	req = httptest.NewRequest(fiber.MethodPost, "/repo.git/git-receive-pack", bytes.NewReader(gzipped(t, body)))
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	st, err := str.GetStorer("repo.git")
+	require.NoError(t, err)
+	_, err = st.Reference("refs/heads/feature")
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+}
+
+func TestHandleUploadPack_GzipBody(t *testing.T) {
+	app, str := setupGitTestApp(t, 0)
+
+	st, err := str.GetStorer("repo.git")
+	require.NoError(t, err)
+	head, err := st.Reference(plumbing.NewBranchReferenceName("main"))
+	require.NoError(t, err)
+
+	upr := packp.NewUploadPackRequest()
+	upr.Wants = []plumbing.Hash{head.Hash()}
+	var body bytes.Buffer
+	require.NoError(t, upr.UploadRequest.Encode(&body))
+	require.NoError(t, pktline.NewEncoder(&body).EncodeString("done\n"))
+
+	req := httptest.NewRequest(fiber.MethodPost, "/repo.git/git-upload-pack", bytes.NewReader(gzipped(t, body.Bytes())))
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "PACK")
+}
+
+func TestProtocolV2(t *testing.T) {
+	app, str := setupGitTestApp(t, 0)
+
+	st, err := str.GetStorer("repo.git")
+	require.NoError(t, err)
+	head, err := st.Reference(plumbing.NewBranchReferenceName("main"))
+	require.NoError(t, err)
+
+	// The advertisement only carries the capabilities
+	req := httptest.NewRequest(fiber.MethodGet, "/repo.git/info/refs?service=git-upload-pack", nil)
+	req.Header.Set("Git-Protocol", "version=2")
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte("000eversion 2\n")), string(data))
+	assert.NotContains(t, string(data), "# service")
+	assert.NotContains(t, string(data), head.Hash().String())
+
+	// Only the references matching the prefix are listed
+	var body bytes.Buffer
+	enc := pktline.NewEncoder(&body)
+	require.NoError(t, enc.EncodeString("command=ls-refs\n"))
+	body.WriteString("0001")
+	require.NoError(t, enc.EncodeString("ref-prefix refs/heads/\n"))
+	require.NoError(t, enc.Flush())
+
+	req = httptest.NewRequest(fiber.MethodPost, "/repo.git/git-upload-pack", &body)
+	req.Header.Set("Git-Protocol", "version=2")
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	data, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), head.Hash().String()+" refs/heads/main")
+	assert.NotContains(t, string(data), "HEAD")
+
+	// A malformed request is rejected before anything is written
+	req = httptest.NewRequest(fiber.MethodPost, "/repo.git/git-upload-pack", bytes.NewReader([]byte("zzzz")))
+	req.Header.Set("Git-Protocol", "version=2")
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+	// A missing repository is not found
+	req = httptest.NewRequest(fiber.MethodPost, "/missing.git/git-upload-pack", bytes.NewReader([]byte("0000")))
+	req.Header.Set("Git-Protocol", "version=2")
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+}
+
+func TestInfoRefs_AutoCreate(t *testing.T) {
+	config.Storage.Local.Path = t.TempDir()
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+
+	gc := &GitController{Logger: zerolog.Nop(), Storage: str}
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/+/info/refs", gc.InfoRefs)
+
+	infoRefs := func(service string) (int, string) {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/team/new.git/info/refs?service="+service, nil), -1)
+		require.NoError(t, err)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(data)
+	}
+
+	// Without a policy the push is rejected with a git error
+	status, body := infoRefs("git-receive-pack")
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Contains(t, body, "# service=git-receive-pack\n0000")
+	assert.Contains(t, body, "ERR repository creation rejected: repository 'team/new' not found")
+	assert.False(t, str.RepositoryExists("team/new.git"))
+
+	// Fetching never creates the repository
+	gc.AutoCreate = &autocreate.Creator{Policy: autocreate.On, Storage: str}
+	status, _ = infoRefs("git-upload-pack")
+	assert.NotEqual(t, fiber.StatusOK, status)
+	assert.False(t, str.RepositoryExists("team/new.git"))
+
+	status, body = infoRefs("git-receive-pack")
+	require.Equal(t, fiber.StatusOK, status)
+	assert.NotContains(t, body, "ERR")
+	assert.True(t, str.RepositoryExists("team/new.git"))
+}
+
+func TestGitServices_MissingRepositoryNotMeasured(t *testing.T) {
+	app, _ := setupGitTestApp(t, 0)
+
+	for _, tc := range []struct{ service, protocol string }{
+		{"git-upload-pack", ""},
+		{"git-upload-pack", "version=2"},
+		{"git-receive-pack", ""},
+	} {
+		req := httptest.NewRequest(fiber.MethodPost, "/unmeasured.git/"+tc.service, bytes.NewReader([]byte("0000")))
+		req.Header.Set("Git-Protocol", tc.protocol)
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusNotFound, resp.StatusCode, tc.service+" "+tc.protocol)
+	}
+
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				assert.NotEqual(t, "unmeasured.git", label.GetValue(), family.GetName())
+			}
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package controller
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/internal/api/middleware"
+	"github.com/labbs/git-server-s3/pkg/common"
+	"github.com/labbs/git-server-s3/pkg/lfs"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/rs/zerolog"
+)
+
+// anonymousOwner is the owner of the locks created without authentication
+const anonymousOwner = "anonymous"
+
+// LFSController handles the Git LFS API of the repositories, under
+// /{repo}/info/lfs where git-lfs finds it from the remote URL: the batch
+// API, the transfers of the objects and the file locks. Downloading and
+// listing the locks require read access, uploading and locking write access.
+// Requests and responses are JSON of the LFS media type, errors included.
+type LFSController struct {
+	Logger      zerolog.Logger               // Logger for request logging and error reporting
+	Storage     storage.GitRepositoryStorage // Storage backend of the repositories
+	Objects     storage.LFSStorage           // Storage of the LFS objects
+	Locks       *lfs.LockStore               // File locks of the repositories
+	Access      *rbac.Store                  // Access control, nil when disabled
+	MaxBodySize int64                        // Maximum size of an uploaded object in bytes, 0 for no limit
+	// PresignExpiry is the validity of the URLs transferring the objects
+	// directly from the storage, 0 to transfer them through the server
+	PresignExpiry time.Duration
+}
+
+// Batch handles POST requests asking how to download or upload objects.
+// Objects already uploaded get no action, missing objects to download an
+// error.
+//
+// Request body: {"operation": "upload", "objects": [{"oid": "...", "size": 123}]}
+// Response: 200 OK with the actions of each object
+func (c *LFSController) Batch(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "LFSBatch").Logger()
+
+	var req lfs.BatchRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return lfsError(ctx, fiber.StatusBadRequest, "invalid request body")
+	}
+
+	var role rbac.Role
+	switch req.Operation {
+	case lfs.OperationDownload:
+		role = rbac.Read
+	case lfs.OperationUpload:
+		role = rbac.Write
+	default:
+		return lfsError(ctx, fiber.StatusUnprocessableEntity, "invalid operation "+strconv.Quote(req.Operation))
+	}
+	if len(req.Transfers) > 0 && !slices.Contains(req.Transfers, lfs.TransferBasic) {
+		return lfsError(ctx, fiber.StatusUnprocessableEntity, "only the basic transfer is supported")
+	}
+	if req.HashAlgo != "" && req.HashAlgo != lfs.HashAlgorithm {
+		return lfsError(ctx, fiber.StatusConflict, "only the sha256 hash algorithm is supported")
+	}
+
+	repoPath, ok := c.repository(ctx, logger, role)
+	if !ok {
+		return nil
+	}
+
+	resp := lfs.BatchResponse{Transfer: lfs.TransferBasic, Objects: []lfs.ObjectResponse{}, HashAlgo: lfs.HashAlgorithm}
+	for _, p := range req.Objects {
+		obj, err := c.batchObject(ctx, repoPath, req.Operation, p)
+		if err != nil {
+			logger.Error().Err(err).Str("repo", repoPath).Str("oid", p.OID).Msg("Failed to prepare LFS transfer")
+			return lfsError(ctx, fiber.StatusInternalServerError, "failed to prepare transfer")
+		}
+		resp.Objects = append(resp.Objects, obj)
+	}
+	return sendLFS(ctx, fiber.StatusOK, resp)
+}
+
+// batchObject returns the actions transferring an object of a batch request
+func (c *LFSController) batchObject(ctx *fiber.Ctx, repoPath, operation string, p lfs.Pointer) (lfs.ObjectResponse, error) {
+	obj := lfs.ObjectResponse{Pointer: p}
+	if !lfs.ValidOID(p.OID) || p.Size < 0 {
+		obj.Error = &lfs.ObjectError{Code: fiber.StatusUnprocessableEntity, Message: "invalid object"}
+		return obj, nil
+	}
+
+	size, err := c.Objects.Stat(repoPath, p.OID)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return obj, err
+	}
+
+	presigner, presign := c.Objects.(storage.LFSPresigner)
+	presign = presign && c.PresignExpiry > 0
+	base := ctx.BaseURL() + "/" + (&url.URL{Path: repoPath}).EscapedPath() + "/info/lfs"
+	header := map[string]string{}
+	if authorization := ctx.Get(fiber.HeaderAuthorization); authorization != "" {
+		header[fiber.HeaderAuthorization] = authorization
+	}
+
+	switch operation {
+	case lfs.OperationDownload:
+		if !exists {
+			obj.Error = &lfs.ObjectError{Code: fiber.StatusNotFound, Message: "object does not exist"}
+			return obj, nil
+		}
+		obj.Size = size
+		if presign {
+			href, signed, err := presigner.PresignDownload(repoPath, p.OID, c.PresignExpiry)
+			if err != nil {
+				return obj, err
+			}
+			obj.Actions = map[string]*lfs.Action{"download": lfs.NewAction(href, signed, c.PresignExpiry)}
+		} else {
+			obj.Actions = map[string]*lfs.Action{"download": lfs.NewAction(base+"/objects/"+p.OID, header, 0)}
+		}
+	case lfs.OperationUpload:
+		if exists && size == p.Size {
+			return obj, nil
+		}
+		if presign {
+			href, signed, err := presigner.PresignUpload(repoPath, p.OID, p.Size, c.PresignExpiry)
+			if err != nil {
+				return obj, err
+			}
+			obj.Actions = map[string]*lfs.Action{"upload": lfs.NewAction(href, signed, c.PresignExpiry)}
+		} else {
+			obj.Actions = map[string]*lfs.Action{"upload": lfs.NewAction(base+"/objects/"+p.OID, header, 0)}
+		}
+		obj.Actions["verify"] = lfs.NewAction(base+"/verify", header, 0)
+	}
+	return obj, nil
+}
+
+// Download handles GET requests returning the content of an object.
+//
+// Response: 200 OK with the content, 404 Not Found if there is no such object
+func (c *LFSController) Download(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "LFSDownload").Logger()
+
+	repoPath, ok := c.repository(ctx, logger, rbac.Read)
+	if !ok {
+		return nil
+	}
+	oid := ctx.Params("oid")
+	if !lfs.ValidOID(oid) {
+		return lfsError(ctx, fiber.StatusNotFound, "object does not exist")
+	}
+
+	r, size, err := c.Objects.Open(repoPath, oid)
+	if errors.Is(err, fs.ErrNotExist) {
+		return lfsError(ctx, fiber.StatusNotFound, "object does not exist")
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("repo", repoPath).Str("oid", oid).Msg("Failed to read LFS object")
+		return lfsError(ctx, fiber.StatusInternalServerError, "failed to read object")
+	}
+
+	ctx.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	// fasthttp closes the reader once sent
+	return ctx.SendStream(r, int(size))
+}
+
+// Upload handles PUT requests storing an object. The body is streamed to the
+// storage once checked against the oid.
+//
+// Request body: the content of the object
+// Response: 200 OK, 422 Unprocessable Entity if the content does not match the oid
+func (c *LFSController) Upload(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "LFSUpload").Logger()
+
+	repoPath, ok := c.repository(ctx, logger, rbac.Write)
+	if !ok {
+		return nil
+	}
+	oid := ctx.Params("oid")
+
+	body, err := requestBody(ctx, c.MaxBodySize)
+	if err == nil {
+		var size int64
+		size, err = lfs.Receive(c.Objects, repoPath, oid, body)
+		body.Close()
+		if err == nil {
+			logger.Info().Str("repo", repoPath).Str("oid", oid).Int64("size", size).Msg("LFS object uploaded")
+			return ctx.SendStatus(fiber.StatusOK)
+		}
+	}
+
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		// The rest of the body is not read, the connection cannot be reused
+		ctx.Context().SetConnectionClose()
+		return lfsError(ctx, fiber.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, lfs.ErrInvalidObject):
+		return lfsError(ctx, fiber.StatusUnprocessableEntity, err.Error())
+	default:
+		logger.Error().Err(err).Str("repo", repoPath).Str("oid", oid).Msg("Failed to store LFS object")
+		return lfsError(ctx, fiber.StatusInternalServerError, "failed to store object")
+	}
+}
+
+// Verify handles POST requests checking that an uploaded object is stored.
+//
+// Request body: {"oid": "...", "size": 123}
+// Response: 200 OK, 404 Not Found if the object is missing, 422 Unprocessable Entity if its size differs
+func (c *LFSController) Verify(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "LFSVerify").Logger()
+
+	var p lfs.Pointer
+	if err := ctx.BodyParser(&p); err != nil || !lfs.ValidOID(p.OID) {
+		return lfsError(ctx, fiber.StatusUnprocessableEntity, "invalid object")
+	}
+	repoPath, ok := c.repository(ctx, logger, rbac.Write)
+	if !ok {
+		return nil
+	}
+
+	err := lfs.Verify(c.Objects, repoPath, p)
+	switch {
+	case err == nil:
+		return sendLFS(ctx, fiber.StatusOK, fiber.Map{})
+	case errors.Is(err, fs.ErrNotExist):
+		return lfsError(ctx, fiber.StatusNotFound, "object does not exist")
+	case errors.Is(err, lfs.ErrInvalidObject):
+		return lfsError(ctx, fiber.StatusUnprocessableEntity, err.Error())
+	default:
+		logger.Error().Err(err).Str("repo", repoPath).Str("oid", p.OID).Msg("Failed to verify LFS object")
+		return lfsError(ctx, fiber.StatusInternalServerError, "failed to verify object")
+	}
+}
+
+// ListLocks handles GET requests listing the file locks of a repository,
+// a page at a time.
+//
+// Query: ?path=assets/hero.psd&id=...&cursor=...&limit=100
+// Response: 200 OK with {"locks": [...], "next_cursor": "..."}
+func (c *LFSController) ListLocks(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "ListLFSLocks").Logger()
+
+	limit, err := strconv.Atoi(ctx.Query("limit", "0"))
+	if err != nil || limit < 0 {
+		return lfsError(ctx, fiber.StatusBadRequest, "invalid limit")
+	}
+	repoPath, ok := c.repository(ctx, logger, rbac.Read)
+	if !ok {
+		return nil
+	}
+
+	locks, next := c.Locks.List(repoPath, lfs.ListOptions{
+		Path:   ctx.Query("path"),
+		ID:     ctx.Query("id"),
+		Cursor: ctx.Query("cursor"),
+		Limit:  limit,
+	})
+	return sendLFS(ctx, fiber.StatusOK, fiber.Map{"locks": locks, "next_cursor": next})
+}
+
+// CreateLock handles POST requests locking a file for the user.
+//
+// Request body: {"path": "assets/hero.psd"}
+// Response: 201 Created with {"lock": {...}}, 409 Conflict with the existing lock
+func (c *LFSController) CreateLock(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "CreateLFSLock").Logger()
+
+	var req struct {
+		Path string   `json:"path"`
+		Ref  *lfs.Ref `json:"ref"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return lfsError(ctx, fiber.StatusBadRequest, "invalid request body")
+	}
+	repoPath, ok := c.repository(ctx, logger, rbac.Write)
+	if !ok {
+		return nil
+	}
+
+	lock, err := c.Locks.Create(repoPath, req.Path, lockOwner(ctx))
+	switch {
+	case err == nil:
+		logger.Info().Str("repo", repoPath).Str("path", lock.Path).Str("owner", lock.Owner.Name).Msg("File locked")
+		return sendLFS(ctx, fiber.StatusCreated, fiber.Map{"lock": lock})
+	case errors.Is(err, lfs.ErrLockExists):
+		return sendLFS(ctx, fiber.StatusConflict, fiber.Map{"lock": lock, "message": err.Error()})
+	case errors.Is(err, lfs.ErrInvalidLock):
+		return lfsError(ctx, fiber.StatusUnprocessableEntity, err.Error())
+	default:
+		logger.Error().Err(err).Str("repo", repoPath).Msg("Failed to create lock")
+		return lfsError(ctx, fiber.StatusInternalServerError, "failed to create lock")
+	}
+}
+
+// VerifyLocks handles POST requests listing the file locks of a repository
+// split between the ones of the user and the others, as checked by git-lfs
+// before a push.
+//
+// Request body: {"cursor": "...", "limit": 100}
+// Response: 200 OK with {"ours": [...], "theirs": [...], "next_cursor": "..."}
+func (c *LFSController) VerifyLocks(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "VerifyLFSLocks").Logger()
+
+	var req struct {
+		Cursor string   `json:"cursor"`
+		Limit  int      `json:"limit"`
+		Ref    *lfs.Ref `json:"ref"`
+	}
+	if err := ctx.BodyParser(&req); err != nil || req.Limit < 0 {
+		return lfsError(ctx, fiber.StatusBadRequest, "invalid request body")
+	}
+	repoPath, ok := c.repository(ctx, logger, rbac.Write)
+	if !ok {
+		return nil
+	}
+
+	locks, next := c.Locks.List(repoPath, lfs.ListOptions{Cursor: req.Cursor, Limit: req.Limit})
+	owner := lockOwner(ctx)
+	ours, theirs := []lfs.Lock{}, []lfs.Lock{}
+	for _, lock := range locks {
+		if lock.Owner.Name == owner {
+			ours = append(ours, lock)
+		} else {
+			theirs = append(theirs, lock)
+		}
+	}
+	return sendLFS(ctx, fiber.StatusOK, fiber.Map{"ours": ours, "theirs": theirs, "next_cursor": next})
+}
+
+// Unlock handles POST requests removing a file lock. Only its owner may
+// remove it, or an admin of the repository with force.
+//
+// Request body: {"force": false}
+// Response: 200 OK with {"lock": {...}}, 403 Forbidden if owned by another user, 404 Not Found if there is no such lock
+func (c *LFSController) Unlock(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "UnlockLFSLock").Logger()
+
+	var req struct {
+		Force bool     `json:"force"`
+		Ref   *lfs.Ref `json:"ref"`
+	}
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&req); err != nil {
+			return lfsError(ctx, fiber.StatusBadRequest, "invalid request body")
+		}
+	}
+	role := rbac.Write
+	if req.Force {
+		role = rbac.Admin
+	}
+	repoPath, ok := c.repository(ctx, logger, role)
+	if !ok {
+		return nil
+	}
+
+	lock, err := c.Locks.Unlock(repoPath, ctx.Params("id"), lockOwner(ctx), req.Force)
+	switch {
+	case err == nil:
+		logger.Info().Str("repo", repoPath).Str("path", lock.Path).Str("owner", lock.Owner.Name).Msg("File unlocked")
+		return sendLFS(ctx, fiber.StatusOK, fiber.Map{"lock": lock})
+	case errors.Is(err, lfs.ErrNotFound):
+		return lfsError(ctx, fiber.StatusNotFound, "lock not found")
+	case errors.Is(err, lfs.ErrNotOwner):
+		return lfsError(ctx, fiber.StatusForbidden, err.Error())
+	default:
+		logger.Error().Err(err).Str("repo", repoPath).Msg("Failed to remove lock")
+		return lfsError(ctx, fiber.StatusInternalServerError, "failed to remove lock")
+	}
+}
+
+// repository returns the repository of an LFS request, after checking that
+// the user has at least role on it and that it exists. It sends the error
+// response and returns false otherwise.
+func (c *LFSController) repository(ctx *fiber.Ctx, logger zerolog.Logger, role rbac.Role) (string, bool) {
+	repoPath := common.NormalizeRepoPath(ctx.Params("+"))
+	if !common.ValidRepoPath(repoPath) {
+		_ = lfsError(ctx, fiber.StatusNotFound, "repository not found")
+		return "", false
+	}
+
+	if c.Access != nil {
+		identity := middleware.Identity(ctx)
+		if !c.Access.Can(identity, repoPath, role) {
+			event := logger.Warn().Str("repo", repoPath).Stringer("role", role)
+			if identity != nil {
+				event = event.Str("user", identity.Username)
+			}
+			event.Msg("Access denied")
+			_ = lfsError(ctx, fiber.StatusForbidden, "access denied")
+			return "", false
+		}
+	}
+
+	if !c.Storage.RepositoryExists(repoPath) {
+		_ = lfsError(ctx, fiber.StatusNotFound, "repository not found")
+		return "", false
+	}
+	return repoPath, true
+}
+
+// lockOwner returns the name of the user owning the locks of the request
+func lockOwner(ctx *fiber.Ctx) string {
+	if identity := middleware.Identity(ctx); identity != nil {
+		return identity.Username
+	}
+	return anonymousOwner
+}
+
+// sendLFS sends a JSON response of the LFS media type
+func sendLFS(ctx *fiber.Ctx, status int, body any) error {
+	if err := ctx.Status(status).JSON(body); err != nil {
+		return err
+	}
+	ctx.Set(fiber.HeaderContentType, lfs.MediaType)
+	return nil
+}
+
+// lfsError sends an LFS error response
+func lfsError(ctx *fiber.Ctx, status int, message string) error {
+	return sendLFS(ctx, status, lfs.ErrorResponse{Message: message})
+}
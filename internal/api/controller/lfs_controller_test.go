@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/pkg/lfs"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupLFSTestApp(t *testing.T) (*fiber.App, *rbac.Store) {
+	t.Helper()
+
+	app, access := setupAccessTestApp(t)
+	// setupAccessTestApp configured the storage path
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	objects, err := local.NewLFSStorage(t.TempDir())
+	require.NoError(t, err)
+	locks, err := lfs.LoadLocks(filepath.Join(t.TempDir(), "locks.json"))
+	require.NoError(t, err)
+
+	lc := &LFSController{Logger: zerolog.Nop(), Storage: str, Objects: objects, Locks: locks, Access: access}
+	app.Post("/+/info/lfs/objects/batch", lc.Batch)
+	app.Get("/+/info/lfs/objects/:oid", lc.Download)
+	app.Put("/+/info/lfs/objects/:oid", lc.Upload)
+	app.Post("/+/info/lfs/verify", lc.Verify)
+	app.Get("/+/info/lfs/locks", lc.ListLocks)
+	app.Post("/+/info/lfs/locks/verify", lc.VerifyLocks)
+	app.Post("/+/info/lfs/locks/:id/unlock", lc.Unlock)
+	app.Post("/+/info/lfs/locks", lc.CreateLock)
+
+	_, err = access.SetGrant(rbac.Grant{User: "alice", Resource: "team/project", Role: rbac.Write})
+	require.NoError(t, err)
+	_, err = access.SetGrant(rbac.Grant{User: "bob", Resource: "team/project", Role: rbac.Read})
+	require.NoError(t, err)
+	return app, access
+}
+
+func TestLFSController_Transfer(t *testing.T) {
+	app, _ := setupLFSTestApp(t)
+	sum := sha256.Sum256([]byte("hero model"))
+	oid := hex.EncodeToString(sum[:])
+	batch := func(operation string) string {
+		return `{"operation":"` + operation + `","transfers":["basic"],"objects":[{"oid":"` + oid + `","size":10}]}`
+	}
+
+	// Uploading requires write access
+	status, body := accessRequest(t, app, "bob", fiber.MethodPost, "/team/project.git/info/lfs/objects/batch", batch("upload"))
+	assert.Equal(t, fiber.StatusForbidden, status)
+	assert.JSONEq(t, `{"message":"access denied"}`, body)
+
+	status, body = accessRequest(t, app, "alice", fiber.MethodPost, "/team/project.git/info/lfs/objects/batch", batch("upload"))
+	require.Equal(t, fiber.StatusOK, status, body)
+	var resp lfs.BatchResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &resp))
+	require.Len(t, resp.Objects, 1)
+	upload := resp.Objects[0].Actions["upload"]
+	require.NotNil(t, upload)
+	assert.Equal(t, "http://example.com/team/project.git/info/lfs/objects/"+oid, upload.Href)
+	assert.NotEmpty(t, upload.Header[fiber.HeaderAuthorization])
+	assert.Equal(t, "http://example.com/team/project.git/info/lfs/verify", resp.Objects[0].Actions["verify"].Href)
+
+	// A missing object cannot be downloaded
+	status, body = accessRequest(t, app, "bob", fiber.MethodPost, "/team/project.git/info/lfs/objects/batch", batch("download"))
+	require.Equal(t, fiber.StatusOK, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &resp))
+	assert.Equal(t, &lfs.ObjectError{Code: fiber.StatusNotFound, Message: "object does not exist"}, resp.Objects[0].Error)
+
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPut, "/team/project.git/info/lfs/objects/"+oid, "something else")
+	assert.Equal(t, fiber.StatusUnprocessableEntity, status)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPost, "/team/project.git/info/lfs/verify", `{"oid":"`+oid+`","size":10}`)
+	assert.Equal(t, fiber.StatusNotFound, status)
+
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPut, "/team/project.git/info/lfs/objects/"+oid, "hero model")
+	require.Equal(t, fiber.StatusOK, status)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPost, "/team/project.git/info/lfs/verify", `{"oid":"`+oid+`","size":10}`)
+	assert.Equal(t, fiber.StatusOK, status)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPost, "/team/project.git/info/lfs/verify", `{"oid":"`+oid+`","size":11}`)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, status)
+
+	// An uploaded object needs no upload
+	status, body = accessRequest(t, app, "alice", fiber.MethodPost, "/team/project.git/info/lfs/objects/batch", batch("upload"))
+	require.Equal(t, fiber.StatusOK, status)
+	resp = lfs.BatchResponse{}
+	require.NoError(t, json.Unmarshal([]byte(body), &resp))
+	assert.Empty(t, resp.Objects[0].Actions)
+
+	status, body = accessRequest(t, app, "bob", fiber.MethodPost, "/team/project.git/info/lfs/objects/batch", batch("download"))
+	require.Equal(t, fiber.StatusOK, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &resp))
+	assert.Equal(t, "http://example.com/team/project.git/info/lfs/objects/"+oid, resp.Objects[0].Actions["download"].Href)
+
+	status, body = accessRequest(t, app, "bob", fiber.MethodGet, "/team/project.git/info/lfs/objects/"+oid, "")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, "hero model", body)
+
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPost, "/team/project.git/info/lfs/objects/batch", `{"operation":"upload","transfers":["tus"],"objects":[]}`)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, status)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPost, "/team/missing.git/info/lfs/objects/batch", batch("download"))
+	assert.Equal(t, fiber.StatusForbidden, status)
+	status, _ = accessRequest(t, app, "root", fiber.MethodPost, "/team/missing.git/info/lfs/objects/batch", batch("download"))
+	assert.Equal(t, fiber.StatusNotFound, status)
+}
+
+func TestLFSController_Locks(t *testing.T) {
+	app, _ := setupLFSTestApp(t)
+
+	// Locking requires write access
+	status, _ := accessRequest(t, app, "bob", fiber.MethodPost, "/team/project.git/info/lfs/locks", `{"path":"assets/hero.psd"}`)
+	assert.Equal(t, fiber.StatusForbidden, status)
+
+	status, body := accessRequest(t, app, "alice", fiber.MethodPost, "/team/project.git/info/lfs/locks", `{"path":"assets/hero.psd"}`)
+	require.Equal(t, fiber.StatusCreated, status, body)
+	var created struct {
+		Lock lfs.Lock `json:"lock"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	assert.Equal(t, "alice", created.Lock.Owner.Name)
+
+	status, body = accessRequest(t, app, "root", fiber.MethodPost, "/team/project.git/info/lfs/locks", `{"path":"assets/hero.psd"}`)
+	assert.Equal(t, fiber.StatusConflict, status)
+	assert.Contains(t, body, created.Lock.ID)
+
+	status, body = accessRequest(t, app, "bob", fiber.MethodGet, "/team/project.git/info/lfs/locks?path=assets/hero.psd", "")
+	require.Equal(t, fiber.StatusOK, status)
+	var list struct {
+		Locks      []lfs.Lock `json:"locks"`
+		NextCursor string     `json:"next_cursor"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	assert.Equal(t, []lfs.Lock{created.Lock}, list.Locks)
+
+	status, body = accessRequest(t, app, "root", fiber.MethodPost, "/team/project.git/info/lfs/locks/verify", `{}`)
+	require.Equal(t, fiber.StatusOK, status)
+	var verify struct {
+		Ours   []lfs.Lock `json:"ours"`
+		Theirs []lfs.Lock `json:"theirs"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &verify))
+	assert.Empty(t, verify.Ours)
+	assert.Equal(t, []lfs.Lock{created.Lock}, verify.Theirs)
+
+	// Only the owner unlocks, or an admin with force
+	unlock := "/team/project.git/info/lfs/locks/" + created.Lock.ID + "/unlock"
+	status, _ = accessRequest(t, app, "root", fiber.MethodPost, unlock, `{}`)
+	assert.Equal(t, fiber.StatusForbidden, status)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPost, unlock, `{"force":true}`)
+	assert.Equal(t, fiber.StatusForbidden, status)
+	status, _ = accessRequest(t, app, "root", fiber.MethodPost, unlock, `{"force":true}`)
+	assert.Equal(t, fiber.StatusOK, status)
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPost, unlock, `{}`)
+	assert.Equal(t, fiber.StatusNotFound, status)
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/pkg/mirror"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/rs/zerolog"
+)
+
+// MirrorController handles HTTP requests managing the pull mirrors: the
+// repositories synchronized from an upstream repository.
+type MirrorController struct {
+	Logger          zerolog.Logger               // Logger for request logging and error reporting
+	Storage         storage.GitRepositoryStorage // Storage backend, to check that the repositories exist
+	Access          *rbac.Store                  // Access control, nil when disabled
+	Mirrors         *mirror.Mirrorer             // Mirrors and their synchronization
+	DefaultInterval time.Duration                // Interval of the mirrors set without one
+}
+
+// mirrorRequest is the body of SetMirror
+type mirrorRequest struct {
+	URL      string          `json:"url"`
+	Interval mirror.Duration `json:"interval"`
+}
+
+// GetMirror handles GET requests returning the upstream of a mirror and the
+// status of its last synchronization. It requires read access.
+//
+// Response: 200 OK with the mirror, 404 Not Found if the repository is not a mirror
+func (c *MirrorController) GetMirror(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "GetMirror").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Read)
+	if !ok {
+		return nil
+	}
+
+	m, ok := c.Mirrors.Store.Get(repoPath)
+	if !ok {
+		return ctx.Status(fiber.StatusNotFound).SendString("repository is not a mirror")
+	}
+	return ctx.JSON(redactMirror(m))
+}
+
+// SetMirror handles PUT requests making a repository a read-only mirror of an
+// upstream repository, synchronized every interval. It requires admin access.
+//
+// Request body: {"url": "https://example.com/project.git", "interval": "30m"}
+// Response: 200 OK with the mirror
+func (c *MirrorController) SetMirror(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "SetMirror").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	var req mirrorRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	interval := time.Duration(req.Interval)
+	if interval == 0 {
+		interval = c.DefaultInterval
+	}
+
+	m, err := c.Mirrors.Set(repoPath, req.URL, interval)
+	if errors.Is(err, mirror.ErrInvalidMirror) {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to save mirror")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to save mirror")
+	}
+
+	logger.Info().Str("repo", repoPath).Str("upstream", mirror.RedactURL(m.URL)).Dur("interval", interval).Msg("Mirror saved")
+	return ctx.JSON(redactMirror(m))
+}
+
+// RemoveMirror handles DELETE requests stopping the mirroring of a
+// repository, which keeps its references and accepts pushes again. It
+// requires admin access.
+//
+// Response: 204 No Content, 404 Not Found if the repository is not a mirror
+func (c *MirrorController) RemoveMirror(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "RemoveMirror").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	err := c.Mirrors.Remove(repoPath)
+	if errors.Is(err, mirror.ErrNotFound) {
+		return ctx.Status(fiber.StatusNotFound).SendString("repository is not a mirror")
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to remove mirror")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to remove mirror")
+	}
+
+	logger.Info().Str("repo", repoPath).Msg("Mirror removed")
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// SyncMirror handles POST requests synchronizing a mirror right away, waiting
+// for the end of the synchronization. It requires write access.
+//
+// Response: 200 OK with the mirror, 502 Bad Gateway with the mirror if the
+// synchronization failed, 404 Not Found if the repository is not a mirror
+func (c *MirrorController) SyncMirror(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "SyncMirror").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Write)
+	if !ok {
+		return nil
+	}
+
+	m, err := c.Mirrors.Sync(ctx.Context(), repoPath)
+	if errors.Is(err, mirror.ErrNotFound) {
+		return ctx.Status(fiber.StatusNotFound).SendString("repository is not a mirror")
+	}
+	if err != nil && m.Status != mirror.StatusFailed {
+		logger.Error().Err(err).Msg("Failed to save mirror")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to save mirror")
+	}
+	if err != nil {
+		logger.Warn().Err(err).Str("repo", repoPath).Msg("Mirror synchronization failed")
+		return ctx.Status(fiber.StatusBadGateway).JSON(redactMirror(m))
+	}
+
+	logger.Info().Str("repo", repoPath).Msg("Mirror synchronized")
+	return ctx.JSON(redactMirror(m))
+}
+
+// redactMirror returns m without the password of its URL
+func redactMirror(m mirror.Mirror) mirror.Mirror {
+	m.URL = mirror.RedactURL(m.URL)
+	return m
+}
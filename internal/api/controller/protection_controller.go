@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/pkg/protection"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/rs/zerolog"
+)
+
+// ProtectionController handles HTTP requests managing the branch protection
+// rules of the repositories. It requires admin access to the repository.
+type ProtectionController struct {
+	Logger      zerolog.Logger               // Logger for request logging and error reporting
+	Storage     storage.GitRepositoryStorage // Storage backend, to check that the repositories exist
+	Access      *rbac.Store                  // Access control, nil when disabled
+	Protections *protection.Store            // Branch protection rules
+}
+
+// ListRules handles GET requests listing the branch protection rules of a repository.
+//
+// Response: 200 OK with JSON array of rules
+func (c *ProtectionController) ListRules(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "ListRules").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+	return ctx.JSON(c.Protections.Rules(repoPath))
+}
+
+// SetRule handles PUT requests protecting the branches matching a pattern,
+// replacing the rule of the same pattern.
+//
+// Request body: {"pattern": "release/*", "bypass": ["alice"]}
+// Response: 200 OK with the rule
+func (c *ProtectionController) SetRule(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "SetRule").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	var rule protection.Rule
+	if err := ctx.BodyParser(&rule); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	rule, err := c.Protections.SetRule(repoPath, rule)
+	if errors.Is(err, protection.ErrInvalidRule) {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to save branch protection")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to save branch protection")
+	}
+
+	logger.Info().Str("repo", repoPath).Str("pattern", rule.Pattern).Strs("bypass", rule.Bypass).Msg("Branch protection saved")
+	return ctx.JSON(rule)
+}
+
+// RemoveRule handles DELETE requests removing the branch protection rule of a pattern.
+//
+// Query parameters: pattern
+// Response: 204 No Content, 404 Not Found if there is no such rule
+func (c *ProtectionController) RemoveRule(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "RemoveRule").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	err := c.Protections.RemoveRule(repoPath, ctx.Query("pattern"))
+	if errors.Is(err, protection.ErrInvalidRule) {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if errors.Is(err, protection.ErrNotFound) {
+		return ctx.Status(fiber.StatusNotFound).SendString("branch protection not found")
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to remove branch protection")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to remove branch protection")
+	}
+
+	logger.Info().Str("repo", repoPath).Str("pattern", ctx.Query("pattern")).Msg("Branch protection removed")
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/pkg/protection"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtectionController(t *testing.T) {
+	app, access := setupAccessTestApp(t)
+	protections, err := protection.Load(filepath.Join(t.TempDir(), "protections.json"))
+	require.NoError(t, err)
+
+	// setupAccessTestApp configured the storage path
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	pc := &ProtectionController{Logger: zerolog.Nop(), Storage: str, Access: access, Protections: protections}
+	app.Get("/api/repos/+/protections", pc.ListRules)
+	app.Put("/api/repos/+/protections", pc.SetRule)
+	app.Delete("/api/repos/+/protections", pc.RemoveRule)
+
+	_, err = access.SetGrant(rbac.Grant{User: "alice", Resource: "team/project", Role: rbac.Write})
+	require.NoError(t, err)
+
+	// Managing the rules requires admin access
+	status, _ := accessRequest(t, app, "alice", fiber.MethodPut, "/api/repos/team/project/protections", `{"pattern": "main"}`)
+	assert.Equal(t, fiber.StatusForbidden, status)
+	status, _ = accessRequest(t, app, "root", fiber.MethodPut, "/api/repos/team/missing/protections", `{"pattern": "main"}`)
+	assert.Equal(t, fiber.StatusNotFound, status)
+	status, _ = accessRequest(t, app, "root", fiber.MethodPut, "/api/repos/team/project/protections", `{"pattern": ""}`)
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	status, body := accessRequest(t, app, "root", fiber.MethodPut, "/api/repos/team/project/protections", `{"pattern": "release/*", "bypass": ["alice"]}`)
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.JSONEq(t, `{"pattern": "release/*", "bypass": ["alice"]}`, body)
+
+	status, body = accessRequest(t, app, "root", fiber.MethodGet, "/api/repos/team/project.git/protections", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.JSONEq(t, `[{"pattern": "release/*", "bypass": ["alice"]}]`, body)
+
+	status, _ = accessRequest(t, app, "root", fiber.MethodDelete, "/api/repos/team/project/protections?pattern=release/*", "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+	status, _ = accessRequest(t, app, "root", fiber.MethodDelete, "/api/repos/team/project/protections?pattern=release/*", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+}
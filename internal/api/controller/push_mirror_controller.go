@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/pkg/mirror"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/rs/zerolog"
+)
+
+// PushMirrorController handles HTTP requests managing the push mirrors of
+// the repositories: the downstream repositories their pushes are replicated
+// to. It requires admin access to the repository.
+type PushMirrorController struct {
+	Logger  zerolog.Logger               // Logger for request logging and error reporting
+	Storage storage.GitRepositoryStorage // Storage backend, to check that the repositories exist
+	Access  *rbac.Store                  // Access control, nil when disabled
+	Pusher  *mirror.Pusher               // Push mirrors and their replication
+}
+
+// ListPushMirrors handles GET requests listing the push mirrors of a repository.
+//
+// Response: 200 OK with JSON array of push mirrors, passwords redacted
+func (c *PushMirrorController) ListPushMirrors(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "ListPushMirrors").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	mirrors := c.Pusher.Store.Mirrors(repoPath)
+	for i := range mirrors {
+		mirrors[i] = mirrors[i].Redacted()
+	}
+	return ctx.JSON(mirrors)
+}
+
+// CreatePushMirror handles POST requests registering a push mirror on a
+// repository. The repository is replicated to it right away, then after
+// every push.
+//
+// Request body: {"url": "https://backup.example.com/project.git"}
+// Response: 201 Created with the push mirror, password redacted
+func (c *PushMirrorController) CreatePushMirror(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "CreatePushMirror").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	m, err := c.Pusher.Store.Add(repoPath, req.URL)
+	if errors.Is(err, mirror.ErrInvalidMirror) {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to save push mirror")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to save push mirror")
+	}
+	_ = c.Pusher.Sync(repoPath, m.ID)
+
+	logger.Info().Str("repo", repoPath).Str("mirror", m.ID).Str("downstream", mirror.RedactURL(m.URL)).Msg("Push mirror created")
+	return ctx.Status(fiber.StatusCreated).JSON(m.Redacted())
+}
+
+// GetPushMirror handles GET requests returning a push mirror of a repository
+// and the status of its last replication.
+//
+// Response: 200 OK with the push mirror, password redacted, 404 Not Found if there is no such push mirror
+func (c *PushMirrorController) GetPushMirror(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "GetPushMirror").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	m, err := c.Pusher.Store.Mirror(repoPath, ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusNotFound).SendString("push mirror not found")
+	}
+	return ctx.JSON(m.Redacted())
+}
+
+// DeletePushMirror handles DELETE requests removing a push mirror of a
+// repository. The downstream repository is left as is.
+//
+// Response: 204 No Content, 404 Not Found if there is no such push mirror
+func (c *PushMirrorController) DeletePushMirror(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "DeletePushMirror").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	err := c.Pusher.Store.Remove(repoPath, ctx.Params("id"))
+	if errors.Is(err, mirror.ErrNotFound) {
+		return ctx.Status(fiber.StatusNotFound).SendString("push mirror not found")
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to delete push mirror")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to delete push mirror")
+	}
+
+	logger.Info().Str("repo", repoPath).Str("mirror", ctx.Params("id")).Msg("Push mirror deleted")
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// SyncPushMirror handles POST requests replicating a repository to a push
+// mirror, in the background like after a push.
+//
+// Response: 202 Accepted, 404 Not Found if there is no such push mirror
+func (c *PushMirrorController) SyncPushMirror(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "SyncPushMirror").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	if err := c.Pusher.Sync(repoPath, ctx.Params("id")); err != nil {
+		return ctx.Status(fiber.StatusNotFound).SendString("push mirror not found")
+	}
+	return ctx.SendStatus(fiber.StatusAccepted)
+}
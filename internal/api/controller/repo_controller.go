@@ -2,31 +2,62 @@
 package controller
 
 import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/internal/api/middleware"
 	"github.com/labbs/git-server-s3/pkg/common"
+	"github.com/labbs/git-server-s3/pkg/lfs"
+	"github.com/labbs/git-server-s3/pkg/mirror"
+	"github.com/labbs/git-server-s3/pkg/protection"
+	"github.com/labbs/git-server-s3/pkg/rbac"
 	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/webhooks"
 	"github.com/rs/zerolog"
 )
 
 // RepoController handles HTTP requests for repository management operations.
-// It provides endpoints for creating and listing Git repositories through
-// the configured storage backend.
+// It provides endpoints for creating, listing, inspecting, updating and
+// deleting Git repositories through the configured storage backend.
 type RepoController struct {
-	Logger  zerolog.Logger               // Logger for request logging and error reporting
-	Storage storage.GitRepositoryStorage // Storage backend for repository operations
+	Logger      zerolog.Logger               // Logger for request logging and error reporting
+	Storage     storage.GitRepositoryStorage // Storage backend for repository operations
+	Access      *rbac.Store                  // Access control, nil when disabled
+	Webhooks    *webhooks.Store              // Webhooks of the repositories, nil when disabled
+	Protections *protection.Store            // Branch protection rules, nil when disabled
+	LFS         storage.LFSStorage           // Git LFS objects, nil when disabled
+	LFSLocks    *lfs.LockStore               // Git LFS file locks, nil when disabled
+	Mirrors     *mirror.Mirrorer             // Pull mirrors, nil when disabled
+	PushMirrors *mirror.Pusher               // Push mirrors, nil when disabled
 }
 
 // CreateRepo handles POST requests to create a new Git repository.
 // It expects a JSON payload with a "name" field and creates a bare repository
 // in the configured storage backend.
 //
-// Request body: {"name": "repository-name"}
-// Response: 201 Created with "repository created" message on success
+// The name may be nested in namespaces (e.g., "team/sub/project"). The
+// repository is empty unless a README or a template repository, which the
+// user must be able to read, is requested. The default branch is the
+// configured one unless given.
+//
+// Request body: {"name": "repository-name", "default_branch": "main", "readme": true,
+// "template": "templates/service", "author": {"name": "...", "email": "..."}}
+// Response: 201 Created with "repository created" message on success, 409 Conflict if it exists
 func (c *RepoController) CreateRepo(ctx *fiber.Ctx) error {
 	logger := c.Logger.With().Str("event", "CreateRepo").Logger()
 
 	var req struct {
-		Name string `json:"name"`
+		Name          string `json:"name"`
+		DefaultBranch string `json:"default_branch"`
+		Readme        bool   `json:"readme"`
+		Template      string `json:"template"`
+		Author        *struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		} `json:"author"`
 	}
 
 	if err := ctx.BodyParser(&req); err != nil {
@@ -35,8 +66,40 @@ func (c *RepoController) CreateRepo(ctx *fiber.Ctx) error {
 
 	// Normalize the repository name to ensure proper .git suffix and path format
 	normName := common.NormalizeRepoPath(req.Name)
+	if !common.ValidRepoPath(normName) {
+		return ctx.Status(fiber.StatusBadRequest).SendString("invalid repository name")
+	}
+
+	// Creating a repository requires admin access to its namespace
+	if !authorize(ctx, logger, c.Access, normName, rbac.Admin) {
+		return nil
+	}
+
+	if c.Storage.RepositoryExists(normName) {
+		return ctx.Status(fiber.StatusConflict).SendString("repository already exists")
+	}
+
+	opts := storage.InitOptions{DefaultBranch: req.DefaultBranch, Readme: req.Readme}
+	if req.Template != "" {
+		opts.Template = common.NormalizeRepoPath(req.Template)
+		if !authorize(ctx, logger, c.Access, opts.Template, rbac.Read) {
+			return nil
+		}
+		if !c.Storage.RepositoryExists(opts.Template) {
+			return ctx.Status(fiber.StatusNotFound).SendString("template repository not found")
+		}
+	}
+	if req.Author != nil {
+		if req.Author.Name == "" || req.Author.Email == "" {
+			return ctx.Status(fiber.StatusBadRequest).SendString("author requires a name and an email")
+		}
+		opts.Author = &storage.Author{Name: req.Author.Name, Email: req.Author.Email}
+	}
 
-	err := c.Storage.CreateRepository(normName)
+	err := storage.InitRepository(c.Storage, normName, opts)
+	if errors.Is(err, storage.ErrInvalidInit) {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to create repository")
 		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to create repository")
@@ -47,7 +110,8 @@ func (c *RepoController) CreateRepo(ctx *fiber.Ctx) error {
 }
 
 // ListRepos handles GET requests to retrieve a list of all repositories.
-// Returns a JSON array containing the names of all repositories in the storage backend.
+// Returns a JSON array containing the names of the repositories in the storage backend
+// that the user can read.
 //
 // Response: 200 OK with JSON array of repository names
 func (c *RepoController) ListRepos(ctx *fiber.Ctx) error {
@@ -59,6 +123,212 @@ func (c *RepoController) ListRepos(ctx *fiber.Ctx) error {
 		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to list repositories")
 	}
 
+	if c.Access != nil {
+		identity := middleware.Identity(ctx)
+		repos = slices.DeleteFunc(repos, func(repo string) bool {
+			return !c.Access.Can(identity, repo, rbac.Read)
+		})
+	}
+
 	logger.Info().Int("count", len(repos)).Msg("Repositories listed successfully")
 	return ctx.Status(fiber.StatusOK).JSON(repos)
 }
+
+// Repository is the description of a repository returned by the API
+type Repository struct {
+	Name          string             `json:"name"`
+	DefaultBranch string             `json:"default_branch,omitempty"` // Empty when HEAD is not a branch
+	Size          int64              `json:"size"`                     // Size in bytes of the files of the repository
+	LastPush      *time.Time         `json:"last_push"`                // Nil when never pushed to
+	Description   string             `json:"description"`
+	Visibility    storage.Visibility `json:"visibility"`
+}
+
+// GetRepo handles GET requests describing a repository.
+//
+// Response: 200 OK with the repository, 404 Not Found if it does not exist
+func (c *RepoController) GetRepo(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "GetRepo").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Read)
+	if !ok {
+		return nil
+	}
+
+	repo, err := c.describe(repoPath)
+	if err != nil {
+		logger.Error().Err(err).Str("repo", repoPath).Msg("Failed to describe repository")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to describe repository")
+	}
+	return ctx.JSON(repo)
+}
+
+// UpdateRepo handles PATCH requests renaming a repository, possibly to
+// another namespace, and changing its description and visibility. Omitted
+// fields are left unchanged. Renaming requires admin access to the new path
+// as well.
+//
+// Request body: {"name": "archive/project", "description": "...", "visibility": "public"}
+// Response: 200 OK with the repository, 404 Not Found if it does not exist,
+// 409 Conflict if the new path is taken
+func (c *RepoController) UpdateRepo(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "UpdateRepo").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	var req struct {
+		Name        *string             `json:"name"`
+		Description *string             `json:"description"`
+		Visibility  *storage.Visibility `json:"visibility"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if req.Visibility != nil && *req.Visibility != storage.Public && *req.Visibility != storage.Private {
+		return ctx.Status(fiber.StatusBadRequest).SendString("invalid visibility, expected public or private")
+	}
+
+	if req.Name != nil {
+		newPath := common.NormalizeRepoPath(*req.Name)
+		if !common.ValidRepoPath(newPath) {
+			return ctx.Status(fiber.StatusBadRequest).SendString("invalid repository name")
+		}
+		if newPath != repoPath {
+			if !authorize(ctx, logger, c.Access, newPath, rbac.Admin) {
+				return nil
+			}
+			if c.Storage.RepositoryExists(newPath) {
+				return ctx.Status(fiber.StatusConflict).SendString("repository already exists")
+			}
+			if err := c.Storage.RenameRepository(repoPath, newPath); err != nil {
+				logger.Error().Err(err).Str("repo", repoPath).Msg("Failed to rename repository")
+				return ctx.Status(fiber.StatusInternalServerError).SendString("failed to rename repository")
+			}
+			for _, records := range c.records() {
+				if err := records.RenameRepository(repoPath, newPath); err != nil {
+					logger.Error().Err(err).Str("repo", repoPath).Msg("Failed to move the records of the repository")
+				}
+			}
+			logger.Info().Str("repo", repoPath).Str("to", newPath).Msg("Repository renamed")
+			repoPath = newPath
+		}
+	}
+
+	if req.Description != nil || req.Visibility != nil {
+		st, err := c.Storage.GetStorer(repoPath)
+		if err == nil {
+			err = storage.UpdateMetadata(st, func(metadata *storage.Metadata) {
+				if req.Description != nil {
+					metadata.Description = *req.Description
+				}
+				if req.Visibility != nil {
+					metadata.Visibility = *req.Visibility
+				}
+			})
+		}
+		if err != nil {
+			logger.Error().Err(err).Str("repo", repoPath).Msg("Failed to update repository")
+			return ctx.Status(fiber.StatusInternalServerError).SendString("failed to update repository")
+		}
+		logger.Info().Str("repo", repoPath).Msg("Repository updated")
+	}
+
+	repo, err := c.describe(repoPath)
+	if err != nil {
+		logger.Error().Err(err).Str("repo", repoPath).Msg("Failed to describe repository")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to describe repository")
+	}
+	return ctx.JSON(repo)
+}
+
+// DeleteRepo handles DELETE requests deleting a repository, with its grants,
+// webhooks and branch protection rules.
+//
+// Response: 204 No Content, 404 Not Found if it does not exist
+func (c *RepoController) DeleteRepo(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "DeleteRepo").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	if err := c.Storage.DeleteRepository(repoPath); err != nil {
+		logger.Error().Err(err).Str("repo", repoPath).Msg("Failed to delete repository")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to delete repository")
+	}
+	for _, records := range c.records() {
+		if err := records.DeleteRepository(repoPath); err != nil {
+			logger.Error().Err(err).Str("repo", repoPath).Msg("Failed to delete the records of the repository")
+		}
+	}
+
+	logger.Info().Str("repo", repoPath).Msg("Repository deleted")
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// repositoryRecords is a store of records kept by repository path, which
+// follow the repository when it is renamed or deleted
+type repositoryRecords interface {
+	RenameRepository(oldPath, newPath string) error
+	DeleteRepository(repoPath string) error
+}
+
+// records returns the stores of records by repository path
+func (c *RepoController) records() []repositoryRecords {
+	var records []repositoryRecords
+	if c.Access != nil {
+		records = append(records, c.Access)
+	}
+	if c.Webhooks != nil {
+		records = append(records, c.Webhooks)
+	}
+	if c.Protections != nil {
+		records = append(records, c.Protections)
+	}
+	if c.LFS != nil {
+		records = append(records, c.LFS, c.LFSLocks)
+	}
+	if c.Mirrors != nil {
+		records = append(records, c.Mirrors)
+	}
+	if c.PushMirrors != nil {
+		records = append(records, c.PushMirrors)
+	}
+	return records
+}
+
+// describe returns the description of an existing repository
+func (c *RepoController) describe(repoPath string) (*Repository, error) {
+	st, err := c.Storage.GetStorer(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &Repository{Name: repoPath}
+	head, err := st.Reference(plumbing.HEAD)
+	if err == nil && head.Type() == plumbing.SymbolicReference && head.Target().IsBranch() {
+		repo.DefaultBranch = head.Target().Short()
+	} else if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, err
+	}
+
+	repo.Size, err = c.Storage.RepositorySize(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := storage.ReadMetadata(st)
+	if err != nil {
+		return nil, err
+	}
+	repo.Description = metadata.Description
+	repo.Visibility = metadata.Visibility
+	if !metadata.LastPush.IsZero() {
+		repo.LastPush = &metadata.LastPush
+	}
+	return repo, nil
+}
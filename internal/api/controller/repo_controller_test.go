@@ -2,14 +2,22 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 
 	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/protection"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -44,6 +52,16 @@ func (m *MockGitRepositoryStorage) DeleteRepository(repoPath string) error {
 	return args.Error(0)
 }
 
+func (m *MockGitRepositoryStorage) RenameRepository(oldPath, newPath string) error {
+	args := m.Called(oldPath, newPath)
+	return args.Error(0)
+}
+
+func (m *MockGitRepositoryStorage) RepositorySize(repoPath string) (int64, error) {
+	args := m.Called(repoPath)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func (m *MockGitRepositoryStorage) ListRepositories() ([]string, error) {
 	args := m.Called()
 	return args.Get(0).([]string), args.Error(1)
@@ -54,6 +72,11 @@ func (m *MockGitRepositoryStorage) Configure() error {
 	return args.Error(0)
 }
 
+func (m *MockGitRepositoryStorage) Check(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
 func setupTestApp() (*fiber.App, *MockGitRepositoryStorage) {
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
@@ -78,7 +101,9 @@ func TestCreateRepoSuccess(t *testing.T) {
 	app, mockStorage := setupTestApp()
 
 	// Mock successful repository creation
+	mockStorage.On("RepositoryExists", "test-repo.git").Return(false)
 	mockStorage.On("CreateRepository", "test-repo.git").Return(nil)
+	mockStorage.On("GetStorer", "test-repo.git").Return(memory.NewStorage(), nil)
 
 	// Prepare request body
 	reqBody := map[string]string{
@@ -121,6 +146,7 @@ func TestCreateRepoMissingName(t *testing.T) {
 	app, mockStorage := setupTestApp()
 
 	// Mock storage to expect empty string (normalized name will be ".git")
+	mockStorage.On("RepositoryExists", ".git").Return(false)
 	mockStorage.On("CreateRepository", ".git").Return(assert.AnError)
 
 	// Send empty body
@@ -141,10 +167,43 @@ func TestCreateRepoMissingName(t *testing.T) {
 	mockStorage.AssertExpectations(t)
 }
 
+func TestCreateRepoNested(t *testing.T) {
+	app, mockStorage := setupTestApp()
+
+	mockStorage.On("RepositoryExists", "team/sub/project.git").Return(false)
+	mockStorage.On("CreateRepository", "team/sub/project.git").Return(nil)
+	mockStorage.On("GetStorer", "team/sub/project.git").Return(memory.NewStorage(), nil)
+
+	bodyBytes, _ := json.Marshal(map[string]string{"name": "/team/sub/project/"})
+	req := httptest.NewRequest("POST", "/api/repo", bytes.NewReader(bodyBytes))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestCreateRepoInvalidPath(t *testing.T) {
+	app, mockStorage := setupTestApp()
+
+	bodyBytes, _ := json.Marshal(map[string]string{"name": "team/../../etc/project"})
+	req := httptest.NewRequest("POST", "/api/repo", bytes.NewReader(bodyBytes))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+	mockStorage.AssertNotCalled(t, "CreateRepository", mock.Anything)
+}
+
 func TestCreateRepoStorageError(t *testing.T) {
 	app, mockStorage := setupTestApp()
 
 	// Mock storage error
+	mockStorage.On("RepositoryExists", "test-repo.git").Return(false)
 	mockStorage.On("CreateRepository", "test-repo.git").Return(assert.AnError)
 
 	// Prepare request body
@@ -259,7 +318,9 @@ func TestRepoControllerIntegration(t *testing.T) {
 	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
 
 	// Test 2: Create a new repository
+	mockStorage.On("RepositoryExists", "integration-test.git").Return(false).Once()
 	mockStorage.On("CreateRepository", "integration-test.git").Return(nil).Once()
+	mockStorage.On("GetStorer", "integration-test.git").Return(memory.NewStorage(), nil).Once()
 
 	reqBody := map[string]string{"name": "integration-test"}
 	bodyBytes, _ := json.Marshal(reqBody)
@@ -294,7 +355,9 @@ func BenchmarkCreateRepo(b *testing.B) {
 	app, mockStorage := setupTestApp()
 
 	// Mock pour accepter tous les appels
+	mockStorage.On("RepositoryExists", mock.AnythingOfType("string")).Return(false)
 	mockStorage.On("CreateRepository", mock.AnythingOfType("string")).Return(nil)
+	mockStorage.On("GetStorer", mock.AnythingOfType("string")).Return(memory.NewStorage(), nil)
 
 	reqBody := map[string]string{"name": "benchmark-repo"}
 	bodyBytes, _ := json.Marshal(reqBody)
@@ -326,3 +389,80 @@ func BenchmarkListRepos(b *testing.B) {
 		resp.Body.Close()
 	}
 }
+
+func TestRepoController_Manage(t *testing.T) {
+	app, access := setupAccessTestApp(t)
+	protections, err := protection.Load(filepath.Join(t.TempDir(), "protections.json"))
+	require.NoError(t, err)
+
+	// setupAccessTestApp configured the storage path
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	rc := &RepoController{Logger: zerolog.Nop(), Storage: str, Access: access, Protections: protections}
+	app.Get("/api/repos/+", rc.GetRepo)
+	app.Patch("/api/repos/+", rc.UpdateRepo)
+	app.Delete("/api/repos/+", rc.DeleteRepo)
+
+	_, err = access.SetGrant(rbac.Grant{User: "alice", Resource: "team/project", Role: rbac.Write})
+	require.NoError(t, err)
+	_, err = protections.SetRule("team/project.git", protection.Rule{Pattern: "main"})
+	require.NoError(t, err)
+
+	status, _ := accessRequest(t, app, "root", fiber.MethodPost, "/api/repo", `{"name": "team/project"}`)
+	assert.Equal(t, fiber.StatusConflict, status)
+
+	status, body := accessRequest(t, app, "alice", fiber.MethodGet, "/api/repos/team/project", "")
+	require.Equal(t, fiber.StatusOK, status)
+	var repo Repository
+	require.NoError(t, json.Unmarshal([]byte(body), &repo))
+	assert.Equal(t, "team/project.git", repo.Name)
+	assert.Equal(t, "main", repo.DefaultBranch)
+	assert.Positive(t, repo.Size)
+	assert.Nil(t, repo.LastPush)
+	assert.EqualValues(t, "private", repo.Visibility)
+
+	status, _ = accessRequest(t, app, "bob", fiber.MethodGet, "/api/repos/team/project", "")
+	assert.Equal(t, fiber.StatusForbidden, status)
+	status, _ = accessRequest(t, app, "root", fiber.MethodGet, "/api/repos/team/missing", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+
+	// Updating requires admin access
+	status, _ = accessRequest(t, app, "alice", fiber.MethodPatch, "/api/repos/team/project", `{"description": "x"}`)
+	assert.Equal(t, fiber.StatusForbidden, status)
+	status, _ = accessRequest(t, app, "root", fiber.MethodPatch, "/api/repos/team/project", `{"visibility": "internal"}`)
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	// A public repository is readable by every user
+	status, body = accessRequest(t, app, "root", fiber.MethodPatch, "/api/repos/team/project", `{"description": "The project", "visibility": "public"}`)
+	require.Equal(t, fiber.StatusOK, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &repo))
+	assert.Equal(t, "The project", repo.Description)
+	access.Public = func(repoPath string) bool { return storage.IsPublic(str, repoPath) }
+	status, _ = accessRequest(t, app, "bob", fiber.MethodGet, "/api/repos/team/project", "")
+	assert.Equal(t, fiber.StatusOK, status)
+
+	// Renaming moves the grants and rules, and fails on an existing repository
+	require.NoError(t, str.CreateRepository("team/other.git"))
+	status, _ = accessRequest(t, app, "root", fiber.MethodPatch, "/api/repos/team/project", `{"name": "team/other"}`)
+	assert.Equal(t, fiber.StatusConflict, status)
+	status, body = accessRequest(t, app, "root", fiber.MethodPatch, "/api/repos/team/project", `{"name": "archive/project"}`)
+	require.Equal(t, fiber.StatusOK, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &repo))
+	assert.Equal(t, "archive/project.git", repo.Name)
+	assert.Equal(t, "The project", repo.Description)
+	assert.False(t, str.RepositoryExists("team/project.git"))
+	assert.Equal(t, rbac.Write, access.Role(&auth.Identity{Username: "alice"}, "archive/project.git"))
+	assert.Len(t, protections.Rules("archive/project.git"), 1)
+	assert.Empty(t, protections.Rules("team/project.git"))
+
+	// Deleting removes the grants and rules
+	status, _ = accessRequest(t, app, "alice", fiber.MethodDelete, "/api/repos/archive/project", "")
+	assert.Equal(t, fiber.StatusForbidden, status)
+	status, _ = accessRequest(t, app, "root", fiber.MethodDelete, "/api/repos/archive/project", "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+	assert.False(t, str.RepositoryExists("archive/project.git"))
+	assert.Empty(t, access.Grants())
+	assert.Empty(t, protections.Rules("archive/project.git"))
+	status, _ = accessRequest(t, app, "root", fiber.MethodDelete, "/api/repos/archive/project", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errBodyTooLarge is returned while reading a request body larger than the configured maximum.
+var errBodyTooLarge = errors.New("request body too large")
+
+// requestBody returns a reader over the request body that streams it from the
+// connection instead of buffering it, decompressing gzip bodies as sent by git
+// clients. Reading fails with errBodyTooLarge past maxSize bytes of
+// (decompressed) body, unless maxSize is 0.
+func requestBody(c *fiber.Ctx, maxSize int64) (io.ReadCloser, error) {
+	if maxSize > 0 && int64(c.Request().Header.ContentLength()) > maxSize {
+		return nil, errBodyTooLarge
+	}
+
+	// Without StreamRequestBody the body has already been read. The raw body is
+	// used as c.Body() would decompress it.
+	body := c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Request().Body())
+	}
+
+	closer := io.NopCloser(nil)
+	switch encoding := c.Get(fiber.HeaderContentEncoding); encoding {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip request body: %w", err)
+		}
+		body, closer = gz, gz
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	if maxSize > 0 {
+		body = &limitedReader{r: body, remaining: maxSize}
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{body, closer}, nil
+}
+
+// limitedReader is an io.LimitedReader that fails instead of reporting EOF
+// when the limit is exceeded, so that a truncated body is never mistaken for
+// a complete one.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
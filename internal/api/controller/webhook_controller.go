@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/webhooks"
+	"github.com/rs/zerolog"
+)
+
+// WebhookController handles HTTP requests managing the webhooks of the
+// repositories and their deliveries. It requires admin access to the
+// repository.
+type WebhookController struct {
+	Logger     zerolog.Logger               // Logger for request logging and error reporting
+	Storage    storage.GitRepositoryStorage // Storage backend, to check that the repositories exist
+	Access     *rbac.Store                  // Access control, nil when disabled
+	Dispatcher *webhooks.Dispatcher         // Webhooks and their deliveries
+}
+
+// ListHooks handles GET requests listing the webhooks of a repository.
+//
+// Response: 200 OK with JSON array of webhooks, secrets redacted
+func (c *WebhookController) ListHooks(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "ListHooks").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	hooks := c.Dispatcher.Store.Hooks(repoPath)
+	for i := range hooks {
+		hooks[i] = hooks[i].Redacted()
+	}
+	return ctx.JSON(hooks)
+}
+
+// CreateHook handles POST requests registering a webhook on a repository.
+// Events are push (the default), create and delete.
+//
+// Request body: {"url": "https://ci.example.com/hook", "secret": "...", "events": ["push"], "active": true}
+// Response: 201 Created with the webhook, secret redacted
+func (c *WebhookController) CreateHook(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "CreateHook").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	req := webhooks.Hook{Active: true}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	hook, err := c.Dispatcher.Store.AddHook(repoPath, req)
+	if errors.Is(err, webhooks.ErrInvalidHook) {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to save webhook")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to save webhook")
+	}
+
+	logger.Info().Str("repo", repoPath).Str("hook", hook.ID).Str("url", hook.URL).Msg("Webhook created")
+	return ctx.Status(fiber.StatusCreated).JSON(hook.Redacted())
+}
+
+// GetHook handles GET requests returning a webhook of a repository.
+//
+// Response: 200 OK with the webhook, secret redacted, 404 Not Found if there is no such webhook
+func (c *WebhookController) GetHook(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "GetHook").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	hook, err := c.Dispatcher.Store.Hook(repoPath, ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusNotFound).SendString("webhook not found")
+	}
+	return ctx.JSON(hook.Redacted())
+}
+
+// DeleteHook handles DELETE requests removing a webhook and its deliveries.
+//
+// Response: 204 No Content, 404 Not Found if there is no such webhook
+func (c *WebhookController) DeleteHook(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "DeleteHook").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	err := c.Dispatcher.Store.DeleteHook(repoPath, ctx.Params("id"))
+	if errors.Is(err, webhooks.ErrNotFound) {
+		return ctx.Status(fiber.StatusNotFound).SendString("webhook not found")
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to delete webhook")
+		return ctx.Status(fiber.StatusInternalServerError).SendString("failed to delete webhook")
+	}
+
+	logger.Info().Str("repo", repoPath).Str("hook", ctx.Params("id")).Msg("Webhook deleted")
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// ListDeliveries handles GET requests listing the deliveries of a webhook,
+// the most recent first.
+//
+// Response: 200 OK with JSON array of deliveries, 404 Not Found if there is no such webhook
+func (c *WebhookController) ListDeliveries(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "ListDeliveries").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	deliveries, err := c.Dispatcher.Store.Deliveries(repoPath, ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusNotFound).SendString("webhook not found")
+	}
+	return ctx.JSON(deliveries)
+}
+
+// Redeliver handles POST requests sending the payload of a delivery again.
+//
+// Response: 202 Accepted with the new delivery, 404 Not Found if there is no such webhook or delivery,
+// 503 Service Unavailable once the server is shutting down
+func (c *WebhookController) Redeliver(ctx *fiber.Ctx) error {
+	logger := c.Logger.With().Str("event", "Redeliver").Logger()
+
+	repoPath, ok := authorizeRepository(ctx, logger, c.Storage, c.Access, rbac.Admin)
+	if !ok {
+		return nil
+	}
+
+	delivery, err := c.Dispatcher.Redeliver(repoPath, ctx.Params("id"), ctx.Params("delivery"))
+	if errors.Is(err, webhooks.ErrStopped) {
+		return ctx.Status(fiber.StatusServiceUnavailable).SendString("server shutting down")
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusNotFound).SendString("delivery not found")
+	}
+
+	logger.Info().Str("repo", repoPath).Str("hook", delivery.HookID).Str("delivery", delivery.ID).Msg("Webhook redelivery queued")
+	return ctx.Status(fiber.StatusAccepted).JSON(delivery)
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/labbs/git-server-s3/pkg/webhooks"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookController(t *testing.T) {
+	type received struct {
+		event, signature string
+		body             []byte
+	}
+	deliveries := make(chan received, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- received{r.Header.Get(webhooks.HeaderEvent), r.Header.Get(webhooks.HeaderSignature), body}
+	}))
+	defer receiver.Close()
+
+	config.Storage.Local.Path = t.TempDir()
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	require.NoError(t, str.CreateRepository("repo.git"))
+	dir := t.TempDir()
+	store, err := webhooks.Load(filepath.Join(dir, "webhooks.json"), filepath.Join(dir, "deliveries"))
+	require.NoError(t, err)
+	dispatcher := webhooks.NewDispatcher(store, str, zerolog.Nop())
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	gc := &GitController{Logger: zerolog.Nop(), Storage: str, Hooks: &hooks.Runner{
+		Logger:      zerolog.Nop(),
+		PostReceive: []hooks.PostReceiveHook{dispatcher},
+	}}
+	app.Post("/+/git-receive-pack", gc.HandleReceivePack)
+
+	wc := &WebhookController{Logger: zerolog.Nop(), Storage: str, Dispatcher: dispatcher}
+	app.Get("/api/repos/+/hooks", wc.ListHooks)
+	app.Post("/api/repos/+/hooks", wc.CreateHook)
+	app.Get("/api/repos/+/hooks/:id", wc.GetHook)
+	app.Delete("/api/repos/+/hooks/:id", wc.DeleteHook)
+	app.Get("/api/repos/+/hooks/:id/deliveries", wc.ListDeliveries)
+	app.Post("/api/repos/+/hooks/:id/deliveries/:delivery/redeliver", wc.Redeliver)
+
+	request := func(method, target, body string) (int, string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(data)
+	}
+
+	status, _ := request(fiber.MethodPost, "/api/repos/missing/hooks", `{"url": "`+receiver.URL+`"}`)
+	assert.Equal(t, fiber.StatusNotFound, status)
+	status, _ = request(fiber.MethodPost, "/api/repos/repo/hooks", `{"url": "ftp://example.com"}`)
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	status, body := request(fiber.MethodPost, "/api/repos/repo/hooks", `{"url": "`+receiver.URL+`", "secret": "s3cr3t", "events": ["push", "create"]}`)
+	require.Equal(t, fiber.StatusCreated, status, body)
+	var hook webhooks.Hook
+	require.NoError(t, json.Unmarshal([]byte(body), &hook))
+	assert.NotEmpty(t, hook.ID)
+	assert.True(t, hook.Active)
+	assert.Equal(t, "********", hook.Secret)
+
+	status, body = request(fiber.MethodGet, "/api/repos/repo.git/hooks/"+hook.ID, "")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.NotContains(t, body, "s3cr3t")
+
+	// A push creating a branch is sent as push and create events
+	push, commit := newPushRequest(t, 1<<10)
+	req := httptest.NewRequest(fiber.MethodPost, "/repo.git/git-receive-pack", bytes.NewReader(push))
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	dispatcher.Wait()
+
+	events := map[string]received{}
+	for range 2 {
+		delivery := <-deliveries
+		events[delivery.event] = delivery
+	}
+	require.Contains(t, events, "push")
+	require.Contains(t, events, "create")
+	assert.Equal(t, webhooks.Sign("s3cr3t", events["push"].body), events["push"].signature)
+
+	var payload webhooks.Payload
+	require.NoError(t, json.Unmarshal(events["push"].body, &payload))
+	assert.Equal(t, "refs/heads/feature", payload.Ref)
+	assert.Equal(t, commit.String(), payload.After)
+	assert.True(t, payload.Created)
+	assert.Equal(t, "repo.git", payload.Repository)
+	require.Len(t, payload.Commits, 1)
+	assert.Equal(t, "add data", payload.Commits[0].Message)
+
+	status, body = request(fiber.MethodGet, "/api/repos/repo/hooks/"+hook.ID+"/deliveries", "")
+	require.Equal(t, fiber.StatusOK, status)
+	var logged []webhooks.Delivery
+	require.NoError(t, json.Unmarshal([]byte(body), &logged))
+	require.Len(t, logged, 2)
+	assert.True(t, logged[0].Delivered)
+	assert.Equal(t, http.StatusOK, logged[0].StatusCode)
+
+	status, _ = request(fiber.MethodPost, "/api/repos/repo/hooks/"+hook.ID+"/deliveries/"+logged[0].ID+"/redeliver", "")
+	assert.Equal(t, fiber.StatusAccepted, status)
+	dispatcher.Wait()
+	redelivered := <-deliveries
+	assert.Equal(t, string(logged[0].Event), redelivered.event)
+	assert.JSONEq(t, string(logged[0].Payload), string(redelivered.body))
+
+	status, _ = request(fiber.MethodPost, "/api/repos/repo/hooks/"+hook.ID+"/deliveries/unknown/redeliver", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+
+	// Shutting down
+	dispatcher.Stop()
+	status, _ = request(fiber.MethodPost, "/api/repos/repo/hooks/"+hook.ID+"/deliveries/"+logged[0].ID+"/redeliver", "")
+	assert.Equal(t, fiber.StatusServiceUnavailable, status)
+
+	status, _ = request(fiber.MethodDelete, "/api/repos/repo/hooks/"+hook.ID, "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+	status, body = request(fiber.MethodGet, "/api/repos/repo/hooks", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.JSONEq(t, "[]", body)
+}
@@ -0,0 +1,93 @@
+// Package middleware provides the Fiber middlewares of the API.
+package middleware
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/rs/zerolog"
+)
+
+// identityKey is the key of the authenticated identity in the request locals
+const identityKey = "identity"
+
+// realm is the protection space announced in WWW-Authenticate challenges
+const realm = "git-server-s3"
+
+// AuthConfig configures the authentication middleware
+type AuthConfig struct {
+	Logger       zerolog.Logger
+	Tokens       *auth.TokenStore
+	BearerTokens bool // Also accept tokens sent as "Authorization: Bearer <token>"
+}
+
+// Auth returns a middleware rejecting the requests without valid credentials
+// with a 401 and the WWW-Authenticate challenge expected by git credential
+// helpers. Users authenticate with HTTP Basic, their personal access token
+// as password. The health endpoints stay open for probes.
+func Auth(cfg AuthConfig) fiber.Handler {
+	logger := cfg.Logger.With().Str("component", "auth").Logger()
+
+	return func(ctx *fiber.Ctx) error {
+		if isPublicPath(ctx.Path()) {
+			return ctx.Next()
+		}
+
+		identity, ok := authenticate(ctx.Get(fiber.HeaderAuthorization), cfg)
+		if !ok {
+			if ctx.Get(fiber.HeaderAuthorization) != "" {
+				logger.Warn().
+					Str("path", ctx.Path()).
+					Str("remote", ctx.IP()).
+					Msg("Invalid credentials")
+			}
+			ctx.Append(fiber.HeaderWWWAuthenticate, `Basic realm="`+realm+`"`)
+			if cfg.BearerTokens {
+				ctx.Append(fiber.HeaderWWWAuthenticate, `Bearer realm="`+realm+`"`)
+			}
+			return ctx.Status(fiber.StatusUnauthorized).SendString("authentication required")
+		}
+
+		ctx.Locals(identityKey, identity)
+		return ctx.Next()
+	}
+}
+
+// Identity returns the authenticated identity of a request, nil when
+// authentication is disabled
+func Identity(ctx *fiber.Ctx) *auth.Identity {
+	identity, _ := ctx.Locals(identityKey).(*auth.Identity)
+	return identity
+}
+
+// isPublicPath reports whether path is served without authentication
+func isPublicPath(path string) bool {
+	return path == "/health" || strings.HasPrefix(path, "/health/")
+}
+
+// authenticate checks the credentials of an Authorization header
+func authenticate(header string, cfg AuthConfig) (*auth.Identity, bool) {
+	scheme, credentials, ok := strings.Cut(header, " ")
+	if !ok {
+		return nil, false
+	}
+
+	switch {
+	case strings.EqualFold(scheme, "Basic"):
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+		if err != nil {
+			return nil, false
+		}
+		username, token, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return nil, false
+		}
+		return cfg.Tokens.AuthenticateBasic(username, token)
+	case strings.EqualFold(scheme, "Bearer") && cfg.BearerTokens:
+		return cfg.Tokens.Authenticate(strings.TrimSpace(credentials))
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupAuthTestApp(t *testing.T, bearer bool) *fiber.App {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(path, []byte("alice "+auth.HashToken("secret")+"\n"), 0600))
+	tokens, err := auth.LoadTokenStore(path)
+	require.NoError(t, err)
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use(Auth(AuthConfig{Logger: zerolog.Nop(), Tokens: tokens, BearerTokens: bearer}))
+	app.Get("/health", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	app.Get("/+/info/refs", func(c *fiber.Ctx) error {
+		return c.SendString(Identity(c).Username)
+	})
+	return app
+}
+
+func TestAuth(t *testing.T) {
+	app := setupAuthTestApp(t, false)
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"no credentials", "", fiber.StatusUnauthorized},
+		{"valid token", "Basic YWxpY2U6c2VjcmV0", fiber.StatusOK},           // alice:secret
+		{"wrong token", "Basic YWxpY2U6d3Jvbmc=", fiber.StatusUnauthorized}, // alice:wrong
+		{"wrong user", "Basic Ym9iOnNlY3JldA==", fiber.StatusUnauthorized},  // bob:secret
+		{"malformed", "Basic not-base64", fiber.StatusUnauthorized},
+		{"bearer disabled", "Bearer secret", fiber.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(fiber.MethodGet, "/repo.git/info/refs", nil)
+			if tt.authorization != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.authorization)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			if tt.status == fiber.StatusUnauthorized {
+				assert.Equal(t, `Basic realm="git-server-s3"`, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+			}
+		})
+	}
+}
+
+func TestAuthBearer(t *testing.T) {
+	app := setupAuthTestApp(t, true)
+
+	req := httptest.NewRequest(fiber.MethodGet, "/repo.git/info/refs", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer secret")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	req = httptest.NewRequest(fiber.MethodGet, "/repo.git/info/refs", nil)
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, `Basic realm="git-server-s3", Bearer realm="git-server-s3"`, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+}
+
+func TestAuthHealthIsOpen(t *testing.T) {
+	app := setupAuthTestApp(t, false)
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/health", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,26 @@
+package router
+
+import "github.com/labbs/git-server-s3/internal/api/controller"
+
+// NewAccessRouter configures the access control management endpoints.
+//
+// Endpoints:
+//   - GET    /api/grants       - List the grants on the resources the user administers
+//   - POST   /api/grants       - Grant a role to a user or team on a resource
+//   - DELETE /api/grants       - Remove a grant (query: user or team, resource)
+//   - GET    /api/teams        - List the teams (site admins)
+//   - PUT    /api/teams/:name  - Create or replace a team (site admins)
+//   - DELETE /api/teams/:name  - Delete a team and its grants (site admins)
+func NewAccessRouter(c *Config) {
+	ac := controller.AccessController{
+		Logger: c.Logger,
+		Access: c.Access,
+	}
+
+	c.Fiber.Get("/api/grants", ac.ListGrants)
+	c.Fiber.Post("/api/grants", ac.SetGrant)
+	c.Fiber.Delete("/api/grants", ac.RemoveGrant)
+	c.Fiber.Get("/api/teams", ac.ListTeams)
+	c.Fiber.Put("/api/teams/:name", ac.SetTeam)
+	c.Fiber.Delete("/api/teams/:name", ac.DeleteTeam)
+}
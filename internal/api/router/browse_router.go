@@ -0,0 +1,27 @@
+package router
+
+import "github.com/labbs/git-server-s3/internal/api/controller"
+
+// NewBrowseRouter configures the read-only repository browsing endpoints.
+//
+// Endpoints:
+//   - GET /api/repos/{repo}/branches      - List the branches
+//   - GET /api/repos/{repo}/tags          - List the tags
+//   - GET /api/repos/{repo}/commits       - Log a revision (query: ref, page, per_page)
+//   - GET /api/repos/{repo}/commits/{sha} - Show a commit
+//   - GET /api/repos/{repo}/tree          - List a directory (query: ref, path)
+//   - GET /api/repos/{repo}/raw           - Fetch the content of a file (query: ref, path)
+func NewBrowseRouter(c *Config) {
+	bc := controller.BrowseController{
+		Logger:  c.Logger,
+		Storage: c.Storage,
+		Access:  c.Access,
+	}
+
+	c.Fiber.Get("/api/repos/+/branches", bc.ListBranches)
+	c.Fiber.Get("/api/repos/+/tags", bc.ListTags)
+	c.Fiber.Get("/api/repos/+/commits", bc.ListCommits)
+	c.Fiber.Get("/api/repos/+/commits/:sha", bc.GetCommit)
+	c.Fiber.Get("/api/repos/+/tree", bc.GetTree)
+	c.Fiber.Get("/api/repos/+/raw", bc.GetRaw)
+}
@@ -1,14 +1,25 @@
 package router
 
-import "github.com/labbs/git-server-s3/internal/api/controller"
+import (
+	"github.com/labbs/git-server-s3/internal/api/controller"
+	"github.com/labbs/git-server-s3/internal/config"
+)
 
+// NewGitRouter configures the Git Smart HTTP endpoints.
+// Repository paths may be nested in any number of namespaces
+// (e.g., /team/sub/project.git/info/refs): the greedy "+" parameter matches
+// the whole repository path, which the controller extracts from the URL.
 func NewGitRouter(c *Config) {
 	gc := controller.GitController{
-		Logger:  c.Logger,
-		Storage: c.Storage,
+		Logger:      c.Logger,
+		Storage:     c.Storage,
+		MaxBodySize: config.Server.MaxBodySize,
+		Access:      c.Access,
+		AutoCreate:  c.AutoCreate,
+		Hooks:       c.Hooks,
 	}
 
-	c.Fiber.Get("/:repo/info/refs", gc.InfoRefs)
-	c.Fiber.Post("/:repo/git-upload-pack", gc.HandleUploadPack)
-	c.Fiber.Post("/:repo/git-receive-pack", gc.HandleReceivePack)
+	c.Fiber.Get("/+/info/refs", gc.InfoRefs)
+	c.Fiber.Post("/+/git-upload-pack", gc.HandleUploadPack)
+	c.Fiber.Post("/+/git-receive-pack", gc.HandleReceivePack)
 }
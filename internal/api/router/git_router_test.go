@@ -0,0 +1,71 @@
+package router
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitRouter_NestedRepositories(t *testing.T) {
+	config.Storage.Local.Path = t.TempDir()
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	require.NoError(t, storage.InitRepository(str, "team/sub/project.git", storage.InitOptions{Readme: true}))
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	NewGitRouter(&Config{Logger: zerolog.Nop(), Fiber: app, Storage: str})
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		status int
+	}{
+		{
+			name:   "nested upload-pack advertisement",
+			method: fiber.MethodGet,
+			url:    "/team/sub/project.git/info/refs?service=git-upload-pack",
+			status: fiber.StatusOK,
+		},
+		{
+			name:   "nested receive-pack advertisement",
+			method: fiber.MethodGet,
+			url:    "/team/sub/project.git/info/refs?service=git-receive-pack",
+			status: fiber.StatusOK,
+		},
+		{
+			name:   "nested upload-pack without request",
+			method: fiber.MethodPost,
+			url:    "/team/sub/project.git/git-upload-pack",
+			status: fiber.StatusBadRequest,
+		},
+		{
+			name:   "parent directory segment",
+			method: fiber.MethodGet,
+			url:    "/team/../project.git/info/refs?service=git-upload-pack",
+			status: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest(tt.method, tt.url, nil))
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			if tt.status == fiber.StatusOK {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Contains(t, string(body), "refs/heads/main")
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package router
+
+import (
+	"github.com/labbs/git-server-s3/internal/api/controller"
+	"github.com/labbs/git-server-s3/internal/config"
+)
+
+// NewLFSRouter configures the Git LFS API, next to the smart HTTP endpoints
+// of the repositories.
+//
+// Endpoints:
+//   - POST /{repo}/info/lfs/objects/batch      - Negotiate the transfers of objects
+//   - GET  /{repo}/info/lfs/objects/{oid}      - Download an object
+//   - PUT  /{repo}/info/lfs/objects/{oid}      - Upload an object
+//   - POST /{repo}/info/lfs/verify             - Verify an uploaded object
+//   - GET  /{repo}/info/lfs/locks              - List the file locks
+//   - POST /{repo}/info/lfs/locks              - Lock a file
+//   - POST /{repo}/info/lfs/locks/verify       - List the locks of the user and the others
+//   - POST /{repo}/info/lfs/locks/{id}/unlock  - Unlock a file
+func NewLFSRouter(c *Config) {
+	lc := controller.LFSController{
+		Logger:      c.Logger,
+		Storage:     c.Storage,
+		Objects:     c.LFS,
+		Locks:       c.LFSLocks,
+		Access:      c.Access,
+		MaxBodySize: config.Server.MaxBodySize,
+	}
+	if config.LFS.Presign {
+		lc.PresignExpiry = config.LFS.PresignExpiry
+	}
+
+	c.Fiber.Post("/+/info/lfs/objects/batch", lc.Batch)
+	c.Fiber.Get("/+/info/lfs/objects/:oid", lc.Download)
+	c.Fiber.Put("/+/info/lfs/objects/:oid", lc.Upload)
+	c.Fiber.Post("/+/info/lfs/verify", lc.Verify)
+	c.Fiber.Get("/+/info/lfs/locks", lc.ListLocks)
+	c.Fiber.Post("/+/info/lfs/locks/verify", lc.VerifyLocks)
+	c.Fiber.Post("/+/info/lfs/locks/:id/unlock", lc.Unlock)
+	c.Fiber.Post("/+/info/lfs/locks", lc.CreateLock)
+}
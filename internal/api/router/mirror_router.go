@@ -0,0 +1,28 @@
+package router
+
+import (
+	"github.com/labbs/git-server-s3/internal/api/controller"
+	"github.com/labbs/git-server-s3/internal/config"
+)
+
+// NewMirrorRouter configures the pull mirror management endpoints.
+//
+// Endpoints:
+//   - GET    /api/repos/{repo}/mirror      - Get the upstream and synchronization status of a mirror
+//   - PUT    /api/repos/{repo}/mirror      - Make a repository a mirror of an upstream repository
+//   - DELETE /api/repos/{repo}/mirror      - Stop mirroring a repository
+//   - POST   /api/repos/{repo}/mirror/sync - Synchronize a mirror right away
+func NewMirrorRouter(c *Config) {
+	mc := controller.MirrorController{
+		Logger:          c.Logger,
+		Storage:         c.Storage,
+		Access:          c.Access,
+		Mirrors:         c.Mirrors,
+		DefaultInterval: config.Mirrors.Interval,
+	}
+
+	c.Fiber.Get("/api/repos/+/mirror", mc.GetMirror)
+	c.Fiber.Put("/api/repos/+/mirror", mc.SetMirror)
+	c.Fiber.Delete("/api/repos/+/mirror", mc.RemoveMirror)
+	c.Fiber.Post("/api/repos/+/mirror/sync", mc.SyncMirror)
+}
@@ -0,0 +1,22 @@
+package router
+
+import "github.com/labbs/git-server-s3/internal/api/controller"
+
+// NewProtectionRouter configures the branch protection management endpoints.
+//
+// Endpoints:
+//   - GET    /api/repos/{repo}/protections - List the branch protection rules
+//   - PUT    /api/repos/{repo}/protections - Protect the branches matching a pattern
+//   - DELETE /api/repos/{repo}/protections - Remove the rule of a pattern (query: pattern)
+func NewProtectionRouter(c *Config) {
+	pc := controller.ProtectionController{
+		Logger:      c.Logger,
+		Storage:     c.Storage,
+		Access:      c.Access,
+		Protections: c.Protections,
+	}
+
+	c.Fiber.Get("/api/repos/+/protections", pc.ListRules)
+	c.Fiber.Put("/api/repos/+/protections", pc.SetRule)
+	c.Fiber.Delete("/api/repos/+/protections", pc.RemoveRule)
+}
@@ -0,0 +1,26 @@
+package router
+
+import "github.com/labbs/git-server-s3/internal/api/controller"
+
+// NewPushMirrorRouter configures the push mirror management endpoints.
+//
+// Endpoints:
+//   - GET    /api/repos/{repo}/push-mirrors          - List the push mirrors
+//   - POST   /api/repos/{repo}/push-mirrors          - Register a push mirror
+//   - GET    /api/repos/{repo}/push-mirrors/:id      - Get a push mirror and its replication status
+//   - DELETE /api/repos/{repo}/push-mirrors/:id      - Delete a push mirror
+//   - POST   /api/repos/{repo}/push-mirrors/:id/sync - Replicate the repository to a push mirror
+func NewPushMirrorRouter(c *Config) {
+	pc := controller.PushMirrorController{
+		Logger:  c.Logger,
+		Storage: c.Storage,
+		Access:  c.Access,
+		Pusher:  c.PushMirrors,
+	}
+
+	c.Fiber.Get("/api/repos/+/push-mirrors", pc.ListPushMirrors)
+	c.Fiber.Post("/api/repos/+/push-mirrors", pc.CreatePushMirror)
+	c.Fiber.Get("/api/repos/+/push-mirrors/:id", pc.GetPushMirror)
+	c.Fiber.Delete("/api/repos/+/push-mirrors/:id", pc.DeletePushMirror)
+	c.Fiber.Post("/api/repos/+/push-mirrors/:id/sync", pc.SyncPushMirror)
+}
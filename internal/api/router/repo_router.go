@@ -4,10 +4,22 @@ import "github.com/labbs/git-server-s3/internal/api/controller"
 
 func NewRepoRouter(c *Config) {
 	gc := controller.RepoController{
-		Logger:  c.Logger,
-		Storage: c.Storage,
+		Logger:      c.Logger,
+		Storage:     c.Storage,
+		Access:      c.Access,
+		Protections: c.Protections,
+		LFS:         c.LFS,
+		LFSLocks:    c.LFSLocks,
+		Mirrors:     c.Mirrors,
+		PushMirrors: c.PushMirrors,
+	}
+	if c.Webhooks != nil {
+		gc.Webhooks = c.Webhooks.Store
 	}
 
 	c.Fiber.Post("/api/repo", gc.CreateRepo)
 	c.Fiber.Get("/api/repos", gc.ListRepos)
+	c.Fiber.Get("/api/repos/+", gc.GetRepo)
+	c.Fiber.Patch("/api/repos/+", gc.UpdateRepo)
+	c.Fiber.Delete("/api/repos/+", gc.DeleteRepo)
 }
@@ -3,7 +3,14 @@ package router
 import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/autocreate"
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/labbs/git-server-s3/pkg/lfs"
+	"github.com/labbs/git-server-s3/pkg/mirror"
+	"github.com/labbs/git-server-s3/pkg/protection"
+	"github.com/labbs/git-server-s3/pkg/rbac"
 	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/webhooks"
 	"github.com/rs/zerolog"
 )
 
@@ -11,13 +18,48 @@ type Config struct {
 	Logger  zerolog.Logger
 	Fiber   *fiber.App
 	Storage storage.GitRepositoryStorage
+	Access  *rbac.Store // Access control, nil when disabled
+	// AutoCreate creates missing repositories on push
+	AutoCreate *autocreate.Creator
+	// Hooks run before and after the reference updates of the pushes
+	Hooks *hooks.Runner
+	// Webhooks of the repositories, nil when disabled
+	Webhooks *webhooks.Dispatcher
+	// Protections are the branch protection rules, nil when disabled
+	Protections *protection.Store
+	// LFS stores the Git LFS objects, the LFS API is disabled when nil
+	LFS      storage.LFSStorage
+	LFSLocks *lfs.LockStore
+	// Mirrors are the pull mirrors, nil when disabled
+	Mirrors *mirror.Mirrorer
+	// PushMirrors replicates the pushes to the push mirrors, nil when disabled
+	PushMirrors *mirror.Pusher
 }
 
 func (c *Config) Configure() {
 	c.Logger.Info().Msg("Configuring API routes")
 
 	NewGitRouter(c)
+	if c.LFS != nil {
+		NewLFSRouter(c)
+	}
+	if c.Webhooks != nil {
+		NewWebhookRouter(c)
+	}
+	if c.Protections != nil {
+		NewProtectionRouter(c)
+	}
+	if c.Mirrors != nil {
+		NewMirrorRouter(c)
+	}
+	if c.PushMirrors != nil {
+		NewPushMirrorRouter(c)
+	}
+	NewBrowseRouter(c)
 	NewRepoRouter(c)
+	if c.Access != nil {
+		NewAccessRouter(c)
+	}
 	if config.Debug.Endpoints {
 		NewDebugRouter(c)
 	}
@@ -0,0 +1,29 @@
+package router
+
+import "github.com/labbs/git-server-s3/internal/api/controller"
+
+// NewWebhookRouter configures the webhook management endpoints. The
+// repository path may be nested in namespaces, like for the Git endpoints.
+//
+// Endpoints:
+//   - GET    /api/repos/{repo}/hooks                                   - List the webhooks
+//   - POST   /api/repos/{repo}/hooks                                   - Register a webhook
+//   - GET    /api/repos/{repo}/hooks/:id                               - Get a webhook
+//   - DELETE /api/repos/{repo}/hooks/:id                               - Delete a webhook
+//   - GET    /api/repos/{repo}/hooks/:id/deliveries                    - List the deliveries of a webhook
+//   - POST   /api/repos/{repo}/hooks/:id/deliveries/:delivery/redeliver - Send a delivery again
+func NewWebhookRouter(c *Config) {
+	wc := controller.WebhookController{
+		Logger:     c.Logger,
+		Storage:    c.Storage,
+		Access:     c.Access,
+		Dispatcher: c.Webhooks,
+	}
+
+	c.Fiber.Get("/api/repos/+/hooks", wc.ListHooks)
+	c.Fiber.Post("/api/repos/+/hooks", wc.CreateHook)
+	c.Fiber.Get("/api/repos/+/hooks/:id", wc.GetHook)
+	c.Fiber.Delete("/api/repos/+/hooks/:id", wc.DeleteHook)
+	c.Fiber.Get("/api/repos/+/hooks/:id/deliveries", wc.ListDeliveries)
+	c.Fiber.Post("/api/repos/+/hooks/:id/deliveries/:delivery/redeliver", wc.Redeliver)
+}
@@ -11,8 +11,18 @@ import (
 	"github.com/labbs/git-server-s3/internal/config"
 	flags "github.com/labbs/git-server-s3/internal/flags"
 	"github.com/labbs/git-server-s3/internal/server"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/autocreate"
+	"github.com/labbs/git-server-s3/pkg/health"
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/labbs/git-server-s3/pkg/lfs"
 	"github.com/labbs/git-server-s3/pkg/logger"
+	"github.com/labbs/git-server-s3/pkg/mirror"
+	"github.com/labbs/git-server-s3/pkg/protection"
+	"github.com/labbs/git-server-s3/pkg/rbac"
 	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/tracing"
+	"github.com/labbs/git-server-s3/pkg/webhooks"
 
 	"github.com/urfave/cli/v3"
 )
@@ -36,6 +46,12 @@ func getFlags() (list []cli.Flag) {
 	list = append(list, flags.ServerFlags()...)
 	list = append(list, flags.LoggerFlags()...)
 	list = append(list, flags.StorageFlags()...)
+	list = append(list, flags.AuthFlags()...)
+	list = append(list, flags.ReposFlags()...)
+	list = append(list, flags.HooksFlags()...)
+	list = append(list, flags.WebhooksFlags()...)
+	list = append(list, flags.LFSFlags()...)
+	list = append(list, flags.MirrorsFlags()...)
 	return
 }
 
@@ -43,6 +59,18 @@ func getFlags() (list []cli.Flag) {
 func runServer(ctx context.Context, c *cli.Command) error {
 	l := logger.NewLogger(config.Logger.Level, config.Logger.Pretty, c.Root().Version)
 
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Exporter:    config.Tracing.Exporter,
+		Endpoint:    config.Tracing.Endpoint,
+		File:        config.Tracing.File,
+		SampleRatio: config.Tracing.SampleRatio,
+		Version:     config.Version,
+	})
+	if err != nil {
+		l.Fatal().Err(err).Msg("Failed to set up tracing")
+		return err
+	}
+
 	str, err := storage.NewGitRepositoryStorage(l)
 	if err != nil {
 		return err
@@ -65,9 +93,129 @@ func runServer(ctx context.Context, c *cli.Command) error {
 	var httpConfig server.HttpConfig
 	httpConfig.Port = config.Server.Port
 	httpConfig.HttpLogs = config.Server.HttpLogs
+	httpConfig.Metrics = config.Metrics.Enabled
+	httpConfig.Tracing = config.Tracing.Exporter != tracing.ExporterNone
 	httpConfig.Logger = l
+	checker := health.NewChecker(config.Health.Timeout)
+	checker.Add("storage", str.Check)
+	httpConfig.Health = checker
 	httpConfig.Storage = str
 
+	var tokens *auth.TokenStore
+	var access *rbac.Store
+	if config.Auth.Enabled {
+		tokens, err = auth.LoadTokenStore(config.Auth.TokensFile)
+		if err != nil {
+			l.Fatal().Err(err).Msg("Failed to load personal access tokens")
+			return err
+		}
+		access, err = rbac.Load(config.Auth.GrantsFile, config.Auth.Admins)
+		if err != nil {
+			l.Fatal().Err(err).Msg("Failed to load repository grants")
+			return err
+		}
+		access.Public = func(repoPath string) bool { return storage.IsPublic(str, repoPath) }
+		if len(config.Auth.Admins) == 0 {
+			l.Warn().Msg("No site admin configured, repositories can only be created by users granted admin on them")
+		}
+		httpConfig.Tokens = tokens
+		httpConfig.BearerTokens = config.Auth.BearerTokens
+		httpConfig.Access = access
+	} else {
+		l.Warn().Msg("HTTP authentication is disabled, anyone can read and push to every repository")
+	}
+
+	policy, err := autocreate.ParsePolicy(config.Repos.AutoCreate)
+	if err != nil {
+		l.Fatal().Err(err).Msg("Invalid repository auto-create policy")
+		return err
+	}
+	if policy == autocreate.Owned && !config.Auth.Enabled {
+		l.Warn().Msg("Repository auto-create is restricted to owned namespaces but authentication is disabled, no repository will be created on push")
+	}
+	autoCreate := &autocreate.Creator{Policy: policy, Storage: str, Access: access}
+	httpConfig.AutoCreate = autoCreate
+
+	protections, err := protection.Load(config.Protection.File)
+	if err != nil {
+		l.Fatal().Err(err).Msg("Failed to load branch protections")
+		return err
+	}
+	httpConfig.Protections = protections
+
+	mirrors, err := mirror.Load(config.Mirrors.File)
+	if err != nil {
+		l.Fatal().Err(err).Msg("Failed to load mirrors")
+		return err
+	}
+	mirrorer := mirror.NewMirrorer(mirrors, str, l)
+	mirrorer.SSHKey = config.Mirrors.SSHKey
+	mirrorer.Timeout = config.Mirrors.Timeout
+	httpConfig.Mirrors = mirrorer
+
+	pushMirrors, err := mirror.LoadPush(config.Mirrors.PushFile)
+	if err != nil {
+		l.Fatal().Err(err).Msg("Failed to load push mirrors")
+		return err
+	}
+	pusher := mirror.NewPusher(pushMirrors, str, l)
+	pusher.SSHKey = config.Mirrors.SSHKey
+	pusher.Timeout = config.Mirrors.Timeout
+	pusher.Attempts = config.Mirrors.PushAttempts
+	pusher.Backoff = config.Mirrors.PushBackoff
+	httpConfig.PushMirrors = pusher
+
+	// In-process hooks are registered by the packages linked in, the
+	// executables come from the configuration. The pushes to the mirrors are
+	// rejected and branch protection is checked before any of them.
+	runner := hooks.NewRunner(l)
+	runner.PreReceive = append([]hooks.PreReceiveHook{mirrors, protections}, runner.PreReceive...)
+	for _, path := range config.Hooks.PreReceive {
+		runner.PreReceive = append(runner.PreReceive, &hooks.Command{Path: path, Timeout: config.Hooks.Timeout})
+	}
+	for _, path := range config.Hooks.PostReceive {
+		runner.PostReceive = append(runner.PostReceive, &hooks.Command{Path: path, Timeout: config.Hooks.Timeout})
+	}
+
+	webhookStore, err := webhooks.Load(config.Webhooks.File, config.Webhooks.DeliveriesDir)
+	if err != nil {
+		l.Fatal().Err(err).Msg("Failed to load webhooks")
+		return err
+	}
+	dispatcher := webhooks.NewDispatcher(webhookStore, str, l)
+	dispatcher.Client.Timeout = config.Webhooks.Timeout
+	dispatcher.Attempts = config.Webhooks.Attempts
+	dispatcher.Backoff = config.Webhooks.Backoff
+	runner.PostReceive = append(runner.PostReceive, dispatcher, pusher)
+
+	httpConfig.Hooks = runner
+	httpConfig.Webhooks = dispatcher
+
+	if config.LFS.Enabled {
+		lfsStorage, err := storage.NewLFSStorage(str)
+		if err != nil {
+			l.Fatal().Err(err).Msg("Failed to configure LFS storage")
+			return err
+		}
+		locks, err := lfs.LoadLocks(config.LFS.LocksFile)
+		if err != nil {
+			l.Fatal().Err(err).Msg("Failed to load LFS locks")
+			return err
+		}
+		if config.LFS.Presign {
+			if _, ok := lfsStorage.(storage.LFSPresigner); !ok {
+				l.Warn().Msg("LFS presigned URLs require the S3 storage, the objects are transferred through the server")
+			}
+		}
+		httpConfig.LFS = lfsStorage
+		httpConfig.LFSLocks = locks
+	}
+
+	if err := mirrorer.Start(); err != nil {
+		l.Fatal().Err(err).Msg("Failed to schedule the mirror synchronizations")
+		return err
+	}
+
 	// Start HTTP server in a goroutine
 	wg.Add(1)
 	go func() {
@@ -87,12 +235,29 @@ func runServer(ctx context.Context, c *cli.Command) error {
 			HostKeyPath: config.SSH.HostKeyPath,
 			Logger:      l,
 			Storage:     str,
+			AutoCreate:  autoCreate,
+			Hooks:       runner,
+		}
+
+		if config.Auth.Enabled {
+			keys, err := auth.LoadKeyStore(config.Auth.AuthorizedKeys)
+			if err != nil {
+				l.Fatal().Err(err).Msg("Failed to load SSH authorized keys")
+				return err
+			}
+			authenticator := &auth.KeyAuthenticator{Keys: keys}
+			if config.Auth.SSHPassword {
+				authenticator.Tokens = tokens
+			}
+			sshConfig.Authenticator = authenticator
+			sshConfig.Access = access
 		}
 
 		if err := sshConfig.Configure(); err != nil {
 			l.Fatal().Err(err).Msg("Failed to configure SSH server")
 			return err
 		}
+		checker.Add("ssh", sshConfig.Check)
 
 		wg.Add(1)
 		go func() {
@@ -107,6 +272,9 @@ func runServer(ctx context.Context, c *cli.Command) error {
 	// Wait for interrupt signal
 	<-sigChan
 	l.Info().Msg("Shutdown signal received, stopping servers...")
+	mirrorer.Stop()
+	dispatcher.Stop()
+	pusher.Stop()
 
 	// Shutdown servers gracefully
 	go func() {
@@ -124,6 +292,10 @@ func runServer(ctx context.Context, c *cli.Command) error {
 	done := make(chan struct{})
 	go func() {
 		wg.Wait()
+		// The running webhook deliveries and push mirror replications are
+		// given the same time, their retries are abandoned
+		dispatcher.Wait()
+		pusher.Wait()
 		close(done)
 	}()
 
@@ -134,5 +306,12 @@ func runServer(ctx context.Context, c *cli.Command) error {
 		l.Warn().Msg("Shutdown timeout reached, forcing exit")
 	}
 
+	// Export the spans still pending
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		l.Error().Err(err).Msg("Failed to flush the traces")
+	}
+
 	return nil
 }
@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 var (
 	// Version is set during the startup process.
 	Version string
@@ -11,9 +13,11 @@ var (
 	// Server is the configuration for the HTTP fiber server.
 	// Port is the port on which the server listens.
 	// HttpLogs enables or disables HTTP request logging.
+	// MaxBodySize is the maximum size in bytes of a fetch or push request body or of an LFS upload, 0 for no limit.
 	Server struct {
-		Port     int
-		HttpLogs bool
+		Port        int
+		HttpLogs    bool
+		MaxBodySize int64
 	}
 
 	// SSH is the configuration for the SSH Git server.
@@ -26,6 +30,120 @@ var (
 		HostKeyPath string
 	}
 
+	// Auth is the configuration of the HTTP authentication.
+	// Enabled requires credentials on the smart HTTP and REST endpoints and on SSH.
+	// TokensFile is the path to the file of personal access tokens.
+	// BearerTokens also accepts tokens sent as bearer tokens.
+	// AuthorizedKeys is the path to the authorized keys file or directory of the SSH users.
+	// SSHPassword enables SSH password authentication, with personal access tokens.
+	// GrantsFile is the path to the file of the repository grants and teams.
+	// Admins are the site admins, admin of every repository.
+	Auth struct {
+		Enabled        bool
+		TokensFile     string
+		BearerTokens   bool
+		AuthorizedKeys string
+		SSHPassword    bool
+		GrantsFile     string
+		Admins         []string
+	}
+
+	// Repos is the configuration of the repositories.
+	// AutoCreate is the policy creating missing repositories on push: off, on,
+	// or owned for the namespaces the pusher owns.
+	// DefaultBranch is the branch HEAD of the new repositories points to.
+	Repos struct {
+		AutoCreate    string
+		DefaultBranch string
+	}
+
+	// Hooks is the configuration of the hooks run on push.
+	// PreReceive are the executables checking the reference updates before they are applied.
+	// PostReceive are the executables run once the reference updates are applied.
+	// Timeout is the time given to each executable.
+	Hooks struct {
+		PreReceive  []string
+		PostReceive []string
+		Timeout     time.Duration
+	}
+
+	// Protection is the configuration of the branch protection.
+	// File is the path to the file of the branch protection rules.
+	Protection struct {
+		File string
+	}
+
+	// Webhooks is the configuration of the outgoing webhooks.
+	// File is the path to the file of the webhooks.
+	// DeliveriesDir is the path to the directory of the delivery logs, one file per repository.
+	// Attempts is the number of attempts of a delivery.
+	// Backoff is the delay before the first retry, doubled after each retry.
+	// Timeout is the timeout of an attempt.
+	Webhooks struct {
+		File          string
+		DeliveriesDir string
+		Attempts      int
+		Backoff       time.Duration
+		Timeout       time.Duration
+	}
+
+	// LFS is the configuration of the Git LFS server.
+	// Enabled serves the LFS batch, transfer and locking API.
+	// Path is the directory of the LFS objects with the local storage, the S3 storage keeps them under the lfs/ prefix of the bucket.
+	// LocksFile is the path to the file of the LFS file locks.
+	// Presign sends presigned URLs to transfer the objects directly from and to the S3 bucket.
+	// PresignExpiry is the validity of the presigned URLs.
+	LFS struct {
+		Enabled       bool
+		Path          string
+		LocksFile     string
+		Presign       bool
+		PresignExpiry time.Duration
+	}
+
+	// Mirrors is the configuration of the pull and push mirrors.
+	// File is the path to the file of the pull mirrors and their synchronization status.
+	// Interval is the interval between two synchronizations of the pull mirrors registered without one.
+	// Timeout is the timeout of a synchronization or replication.
+	// SSHKey is the path to the private key authenticating to the SSH upstreams and downstreams, the SSH agent is used when empty.
+	// AllowFile accepts the file:// upstreams and downstreams, outside of the local storage of the repositories.
+	// PushFile is the path to the file of the push mirrors and their replication status.
+	// PushAttempts is the number of attempts of a replication to a push mirror.
+	// PushBackoff is the delay before the first retry of a replication, doubled after each retry.
+	Mirrors struct {
+		File         string
+		Interval     time.Duration
+		Timeout      time.Duration
+		SSHKey       string
+		AllowFile    bool
+		PushFile     string
+		PushAttempts int
+		PushBackoff  time.Duration
+	}
+
+	// Metrics is the configuration of the Prometheus metrics.
+	// Enabled serves the metrics on /metrics.
+	Metrics struct {
+		Enabled bool
+	}
+
+	// Health is the configuration of the readiness checks.
+	// Timeout bounds the duration of each check of /health/ready.
+	Health struct {
+		Timeout time.Duration
+	}
+
+	// Tracing is the configuration of the OpenTelemetry tracing.
+	// Exporter is none, otlp, stdout or file. Endpoint is the URL of the
+	// OTLP/HTTP collector, File the path of the file exporter. SampleRatio is
+	// the ratio of the traces started by the server that are recorded.
+	Tracing struct {
+		Exporter    string
+		Endpoint    string
+		File        string
+		SampleRatio float64
+	}
+
 	// Debug enables or disables debug endpoints.
 	Debug struct {
 		Endpoints bool
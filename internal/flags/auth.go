@@ -0,0 +1,83 @@
+package flags
+
+import (
+	"github.com/labbs/git-server-s3/internal/config"
+
+	altsrc "github.com/urfave/cli-altsrc/v3"
+	altsrcyaml "github.com/urfave/cli-altsrc/v3/yaml"
+	"github.com/urfave/cli/v3"
+)
+
+func AuthFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "auth.enabled",
+			Value:       false,
+			Usage:       "Require credentials on the smart HTTP and REST endpoints and on SSH",
+			Destination: &config.Auth.Enabled,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("AUTH_ENABLED"),
+				altsrcyaml.YAML("auth.enabled", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "auth.tokens",
+			Value:       "./tokens",
+			Usage:       "Path to the personal access tokens file, one \"<username> <sha256 of the token>\" per line",
+			Destination: &config.Auth.TokensFile,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("AUTH_TOKENS_FILE"),
+				altsrcyaml.YAML("auth.tokens", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.BoolFlag{
+			Name:        "auth.bearer",
+			Value:       false,
+			Usage:       "Also accept personal access tokens as bearer tokens",
+			Destination: &config.Auth.BearerTokens,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("AUTH_BEARER"),
+				altsrcyaml.YAML("auth.bearer", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "auth.authorizedkeys",
+			Value:       "./authorized_keys",
+			Usage:       "Path to the SSH authorized keys, a file or a directory of one file per user",
+			Destination: &config.Auth.AuthorizedKeys,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("AUTH_AUTHORIZED_KEYS"),
+				altsrcyaml.YAML("auth.authorizedkeys", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.BoolFlag{
+			Name:        "auth.ssh.password",
+			Value:       false,
+			Usage:       "Accept personal access tokens as SSH passwords",
+			Destination: &config.Auth.SSHPassword,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("AUTH_SSH_PASSWORD"),
+				altsrcyaml.YAML("auth.ssh.password", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "auth.grants",
+			Value:       "./grants.json",
+			Usage:       "Path to the file of the repository grants and teams, managed through the /api/grants and /api/teams endpoints",
+			Destination: &config.Auth.GrantsFile,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("AUTH_GRANTS_FILE"),
+				altsrcyaml.YAML("auth.grants", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringSliceFlag{
+			Name:        "auth.admins",
+			Usage:       "Site admins, admin of every repository",
+			Destination: &config.Auth.Admins,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("AUTH_ADMINS"),
+				altsrcyaml.YAML("auth.admins", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+	}
+}
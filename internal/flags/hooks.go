@@ -0,0 +1,44 @@
+package flags
+
+import (
+	"time"
+
+	"github.com/labbs/git-server-s3/internal/config"
+
+	altsrc "github.com/urfave/cli-altsrc/v3"
+	altsrcyaml "github.com/urfave/cli-altsrc/v3/yaml"
+	"github.com/urfave/cli/v3"
+)
+
+func HooksFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "hooks.pre_receive",
+			Usage:       "Comma-separated executables run before the reference updates of a push, fed \"<old> <new> <ref>\" lines, a non-zero exit status rejects the push",
+			Destination: &config.Hooks.PreReceive,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("HOOKS_PRE_RECEIVE"),
+				altsrcyaml.YAML("hooks.pre_receive", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringSliceFlag{
+			Name:        "hooks.post_receive",
+			Usage:       "Comma-separated executables run after the reference updates of a push, fed \"<old> <new> <ref>\" lines",
+			Destination: &config.Hooks.PostReceive,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("HOOKS_POST_RECEIVE"),
+				altsrcyaml.YAML("hooks.post_receive", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.DurationFlag{
+			Name:        "hooks.timeout",
+			Value:       time.Minute,
+			Usage:       "Time given to each hook executable",
+			Destination: &config.Hooks.Timeout,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("HOOKS_TIMEOUT"),
+				altsrcyaml.YAML("hooks.timeout", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+	}
+}
@@ -0,0 +1,66 @@
+package flags
+
+import (
+	"time"
+
+	"github.com/labbs/git-server-s3/internal/config"
+
+	altsrc "github.com/urfave/cli-altsrc/v3"
+	altsrcyaml "github.com/urfave/cli-altsrc/v3/yaml"
+	"github.com/urfave/cli/v3"
+)
+
+func LFSFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "lfs.enabled",
+			Value:       true,
+			Usage:       "Serve the Git LFS batch, transfer and locking API",
+			Destination: &config.LFS.Enabled,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("LFS_ENABLED"),
+				altsrcyaml.YAML("lfs.enabled", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "lfs.path",
+			Value:       "./lfs",
+			Usage:       "Directory of the LFS objects with the local storage, the S3 storage keeps them under the lfs/ prefix of the bucket",
+			Destination: &config.LFS.Path,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("LFS_PATH"),
+				altsrcyaml.YAML("lfs.path", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "lfs.locks_file",
+			Value:       "./lfs-locks.json",
+			Usage:       "Path to the file of the LFS file locks",
+			Destination: &config.LFS.LocksFile,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("LFS_LOCKS_FILE"),
+				altsrcyaml.YAML("lfs.locks_file", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.BoolFlag{
+			Name:        "lfs.presign",
+			Value:       false,
+			Usage:       "Send presigned URLs to transfer the LFS objects directly from and to the S3 bucket",
+			Destination: &config.LFS.Presign,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("LFS_PRESIGN"),
+				altsrcyaml.YAML("lfs.presign", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.DurationFlag{
+			Name:        "lfs.presign_expiry",
+			Value:       15 * time.Minute,
+			Usage:       "Validity of the presigned URLs of the LFS objects",
+			Destination: &config.LFS.PresignExpiry,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("LFS_PRESIGN_EXPIRY"),
+				altsrcyaml.YAML("lfs.presign_expiry", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+	}
+}
@@ -0,0 +1,96 @@
+package flags
+
+import (
+	"time"
+
+	"github.com/labbs/git-server-s3/internal/config"
+
+	altsrc "github.com/urfave/cli-altsrc/v3"
+	altsrcyaml "github.com/urfave/cli-altsrc/v3/yaml"
+	"github.com/urfave/cli/v3"
+)
+
+func MirrorsFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "mirrors.file",
+			Value:       "./mirrors.json",
+			Usage:       "Path to the file of the pull mirrors and their synchronization status",
+			Destination: &config.Mirrors.File,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("MIRRORS_FILE"),
+				altsrcyaml.YAML("mirrors.file", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.DurationFlag{
+			Name:        "mirrors.interval",
+			Value:       time.Hour,
+			Usage:       "Interval between two synchronizations of the mirrors registered without one",
+			Destination: &config.Mirrors.Interval,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("MIRRORS_INTERVAL"),
+				altsrcyaml.YAML("mirrors.interval", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.DurationFlag{
+			Name:        "mirrors.timeout",
+			Value:       10 * time.Minute,
+			Usage:       "Timeout of a mirror synchronization or replication",
+			Destination: &config.Mirrors.Timeout,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("MIRRORS_TIMEOUT"),
+				altsrcyaml.YAML("mirrors.timeout", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "mirrors.ssh_key",
+			Value:       "",
+			Usage:       "Path to the private key authenticating to the SSH upstreams and downstreams, the SSH agent is used when empty",
+			Destination: &config.Mirrors.SSHKey,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("MIRRORS_SSH_KEY"),
+				altsrcyaml.YAML("mirrors.ssh_key", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.BoolFlag{
+			Name:        "mirrors.allow_file",
+			Value:       false,
+			Usage:       "Accept file:// upstreams and downstreams, which read and write the filesystem of the server, outside of the local storage of the repositories",
+			Destination: &config.Mirrors.AllowFile,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("MIRRORS_ALLOW_FILE"),
+				altsrcyaml.YAML("mirrors.allow_file", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "mirrors.push_file",
+			Value:       "./push-mirrors.json",
+			Usage:       "Path to the file of the push mirrors and their replication status",
+			Destination: &config.Mirrors.PushFile,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("MIRRORS_PUSH_FILE"),
+				altsrcyaml.YAML("mirrors.push_file", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.IntFlag{
+			Name:        "mirrors.push_attempts",
+			Value:       5,
+			Usage:       "Number of attempts of a replication to a push mirror",
+			Destination: &config.Mirrors.PushAttempts,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("MIRRORS_PUSH_ATTEMPTS"),
+				altsrcyaml.YAML("mirrors.push_attempts", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.DurationFlag{
+			Name:        "mirrors.push_backoff",
+			Value:       10 * time.Second,
+			Usage:       "Delay before the first retry of a replication to a push mirror, doubled after each retry",
+			Destination: &config.Mirrors.PushBackoff,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("MIRRORS_PUSH_BACKOFF"),
+				altsrcyaml.YAML("mirrors.push_backoff", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+	}
+}
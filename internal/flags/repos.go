@@ -0,0 +1,44 @@
+package flags
+
+import (
+	"github.com/labbs/git-server-s3/internal/config"
+
+	altsrc "github.com/urfave/cli-altsrc/v3"
+	altsrcyaml "github.com/urfave/cli-altsrc/v3/yaml"
+	"github.com/urfave/cli/v3"
+)
+
+func ReposFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "repos.auto_create",
+			Value:       "off",
+			Usage:       "Create missing repositories on push: off, on, or owned for the namespaces the pusher owns (named after the user or granted admin)",
+			Destination: &config.Repos.AutoCreate,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("REPOS_AUTO_CREATE"),
+				altsrcyaml.YAML("repos.auto_create", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "repos.default_branch",
+			Value:       "main",
+			Usage:       "Default branch of the new repositories, unless given on creation",
+			Destination: &config.Repos.DefaultBranch,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("REPOS_DEFAULT_BRANCH"),
+				altsrcyaml.YAML("repos.default_branch", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "repos.protections",
+			Value:       "./protections.json",
+			Usage:       "Path to the file of the branch protection rules of the repositories",
+			Destination: &config.Protection.File,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("REPOS_PROTECTIONS"),
+				altsrcyaml.YAML("repos.protections", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+	}
+}
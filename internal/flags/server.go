@@ -1,6 +1,8 @@
 package flags
 
 import (
+	"time"
+
 	"github.com/labbs/git-server-s3/internal/config"
 
 	altsrc "github.com/urfave/cli-altsrc/v3"
@@ -30,6 +32,16 @@ func ServerFlags() []cli.Flag {
 				altsrcyaml.YAML("http.logs", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
 			),
 		},
+		&cli.Int64Flag{
+			Name:        "http.maxbodysize",
+			Value:       10 << 30,
+			Usage:       "Maximum size in bytes of a fetch or push request body or of an LFS upload, 0 for no limit",
+			Destination: &config.Server.MaxBodySize,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("HTTP_MAX_BODY_SIZE"),
+				altsrcyaml.YAML("http.maxbodysize", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
 		&cli.BoolFlag{
 			Name:        "ssh.enabled",
 			Value:       false,
@@ -57,6 +69,66 @@ func ServerFlags() []cli.Flag {
 				altsrcyaml.YAML("ssh.hostkey", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
 			),
 		},
+		&cli.BoolFlag{
+			Name:        "metrics.enabled",
+			Value:       true,
+			Usage:       "Serve the Prometheus metrics on /metrics",
+			Destination: &config.Metrics.Enabled,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("METRICS_ENABLED"),
+				altsrcyaml.YAML("metrics.enabled", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.DurationFlag{
+			Name:        "health.timeout",
+			Value:       5 * time.Second,
+			Usage:       "Maximum duration of each readiness check of /health/ready",
+			Destination: &config.Health.Timeout,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("HEALTH_TIMEOUT"),
+				altsrcyaml.YAML("health.timeout", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "tracing.exporter",
+			Value:       "none",
+			Usage:       "Exporter of the OpenTelemetry spans: none, otlp, stdout or file",
+			Destination: &config.Tracing.Exporter,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("TRACING_EXPORTER"),
+				altsrcyaml.YAML("tracing.exporter", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "tracing.endpoint",
+			Value:       "",
+			Usage:       "URL of the OTLP/HTTP collector, defaults to the OTEL_EXPORTER_OTLP_* variables or http://localhost:4318",
+			Destination: &config.Tracing.Endpoint,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("TRACING_ENDPOINT"),
+				altsrcyaml.YAML("tracing.endpoint", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "tracing.file",
+			Value:       "./traces.json",
+			Usage:       "File the spans are appended to with the file exporter",
+			Destination: &config.Tracing.File,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("TRACING_FILE"),
+				altsrcyaml.YAML("tracing.file", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.FloatFlag{
+			Name:        "tracing.sample_ratio",
+			Value:       1,
+			Usage:       "Ratio of the traces started by the server that are recorded, the traces of the clients follow their sampling decision",
+			Destination: &config.Tracing.SampleRatio,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("TRACING_SAMPLE_RATIO"),
+				altsrcyaml.YAML("tracing.sample_ratio", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
 		&cli.BoolFlag{
 			Name:        "debug.endpoints",
 			Value:       false,
@@ -0,0 +1,66 @@
+package flags
+
+import (
+	"time"
+
+	"github.com/labbs/git-server-s3/internal/config"
+
+	altsrc "github.com/urfave/cli-altsrc/v3"
+	altsrcyaml "github.com/urfave/cli-altsrc/v3/yaml"
+	"github.com/urfave/cli/v3"
+)
+
+func WebhooksFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "webhooks.file",
+			Value:       "./webhooks.json",
+			Usage:       "Path to the file of the repository webhooks",
+			Destination: &config.Webhooks.File,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("WEBHOOKS_FILE"),
+				altsrcyaml.YAML("webhooks.file", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.StringFlag{
+			Name:        "webhooks.deliveries_dir",
+			Value:       "./webhook-deliveries",
+			Usage:       "Path to the directory of the webhook delivery logs, one file per repository",
+			Destination: &config.Webhooks.DeliveriesDir,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("WEBHOOKS_DELIVERIES_DIR"),
+				altsrcyaml.YAML("webhooks.deliveries_dir", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.IntFlag{
+			Name:        "webhooks.attempts",
+			Value:       5,
+			Usage:       "Number of attempts of a webhook delivery",
+			Destination: &config.Webhooks.Attempts,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("WEBHOOKS_ATTEMPTS"),
+				altsrcyaml.YAML("webhooks.attempts", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.DurationFlag{
+			Name:        "webhooks.backoff",
+			Value:       time.Second,
+			Usage:       "Delay before the first retry of a webhook delivery, doubled after each retry",
+			Destination: &config.Webhooks.Backoff,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("WEBHOOKS_BACKOFF"),
+				altsrcyaml.YAML("webhooks.backoff", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+		&cli.DurationFlag{
+			Name:        "webhooks.timeout",
+			Value:       10 * time.Second,
+			Usage:       "Timeout of a webhook delivery attempt",
+			Destination: &config.Webhooks.Timeout,
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("WEBHOOKS_TIMEOUT"),
+				altsrcyaml.YAML("webhooks.timeout", altsrc.NewStringPtrSourcer(&config.ConfigFile)),
+			),
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/labbs/git-server-s3/pkg/common"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+)
+
+// Git services served over SSH
+const (
+	serviceUploadPack  = "git-upload-pack"
+	serviceReceivePack = "git-receive-pack"
+)
+
+// Errors of parseGitCommand, sent to the client on stderr
+var (
+	errInvalidCommand  = errors.New("invalid Git command, only git-upload-pack and git-receive-pack are supported")
+	errInvalidRepoPath = errors.New("invalid repository path")
+)
+
+// gitCommand is a Git command run by a client over SSH
+type gitCommand struct {
+	Service  string // git-upload-pack or git-receive-pack
+	RepoPath string // Normalized path of the repository, see common.NormalizeRepoPath
+}
+
+// parseGitCommand parses the command of an exec request.
+// Example: "git-upload-pack '/team/demo.git'" -> {git-upload-pack team/demo.git}
+func parseGitCommand(cmd string) (gitCommand, error) {
+	service, arg, _ := strings.Cut(strings.TrimSpace(cmd), " ")
+	if service != serviceUploadPack && service != serviceReceivePack {
+		return gitCommand{}, errInvalidCommand
+	}
+
+	repoPath := repoPathFromArg(arg)
+	if repoPath == "" {
+		return gitCommand{}, errInvalidRepoPath
+	}
+	return gitCommand{Service: service, RepoPath: repoPath}, nil
+}
+
+// repoPathFromArg cleans the repository argument of a Git command and
+// returns the normalized path, empty when it is not a valid repository path.
+// Example: '/demo.git' -> "demo.git"
+func repoPathFromArg(arg string) string {
+	arg = strings.TrimSpace(arg)
+
+	// Remove quotes
+	arg = strings.Trim(arg, "'\"")
+
+	// Remove leading colon (some Git clients use it)
+	arg = strings.TrimPrefix(arg, ":")
+
+	// Remove host part if present (host:path format)
+	if i := strings.Index(arg, ":"); i >= 0 {
+		arg = arg[i+1:]
+	}
+
+	// Remove leading slash
+	arg = strings.TrimPrefix(arg, "/")
+
+	if arg == "" {
+		return ""
+	}
+
+	repoPath := common.NormalizeRepoPath(arg)
+	if !common.ValidRepoPath(repoPath) {
+		return ""
+	}
+	return repoPath
+}
+
+// role returns the role required to run the command on its repository
+func (c gitCommand) role() rbac.Role {
+	if c.Service == serviceReceivePack {
+		return rbac.Write
+	}
+	return rbac.Read
+}
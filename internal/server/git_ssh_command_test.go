@@ -0,0 +1,106 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGitCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    gitCommand
+		wantErr error
+	}{
+		{
+			name:    "upload pack command",
+			command: "git-upload-pack '/repo.git'",
+			want:    gitCommand{Service: serviceUploadPack, RepoPath: "repo.git"},
+		},
+		{
+			name:    "receive pack command",
+			command: "git-receive-pack '/repo.git'",
+			want:    gitCommand{Service: serviceReceivePack, RepoPath: "repo.git"},
+		},
+		{
+			name:    "unquoted path",
+			command: "git-upload-pack team/demo",
+			want:    gitCommand{Service: serviceUploadPack, RepoPath: "team/demo.git"},
+		},
+		{
+			name:    "surrounding spaces",
+			command: "  git-upload-pack   '/team/demo.git'  ",
+			want:    gitCommand{Service: serviceUploadPack, RepoPath: "team/demo.git"},
+		},
+		{
+			name:    "invalid command",
+			command: "invalid-command",
+			wantErr: errInvalidCommand,
+		},
+		{
+			name:    "shell command",
+			command: "sh -c 'git-upload-pack repo.git'",
+			wantErr: errInvalidCommand,
+		},
+		{
+			name:    "empty command",
+			command: "",
+			wantErr: errInvalidCommand,
+		},
+		{
+			name:    "missing path",
+			command: "git-receive-pack",
+			wantErr: errInvalidRepoPath,
+		},
+		{
+			name:    "parent directory segment",
+			command: "git-upload-pack '/team/../../project.git'",
+			wantErr: errInvalidRepoPath,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := parseGitCommand(tt.command)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cmd)
+		})
+	}
+}
+
+func TestRepoPathFromArg(t *testing.T) {
+	tests := []struct {
+		name     string
+		arg      string
+		expected string
+	}{
+		{"simple repo path", "'repo.git'", "repo.git"},
+		{"double quoted path", "\"repo.git\"", "repo.git"},
+		{"path with leading slash", "'/repo.git'", "repo.git"},
+		{"path with colon prefix", "':repo.git'", "repo.git"},
+		{"path with host", "'host:/path/repo.git'", "path/repo.git"},
+		{"repo without .git suffix", "'myrepo'", "myrepo.git"},
+		{"empty path", "''", ""},
+		{"path with spaces", "  '/repo.git'  ", "repo.git"},
+		{"nested namespaces", "'/team/sub/project.git'", "team/sub/project.git"},
+		{"parent directory segment", "'/team/../../project.git'", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, repoPathFromArg(tt.arg))
+		})
+	}
+}
+
+func TestGitCommand_role(t *testing.T) {
+	assert.Equal(t, rbac.Read, gitCommand{Service: serviceUploadPack}.role())
+	assert.Equal(t, rbac.Write, gitCommand{Service: serviceReceivePack}.role())
+}
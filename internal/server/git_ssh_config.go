@@ -1,29 +1,42 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/autocreate"
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/labbs/git-server-s3/pkg/rbac"
 	"github.com/labbs/git-server-s3/pkg/storage"
 	"github.com/rs/zerolog"
 )
 
-// GitSSHConfig holds configuration for the custom Git SSH server.
-// This replaces the old SSHConfig that used gliderlabs/ssh.
+// GitSSHConfig holds configuration for the Git SSH server.
 type GitSSHConfig struct {
-	Port        int                          // SSH server port
-	HostKeyPath string                       // Path to SSH host key file
-	Logger      zerolog.Logger               // Logger for SSH operations
-	Storage     storage.GitRepositoryStorage // Storage backend for repositories
-	server      *GitSSHServer                // The underlying Git SSH server instance
+	Port          int                          // SSH server port
+	HostKeyPath   string                       // Path to SSH host key file
+	Logger        zerolog.Logger               // Logger for SSH operations
+	Storage       storage.GitRepositoryStorage // Storage backend for repositories
+	Authenticator auth.SSHAuthenticator        // Client authentication, nil for demo mode (any credentials accepted)
+	Access        *rbac.Store                  // Access control of the repositories, nil when disabled
+	AutoCreate    *autocreate.Creator          // Creation of missing repositories on push, nil to reject them
+	Hooks         *hooks.Runner                // Pre-receive and post-receive hooks, nil for none
+	server        *GitSSHServer                // The underlying Git SSH server instance
 }
 
 // Configure sets up the custom Git SSH server.
 func (c *GitSSHConfig) Configure() error {
 	c.server = &GitSSHServer{
-		Port:        c.formatPort(),
-		Logger:      c.Logger,
-		Storage:     c.Storage,
-		HostKeyPath: c.HostKeyPath,
+		Port:          c.formatPort(),
+		Logger:        c.Logger,
+		Storage:       c.Storage,
+		HostKeyPath:   c.HostKeyPath,
+		Authenticator: c.Authenticator,
+		Access:        c.Access,
+		AutoCreate:    c.AutoCreate,
+		Hooks:         c.Hooks,
 	}
 
 	return c.server.Configure()
@@ -47,6 +60,14 @@ func (c *GitSSHConfig) Shutdown() error {
 	return nil
 }
 
+// Check reports whether the SSH server accepts connections, it is a readiness check.
+func (c *GitSSHConfig) Check(ctx context.Context) error {
+	if c.server == nil {
+		return errors.New("SSH server is not configured")
+	}
+	return c.server.Check(ctx)
+}
+
 // formatPort formats the port number as a string with colon prefix.
 func (c *GitSSHConfig) formatPort() string {
 	if c.Port == 0 {
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/autocreate"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// Messages of git when the ssh command ends without an exit status, the
+// server must never cause them
+var hungUp = []string{"hung up unexpectedly", "unexpected disconnect", "remote transport reported error"}
+
+// gitClient runs the git binary against a test server over OpenSSH
+type gitClient struct {
+	url string   // URL of the server, without the repository path
+	env []string // Environment of the git commands
+}
+
+// newGitClient starts the server on a loopback listener and returns a git
+// client authenticated as alice. It skips the test when git or ssh is missing.
+func newGitClient(t *testing.T, s *GitSSHServer, alice ed25519.PrivateKey) *gitClient {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("end-to-end test")
+	}
+	for _, bin := range []string{"git", "ssh"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not installed", bin)
+		}
+	}
+
+	dir := t.TempDir()
+	block, err := ssh.MarshalPrivateKey(alice, "")
+	require.NoError(t, err)
+	keyPath := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handleConnection(conn)
+		}
+	}()
+
+	sshCommand := "ssh -i " + keyPath + " -o IdentitiesOnly=yes -o StrictHostKeyChecking=no" +
+		" -o UserKnownHostsFile=/dev/null -o LogLevel=ERROR"
+	return &gitClient{
+		url: "ssh://git@" + listener.Addr().String() + "/",
+		env: append(os.Environ(),
+			"HOME="+dir,
+			"GIT_CONFIG_NOSYSTEM=1",
+			"GIT_SSH_COMMAND="+sshCommand,
+			"GIT_TERMINAL_PROMPT=0",
+			"GIT_AUTHOR_NAME=Alice",
+			"GIT_AUTHOR_EMAIL=alice@example.com",
+			"GIT_COMMITTER_NAME=Alice",
+			"GIT_COMMITTER_EMAIL=alice@example.com",
+		),
+	}
+}
+
+// run runs git in dir and returns its output
+func (c *gitClient) run(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = c.env
+	out, err := cmd.CombinedOutput()
+	for _, msg := range hungUp {
+		assert.NotContains(t, string(out), msg, "git %s", strings.Join(args, " "))
+	}
+	return string(out), err
+}
+
+// git runs git in dir, it must succeed
+func (c *gitClient) git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	out, err := c.run(t, dir, args...)
+	require.NoError(t, err, "git %s: %s", strings.Join(args, " "), out)
+	return out
+}
+
+// commit writes a file in the working copy and commits it
+func (c *gitClient) commit(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	c.git(t, dir, "add", name)
+	c.git(t, dir, "commit", "-q", "-m", "Update "+name)
+	return c.head(t, dir, "HEAD")
+}
+
+// head returns the commit of a revision
+func (c *gitClient) head(t *testing.T, dir, rev string) string {
+	t.Helper()
+	return strings.TrimSpace(c.git(t, dir, "rev-parse", rev))
+}
+
+func TestGitSSHServer_E2E(t *testing.T) {
+	config.Storage.Local.Path = t.TempDir()
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+
+	// alice can write her repositories and the team ones, and read the others
+	_, alice, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(alice)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "authorized_keys")
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " alice\n"
+	require.NoError(t, os.WriteFile(keysPath, []byte(line), 0600))
+	keys, err := auth.LoadKeyStore(keysPath)
+	require.NoError(t, err)
+	access, err := rbac.Load(filepath.Join(dir, "grants.json"), nil)
+	require.NoError(t, err)
+	_, err = access.SetGrant(rbac.Grant{User: "alice", Resource: "team/*", Role: rbac.Write})
+	require.NoError(t, err)
+	_, err = access.SetGrant(rbac.Grant{User: "alice", Resource: "*", Role: rbac.Read})
+	require.NoError(t, err)
+
+	s := &GitSSHServer{
+		Logger:        zerolog.Nop(),
+		Storage:       str,
+		HostKeyPath:   filepath.Join(dir, "host_key"),
+		Authenticator: &auth.KeyAuthenticator{Keys: keys},
+		Access:        access,
+		AutoCreate:    &autocreate.Creator{Policy: autocreate.On, Storage: str},
+	}
+	require.NoError(t, s.Configure())
+	c := newGitClient(t, s, alice)
+	url := c.url + "team/e2e.git"
+
+	work := t.TempDir()
+	c.git(t, work, "init", "-q", "-b", "main")
+	c.git(t, work, "remote", "add", "origin", url)
+	first := c.commit(t, work, "README.md", "# e2e\n")
+
+	t.Run("push creates the repository", func(t *testing.T) {
+		c.git(t, work, "push", "-u", "origin", "main")
+		assert.True(t, str.RepositoryExists("team/e2e.git"))
+	})
+
+	clones, clonesDir := map[string]string{}, t.TempDir()
+	for _, version := range []string{"0", "2"} {
+		t.Run("clone with protocol v"+version, func(t *testing.T) {
+			clone := filepath.Join(clonesDir, "v"+version)
+			c.git(t, "", "-c", "protocol.version="+version, "clone", "-q", url, clone)
+			assert.Equal(t, first, c.head(t, clone, "HEAD"))
+			content, err := os.ReadFile(filepath.Join(clone, "README.md"))
+			require.NoError(t, err)
+			assert.Equal(t, "# e2e\n", string(content))
+			clones[version] = clone
+		})
+	}
+	require.Len(t, clones, 2)
+
+	second := c.commit(t, work, "main.go", "package main\n")
+	t.Run("incremental fetch", func(t *testing.T) {
+		c.git(t, work, "push", "origin", "main")
+		for version, clone := range clones {
+			c.git(t, clone, "-c", "protocol.version="+version, "pull", "-q", "--ff-only")
+			assert.Equal(t, second, c.head(t, clone, "HEAD"), "protocol v"+version)
+		}
+	})
+
+	t.Run("up-to-date fetch and push", func(t *testing.T) {
+		for version, clone := range clones {
+			c.git(t, clone, "-c", "protocol.version="+version, "fetch")
+		}
+		assert.Contains(t, c.git(t, work, "push", "origin", "main"), "Everything up-to-date")
+	})
+
+	t.Run("branch creation and deletion", func(t *testing.T) {
+		c.git(t, work, "push", "origin", "main:feature")
+		assert.Contains(t, c.git(t, work, "ls-remote", "origin"), "refs/heads/feature")
+
+		c.git(t, work, "push", "origin", "--delete", "feature")
+		refs := c.git(t, work, "ls-remote", "origin")
+		assert.NotContains(t, refs, "refs/heads/feature")
+		assert.Contains(t, refs, second+"\trefs/heads/main")
+	})
+
+	t.Run("missing repository", func(t *testing.T) {
+		out, err := c.run(t, "", "clone", "-q", c.url+"team/missing.git", filepath.Join(t.TempDir(), "clone"))
+		require.Error(t, err)
+		assert.Contains(t, out, "repository not found")
+	})
+
+	t.Run("access denied", func(t *testing.T) {
+		out, err := c.run(t, work, "push", c.url+"other/e2e.git", "main")
+		require.Error(t, err)
+		assert.Contains(t, out, "access denied")
+		assert.False(t, str.RepositoryExists("other/e2e.git"))
+	})
+}
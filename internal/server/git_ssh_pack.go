@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/autocreate"
+	"github.com/labbs/git-server-s3/pkg/common"
+	"github.com/labbs/git-server-s3/pkg/protocolv2"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/tracing"
+	"github.com/rs/zerolog"
+)
+
+// flushPkt is the flush packet a client sends in place of its request when
+// it needs nothing, e.g. a fetch of an up-to-date repository
+var flushPkt = []byte("0000")
+
+// handleUploadPack serves a clone or fetch of the repository: the reference
+// advertisement, then the packfile of the objects the client wants. Protocol
+// v2 clients are served their commands instead.
+func (s *GitSSHServer) handleUploadPack(ctx context.Context, r io.Reader, w io.Writer, repoPath string, v2 bool, logger zerolog.Logger) error {
+	if v2 {
+		logger.Debug().Msg("Using protocol v2")
+		st, err := s.Storage.GetStorer(repoPath)
+		if err != nil {
+			return err
+		}
+		return protocolv2.Serve(ctx, storage.WithContext(ctx, st), r, w)
+	}
+
+	srv, ep, err := common.GetTransportServer(ctx, repoPath, s.Storage)
+	if err != nil {
+		return err
+	}
+	sess, err := srv.NewUploadPackSession(ep, nil)
+	if err != nil {
+		return err
+	}
+
+	adv, err := sess.AdvertisedReferences()
+	if err != nil {
+		return err
+	}
+	if err := adv.Encode(w); err != nil {
+		return err
+	}
+
+	in := bufio.NewReader(r)
+	if done, err := clientDone(in); done || err != nil {
+		return err
+	}
+
+	req := packp.NewUploadPackRequest()
+	if err := req.Decode(in); err != nil {
+		return err
+	}
+
+	spanCtx, span := tracing.Start(ctx, "UploadPack", tracing.Repository(repoPath))
+	resp, err := sess.UploadPack(spanCtx, req)
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	_, span = tracing.Start(ctx, "SendPackfile", tracing.Repository(repoPath))
+	err = resp.Encode(w)
+	tracing.End(span, err)
+	return err
+}
+
+// handleReceivePack serves a push to the repository: the reference
+// advertisement, then the storage of the packfile and the reference updates
+// sent by the client, whose status is reported with the output of the hooks.
+// identity is the authenticated user, nil when authentication is disabled.
+func (s *GitSSHServer) handleReceivePack(ctx context.Context, r io.Reader, w io.Writer, repoPath string, identity *auth.Identity) error {
+	sess, err := common.NewReceivePackSession(ctx, repoPath, s.Storage)
+	if err != nil {
+		return err
+	}
+	sess.Hooks = s.Hooks
+	sess.Pusher = identity
+
+	adv, err := sess.AdvertisedReferences()
+	if err != nil {
+		return err
+	}
+	if err := adv.Encode(w); err != nil {
+		return err
+	}
+
+	in := bufio.NewReader(r)
+	if done, err := clientDone(in); done || err != nil {
+		return err
+	}
+
+	req := packp.NewReferenceUpdateRequest()
+	if err := req.Decode(in); err != nil {
+		return err
+	}
+
+	report, err := sess.ReceivePack(ctx, req)
+	if err != nil {
+		// The report tells the client why the push failed
+		if report != nil {
+			_ = sess.WriteResult(w, report)
+		}
+		return err
+	}
+	return sess.WriteResult(w, report)
+}
+
+// clientDone reports whether the client ended the conversation after the
+// reference advertisement, with a flush packet or by closing its side
+func clientDone(r *bufio.Reader) (bool, error) {
+	head, err := r.Peek(len(flushPkt))
+	if errors.Is(err, io.EOF) && len(head) == 0 {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(head, flushPkt), nil
+}
+
+// createOnPush creates the missing repository of a push following the
+// auto-create policy. A rejection is written to the client as a git error,
+// in place of the reference advertisement.
+func createOnPush(creator *autocreate.Creator, identity *auth.Identity, repoPath string, w io.Writer, logger zerolog.Logger) bool {
+	err := creator.Create(identity, repoPath)
+	switch {
+	case err == nil:
+		logger.Info().Msg("Repository created on push")
+		return true
+	case errors.Is(err, autocreate.ErrRejected):
+		logger.Warn().Err(err).Msg("Repository creation on push rejected")
+		_ = common.WriteError(w, err.Error())
+	default:
+		logger.Error().Err(err).Msg("Failed to create repository on push")
+		_ = common.WriteError(w, "failed to create repository")
+	}
+	return false
+}
@@ -1,74 +1,52 @@
-// Package server provides a custom SSH Git server implementation.
-// This implementation uses golang.org/x/crypto/ssh directly for better control
-// over the Git protocol and to avoid "remote end hung up unexpectedly" errors.
+// Package server provides the HTTP and SSH servers of the Git repositories.
+// The SSH server uses golang.org/x/crypto/ssh directly for full control over
+// the Git protocol: the exec request, the pack exchange and the exit status.
 package server
 
 import (
-	"bufio"
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
-	"github.com/labbs/git-server-s3/pkg/common"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/autocreate"
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/labbs/git-server-s3/pkg/metrics"
+	"github.com/labbs/git-server-s3/pkg/protocolv2"
+	"github.com/labbs/git-server-s3/pkg/rbac"
 	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/tracing"
 	"github.com/rs/zerolog"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
 	"golang.org/x/crypto/ssh"
 )
 
-// bufferedChannel creates a buffered wrapper around an SSH channel for improved performance
-type bufferedChannel struct {
-	ssh.Channel
-	reader *bufio.Reader
-	writer *bufio.Writer
-}
-
-func newBufferedChannel(channel ssh.Channel) *bufferedChannel {
-	// Create very large buffers (4MB each) to handle massive Git operations
-	// This matches the buffer sizes used by GitHub and GitLab
-	return &bufferedChannel{
-		Channel: channel,
-		reader:  bufio.NewReaderSize(channel, 4*1024*1024), // 4MB read buffer
-		writer:  bufio.NewWriterSize(channel, 4*1024*1024), // 4MB write buffer
-	}
-}
-
-func (bc *bufferedChannel) Read(p []byte) (n int, err error) {
-	return bc.reader.Read(p)
-}
-
-func (bc *bufferedChannel) Write(p []byte) (n int, err error) {
-	n, err = bc.writer.Write(p)
-	if err == nil {
-		// Flush immediately for Git protocol compatibility
-		bc.writer.Flush()
-	}
-	return n, err
-}
-
-func (bc *bufferedChannel) Close() error {
-	if bc.writer != nil {
-		bc.writer.Flush()
-	}
-	return bc.Channel.Close()
-}
-
-// Unlike generic SSH servers, this implementation handles the Git protocol directly.
+// GitSSHServer is the SSH server of the Git repositories. Unlike generic SSH
+// servers, it handles the Git protocol directly: only git-upload-pack and
+// git-receive-pack can be run.
 type GitSSHServer struct {
-	Port        string                       // SSH server port (e.g., ":2222")
-	Logger      zerolog.Logger               // Logger for SSH operations
-	Storage     storage.GitRepositoryStorage // Storage backend for repositories
-	HostKeyPath string                       // Path to SSH host key file
-	listener    net.Listener                 // Network listener
-	sshConfig   *ssh.ServerConfig            // SSH server configuration
+	Port          string                       // SSH server port (e.g., ":2222")
+	Logger        zerolog.Logger               // Logger for SSH operations
+	Storage       storage.GitRepositoryStorage // Storage backend for repositories
+	HostKeyPath   string                       // Path to SSH host key file
+	Authenticator auth.SSHAuthenticator        // Client authentication, nil for demo mode (any credentials accepted)
+	Access        *rbac.Store                  // Access control of the repositories, nil when disabled
+	AutoCreate    *autocreate.Creator          // Creation of missing repositories on push, nil to reject them
+	Hooks         *hooks.Runner                // Pre-receive and post-receive hooks, nil for none
+	listener      net.Listener                 // Network listener
+	listening     atomic.Bool                  // Whether connections are accepted, see Check
+	sshConfig     *ssh.ServerConfig            // SSH server configuration
 }
 
 // Configure sets up the SSH server with proper Git protocol handling.
@@ -89,29 +67,37 @@ func (s *GitSSHServer) Configure() error {
 
 	// Create SSH server configuration with enhanced buffer and timeout settings
 	s.sshConfig = &ssh.ServerConfig{
-		// Demo authentication - in production, implement proper auth
-		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		// Configure SSH server to handle large Git operations like GitHub
+		ServerVersion: "SSH-2.0-GitServerS3",
+		MaxAuthTries:  3,
+		// Add configuration for better Git protocol handling
+		NoClientAuth: false,
+	}
+
+	if s.Authenticator == nil {
+		logger.Warn().Msg("SSH authentication is disabled, any key and password is accepted")
+		s.sshConfig.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
 			logger.Info().
 				Str("user", conn.User()).
 				Str("remote", conn.RemoteAddr().String()).
 				Msg("Password authentication attempt")
-			// Accept any password for demo (implement proper validation in production)
+			// Accept any password in demo mode
 			return nil, nil
-		},
-		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		}
+		s.sshConfig.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 			logger.Info().
 				Str("user", conn.User()).
 				Str("key_type", key.Type()).
 				Str("remote", conn.RemoteAddr().String()).
 				Msg("Public key authentication attempt")
-			// Accept any valid key for demo (implement proper validation in production)
+			// Accept any valid key in demo mode
 			return nil, nil
-		},
-		// Configure SSH server to handle large Git operations like GitHub
-		ServerVersion: "SSH-2.0-GitServerS3",
-		MaxAuthTries:  3,
-		// Add configuration for better Git protocol handling
-		NoClientAuth: false,
+		}
+	} else {
+		s.sshConfig.PublicKeyCallback = s.publicKeyCallback(logger)
+		if s.Authenticator.PasswordAuth() {
+			s.sshConfig.PasswordCallback = s.passwordCallback(logger)
+		}
 	}
 
 	// Add host key to server config
@@ -121,6 +107,48 @@ func (s *GitSSHServer) Configure() error {
 	return nil
 }
 
+// publicKeyCallback authenticates public keys with the Authenticator. The
+// identity is carried in the permissions of the connection.
+func (s *GitSSHServer) publicKeyCallback(logger zerolog.Logger) func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+	return func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		logger := logger.With().
+			Str("user", conn.User()).
+			Str("fingerprint", ssh.FingerprintSHA256(key)).
+			Str("remote", conn.RemoteAddr().String()).
+			Logger()
+
+		identity, err := s.Authenticator.AuthenticatePublicKey(conn.User(), key)
+		if err != nil {
+			metrics.SSHAuthFailure("publickey")
+			logger.Debug().Err(err).Msg("Public key rejected")
+			return nil, err
+		}
+
+		logger.Info().Str("identity", identity.Username).Msg("Public key authentication accepted")
+		return identity.SSHPermissions(), nil
+	}
+}
+
+// passwordCallback authenticates passwords with the Authenticator
+func (s *GitSSHServer) passwordCallback(logger zerolog.Logger) func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+	return func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		logger := logger.With().
+			Str("user", conn.User()).
+			Str("remote", conn.RemoteAddr().String()).
+			Logger()
+
+		identity, err := s.Authenticator.AuthenticatePassword(conn.User(), string(password))
+		if err != nil {
+			metrics.SSHAuthFailure("password")
+			logger.Warn().Err(err).Msg("Password authentication failed")
+			return nil, err
+		}
+
+		logger.Info().Str("identity", identity.Username).Msg("Password authentication accepted")
+		return identity.SSHPermissions(), nil
+	}
+}
+
 // Start begins listening for SSH connections and handles Git operations.
 func (s *GitSSHServer) Start() error {
 	logger := s.Logger.With().Str("component", "git-ssh-server").Logger()
@@ -132,6 +160,8 @@ func (s *GitSSHServer) Start() error {
 		return err
 	}
 	s.listener = listener
+	s.listening.Store(true)
+	defer s.listening.Store(false)
 
 	logger.Info().Str("addr", s.Port).Msg("Git SSH server started")
 
@@ -156,12 +186,21 @@ func (s *GitSSHServer) Start() error {
 // Stop gracefully stops the SSH server.
 func (s *GitSSHServer) Stop() error {
 	s.Logger.Info().Msg("Stopping Git SSH server")
+	s.listening.Store(false)
 	if s.listener != nil {
 		return s.listener.Close()
 	}
 	return nil
 }
 
+// Check reports whether the server accepts connections, it is a readiness check.
+func (s *GitSSHServer) Check(ctx context.Context) error {
+	if !s.listening.Load() {
+		return errors.New("SSH listener is down")
+	}
+	return nil
+}
+
 // handleConnection processes an incoming SSH connection.
 func (s *GitSSHServer) handleConnection(conn net.Conn) {
 	logger := s.Logger.With().
@@ -170,6 +209,7 @@ func (s *GitSSHServer) handleConnection(conn net.Conn) {
 		Logger()
 
 	defer conn.Close()
+	defer metrics.SSHConnection()()
 
 	// Set connection timeouts to handle large Git operations
 	// This prevents "remote end hung up unexpectedly" errors
@@ -196,6 +236,9 @@ func (s *GitSSHServer) handleConnection(conn net.Conn) {
 	}
 	defer sshConn.Close()
 
+	if identity := auth.IdentityFromSSHPermissions(sshConn.Permissions); identity != nil {
+		logger = logger.With().Str("identity", identity.Username).Logger()
+	}
 	logger.Info().Str("user", sshConn.User()).Msg("SSH connection established")
 
 	// Handle global requests (usually none for Git)
@@ -224,15 +267,33 @@ func (s *GitSSHServer) handleChannel(conn *ssh.ServerConn, newChannel ssh.NewCha
 	}
 	defer channel.Close()
 
-	// Process channel requests
+	// Process channel requests, environment variables are sent before exec
+	var gitProtocol string
 	for req := range requests {
 		switch req.Type {
+		case "env":
+			var env struct{ Name, Value string }
+			accepted := ssh.Unmarshal(req.Payload, &env) == nil && env.Name == "GIT_PROTOCOL"
+			if accepted {
+				gitProtocol = env.Value
+			}
+			if req.WantReply {
+				req.Reply(accepted, nil)
+			}
 		case "exec":
-			// Execute Git command
-			s.handleExecRequest(conn, channel, req, logger)
-			return // Exit after handling exec request
+			// A session runs a single command, the requests that follow are discarded
+			go ssh.DiscardRequests(requests)
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				return
+			}
+			req.Reply(true, nil)
+			status := s.handleExec(conn, channel, payload.Command, gitProtocol, logger)
+			s.exit(channel, status, logger)
+			return
 		default:
-			// Reject other request types
+			// Reject other request types, shells and terminals included
 			if req.WantReply {
 				req.Reply(false, nil)
 			}
@@ -240,295 +301,86 @@ func (s *GitSSHServer) handleChannel(conn *ssh.ServerConn, newChannel ssh.NewCha
 	}
 }
 
-// handleExecRequest processes Git command execution requests.
-func (s *GitSSHServer) handleExecRequest(conn *ssh.ServerConn, channel ssh.Channel, req *ssh.Request, logger zerolog.Logger) {
-	if !req.WantReply {
-		s.sendExitStatusAndClose(channel, 1)
-		return
-	}
-
-	// Extract command from request payload
-	command := string(req.Payload[4:]) // Skip 4-byte length prefix
+// handleExec runs a Git command and returns its exit status. The errors are
+// reported to the client on stderr, or as git errors when it expects a
+// reference advertisement. gitProtocol is the value of the GIT_PROTOCOL
+// environment variable sent by the client.
+func (s *GitSSHServer) handleExec(conn *ssh.ServerConn, channel ssh.Channel, command, gitProtocol string, logger zerolog.Logger) int {
 	logger = logger.With().Str("command", command).Logger()
 
-	// Parse Git command
-	service, repoPath := s.parseGitCommand(command)
-	if service == "" {
-		logger.Error().Msg("Invalid Git command")
-		req.Reply(false, nil)
-		s.sendExitStatusAndClose(channel, 1)
-		return
+	cmd, err := parseGitCommand(command)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Invalid Git command")
+		fmt.Fprintln(channel.Stderr(), err)
+		return 1
 	}
 
 	logger = logger.With().
-		Str("service", service).
-		Str("repo_path", repoPath).
+		Str("service", cmd.Service).
+		Str("repo_path", cmd.RepoPath).
 		Logger()
 
-	// Accept the request
-	req.Reply(true, nil)
-
-	var exitCode int = 0
-
-	// Handle the Git operation
-	switch service {
-	case "git-upload-pack":
-		if err := s.handleUploadPack(channel, repoPath, logger); err != nil {
-			logger.Error().Err(err).Msg("Upload pack failed")
-			exitCode = 1
-		}
-	case "git-receive-pack":
-		if err := s.handleReceivePack(channel, repoPath, logger); err != nil {
-			logger.Error().Err(err).Msg("Receive pack failed")
-			exitCode = 1
-		}
-	default:
-		logger.Error().Str("service", service).Msg("Unsupported Git service")
-		exitCode = 1
-	}
-
-	// Critical fix from go-git issue #1062:
-	// Don't close the channel immediately - let the client close first
-	s.sendExitStatusAndClose(channel, exitCode)
-}
-
-// handleUploadPack processes git-upload-pack operations (clone/fetch).
-func (s *GitSSHServer) handleUploadPack(channel ssh.Channel, repoPath string, logger zerolog.Logger) error {
-	logger.Info().Msg("Processing upload pack request")
-
-	// Create buffered channel for better performance with large Git operations
-	bufferedChan := newBufferedChannel(channel)
-
-	// Get transport server for the repository
-	srv, endpoint, err := common.GetTransportServer(repoPath, s.Storage)
-	if err != nil {
-		logger.Error().Err(err).Msg("Failed to get transport server")
-		return err
+	identity := auth.IdentityFromSSHPermissions(conn.Permissions)
+	if s.Access != nil && !s.Access.Can(identity, cmd.RepoPath, cmd.role()) {
+		logger.Warn().Msg("Access denied")
+		fmt.Fprintln(channel.Stderr(), "access denied")
+		return 1
 	}
 
-	// Create upload pack service
-	up, err := srv.NewUploadPackSession(endpoint, nil)
-	if err != nil {
-		logger.Error().Err(err).Msg("Failed to create upload pack session")
-		return err
-	}
-
-	// Send advertised references
-	advRefs, err := up.AdvertisedReferences()
-	if err != nil {
-		logger.Error().Err(err).Msg("Failed to get advertised references")
-		return err
-	}
-
-	// Always encode the advertised references, even if empty
-	if err := advRefs.Encode(bufferedChan); err != nil {
-		logger.Error().Err(err).Msg("Failed to encode advertised references")
-		return err
-	}
-
-	// Check if repository is empty (no references)
-	if advRefs == nil || len(advRefs.References) == 0 {
-		logger.Info().Msg("Repository is empty - no further processing needed")
-		return nil
-	}
-
-	// Read client request
-	req := packp.NewUploadPackRequest()
-	if err := req.Decode(bufferedChan); err != nil {
-		// Handle empty repository case gracefully
-		if strings.Contains(err.Error(), "missing 'want' prefix") ||
-			strings.Contains(err.Error(), "EOF") {
-			logger.Info().Msg("Client disconnected - likely empty repository or no wants")
-			return nil
+	// A push to a missing repository creates it when the auto-create policy allows it
+	if !s.Storage.RepositoryExists(cmd.RepoPath) {
+		if cmd.Service != serviceReceivePack {
+			logger.Warn().Msg("Repository not found")
+			fmt.Fprintln(channel.Stderr(), "repository not found")
+			return 1
 		}
-		logger.Error().Err(err).Msg("Failed to decode upload pack request")
-		return err
-	}
-
-	// Process upload pack
-	resp, err := up.UploadPack(context.Background(), req)
-	if err != nil {
-		logger.Error().Err(err).Msg("Upload pack failed")
-		return err
-	}
-	defer resp.Close()
-
-	// Send response to client
-	if err := resp.Encode(bufferedChan); err != nil {
-		logger.Error().Err(err).Msg("Failed to encode upload pack response")
-		return err
-	}
-
-	logger.Info().Msg("Upload pack completed successfully")
-	return nil
-}
-
-// handleReceivePack processes git-receive-pack operations (push).
-func (s *GitSSHServer) handleReceivePack(channel ssh.Channel, repoPath string, logger zerolog.Logger) error {
-	logger.Info().Msg("Processing receive pack request")
-
-	// Create buffered channel for better performance with large Git operations
-	bufferedChan := newBufferedChannel(channel)
-
-	// Get transport server for the repository
-	srv, endpoint, err := common.GetTransportServer(repoPath, s.Storage)
-	if err != nil {
-		logger.Error().Err(err).Msg("Failed to get transport server")
-		return err
-	}
-
-	// Create receive pack service
-	rp, err := srv.NewReceivePackSession(endpoint, nil)
-	if err != nil {
-		logger.Error().Err(err).Msg("Failed to create receive pack session")
-		return err
-	}
-
-	// Send advertised references
-	advRefs, err := rp.AdvertisedReferences()
-	if err != nil {
-		logger.Error().Err(err).Msg("Failed to get advertised references")
-		return err
-	}
-
-	if err := advRefs.Encode(bufferedChan); err != nil {
-		logger.Error().Err(err).Msg("Failed to encode advertised references")
-		return err
-	}
-
-	// Read client request
-	req := packp.NewReferenceUpdateRequest()
-	if err := req.Decode(bufferedChan); err != nil {
-		logger.Error().Err(err).Msg("Failed to decode receive pack request")
-		return err
-	}
-
-	// Process receive pack
-	report, err := rp.ReceivePack(context.Background(), req)
-	if err != nil {
-		logger.Error().Err(err).Msg("Receive pack failed")
-		return err
-	}
-
-	// Send status report to client if available
-	if report != nil {
-		// First, ensure we flush any buffered data
-		if err := bufferedChan.writer.Flush(); err != nil {
-			logger.Debug().Err(err).Msg("Failed to flush buffer before status report")
-		}
-
-		// Try to encode the report, ignore EOF as it's common during connection close
-		if err := report.Encode(bufferedChan); err != nil {
-			if err.Error() != "EOF" {
-				logger.Debug().Err(err).Msg("Failed to encode receive pack report")
-			}
+		if !createOnPush(s.AutoCreate, identity, cmd.RepoPath, channel, logger) {
+			return 1
 		}
 	}
 
-	logger.Info().Msg("Receive pack completed successfully")
-	return nil
-}
-
-// sendExitStatusAndClose properly handles SSH session termination
-// This fixes the "remote end hung up unexpectedly" issue based on go-git issue #1062
-func (s *GitSSHServer) sendExitStatusAndClose(channel ssh.Channel, status int) {
-	logger := s.Logger.With().Str("component", "ssh-close").Logger()
-	logger.Debug().Int("status", status).Msg("Starting sendExitStatusAndClose")
-
-	// For successful operations, use gentle close to prevent hangup messages
-	if status == 0 {
-		logger.Debug().Msg("Success case: using gentle close strategy")
-		// Just close write side and let client close the connection
-		if closeWriter, ok := channel.(interface{ CloseWrite() error }); ok {
-			if err := closeWriter.CloseWrite(); err != nil {
-				logger.Debug().Err(err).Msg("Failed to close write side")
-			} else {
-				logger.Debug().Msg("Write side closed successfully")
-			}
-		}
-
-		// Give client time to close gracefully
-		logger.Debug().Msg("Entering 200ms sleep for gentle close")
-		time.Sleep(200 * time.Millisecond)
-		logger.Debug().Msg("Gentle close completed")
-		return
+	// The command is the root span of the storage calls it makes
+	ctx, span := tracing.Start(context.Background(), "SSH "+cmd.Service, tracing.Service(cmd.Service), tracing.Repository(cmd.RepoPath))
+	if identity != nil {
+		span.SetAttributes(semconv.UserName(identity.Username))
 	}
 
-	// For error cases, still send proper exit status
-	logger.Debug().Msg("Error case: sending exit-status request")
-	exitStatus := ssh.Marshal(struct{ Value uint32 }{uint32(status)})
-	accepted, err := channel.SendRequest("exit-status", false, exitStatus)
-	if err != nil {
-		logger.Debug().Err(err).Msg("Failed to send exit-status")
-	} else {
-		logger.Debug().Bool("accepted", accepted).Msg("Exit-status sent successfully")
-	}
+	op := metrics.StartOperation(cmd.Service, cmd.RepoPath, metrics.ProtocolSSH)
+	in, out := op.Reader(channel), op.Writer(channel)
 
-	// Close write side to signal we're done sending data
-	logger.Debug().Msg("Attempting to close write side of channel")
-	if closeWriter, ok := channel.(interface{ CloseWrite() error }); ok {
-		if err := closeWriter.CloseWrite(); err != nil {
-			logger.Debug().Err(err).Msg("Failed to close write side")
-		} else {
-			logger.Debug().Msg("Write side closed successfully")
-		}
-	} else {
-		logger.Debug().Msg("Channel does not support CloseWrite")
+	logger.Info().Msg("Processing Git command")
+	switch cmd.Service {
+	case serviceUploadPack:
+		err = s.handleUploadPack(ctx, in, out, cmd.RepoPath, protocolv2.IsRequested(gitProtocol), logger)
+	case serviceReceivePack:
+		err = s.handleReceivePack(ctx, in, out, cmd.RepoPath, identity)
 	}
+	op.Done()
+	tracing.End(span, err)
 
-	// Add delay and close for error cases
-	logger.Debug().Msg("Entering 100ms sleep to let client process data")
-	time.Sleep(100 * time.Millisecond)
-	logger.Debug().Msg("Sleep completed, now closing channel")
-
-	// Now close the channel - this gives the client time to process everything
-	if err := channel.Close(); err != nil {
-		logger.Debug().Err(err).Msg("Failed to close channel")
-	} else {
-		logger.Debug().Msg("Channel closed successfully")
+	if err != nil {
+		logger.Error().Err(err).Msg("Git command failed")
+		fmt.Fprintln(channel.Stderr(), err)
+		return 1
 	}
-	logger.Debug().Msg("sendExitStatusAndClose completed")
+	logger.Info().Msg("Git command completed successfully")
+	return 0
 }
 
-// parseGitCommand extracts the Git service and repository path from a command.
-func (s *GitSSHServer) parseGitCommand(cmd string) (service, repoPath string) {
-	cmd = strings.TrimSpace(cmd)
-	if cmd == "" {
-		return "", ""
+// exit ends the command with its exit status, then closes the channel. The
+// status is sent whatever the outcome: without it, the ssh client exits with
+// 255 and git reports that the remote end hung up unexpectedly.
+func (s *GitSSHServer) exit(channel ssh.Channel, status int, logger zerolog.Logger) {
+	if err := channel.CloseWrite(); err != nil {
+		logger.Debug().Err(err).Msg("Failed to close the write side of the channel")
 	}
-
-	parts := strings.Fields(cmd)
-	if len(parts) < 2 {
-		return "", ""
+	exitStatus := ssh.Marshal(struct{ Status uint32 }{uint32(status)})
+	if _, err := channel.SendRequest("exit-status", false, exitStatus); err != nil {
+		logger.Debug().Err(err).Msg("Failed to send the exit status")
 	}
-
-	service = parts[0]
-	repoArg := strings.Join(parts[1:], " ")
-
-	// Extract repository path from argument
-	repoPath = s.extractRepoPath(repoArg)
-	repoPath = common.NormalizeRepoPath(repoPath)
-
-	return service, repoPath
-}
-
-// extractRepoPath extracts the repository path from the SSH argument.
-func (s *GitSSHServer) extractRepoPath(arg string) string {
-	// Remove quotes if present
-	arg = strings.Trim(arg, "'\"")
-
-	// Remove leading slash if present
-	arg = strings.TrimPrefix(arg, "/")
-
-	// Handle formats like "host:path" by taking only the path part
-	if strings.Contains(arg, ":") {
-		parts := strings.Split(arg, ":")
-		if len(parts) > 1 {
-			arg = parts[1]
-		}
+	if err := channel.Close(); err != nil && !errors.Is(err, io.EOF) {
+		logger.Debug().Err(err).Msg("Failed to close the channel")
 	}
-
-	return arg
 }
 
 // ensureHostKey generates or loads an SSH host key.
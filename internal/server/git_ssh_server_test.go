@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/autocreate"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return signer
+}
+
+// sshDial connects a client to the server through a loopback connection
+func sshDial(t *testing.T, s *GitSSHServer, config *ssh.ClientConfig) (*ssh.Client, error) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			s.handleConnection(conn)
+		}
+	}()
+
+	return ssh.Dial("tcp", listener.Addr().String(), config)
+}
+
+// sshHandshake checks that a client can connect to the server
+func sshHandshake(t *testing.T, s *GitSSHServer, config *ssh.ClientConfig) error {
+	t.Helper()
+
+	client, err := sshDial(t, s, config)
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
+
+func TestGitSSHServer_Authentication(t *testing.T) {
+	alice, stranger := newSigner(t), newSigner(t)
+
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "authorized_keys")
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(alice.PublicKey()))) + " alice\n"
+	require.NoError(t, os.WriteFile(keysPath, []byte(line), 0600))
+	keys, err := auth.LoadKeyStore(keysPath)
+	require.NoError(t, err)
+
+	s := &GitSSHServer{
+		Logger:        zerolog.Nop(),
+		HostKeyPath:   filepath.Join(dir, "host_key"),
+		Authenticator: &auth.KeyAuthenticator{Keys: keys},
+	}
+	require.NoError(t, s.Configure())
+	assert.Nil(t, s.sshConfig.PasswordCallback, "password authentication is disabled without tokens")
+
+	clientConfig := func(method ssh.AuthMethod) *ssh.ClientConfig {
+		return &ssh.ClientConfig{
+			User:            "git",
+			Auth:            []ssh.AuthMethod{method},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		}
+	}
+
+	assert.NoError(t, sshHandshake(t, s, clientConfig(ssh.PublicKeys(alice))))
+	assert.Error(t, sshHandshake(t, s, clientConfig(ssh.PublicKeys(stranger))))
+	assert.Error(t, sshHandshake(t, s, clientConfig(ssh.Password("demo"))))
+}
+
+func TestGitSSHServer_AccessDenied(t *testing.T) {
+	alice := newSigner(t)
+
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "authorized_keys")
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(alice.PublicKey()))) + " alice\n"
+	require.NoError(t, os.WriteFile(keysPath, []byte(line), 0600))
+	keys, err := auth.LoadKeyStore(keysPath)
+	require.NoError(t, err)
+	access, err := rbac.Load(filepath.Join(dir, "grants.json"), nil)
+	require.NoError(t, err)
+	_, err = access.SetGrant(rbac.Grant{User: "alice", Resource: "team/*", Role: rbac.Read})
+	require.NoError(t, err)
+
+	s := &GitSSHServer{
+		Logger:        zerolog.Nop(),
+		HostKeyPath:   filepath.Join(dir, "host_key"),
+		Authenticator: &auth.KeyAuthenticator{Keys: keys},
+		Access:        access,
+	}
+	require.NoError(t, s.Configure())
+
+	client, err := sshDial(t, s, &ssh.ClientConfig{
+		User:            "git",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(alice)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	// alice can read but not push, the repository is not looked up
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	var stderr strings.Builder
+	session.Stderr = &stderr
+	err = session.Run("git-receive-pack 'team/project.git'")
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 1, exitErr.ExitStatus())
+	assert.Equal(t, "access denied\n", stderr.String())
+}
+
+func TestGitSSHServer_AutoCreate(t *testing.T) {
+	config.Storage.Local.Path = t.TempDir()
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+
+	s := &GitSSHServer{
+		Logger:      zerolog.Nop(),
+		Storage:     str,
+		HostKeyPath: filepath.Join(t.TempDir(), "host_key"),
+	}
+	require.NoError(t, s.Configure())
+
+	push := func() (string, error) {
+		client, err := sshDial(t, s, &ssh.ClientConfig{
+			User:            "alice",
+			Auth:            []ssh.AuthMethod{ssh.Password("demo")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		require.NoError(t, err)
+		defer client.Close()
+
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		var stdout strings.Builder
+		session.Stdout = &stdout
+		session.Stdin = strings.NewReader("0000")
+		err = session.Run("git-receive-pack 'alice/new.git'")
+		return stdout.String(), err
+	}
+
+	// Without a policy the push is rejected with a git error
+	stdout, err := push()
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 1, exitErr.ExitStatus())
+	assert.Contains(t, stdout, "ERR repository creation rejected: repository 'alice/new' not found")
+	assert.False(t, str.RepositoryExists("alice/new.git"))
+
+	// The references of the created repository are advertised, the session
+	// ends with a zero exit status
+	s.AutoCreate = &autocreate.Creator{Policy: autocreate.On, Storage: str}
+	stdout, err = push()
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "report-status")
+	assert.NotContains(t, stdout, "ERR")
+	assert.True(t, str.RepositoryExists("alice/new.git"))
+}
+
+func TestGitSSHServer_Check(t *testing.T) {
+	s := &GitSSHServer{
+		Port:        "127.0.0.1:0",
+		Logger:      zerolog.Nop(),
+		HostKeyPath: filepath.Join(t.TempDir(), "host_key"),
+	}
+	require.NoError(t, s.Configure())
+	assert.Error(t, s.Check(context.Background()), "not started")
+
+	done := make(chan error)
+	go func() { done <- s.Start() }()
+	require.Eventually(t, func() bool {
+		return s.Check(context.Background()) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, s.Stop())
+	require.NoError(t, <-done)
+	assert.Error(t, s.Check(context.Background()))
+}
+
+func TestGitSSHServer_ensureHostKey(t *testing.T) {
+	s := &GitSSHServer{
+		Logger:      zerolog.Nop(),
+		HostKeyPath: filepath.Join(t.TempDir(), "host_key"),
+	}
+
+	// The key is generated on first use
+	generated, err := s.ensureHostKey()
+	require.NoError(t, err)
+	assert.FileExists(t, s.HostKeyPath)
+
+	// then loaded
+	loaded, err := s.ensureHostKey()
+	require.NoError(t, err)
+	assert.Equal(t, generated.PublicKey().Marshal(), loaded.PublicKey().Marshal())
+}
@@ -1,11 +1,25 @@
 package server
 
 import (
+	"io"
 	"strconv"
+	"strings"
 
+	"github.com/labbs/git-server-s3/internal/api/middleware"
 	"github.com/labbs/git-server-s3/internal/api/router"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/autocreate"
+	"github.com/labbs/git-server-s3/pkg/health"
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/labbs/git-server-s3/pkg/lfs"
 	"github.com/labbs/git-server-s3/pkg/logger/zerolog"
+	"github.com/labbs/git-server-s3/pkg/metrics"
+	"github.com/labbs/git-server-s3/pkg/mirror"
+	"github.com/labbs/git-server-s3/pkg/protection"
+	"github.com/labbs/git-server-s3/pkg/rbac"
 	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/tracing"
+	"github.com/labbs/git-server-s3/pkg/webhooks"
 
 	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
@@ -22,6 +36,37 @@ type HttpConfig struct {
 	Fiber    *fiber.App
 	Logger   z.Logger
 	Storage  storage.GitRepositoryStorage
+
+	// Metrics records the requests and serves the Prometheus metrics on /metrics
+	Metrics bool
+	// Health runs the readiness checks of /health/ready, nil for none
+	Health *health.Checker
+	// Tracing starts a span for each request, continuing the trace of its traceparent header
+	Tracing bool
+
+	// Tokens authenticates the requests, authentication is disabled when nil
+	Tokens *auth.TokenStore
+	// BearerTokens also accepts tokens sent as bearer tokens
+	BearerTokens bool
+	// Access is the access control of the repositories, disabled when nil
+	Access *rbac.Store
+	// AutoCreate creates missing repositories on push, following the auto-create policy
+	AutoCreate *autocreate.Creator
+	// Hooks run before and after the reference updates of the pushes
+	Hooks *hooks.Runner
+	// Webhooks sends the pushes to the webhooks of the repositories, its
+	// management endpoints are disabled when nil
+	Webhooks *webhooks.Dispatcher
+	// Protections are the branch protection rules, enforced by a pre-receive
+	// hook, their management endpoints are disabled when nil
+	Protections *protection.Store
+	// LFS stores the Git LFS objects, the LFS API is disabled when nil
+	LFS      storage.LFSStorage
+	LFSLocks *lfs.LockStore
+	// Mirrors are the pull mirrors, nil when disabled
+	Mirrors *mirror.Mirrorer
+	// PushMirrors replicates the pushes to the push mirrors, nil when disabled
+	PushMirrors *mirror.Pusher
 }
 
 func (c *HttpConfig) Configure() {
@@ -29,6 +74,9 @@ func (c *HttpConfig) Configure() {
 		JSONEncoder:           json.Marshal,
 		JSONDecoder:           json.Unmarshal,
 		DisableStartupMessage: true,
+		// Request bodies are streamed so that pushes of any size use bounded
+		// memory, see limitBufferedBody for the other routes
+		StreamRequestBody: true,
 	}
 
 	r := fiber.New(fiberConfig)
@@ -36,29 +84,92 @@ func (c *HttpConfig) Configure() {
 	if c.HttpLogs {
 		r.Use(zerolog.HTTPLogger(c.Logger))
 	}
+	if c.Metrics {
+		// Before recover, so that the panics are recorded as errors
+		r.Use(metrics.Middleware())
+	}
 
 	r.Use(recover.New())
 	r.Use(cors.New())
 	r.Use(compress.New())
 	r.Use(requestid.New())
+	if c.Tracing {
+		// After requestid, whose request ID is set on the spans
+		r.Use(tracing.Middleware())
+	}
+	if c.Tokens != nil {
+		// Before limitBufferedBody, so that anonymous request bodies are not read
+		r.Use(middleware.Auth(middleware.AuthConfig{
+			Logger:       c.Logger,
+			Tokens:       c.Tokens,
+			BearerTokens: c.BearerTokens,
+		}))
+	}
+	r.Use(limitBufferedBody(fiber.DefaultBodyLimit))
 
-	r.Get("/health", func(ctx *fiber.Ctx) error {
-		return ctx.JSON(fiber.Map{
-			"status":  "ok",
-			"service": "git-server-s3",
-		})
-	})
+	checker := c.Health
+	if checker == nil {
+		checker = health.NewChecker(0)
+	}
+	r.Get("/health", health.LiveHandler())
+	r.Get("/health/live", health.LiveHandler())
+	r.Get("/health/ready", checker.ReadyHandler())
+	if c.Metrics {
+		r.Get("/metrics", metrics.Handler())
+	}
 
 	c.Fiber = r
 }
 
+// limitBufferedBody buffers the streamed request body of every route but the
+// smart HTTP pack endpoints and the LFS uploads, which stream it, rejecting
+// bodies larger than limit.
+func limitBufferedBody(limit int) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		path := ctx.Path()
+		if !ctx.Request().IsBodyStream() ||
+			strings.HasSuffix(path, "/git-upload-pack") ||
+			strings.HasSuffix(path, "/git-receive-pack") ||
+			(ctx.Method() == fiber.MethodPut && strings.Contains(path, "/info/lfs/objects/")) {
+			return ctx.Next()
+		}
+
+		if ctx.Request().Header.ContentLength() > limit {
+			// The body is left unread, the connection cannot be reused
+			ctx.Context().SetConnectionClose()
+			return ctx.SendStatus(fiber.StatusRequestEntityTooLarge)
+		}
+
+		body, err := io.ReadAll(io.LimitReader(ctx.Context().RequestBodyStream(), int64(limit)+1))
+		if err != nil {
+			return ctx.SendStatus(fiber.StatusBadRequest)
+		}
+		if len(body) > limit {
+			ctx.Context().SetConnectionClose()
+			return ctx.SendStatus(fiber.StatusRequestEntityTooLarge)
+		}
+
+		ctx.Request().SetBody(body)
+		return ctx.Next()
+	}
+}
+
 func (c *HttpConfig) NewServer() error {
 	c.Configure()
 
 	apirc := router.Config{
-		Logger:  c.Logger,
-		Fiber:   c.Fiber,
-		Storage: c.Storage,
+		Logger:      c.Logger,
+		Fiber:       c.Fiber,
+		Storage:     c.Storage,
+		Access:      c.Access,
+		AutoCreate:  c.AutoCreate,
+		Hooks:       c.Hooks,
+		Webhooks:    c.Webhooks,
+		Protections: c.Protections,
+		LFS:         c.LFS,
+		LFSLocks:    c.LFSLocks,
+		Mirrors:     c.Mirrors,
+		PushMirrors: c.PushMirrors,
 	}
 
 	apirc.Configure()
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/health"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitBufferedBody(t *testing.T) {
+	app := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+		StreamRequestBody:     true,
+		BodyLimit:             16,
+	})
+	app.Use(limitBufferedBody(1024))
+
+	echo := func(ctx *fiber.Ctx) error {
+		return ctx.Send(ctx.Body())
+	}
+	app.Post("/api/repo", echo)
+	app.Post("/repo.git/git-receive-pack", func(ctx *fiber.Ctx) error {
+		n, err := io.Copy(io.Discard, ctx.Context().RequestBodyStream())
+		if err != nil {
+			return err
+		}
+		return ctx.JSON(n)
+	})
+
+	tests := []struct {
+		name   string
+		url    string
+		size   int
+		status int
+	}{
+		{name: "small API body", url: "/api/repo", size: 512, status: fiber.StatusOK},
+		{name: "large API body", url: "/api/repo", size: 4096, status: fiber.StatusRequestEntityTooLarge},
+		{name: "large push body", url: "/repo.git/git-receive-pack", size: 64 << 10, status: fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := bytes.Repeat([]byte("x"), tt.size)
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, tt.url, bytes.NewReader(body)), -1)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		c := &HttpConfig{Metrics: enabled}
+		c.Configure()
+
+		resp, err := c.Fiber.Test(httptest.NewRequest(fiber.MethodGet, "/health", nil))
+		require.NoError(t, err)
+		require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+		resp, err = c.Fiber.Test(httptest.NewRequest(fiber.MethodGet, "/metrics", nil))
+		require.NoError(t, err)
+		if !enabled {
+			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+			continue
+		}
+		require.Equal(t, fiber.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), `git_server_http_requests_total{method="GET",route="/health",status="200"}`)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	dir := t.TempDir()
+	config.Storage.Local.Path = dir
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+
+	checker := health.NewChecker(time.Second)
+	checker.Add("storage", str.Check)
+	c := &HttpConfig{Health: checker}
+	c.Configure()
+
+	ready := func() (int, health.Report) {
+		resp, err := c.Fiber.Test(httptest.NewRequest(fiber.MethodGet, "/health/ready", nil))
+		require.NoError(t, err)
+		var report health.Report
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		return resp.StatusCode, report
+	}
+
+	status, report := ready()
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, health.StatusOK, report.Components["storage"].Status)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the canary is deleted")
+
+	// The storage directory is gone, the node is not ready but still alive
+	require.NoError(t, os.RemoveAll(dir))
+	status, report = ready()
+	assert.Equal(t, fiber.StatusServiceUnavailable, status)
+	assert.Equal(t, health.StatusFail, report.Status)
+	assert.Contains(t, report.Components["storage"].Error, "failed to write the canary")
+
+	for _, path := range []string{"/health", "/health/live"} {
+		resp, err := c.Fiber.Test(httptest.NewRequest(fiber.MethodGet, path, nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode, path)
+	}
+}
@@ -0,0 +1,7 @@
+// Package auth authenticates the users of the HTTP and SSH servers.
+package auth
+
+// Identity is an authenticated user
+type Identity struct {
+	Username string
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrInvalidCredentials is returned when SSH credentials are not accepted
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// identityExtension is the ssh.Permissions extension holding the username of
+// the authenticated identity
+const identityExtension = "git-server-s3-identity"
+
+// SSHAuthenticator authenticates the users of the SSH server.
+// The SSH user name is usually "git": users are identified by their key.
+type SSHAuthenticator interface {
+	// AuthenticatePublicKey returns the identity owning key
+	AuthenticatePublicKey(user string, key ssh.PublicKey) (*Identity, error)
+	// AuthenticatePassword returns the identity of user if password is valid
+	AuthenticatePassword(user, password string) (*Identity, error)
+	// PasswordAuth reports whether password authentication is offered at all
+	PasswordAuth() bool
+}
+
+// SSHPermissions returns the ssh.Permissions carrying the identity through
+// the connection, see IdentityFromSSHPermissions.
+func (i *Identity) SSHPermissions() *ssh.Permissions {
+	return &ssh.Permissions{
+		Extensions: map[string]string{identityExtension: i.Username},
+	}
+}
+
+// IdentityFromSSHPermissions returns the identity authenticated for a SSH
+// connection, nil if there is none.
+func IdentityFromSSHPermissions(p *ssh.Permissions) *Identity {
+	if p == nil || p.Extensions[identityExtension] == "" {
+		return nil
+	}
+	return &Identity{Username: p.Extensions[identityExtension]}
+}
+
+// KeyStore maps SSH public keys, by SHA-256 fingerprint, to their owner.
+//
+// Keys are loaded either from a directory holding one authorized_keys file
+// per user, named after the user (alice or alice.pub), or from a single file
+// whose lines are either:
+//
+//	SHA256:<fingerprint> <username>
+//	<authorized_keys line, the comment being the username>
+type KeyStore struct {
+	owners map[string]string
+}
+
+// LoadKeyStore reads the keys of a directory or of a file
+func LoadKeyStore(path string) (*KeyStore, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized keys: %w", err)
+	}
+
+	store := &KeyStore{owners: make(map[string]string)}
+	if !info.IsDir() {
+		return store, store.loadFile(path, "")
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized keys: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		username := strings.TrimSuffix(entry.Name(), ".pub")
+		if err := store.loadFile(filepath.Join(path, entry.Name()), username); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// loadFile reads an authorized keys file. Keys belong to username, or to the
+// user named by each line when username is empty.
+func (s *KeyStore) loadFile(path, username string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read authorized keys: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fingerprint, owner, err := parseKeyLine(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		if username != "" {
+			owner = username
+		}
+		if owner == "" {
+			return fmt.Errorf("%s:%d: missing username", path, lineNumber)
+		}
+		if existing, ok := s.owners[fingerprint]; ok && existing != owner {
+			return fmt.Errorf("%s:%d: key %s already belongs to %s", path, lineNumber, fingerprint, existing)
+		}
+		s.owners[fingerprint] = owner
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read authorized keys: %w", err)
+	}
+	return nil
+}
+
+// parseKeyLine returns the fingerprint of the key of a line, and the user it
+// names if any
+func parseKeyLine(line string) (fingerprint, username string, err error) {
+	if strings.HasPrefix(line, "SHA256:") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return "", "", errors.New(`expected "SHA256:<fingerprint> <username>"`)
+		}
+		return fields[0], fields[1], nil
+	}
+
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid public key: %w", err)
+	}
+	return ssh.FingerprintSHA256(key), strings.TrimSpace(comment), nil
+}
+
+// Owner returns the username owning key
+func (s *KeyStore) Owner(key ssh.PublicKey) (string, bool) {
+	username, ok := s.owners[ssh.FingerprintSHA256(key)]
+	return username, ok
+}
+
+// KeyAuthenticator is the SSHAuthenticator of the server: public keys are
+// checked against a KeyStore, passwords are personal access tokens, as for
+// HTTP. Password authentication is disabled when Tokens is nil.
+type KeyAuthenticator struct {
+	Keys   *KeyStore
+	Tokens *TokenStore
+}
+
+func (a *KeyAuthenticator) AuthenticatePublicKey(user string, key ssh.PublicKey) (*Identity, error) {
+	username, ok := a.Keys.Owner(key)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Username: username}, nil
+}
+
+func (a *KeyAuthenticator) AuthenticatePassword(user, password string) (*Identity, error) {
+	if a.Tokens == nil {
+		return nil, ErrInvalidCredentials
+	}
+	identity, ok := a.Tokens.AuthenticateBasic(user, password)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return identity, nil
+}
+
+func (a *KeyAuthenticator) PasswordAuth() bool {
+	return a.Tokens != nil
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newPublicKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return key
+}
+
+// authorizedKey returns the authorized_keys line of key
+func authorizedKey(key ssh.PublicKey, comment string) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " " + comment + "\n"
+}
+
+func TestLoadKeyStoreDirectory(t *testing.T) {
+	alice, bob, unknown := newPublicKey(t), newPublicKey(t), newPublicKey(t)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "alice.pub"), []byte(authorizedKey(alice, "alice@laptop")), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bob"), []byte("# work key\n"+authorizedKey(bob, "")), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden"), []byte("garbage"), 0600))
+
+	store, err := LoadKeyStore(dir)
+	require.NoError(t, err)
+
+	owner, ok := store.Owner(alice)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", owner)
+	owner, ok = store.Owner(bob)
+	assert.True(t, ok)
+	assert.Equal(t, "bob", owner)
+	_, ok = store.Owner(unknown)
+	assert.False(t, ok)
+}
+
+func TestLoadKeyStoreFile(t *testing.T) {
+	alice, bob := newPublicKey(t), newPublicKey(t)
+
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	content := authorizedKey(alice, "alice") + ssh.FingerprintSHA256(bob) + " bob\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	store, err := LoadKeyStore(path)
+	require.NoError(t, err)
+
+	owner, _ := store.Owner(alice)
+	assert.Equal(t, "alice", owner)
+	owner, _ = store.Owner(bob)
+	assert.Equal(t, "bob", owner)
+}
+
+func TestLoadKeyStoreInvalid(t *testing.T) {
+	key := newPublicKey(t)
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+
+	// A key without username
+	require.NoError(t, os.WriteFile(path, []byte(authorizedKey(key, "")), 0600))
+	_, err := LoadKeyStore(path)
+	assert.ErrorContains(t, err, "missing username")
+
+	// A key owned by two users
+	require.NoError(t, os.WriteFile(path, []byte(authorizedKey(key, "alice")+authorizedKey(key, "bob")), 0600))
+	_, err = LoadKeyStore(path)
+	assert.ErrorContains(t, err, "already belongs to alice")
+
+	require.NoError(t, os.WriteFile(path, []byte("not a key\n"), 0600))
+	_, err = LoadKeyStore(path)
+	assert.ErrorContains(t, err, "invalid public key")
+}
+
+func TestKeyAuthenticator(t *testing.T) {
+	alice := newPublicKey(t)
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	require.NoError(t, os.WriteFile(path, []byte(authorizedKey(alice, "alice")), 0600))
+	keys, err := LoadKeyStore(path)
+	require.NoError(t, err)
+
+	authenticator := &KeyAuthenticator{Keys: keys}
+	identity, err := authenticator.AuthenticatePublicKey("git", alice)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", identity.Username)
+	_, err = authenticator.AuthenticatePublicKey("git", newPublicKey(t))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Passwords are disabled without tokens
+	assert.False(t, authenticator.PasswordAuth())
+	_, err = authenticator.AuthenticatePassword("alice", "secret")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	authenticator.Tokens, err = LoadTokenStore(writeTokensFile(t, "alice "+HashToken("secret")+"\n"))
+	require.NoError(t, err)
+	assert.True(t, authenticator.PasswordAuth())
+	identity, err = authenticator.AuthenticatePassword("alice", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", identity.Username)
+}
+
+func TestIdentitySSHPermissions(t *testing.T) {
+	identity := &Identity{Username: "alice"}
+	assert.Equal(t, identity, IdentityFromSSHPermissions(identity.SSHPermissions()))
+	assert.Nil(t, IdentityFromSSHPermissions(nil))
+	assert.Nil(t, IdentityFromSSHPermissions(&ssh.Permissions{}))
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TokenStore holds the personal access tokens of the users.
+//
+// Tokens are loaded from a file with one "<username> <sha256>" line per token,
+// where <sha256> is the hex encoded SHA-256 of the token, so that the file
+// never holds the tokens themselves. Empty lines and lines starting with #
+// are ignored. A user may have several tokens.
+type TokenStore struct {
+	// owners maps token hashes to the username they belong to
+	owners map[string]string
+}
+
+// HashToken returns the hex encoded SHA-256 of a token, as stored in a tokens file
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadTokenStore reads a tokens file
+func LoadTokenStore(path string) (*TokenStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tokens file: %w", err)
+	}
+	defer f.Close()
+
+	store := &TokenStore{owners: make(map[string]string)}
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<username> <sha256>\"", path, lineNumber)
+		}
+		hash, err := hex.DecodeString(fields[1])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: invalid SHA-256 token hash", path, lineNumber)
+		}
+		store.owners[strings.ToLower(fields[1])] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %w", err)
+	}
+
+	return store, nil
+}
+
+// Authenticate returns the identity owning token
+func (s *TokenStore) Authenticate(token string) (*Identity, bool) {
+	if token == "" {
+		return nil, false
+	}
+	username, ok := s.owners[HashToken(token)]
+	if !ok {
+		return nil, false
+	}
+	return &Identity{Username: username}, true
+}
+
+// AuthenticateBasic returns the identity of a HTTP Basic user, whose password
+// is one of its tokens
+func (s *TokenStore) AuthenticateBasic(username, token string) (*Identity, bool) {
+	identity, ok := s.Authenticate(token)
+	if !ok || subtle.ConstantTimeCompare([]byte(identity.Username), []byte(username)) != 1 {
+		return nil, false
+	}
+	return identity, true
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTokensFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestTokenStore(t *testing.T) {
+	path := writeTokensFile(t, "# users\n\nalice "+HashToken("alice-token")+"\nbob "+HashToken("bob-token")+"\n")
+	store, err := LoadTokenStore(path)
+	require.NoError(t, err)
+
+	identity, ok := store.Authenticate("alice-token")
+	require.True(t, ok)
+	assert.Equal(t, "alice", identity.Username)
+
+	_, ok = store.Authenticate("unknown")
+	assert.False(t, ok)
+	_, ok = store.Authenticate("")
+	assert.False(t, ok)
+
+	identity, ok = store.AuthenticateBasic("bob", "bob-token")
+	require.True(t, ok)
+	assert.Equal(t, "bob", identity.Username)
+
+	// The token must belong to the user
+	_, ok = store.AuthenticateBasic("alice", "bob-token")
+	assert.False(t, ok)
+}
+
+func TestLoadTokenStoreInvalid(t *testing.T) {
+	_, err := LoadTokenStore(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+
+	_, err = LoadTokenStore(writeTokensFile(t, "alice\n"))
+	assert.ErrorContains(t, err, ":1:")
+
+	_, err = LoadTokenStore(writeTokensFile(t, "alice plaintext-token\n"))
+	assert.ErrorContains(t, err, "invalid SHA-256")
+}
@@ -0,0 +1,88 @@
+// Package autocreate implements the repository auto-create policy: whether a
+// push to a missing repository creates it. The policy is shared by the smart
+// HTTP and SSH servers.
+package autocreate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage"
+)
+
+// Policy tells which pushes to a missing repository create it
+type Policy string
+
+const (
+	// Off rejects every push to a missing repository
+	Off Policy = "off"
+	// On creates the repository for any user allowed to push to it
+	On Policy = "on"
+	// Owned creates the repository only in a namespace the pusher owns: the
+	// namespace named after the user, or one the user is granted admin on
+	Owned Policy = "owned"
+)
+
+// ParsePolicy returns the policy named s
+func ParsePolicy(s string) (Policy, error) {
+	switch policy := Policy(strings.ToLower(strings.TrimSpace(s))); policy {
+	case Off, On, Owned:
+		return policy, nil
+	case "":
+		return Off, nil
+	default:
+		return Off, fmt.Errorf("invalid auto-create policy %q, expected off, on or owned", s)
+	}
+}
+
+// ErrRejected is returned when the policy does not allow creating the
+// repository, the message of the wrapping error is meant for the git client
+var ErrRejected = errors.New("repository creation rejected")
+
+// Creator creates missing repositories on push according to its policy. A
+// nil Creator rejects every creation.
+type Creator struct {
+	Policy  Policy
+	Storage storage.GitRepositoryStorage
+	Access  *rbac.Store // Grants giving namespace ownership, nil when access control is disabled
+}
+
+// Create creates the missing repository repoPath pushed to by identity. It
+// returns an error wrapping ErrRejected when the policy does not allow it.
+func (c *Creator) Create(identity *auth.Identity, repoPath string) error {
+	if err := c.check(identity, repoPath); err != nil {
+		return err
+	}
+	if err := storage.InitRepository(c.Storage, repoPath, storage.InitOptions{}); err != nil {
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+	return nil
+}
+
+// check returns an error wrapping ErrRejected when the policy does not allow
+// identity to create repoPath
+func (c *Creator) check(identity *auth.Identity, repoPath string) error {
+	name := strings.TrimSuffix(repoPath, ".git")
+	if c == nil || c.Policy == Off || c.Policy == "" {
+		return fmt.Errorf("%w: repository '%s' not found, creating repositories on push is disabled", ErrRejected, name)
+	}
+	if c.Policy == Owned && !c.owns(identity, repoPath) {
+		return fmt.Errorf("%w: repository '%s' not found, repositories can only be created on push in a namespace you own", ErrRejected, name)
+	}
+	return nil
+}
+
+// owns reports whether the namespace of repoPath belongs to identity: it is
+// named after the user, or the user is admin of the repository through a grant
+func (c *Creator) owns(identity *auth.Identity, repoPath string) bool {
+	if identity == nil {
+		return false
+	}
+	if namespace, _, ok := strings.Cut(repoPath, "/"); ok && namespace == identity.Username {
+		return true
+	}
+	return c.Access != nil && c.Access.Can(identity, repoPath, rbac.Admin)
+}
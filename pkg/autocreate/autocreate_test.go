@@ -0,0 +1,74 @@
+package autocreate
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/rbac"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCreator(t *testing.T, policy Policy) *Creator {
+	t.Helper()
+
+	config.Storage.Local.Path = t.TempDir()
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	access, err := rbac.Load(filepath.Join(t.TempDir(), "grants.json"), nil)
+	require.NoError(t, err)
+	_, err = access.SetGrant(rbac.Grant{User: "alice", Resource: "team/*", Role: rbac.Admin})
+	require.NoError(t, err)
+
+	return &Creator{Policy: policy, Storage: str, Access: access}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for s, expected := range map[string]Policy{"": Off, "off": Off, "On": On, " owned ": Owned} {
+		policy, err := ParsePolicy(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, policy, s)
+	}
+	_, err := ParsePolicy("always")
+	assert.Error(t, err)
+}
+
+func TestCreate(t *testing.T) {
+	alice := &auth.Identity{Username: "alice"}
+
+	var disabled *Creator
+	err := disabled.Create(alice, "alice/project.git")
+	assert.ErrorIs(t, err, ErrRejected)
+	assert.Contains(t, err.Error(), "repository 'alice/project' not found")
+
+	c := newTestCreator(t, Off)
+	assert.ErrorIs(t, c.Create(alice, "alice/project.git"), ErrRejected)
+	assert.False(t, c.Storage.RepositoryExists("alice/project.git"))
+
+	c = newTestCreator(t, On)
+	require.NoError(t, c.Create(nil, "project.git"))
+	assert.True(t, c.Storage.RepositoryExists("project.git"))
+}
+
+func TestCreateOwned(t *testing.T) {
+	c := newTestCreator(t, Owned)
+	alice := &auth.Identity{Username: "alice"}
+
+	// The personal namespace and the namespaces granted admin are owned
+	require.NoError(t, c.Create(alice, "alice/project.git"))
+	require.NoError(t, c.Create(alice, "team/project.git"))
+
+	for _, repoPath := range []string{"bob/project.git", "project.git", "alice.git"} {
+		assert.ErrorIs(t, c.Create(alice, repoPath), ErrRejected, repoPath)
+		assert.False(t, c.Storage.RepositoryExists(repoPath), repoPath)
+	}
+	assert.ErrorIs(t, c.Create(nil, "alice/other.git"), ErrRejected)
+
+	// Without access control, only the personal namespace is owned
+	c.Access = nil
+	assert.ErrorIs(t, c.Create(alice, "team/other.git"), ErrRejected)
+}
@@ -0,0 +1,340 @@
+// Package browse reads the references, commits, trees and files of a
+// repository from its storer, for the repository browsing API. It works the
+// same on every storage backend.
+package browse
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// ErrNotFound is returned for a revision, path or object that does not exist
+var ErrNotFound = errors.New("not found")
+
+// Branch is a branch and the commit at its tip
+type Branch struct {
+	Name    string `json:"name"`
+	Commit  string `json:"commit"`
+	Default bool   `json:"default"` // HEAD points to the branch
+}
+
+// Tag is a tag and the object it points to, peeled for annotated tags
+type Tag struct {
+	Name      string `json:"name"`
+	Target    string `json:"target"`
+	Annotated bool   `json:"annotated"`
+	Message   string `json:"message,omitempty"` // Message of an annotated tag
+}
+
+// Signature is the author or committer of a commit
+type Signature struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Date  time.Time `json:"date"`
+}
+
+// Commit is a commit with the hashes of its parents and tree
+type Commit struct {
+	SHA       string    `json:"sha"`
+	Message   string    `json:"message"`
+	Author    Signature `json:"author"`
+	Committer Signature `json:"committer"`
+	Parents   []string  `json:"parents"`
+	Tree      string    `json:"tree"`
+}
+
+// Entry types of a tree
+const (
+	TypeBlob   = "blob"
+	TypeTree   = "tree"
+	TypeCommit = "commit" // Submodule
+)
+
+// TreeEntry is an entry of a tree
+type TreeEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+	SHA  string `json:"sha"`
+	Size int64  `json:"size,omitempty"` // Size of a blob
+}
+
+// Branches returns the branches of the repository, sorted by name
+func Branches(st storer.Storer) ([]Branch, error) {
+	var head plumbing.ReferenceName
+	if ref, err := st.Reference(plumbing.HEAD); err == nil && ref.Type() == plumbing.SymbolicReference {
+		head = ref.Target()
+	}
+
+	branches := []Branch{}
+	err := eachReference(st, func(ref *plumbing.Reference) error {
+		if ref.Name().IsBranch() {
+			branches = append(branches, Branch{
+				Name:    ref.Name().Short(),
+				Commit:  ref.Hash().String(),
+				Default: ref.Name() == head,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(branches, func(a, b Branch) int { return strings.Compare(a.Name, b.Name) })
+	return branches, nil
+}
+
+// Tags returns the tags of the repository, sorted by name
+func Tags(st storer.Storer) ([]Tag, error) {
+	tags := []Tag{}
+	err := eachReference(st, func(ref *plumbing.Reference) error {
+		if !ref.Name().IsTag() {
+			return nil
+		}
+		t := Tag{Name: ref.Name().Short(), Target: ref.Hash().String()}
+		obj, err := object.GetObject(st, ref.Hash())
+		if err != nil {
+			return err
+		}
+		// A lightweight tag points to another object type
+		if tag, ok := obj.(*object.Tag); ok {
+			target, err := peel(st, tag)
+			if err != nil {
+				return err
+			}
+			t.Target, t.Annotated, t.Message = target.String(), true, tag.Message
+		}
+		tags = append(tags, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(tags, func(a, b Tag) int { return strings.Compare(a.Name, b.Name) })
+	return tags, nil
+}
+
+// eachReference calls fn with each reference of the repository, symbolic
+// references excluded
+func eachReference(st storer.Storer, fn func(*plumbing.Reference) error) error {
+	refs, err := st.IterReferences()
+	if err != nil {
+		return err
+	}
+	defer refs.Close()
+	return refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		return fn(ref)
+	})
+}
+
+// peel returns the object an annotated tag points to, following tags of tags
+func peel(st storer.Storer, tag *object.Tag) (plumbing.Hash, error) {
+	for tag.TargetType == plumbing.TagObject {
+		next, err := object.GetTag(st, tag.Target)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		tag = next
+	}
+	return tag.Target, nil
+}
+
+// Resolve returns the commit of a revision: a full commit hash, a branch, a
+// tag or a full reference name. An empty revision is HEAD.
+func Resolve(st storer.Storer, rev string) (*object.Commit, error) {
+	hash, err := resolveHash(st, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := object.GetObject(st, hash)
+	if err != nil {
+		return nil, notFound(err, "commit %s", rev)
+	}
+	if tag, ok := obj.(*object.Tag); ok {
+		if hash, err = peel(st, tag); err != nil {
+			return nil, notFound(err, "commit %s", rev)
+		}
+	}
+	commit, err := object.GetCommit(st, hash)
+	if err != nil {
+		return nil, notFound(err, "commit %s", rev)
+	}
+	return commit, nil
+}
+
+// resolveHash returns the object hash a revision names
+func resolveHash(st storer.Storer, rev string) (plumbing.Hash, error) {
+	if rev == "" {
+		rev = plumbing.HEAD.String()
+	}
+	if plumbing.IsHash(rev) {
+		return plumbing.NewHash(rev), nil
+	}
+
+	names := []plumbing.ReferenceName{plumbing.ReferenceName(rev)}
+	if !strings.HasPrefix(rev, "refs/") && rev != plumbing.HEAD.String() {
+		names = []plumbing.ReferenceName{plumbing.NewBranchReferenceName(rev), plumbing.NewTagReferenceName(rev)}
+	}
+	for _, name := range names {
+		ref, err := storer.ResolveReference(st, name)
+		if err == nil {
+			return ref.Hash(), nil
+		}
+		if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return plumbing.ZeroHash, err
+		}
+	}
+	return plumbing.ZeroHash, fmt.Errorf("%w: revision %s", ErrNotFound, rev)
+}
+
+// GetCommit returns the commit of a revision
+func GetCommit(st storer.Storer, rev string) (Commit, error) {
+	commit, err := Resolve(st, rev)
+	if err != nil {
+		return Commit{}, err
+	}
+	return newCommit(commit), nil
+}
+
+// Log returns the commits reachable from a revision, the most recent first,
+// skipping the first skip ones and returning at most limit. more reports
+// whether commits remain after the returned ones.
+func Log(st storer.Storer, rev string, skip, limit int) (commits []Commit, more bool, err error) {
+	commit, err := Resolve(st, rev)
+	if err != nil {
+		return nil, false, err
+	}
+
+	commits = []Commit{}
+	iter := object.NewCommitIterCTime(commit, nil, nil)
+	defer iter.Close()
+	err = iter.ForEach(func(c *object.Commit) error {
+		if skip > 0 {
+			skip--
+			return nil
+		}
+		if len(commits) == limit {
+			more = true
+			return storer.ErrStop
+		}
+		commits = append(commits, newCommit(c))
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return commits, more, nil
+}
+
+// newCommit returns the description of a commit
+func newCommit(c *object.Commit) Commit {
+	commit := Commit{
+		SHA:       c.Hash.String(),
+		Message:   c.Message,
+		Author:    Signature{Name: c.Author.Name, Email: c.Author.Email, Date: c.Author.When},
+		Committer: Signature{Name: c.Committer.Name, Email: c.Committer.Email, Date: c.Committer.When},
+		Parents:   []string{},
+		Tree:      c.TreeHash.String(),
+	}
+	for _, parent := range c.ParentHashes {
+		commit.Parents = append(commit.Parents, parent.String())
+	}
+	return commit
+}
+
+// Tree returns the entries of the directory at dir in a revision, the root
+// directory when dir is empty
+func Tree(st storer.Storer, rev, dir string) ([]TreeEntry, error) {
+	commit, err := Resolve(st, rev)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	dir = cleanPath(dir)
+	if dir != "" {
+		tree, err = tree.Tree(dir)
+		if err != nil {
+			return nil, notFound(err, "directory %s", dir)
+		}
+	}
+
+	entries := make([]TreeEntry, 0, len(tree.Entries))
+	for _, e := range tree.Entries {
+		entry := TreeEntry{
+			Name: e.Name,
+			Path: path.Join(dir, e.Name),
+			Type: TypeBlob,
+			Mode: fmt.Sprintf("%06o", uint32(e.Mode)),
+			SHA:  e.Hash.String(),
+		}
+		switch e.Mode {
+		case filemode.Dir:
+			entry.Type = TypeTree
+		case filemode.Submodule:
+			entry.Type = TypeCommit
+		default:
+			if entry.Size, err = tree.Size(e.Name); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Blob returns the content of the file at file in a revision and its size.
+// The caller closes the reader.
+func Blob(st storer.Storer, rev, file string) (io.ReadCloser, int64, error) {
+	commit, err := Resolve(st, rev)
+	if err != nil {
+		return nil, 0, err
+	}
+	file = cleanPath(file)
+	if file == "" {
+		return nil, 0, fmt.Errorf("%w: missing path", ErrNotFound)
+	}
+
+	f, err := commit.File(file)
+	if err != nil {
+		return nil, 0, notFound(err, "file %s", file)
+	}
+	r, err := f.Reader()
+	if err != nil {
+		return nil, 0, err
+	}
+	return r, f.Size, nil
+}
+
+// cleanPath returns the canonical form of a path in a tree, empty for the root
+func cleanPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// notFound returns an error wrapping ErrNotFound when err reports a missing
+// object, err otherwise
+func notFound(err error, format string, args ...any) error {
+	if errors.Is(err, plumbing.ErrObjectNotFound) || errors.Is(err, object.ErrUnsupportedObject) ||
+		errors.Is(err, object.ErrDirectoryNotFound) || errors.Is(err, object.ErrFileNotFound) {
+		return fmt.Errorf("%w: %s", ErrNotFound, fmt.Sprintf(format, args...))
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package browse
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRepository returns a repository with three commits on main, a
+// feature branch at the first one, a lightweight and an annotated tag
+func newTestRepository(t *testing.T) (*memory.Storage, []plumbing.Hash) {
+	t.Helper()
+
+	st := memory.NewStorage()
+	fs := memfs.New()
+	repo, err := git.Init(st, fs)
+	require.NoError(t, err)
+	require.NoError(t, st.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Main)))
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	var commits []plumbing.Hash
+	for i, file := range []string{"README.md", "docs/guide.md", "docs/api.md"} {
+		require.NoError(t, util.WriteFile(fs, file, []byte("# "+file+"\n"), 0644))
+		_, err := wt.Add(file)
+		require.NoError(t, err)
+		signature := &object.Signature{Name: "Alice", Email: "alice@example.com", When: time.Unix(int64(1000+i), 0)}
+		hash, err := wt.Commit("Add "+file, &git.CommitOptions{Author: signature, Committer: signature})
+		require.NoError(t, err)
+		commits = append(commits, hash)
+	}
+
+	require.NoError(t, st.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("feature"), commits[0])))
+	_, err = repo.CreateTag("v1", commits[1], nil)
+	require.NoError(t, err)
+	_, err = repo.CreateTag("v2", commits[2], &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "Alice", Email: "alice@example.com", When: time.Unix(2000, 0)},
+		Message: "Release 2",
+	})
+	require.NoError(t, err)
+	return st, commits
+}
+
+func TestBranchesAndTags(t *testing.T) {
+	st, commits := newTestRepository(t)
+
+	branches, err := Branches(st)
+	require.NoError(t, err)
+	assert.Equal(t, []Branch{
+		{Name: "feature", Commit: commits[0].String()},
+		{Name: "main", Commit: commits[2].String(), Default: true},
+	}, branches)
+
+	tags, err := Tags(st)
+	require.NoError(t, err)
+	assert.Equal(t, []Tag{
+		{Name: "v1", Target: commits[1].String()},
+		{Name: "v2", Target: commits[2].String(), Annotated: true, Message: "Release 2\n"},
+	}, tags)
+}
+
+func TestResolve(t *testing.T) {
+	st, commits := newTestRepository(t)
+
+	for rev, want := range map[string]plumbing.Hash{
+		"":                   commits[2],
+		"HEAD":               commits[2],
+		"feature":            commits[0],
+		"refs/heads/feature": commits[0],
+		"v1":                 commits[1],
+		"v2":                 commits[2],
+		commits[1].String():  commits[1],
+	} {
+		commit, err := Resolve(st, rev)
+		require.NoError(t, err, rev)
+		assert.Equal(t, want, commit.Hash, rev)
+	}
+
+	_, err := Resolve(st, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = Resolve(st, plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa").String())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLog(t *testing.T) {
+	st, commits := newTestRepository(t)
+
+	log, more, err := Log(st, "main", 0, 2)
+	require.NoError(t, err)
+	assert.True(t, more)
+	require.Len(t, log, 2)
+	assert.Equal(t, commits[2].String(), log[0].SHA)
+	assert.Equal(t, []string{commits[1].String()}, log[0].Parents)
+
+	log, more, err = Log(st, "main", 2, 2)
+	require.NoError(t, err)
+	assert.False(t, more)
+	require.Len(t, log, 1)
+	assert.Equal(t, commits[0].String(), log[0].SHA)
+	assert.Empty(t, log[0].Parents)
+}
+
+func TestTreeAndBlob(t *testing.T) {
+	st, _ := newTestRepository(t)
+
+	entries, err := Tree(st, "main", "")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "README.md", entries[0].Name)
+	assert.Equal(t, TypeBlob, entries[0].Type)
+	assert.Equal(t, "100644", entries[0].Mode)
+	assert.EqualValues(t, len("# README.md\n"), entries[0].Size)
+	assert.Equal(t, TreeEntry{Name: "docs", Path: "docs", Type: TypeTree, Mode: "040000", SHA: entries[1].SHA}, entries[1])
+
+	entries, err = Tree(st, "v1", "/docs/")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "docs/guide.md", entries[0].Path)
+
+	_, err = Tree(st, "main", "README.md")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = Tree(st, "main", "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	r, size, err := Blob(st, "", "docs/api.md")
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "# docs/api.md\n", string(content))
+	assert.EqualValues(t, len(content), size)
+
+	_, _, err = Blob(st, "feature", "docs/api.md")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, _, err = Blob(st, "main", "docs")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -1,9 +1,12 @@
 package common
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestExtractRepoPathFromURL(t *testing.T) {
@@ -49,6 +52,18 @@ func TestExtractRepoPathFromURL(t *testing.T) {
 			suffix:   "/info/refs",
 			expected: "test-repo",
 		},
+		{
+			name:     "deeply nested path",
+			url:      "/team/sub/project.git/git-receive-pack",
+			suffix:   "/git-receive-pack",
+			expected: "team/sub/project.git",
+		},
+		{
+			name:     "parent directory segment",
+			url:      "/team/../../project.git/info/refs",
+			suffix:   "/info/refs",
+			expected: "",
+		},
 	}
 
 	for _, tt := range tests {
@@ -125,6 +140,28 @@ func TestNormalizeRepoPath(t *testing.T) {
 	}
 }
 
+func TestValidRepoPath(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{input: "project.git", expected: true},
+		{input: "team/sub/project.git", expected: true},
+		{input: "", expected: false},
+		{input: "team//project.git", expected: false},
+		{input: "/project.git", expected: false},
+		{input: "./project.git", expected: false},
+		{input: "team/../project.git", expected: false},
+		{input: "team\\project.git", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ValidRepoPath(tt.input))
+		})
+	}
+}
+
 // Tests pour valider des cas d'usage réels
 func TestNormalizeRepoPathRealExamples(t *testing.T) {
 	// Cas d'usage typiques qu'on peut rencontrer
@@ -187,6 +224,31 @@ func TestExtractRepoPathRealExamples(t *testing.T) {
 	}
 }
 
+func TestWriteJSONFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+
+	require.NoError(t, WriteJSONFile(path, map[string]int{"a": 1}))
+	require.NoError(t, WriteJSONFile(path, map[string]int{"b": 2}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"b": 2}`, string(data))
+
+	// No temporary file is left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// A value that cannot be encoded leaves the file unchanged
+	assert.Error(t, WriteJSONFile(path, func() {}))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"b": 2}`, string(data))
+
+	assert.Error(t, WriteJSONFile(filepath.Join(dir, "missing", "data.json"), 1))
+}
+
 // Tests de performance pour s'assurer que les fonctions sont rapides
 func BenchmarkNormalizeRepoPath(b *testing.B) {
 	testInput := "organization/very-long-project-name-with-many-characters"
@@ -11,7 +11,7 @@ import "strings"
 //   - suffix: The suffix to remove (e.g., "/info/refs", "/git-upload-pack")
 //
 // Returns:
-//   - The clean repository path (e.g., "myrepo.git") or empty string if invalid
+//   - The clean repository path (e.g., "myrepo.git") or empty string if invalid, see ValidRepoPath
 //
 // Example:
 //
@@ -29,7 +29,7 @@ func ExtractRepoPathFromURL(urlPath, suffix string) string {
 
 	// Remove leading slash to get relative path
 	rel := strings.TrimPrefix(base, "/")
-	if rel == "" {
+	if !ValidRepoPath(rel) {
 		return ""
 	}
 
@@ -1,27 +1,35 @@
 package common
 
 import (
+	"context"
 	"path/filepath"
 
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/server"
 	"github.com/gofiber/fiber/v2"
 	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/tracing"
 )
 
-func GetTransportServer(repoPath string, str storage.GitRepositoryStorage) (transport.Transport, *transport.Endpoint, error) {
+// GetTransportServer returns a go-git transport server of the repository,
+// whose storage calls are made with ctx.
+func GetTransportServer(ctx context.Context, repoPath string, str storage.GitRepositoryStorage) (transport.Transport, *transport.Endpoint, error) {
 	normalizedPath := NormalizeRepoPath(repoPath)
 
+	_, span := tracing.Start(ctx, "GetTransportServer", tracing.Repository(normalizedPath))
 	if !str.RepositoryExists(normalizedPath) {
-		return nil, nil, fiber.NewError(fiber.StatusNotFound, "repository not found")
+		err := fiber.NewError(fiber.StatusNotFound, "repository not found")
+		tracing.End(span, err)
+		return nil, nil, err
 	}
 
 	// Create a loader for this specific repository
-	loader := storage.NewGitServerLoader(str, normalizedPath)
+	loader := storage.NewGitServerLoader(ctx, str, normalizedPath)
 
 	// Create the transport server
 	srv := server.NewServer(loader)
 	ep := &transport.Endpoint{Path: "/" + filepath.Base(normalizedPath)}
 
+	span.End()
 	return srv, ep, nil
 }
@@ -3,8 +3,8 @@ package common
 import "strings"
 
 // NormalizeRepoPath normalizes a repository path to ensure proper Git bare repository naming.
-// It trims whitespace and ensures the path ends with ".git" suffix, which is the standard
-// convention for bare Git repositories.
+// It trims whitespace and surrounding slashes and ensures the path ends with ".git" suffix,
+// which is the standard convention for bare Git repositories.
 //
 // Parameters:
 //   - repoPath: The raw repository path/name from user input
@@ -17,10 +17,14 @@ import "strings"
 //	NormalizeRepoPath("myrepo") → "myrepo.git"
 //	NormalizeRepoPath("myrepo.git") → "myrepo.git"
 //	NormalizeRepoPath("  myrepo  ") → "myrepo.git"
+//	NormalizeRepoPath("/team/project/") → "team/project.git"
 func NormalizeRepoPath(repoPath string) string {
 	// Remove leading and trailing whitespace
 	repoPath = strings.TrimSpace(repoPath)
 
+	// Remove leading and trailing slashes, nested paths are kept relative
+	repoPath = strings.Trim(repoPath, "/")
+
 	// Ensure the repository path ends with .git suffix
 	if !strings.HasSuffix(repoPath, ".git") {
 		repoPath += ".git"
@@ -0,0 +1,270 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+	"github.com/gofiber/fiber/v2"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/tracing"
+)
+
+// looseRefsThreshold is the number of loose references from which a push
+// packs them, bounding the requests made to advertise the references
+var looseRefsThreshold = 64
+
+// ReceivePackSession is a go-git receive-pack session whose reference updates
+// are compare-and-swap operations against the old values sent by the client.
+// A reference moved by a concurrent push is reported as rejected instead of
+// being silently overwritten.
+//
+// The pushed objects are stored before the pre-receive hooks run, the
+// updates they reject are not applied, and the post-receive hooks are
+// notified of the applied ones. The output of the hooks is sent on the
+// sideband with the status report, see WriteResult.
+type ReceivePackSession struct {
+	transport.ReceivePackSession
+	guard    *storage.ReferenceGuard
+	repoPath string
+
+	// Hooks run before and after the reference updates, nil for none
+	Hooks *hooks.Runner
+	// Pusher is the authenticated user, nil when authentication is disabled
+	Pusher *auth.Identity
+
+	sideband bool
+	output   bytes.Buffer
+}
+
+// NewReceivePackSession creates a receive-pack session for the given
+// repository, whose storage calls are made with ctx.
+func NewReceivePackSession(ctx context.Context, repoPath string, str storage.GitRepositoryStorage) (*ReceivePackSession, error) {
+	normalizedPath := NormalizeRepoPath(repoPath)
+	if !str.RepositoryExists(normalizedPath) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "repository not found")
+	}
+
+	st, err := str.GetStorer(normalizedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	guard := storage.NewReferenceGuard(storage.WithContext(ctx, st))
+	ep := &transport.Endpoint{Path: "/" + filepath.Base(normalizedPath)}
+	srv := server.NewServer(server.MapLoader{ep.String(): guard})
+
+	sess, err := srv.NewReceivePackSession(ep, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReceivePackSession{ReceivePackSession: sess, guard: guard, repoPath: normalizedPath}, nil
+}
+
+// AdvertisedReferences returns the references of the repository and the
+// capabilities of the session, go-git's plus the sideband.
+func (s *ReceivePackSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	ar, err := s.ReceivePackSession.AdvertisedReferences()
+	if err != nil {
+		return nil, err
+	}
+	if err := ar.Capabilities.Set(capability.Sideband64k); err != nil {
+		return nil, err
+	}
+	return ar, nil
+}
+
+// ReceivePack writes the pushed packfile and applies the reference updates of
+// req accepted by the pre-receive hooks. The returned report lists the
+// commands in the order of the request, it is nil when the client did not
+// ask for it.
+func (s *ReceivePackSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	// go-git supports neither the sideband, handled by WriteResult, nor
+	// pushes without status report, which is needed to run the post-receive
+	// hooks on the applied updates only
+	s.sideband = req.Capabilities.Supports(capability.Sideband64k)
+	req.Capabilities.Delete(capability.Sideband64k)
+	wantReport := req.Capabilities.Supports(capability.ReportStatus)
+	if err := req.Capabilities.Set(capability.ReportStatus); err != nil {
+		return nil, err
+	}
+
+	s.guard.Expect(req.Commands)
+
+	// No packfile is sent when every command is a deletion
+	deletesOnly := !slices.ContainsFunc(req.Commands, func(cmd *packp.Command) bool {
+		return cmd.Action() != packp.Delete
+	})
+	if req.Packfile != nil && deletesOnly {
+		_ = req.Packfile.Close()
+		req.Packfile = nil
+	}
+	if req.Packfile != nil {
+		_, span := tracing.Start(ctx, "StorePackfile", tracing.Repository(s.repoPath))
+		err := s.writePackfile(ctx, req.Packfile)
+		tracing.End(span, err)
+		if err != nil {
+			report := packp.NewReportStatus()
+			report.UnpackStatus = err.Error()
+			if !wantReport {
+				report = nil
+			}
+			return report, err
+		}
+		req.Packfile = nil
+	}
+
+	commands := req.Commands
+	push := &hooks.Push{
+		Repository: s.repoPath,
+		Pusher:     s.Pusher,
+		Updates:    updates(commands),
+		Storer:     s.guard,
+		Output:     &s.output,
+	}
+
+	rejected := hooks.Rejections{}
+	if s.Hooks != nil {
+		hookCtx, span := tracing.Start(ctx, "PreReceive", tracing.Repository(s.repoPath))
+		rejected = s.Hooks.RunPreReceive(hookCtx, push)
+		span.End()
+	}
+	req.Commands = slices.DeleteFunc(slices.Clone(commands), func(cmd *packp.Command) bool {
+		_, ok := rejected[cmd.Name]
+		return ok
+	})
+
+	updateCtx, span := tracing.Start(ctx, "UpdateReferences", tracing.Repository(s.repoPath))
+	result, err := s.ReceivePackSession.ReceivePack(updateCtx, req)
+	tracing.End(span, err)
+	if result == nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]string, len(result.CommandStatuses))
+	for _, status := range result.CommandStatuses {
+		statuses[status.ReferenceName.String()] = status.Status
+	}
+
+	report := packp.NewReportStatus()
+	report.UnpackStatus = result.UnpackStatus
+	var applied []*packp.Command
+	for _, cmd := range commands {
+		status, ok := statuses[cmd.Name.String()]
+		if reason, rejected := rejected[cmd.Name]; rejected {
+			// The status is a single pkt-line
+			status = strings.Join(strings.Fields(reason), " ")
+		} else if !ok {
+			status = "ok"
+		}
+		if status == "ok" {
+			applied = append(applied, cmd)
+		}
+		report.CommandStatuses = append(report.CommandStatuses, &packp.CommandStatus{ReferenceName: cmd.Name, Status: status})
+	}
+
+	if len(applied) > 0 {
+		// Best effort, the references are already updated
+		_ = storage.UpdateMetadata(s.guard.Storer, func(metadata *storage.Metadata) {
+			metadata.LastPush = time.Now()
+		})
+		s.packRefs(ctx)
+	}
+
+	if s.Hooks != nil {
+		push.Updates = updates(applied)
+		hookCtx, span := tracing.Start(ctx, "PostReceive", tracing.Repository(s.repoPath))
+		s.Hooks.RunPostReceive(hookCtx, push)
+		span.End()
+	}
+
+	if !wantReport {
+		report = nil
+	}
+	return report, err
+}
+
+// packRefs packs the loose references once there are looseRefsThreshold of
+// them. Best effort, the references stay loose on failure.
+func (s *ReceivePackSession) packRefs(ctx context.Context) {
+	count, err := s.guard.CountLooseRefs()
+	if err != nil || count < looseRefsThreshold {
+		return
+	}
+
+	_, span := tracing.Start(ctx, "PackRefs", tracing.Repository(s.repoPath))
+	tracing.End(span, s.guard.PackRefs())
+}
+
+// writePackfile stores the pushed objects, before any reference is updated
+func (s *ReceivePackSession) writePackfile(ctx context.Context, r io.ReadCloser) error {
+	rc := ioutil.NewContextReadCloser(ctx, r)
+	if err := packfile.UpdateObjectStorage(s.guard, rc); err != nil {
+		_ = rc.Close()
+		return err
+	}
+	return rc.Close()
+}
+
+// WriteResult sends the status report to the client. When the client
+// negotiated the sideband, the output of the hooks is sent first as progress
+// messages, which git shows on its "remote:" lines.
+func (s *ReceivePackSession) WriteResult(w io.Writer, report *packp.ReportStatus) error {
+	if !s.sideband {
+		if report == nil {
+			return nil
+		}
+		return report.Encode(w)
+	}
+
+	enc := pktline.NewEncoder(w)
+	if err := writeSideband(enc, sideband.ProgressMessage, s.output.Bytes()); err != nil {
+		return err
+	}
+	if report != nil {
+		var buf bytes.Buffer
+		if err := report.Encode(&buf); err != nil {
+			return err
+		}
+		if err := writeSideband(enc, sideband.PackData, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return enc.Flush()
+}
+
+// writeSideband sends data on a sideband channel, split in as many packets as needed
+func writeSideband(enc *pktline.Encoder, channel sideband.Channel, data []byte) error {
+	for len(data) > 0 {
+		n := min(len(data), pktline.MaxPayloadSize-1)
+		if err := enc.Encode(channel.WithPayload(data[:n])); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// updates returns the reference updates of the given commands
+func updates(cmds []*packp.Command) []hooks.Update {
+	updates := make([]hooks.Update, 0, len(cmds))
+	for _, cmd := range cmds {
+		updates = append(updates, hooks.Update{Name: cmd.Name, Old: cmd.Old, New: cmd.New})
+	}
+	return updates
+}
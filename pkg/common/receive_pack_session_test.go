@@ -0,0 +1,221 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/labbs/git-server-s3/pkg/storage/s3"
+	"github.com/labbs/git-server-s3/pkg/storage/s3/s3test"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newUpdateRequest(name plumbing.ReferenceName, old, new plumbing.Hash) *packp.ReferenceUpdateRequest {
+	req := packp.NewReferenceUpdateRequest()
+	_ = req.Capabilities.Set(capability.ReportStatus)
+	req.Commands = []*packp.Command{{Name: name, Old: old, New: new}}
+	return req
+}
+
+func TestReceivePackSession_ConcurrentPushRejected(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "receive-pack-test-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	config.Storage.Local.Path = tempDir
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	require.NoError(t, storage.InitRepository(str, "repo.git", storage.InitOptions{Readme: true}))
+
+	st, err := str.GetStorer("repo.git")
+	require.NoError(t, err)
+	head, err := st.Reference(plumbing.NewBranchReferenceName("main"))
+	require.NoError(t, err)
+	base := head.Hash()
+
+	// Both clients fetched the same tip and push on top of it
+	first, err := NewReceivePackSession(context.Background(), "repo.git", str)
+	require.NoError(t, err)
+	second, err := NewReceivePackSession(context.Background(), "repo.git", str)
+	require.NoError(t, err)
+
+	hashA := plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	hashB := plumbing.NewHash("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+
+	report, err := first.ReceivePack(context.Background(), newUpdateRequest(head.Name(), base, hashA))
+	require.NoError(t, err)
+	require.NoError(t, report.Error())
+
+	report, err = second.ReceivePack(context.Background(), newUpdateRequest(head.Name(), base, hashB))
+	assert.Error(t, err)
+	require.NotNil(t, report)
+	require.Len(t, report.CommandStatuses, 1)
+	assert.Equal(t, "reference has changed concurrently", report.CommandStatuses[0].Status)
+
+	// The first push is not overwritten
+	ref, err := st.Reference(head.Name())
+	require.NoError(t, err)
+	assert.Equal(t, hashA, ref.Hash())
+}
+
+func TestReceivePackSession_HookRejection(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "receive-pack-test-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	config.Storage.Local.Path = tempDir
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	require.NoError(t, storage.InitRepository(str, "repo.git", storage.InitOptions{Readme: true}))
+
+	st, err := str.GetStorer("repo.git")
+	require.NoError(t, err)
+	head, err := st.Reference(plumbing.NewBranchReferenceName("main"))
+	require.NoError(t, err)
+
+	var notified []hooks.Update
+	runner := &hooks.Runner{
+		Logger: zerolog.Nop(),
+		PreReceive: []hooks.PreReceiveHook{hooks.PreReceiveFunc(func(ctx context.Context, push *hooks.Push) (hooks.Rejections, error) {
+			return hooks.Rejections{"refs/heads/locked": "branch is\nlocked"}, nil
+		})},
+		PostReceive: []hooks.PostReceiveHook{hooks.PostReceiveFunc(func(ctx context.Context, push *hooks.Push) error {
+			notified = push.Updates
+			return nil
+		})},
+	}
+
+	sess, err := NewReceivePackSession(context.Background(), "repo.git", str)
+	require.NoError(t, err)
+	sess.Hooks = runner
+
+	req := newUpdateRequest("refs/heads/locked", plumbing.ZeroHash, head.Hash())
+	req.Commands = append(req.Commands, &packp.Command{Name: "refs/heads/feature", New: head.Hash()})
+	_ = req.Capabilities.Set(capability.Sideband64k)
+
+	report, err := sess.ReceivePack(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, report.CommandStatuses, 2)
+	assert.Equal(t, "branch is locked", report.CommandStatuses[0].Status)
+	assert.Equal(t, "ok", report.CommandStatuses[1].Status)
+
+	_, err = st.Reference("refs/heads/locked")
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+	_, err = st.Reference("refs/heads/feature")
+	assert.NoError(t, err)
+	require.Len(t, notified, 1)
+	assert.Equal(t, plumbing.ReferenceName("refs/heads/feature"), notified[0].Name)
+
+	// The rejection is sent as progress before the report, on the sideband
+	var buf bytes.Buffer
+	require.NoError(t, sess.WriteResult(&buf, report))
+
+	demux := sideband.NewDemuxer(sideband.Sideband64k, &buf)
+	var progress bytes.Buffer
+	demux.Progress = &progress
+	decoded := packp.NewReportStatus()
+	require.NoError(t, decoded.Decode(demux))
+	assert.Equal(t, "rejected refs/heads/locked: branch is\nlocked\n", progress.String())
+	assert.Equal(t, "branch is locked", decoded.CommandStatuses[0].Status)
+}
+
+func TestReceivePackSession_DeletesOnly(t *testing.T) {
+	config.Storage.Local.Path = t.TempDir()
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	require.NoError(t, storage.InitRepository(str, "repo.git", storage.InitOptions{Readme: true}))
+
+	st, err := str.GetStorer("repo.git")
+	require.NoError(t, err)
+	head, err := st.Reference(plumbing.NewBranchReferenceName("main"))
+	require.NoError(t, err)
+
+	sess, err := NewReceivePackSession(context.Background(), "repo.git", str)
+	require.NoError(t, err)
+
+	// Clients send no packfile after deletions, the decoded request has an empty one
+	req := newUpdateRequest(head.Name(), head.Hash(), plumbing.ZeroHash)
+	req.Packfile = io.NopCloser(&bytes.Buffer{})
+
+	report, err := sess.ReceivePack(context.Background(), req)
+	require.NoError(t, err)
+	require.NoError(t, report.Error())
+
+	_, err = st.Reference(head.Name())
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+
+	metadata, err := storage.ReadMetadata(st)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), metadata.LastPush, time.Minute)
+}
+
+func TestReceivePackSession_PacksReferences(t *testing.T) {
+	previous := looseRefsThreshold
+	looseRefsThreshold = 8
+	t.Cleanup(func() { looseRefsThreshold = previous })
+
+	fake := s3test.NewServer(t)
+	config.Storage.S3.Bucket = s3test.Bucket
+	config.Storage.S3.Endpoint = fake.URL
+	config.Storage.S3.Region = "us-east-1"
+	config.Storage.S3.AccessKey, config.Storage.S3.SecretKey = "test", "test"
+	str := s3.NewS3Storage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	require.NoError(t, storage.InitRepository(str, "repo.git", storage.InitOptions{Readme: true}))
+
+	st, err := str.GetStorer("repo.git")
+	require.NoError(t, err)
+	head, err := st.Reference(plumbing.NewBranchReferenceName("main"))
+	require.NoError(t, err)
+
+	// requests returns the number of requests made to advertise the references
+	requests := func() int64 {
+		before := fake.Count("GET object") + fake.Count("GET list")
+		sess, err := NewReceivePackSession(context.Background(), "repo.git", str)
+		require.NoError(t, err)
+		_, err = sess.AdvertisedReferences()
+		require.NoError(t, err)
+		return fake.Count("GET object") + fake.Count("GET list") - before
+	}
+	first := requests()
+
+	for push := 0; push < 10; push++ {
+		sess, err := NewReceivePackSession(context.Background(), "repo.git", str)
+		require.NoError(t, err)
+		req := packp.NewReferenceUpdateRequest()
+		_ = req.Capabilities.Set(capability.ReportStatus)
+		for i := 0; i < 3; i++ {
+			name := plumbing.NewTagReferenceName(fmt.Sprintf("v%d.%d", push, i))
+			req.Commands = append(req.Commands, &packp.Command{Name: name, New: head.Hash()})
+		}
+		report, err := sess.ReceivePack(context.Background(), req)
+		require.NoError(t, err)
+		require.NoError(t, report.Error())
+
+		// The loose references never pile up past the threshold
+		assert.LessOrEqual(t, requests(), first+int64(looseRefsThreshold), "push %d", push)
+	}
+
+	refs, err := st.IterReferences()
+	require.NoError(t, err)
+	count := 0
+	require.NoError(t, refs.ForEach(func(*plumbing.Reference) error {
+		count++
+		return nil
+	}))
+	assert.Equal(t, 32, count, "HEAD, main and the tags")
+}
@@ -0,0 +1,27 @@
+package common
+
+import "strings"
+
+// ValidRepoPath reports whether repoPath can be used as a repository path.
+// Repository paths are relative and may be nested in any number of namespaces
+// (e.g., "team/sub/project.git"), but every segment must be a plain name so
+// that a path never resolves outside of the storage root.
+//
+// Examples:
+//
+//	ValidRepoPath("team/sub/project.git") → true
+//	ValidRepoPath("team//project.git") → false
+//	ValidRepoPath("../project.git") → false
+func ValidRepoPath(repoPath string) bool {
+	if repoPath == "" || strings.Contains(repoPath, "\\") {
+		return false
+	}
+
+	for _, segment := range strings.Split(repoPath, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,13 @@
+package common
+
+import (
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+)
+
+// WriteError sends an error packet in place of a reference advertisement,
+// which git clients show as "remote error: <message>".
+func WriteError(w io.Writer, message string) error {
+	return pktline.NewEncoder(w).Encodef("ERR %s\n", message)
+}
@@ -0,0 +1,142 @@
+// Package health holds the readiness checks of the server: the components it
+// depends on, such as the storage backend and the SSH listener, are probed
+// on each request to /health/ready so that load balancers stop routing to a
+// node that cannot serve the repositories.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Statuses of the components and of the server
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check probes a component, it returns nil when the component is healthy
+type Check func(ctx context.Context) error
+
+// Component is the outcome of the check of a component
+type Component struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of the checks, the server is ready when every
+// component is
+type Report struct {
+	Status     string               `json:"status"`
+	Service    string               `json:"service"`
+	Components map[string]Component `json:"components"`
+}
+
+// Checker runs the checks of the components. Components can be added while
+// the checks are served.
+type Checker struct {
+	// Timeout bounds the duration of each check, 0 for no limit
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	checks map[string]Check
+}
+
+// NewChecker creates a checker without components
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{Timeout: timeout, checks: make(map[string]Check)}
+}
+
+// Add registers the check of a component, replacing the check of the same name
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Run checks the components concurrently
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.Unlock()
+
+	report := Report{Status: StatusOK, Service: "git-server-s3", Components: make(map[string]Component, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			component := c.run(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[name] = component
+			if component.Status != StatusOK {
+				report.Status = StatusFail
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+// run runs a check within the timeout
+func (c *Checker) run(ctx context.Context, check Check) Component {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	// A check ignoring the context does not hold the probe past the timeout
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("check timed out: %w", ctx.Err())
+	}
+
+	component := Component{Status: StatusOK, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		component.Status = StatusFail
+		component.Error = err.Error()
+	}
+	return component
+}
+
+// LiveHandler answers the liveness probes: the server is up and serving
+// requests, whatever the state of its components
+func LiveHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"status":  StatusOK,
+			"service": "git-server-s3",
+		})
+	}
+}
+
+// ReadyHandler answers the readiness probes with the report of the checks,
+// 503 when a component fails
+func (c *Checker) ReadyHandler() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		report := c.Run(ctx.UserContext())
+		if report.Status != StatusOK {
+			ctx.Status(fiber.StatusServiceUnavailable)
+		}
+		return ctx.JSON(report)
+	}
+}
@@ -0,0 +1,77 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	checker := NewChecker(50 * time.Millisecond)
+	checker.Add("storage", func(ctx context.Context) error { return nil })
+	report := checker.Run(context.Background())
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, StatusOK, report.Components["storage"].Status)
+
+	checker.Add("ssh", func(ctx context.Context) error { return errors.New("SSH listener is down") })
+	// A check ignoring its context is abandoned at the timeout
+	checker.Add("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	start := time.Now()
+	report = checker.Run(context.Background())
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, StatusOK, report.Components["storage"].Status)
+	assert.Equal(t, Component{Status: StatusFail, LatencyMs: report.Components["ssh"].LatencyMs, Error: "SSH listener is down"}, report.Components["ssh"])
+	assert.Equal(t, StatusFail, report.Components["slow"].Status)
+	assert.Contains(t, report.Components["slow"].Error, "timed out")
+	assert.GreaterOrEqual(t, report.Components["slow"].LatencyMs, 50.0)
+}
+
+func TestHandlers(t *testing.T) {
+	healthy := true
+	checker := NewChecker(time.Second)
+	checker.Add("storage", func(ctx context.Context) error {
+		if !healthy {
+			return errors.New("bucket unreachable")
+		}
+		return nil
+	})
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/health/live", LiveHandler())
+	app.Get("/health/ready", checker.ReadyHandler())
+
+	ready := func() (int, Report) {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/health/ready", nil))
+		require.NoError(t, err)
+		var report Report
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		return resp.StatusCode, report
+	}
+
+	status, report := ready()
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, StatusOK, report.Components["storage"].Status)
+
+	healthy = false
+	status, report = ready()
+	assert.Equal(t, fiber.StatusServiceUnavailable, status)
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, "bucket unreachable", report.Components["storage"].Error)
+
+	// The liveness does not depend on the components
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/health/live", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+}
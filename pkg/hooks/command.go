@@ -0,0 +1,69 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// DefaultTimeout is the time given to an external hook when Command.Timeout is zero
+const DefaultTimeout = time.Minute
+
+// Command is a hook run as an external executable, the way git runs its
+// hooks: the updates are fed on its standard input, one
+// "<old> <new> <ref>" line each, zero hashes standing for created and
+// deleted references. Its output is shown to the client.
+//
+// As a pre-receive hook, a non-zero exit status rejects the whole push. The
+// repository path and the pusher are set in the GIT_SERVER_REPOSITORY and
+// GIT_SERVER_PUSHER environment variables.
+type Command struct {
+	Path    string
+	Timeout time.Duration
+}
+
+func (c *Command) PreReceive(ctx context.Context, push *Push) (Rejections, error) {
+	if err := c.run(ctx, push); err != nil {
+		return nil, fmt.Errorf("pre-receive hook declined")
+	}
+	return nil, nil
+}
+
+func (c *Command) PostReceive(ctx context.Context, push *Push) error {
+	return c.run(ctx, push)
+}
+
+func (c *Command) run(ctx context.Context, push *Push) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdin bytes.Buffer
+	for _, update := range push.Updates {
+		fmt.Fprintf(&stdin, "%s %s %s\n", update.Old, update.New, update.Name)
+	}
+
+	pusher := ""
+	if push.Pusher != nil {
+		pusher = push.Pusher.Username
+	}
+
+	cmd := exec.CommandContext(ctx, c.Path)
+	cmd.Stdin = &stdin
+	cmd.Stdout = push.Output
+	cmd.Stderr = push.Output
+	cmd.Env = append(os.Environ(),
+		"GIT_SERVER_REPOSITORY="+push.Repository,
+		"GIT_SERVER_PUSHER="+pusher,
+	)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("hook %s failed: %w", c.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package hooks
+
+import (
+	"container/heap"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// MaxCommitWalk is the number of commits a CommitLister loads at most to list
+// the commits of an update, whatever the size of the history
+const MaxCommitWalk = 1000
+
+// CommitLister lists the commits introduced by the updates of a push. The
+// branches the push does not update are loaded once and shared between the
+// updates. It is not safe for concurrent use.
+type CommitLister struct {
+	push     *Push
+	excluded []*object.Commit // Tips of the branches not updated, nil until loaded
+	loaded   bool
+}
+
+// NewCommitLister returns a lister of the commits of the updates of push
+func NewCommitLister(push *Push) *CommitLister {
+	return &CommitLister{push: push}
+}
+
+// Commits returns the commits introduced by update, the most recent first and
+// at most limit of them: reachable from its new value, and neither from its
+// old value nor from the branches the push does not update.
+//
+// As git rev-list does, the history is walked from the new value by commit
+// date alongside the excluded tips, and the walk stops once every commit left
+// is reachable from them, so its cost depends on the size of the update
+// rather than on the size of the repository. It loads at most MaxCommitWalk
+// commits, the commits found until then are returned past that.
+func (l *CommitLister) Commits(update Update, limit int) ([]*object.Commit, error) {
+	if update.IsDelete() || limit <= 0 {
+		return nil, nil
+	}
+	tip, err := l.push.commit(update.New)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.loadExcluded(); err != nil {
+		return nil, err
+	}
+
+	w := &commitWalk{push: l.push, seen: make(map[plumbing.Hash]*walkEntry)}
+	if old, err := l.push.commit(update.Old); err == nil {
+		w.add(old, true)
+	}
+	for _, c := range l.excluded {
+		w.add(c, true)
+	}
+	w.add(tip, false)
+
+	var walked []*walkEntry
+	slop := walkSlop
+	for w.queue.Len() > 0 && len(w.seen) < MaxCommitWalk {
+		e := heap.Pop(&w.queue).(*walkEntry)
+		e.queued = false
+		if !e.uninteresting {
+			w.interesting--
+			walked = append(walked, e)
+		}
+		if err := w.addParents(e); err != nil {
+			return nil, err
+		}
+
+		// A few more commits are walked once everything left is
+		// uninteresting, in case of clock skew
+		if w.interesting > 0 {
+			slop = walkSlop
+		} else if slop--; slop == 0 {
+			break
+		}
+	}
+
+	// Commits walked before being found reachable from an excluded tip are
+	// only marked afterwards
+	var commits []*object.Commit
+	for _, e := range walked {
+		if len(commits) == limit {
+			break
+		}
+		if !e.uninteresting {
+			commits = append(commits, e.commit)
+		}
+	}
+	return commits, nil
+}
+
+// loadExcluded loads the tips of the branches the push does not update
+func (l *CommitLister) loadExcluded() error {
+	if l.loaded {
+		return nil
+	}
+
+	iter, err := l.push.Storer.IterReferences()
+	if err != nil {
+		return err
+	}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || !ref.Name().IsBranch() || l.push.updates(ref.Name()) {
+			return nil
+		}
+		c, err := l.push.commit(ref.Hash())
+		if err != nil {
+			// A branch to a tree or blob
+			return nil
+		}
+		l.excluded = append(l.excluded, c)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	l.loaded = true
+	return nil
+}
+
+// updates reports whether the push updates the reference
+func (p *Push) updates(name plumbing.ReferenceName) bool {
+	for _, u := range p.Updates {
+		if u.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// walkSlop is the number of commits walked once every commit left to walk is
+// uninteresting, as git does
+const walkSlop = 5
+
+// walkEntry is a commit of a commitWalk. Uninteresting commits are reachable
+// from an excluded tip.
+type walkEntry struct {
+	commit        *object.Commit
+	uninteresting bool
+	queued        bool
+	index         int // Index in the queue while queued
+}
+
+// commitWalk walks commits newest first, propagating the uninteresting mark
+// from the excluded tips to their ancestors
+type commitWalk struct {
+	push        *Push
+	seen        map[plumbing.Hash]*walkEntry
+	queue       walkQueue
+	interesting int // Queued commits not marked uninteresting
+}
+
+// add queues a commit, or marks it uninteresting if it is already known
+func (w *commitWalk) add(c *object.Commit, uninteresting bool) {
+	if e, ok := w.seen[c.Hash]; ok {
+		if uninteresting {
+			w.markUninteresting(e)
+		}
+		return
+	}
+
+	e := &walkEntry{commit: c, uninteresting: uninteresting, queued: true}
+	w.seen[c.Hash] = e
+	if !uninteresting {
+		w.interesting++
+	}
+	heap.Push(&w.queue, e)
+}
+
+// markUninteresting marks a known commit uninteresting, along with the
+// known ancestors of the ones already walked
+func (w *commitWalk) markUninteresting(e *walkEntry) {
+	stack := []*walkEntry{e}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if e.uninteresting {
+			continue
+		}
+		e.uninteresting = true
+		if e.queued {
+			// Uninteresting commits come first among the ones of the same date
+			heap.Fix(&w.queue, e.index)
+			w.interesting--
+			continue
+		}
+		for _, hash := range e.commit.ParentHashes {
+			if parent, ok := w.seen[hash]; ok {
+				stack = append(stack, parent)
+			}
+		}
+	}
+}
+
+// addParents queues the parents of a walked commit, with its mark
+func (w *commitWalk) addParents(e *walkEntry) error {
+	for _, hash := range e.commit.ParentHashes {
+		if known, ok := w.seen[hash]; ok {
+			if e.uninteresting {
+				w.markUninteresting(known)
+			}
+			continue
+		}
+		parent, err := object.GetCommit(w.push.Storer, hash)
+		if err != nil {
+			return err
+		}
+		w.add(parent, e.uninteresting)
+	}
+	return nil
+}
+
+// walkQueue is a heap of commits, the most recently committed first
+type walkQueue []*walkEntry
+
+func (q walkQueue) Len() int { return len(q) }
+
+// Less walks the uninteresting commits first among the ones of the same
+// date, so that the mark reaches the common ancestors before they are walked
+func (q walkQueue) Less(i, j int) bool {
+	ti, tj := q[i].commit.Committer.When, q[j].commit.Committer.When
+	if ti.Equal(tj) {
+		return q[i].uninteresting && !q[j].uninteresting
+	}
+	return ti.After(tj)
+}
+
+func (q walkQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+
+func (q *walkQueue) Push(x any) {
+	e := x.(*walkEntry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *walkQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,180 @@
+// Package hooks implements the server-side hooks run on push: pre-receive
+// hooks check the reference updates before they are applied and can reject
+// them, post-receive hooks run once they are applied.
+//
+// Hooks are either Go values registered in-process, see RegisterPreReceive
+// and RegisterPostReceive, or external executables, see Command.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/rs/zerolog"
+)
+
+// Update is the update of a reference by a push
+type Update struct {
+	Name plumbing.ReferenceName
+	Old  plumbing.Hash // Zero when the reference is created
+	New  plumbing.Hash // Zero when the reference is deleted
+}
+
+// IsCreate reports whether the update creates the reference
+func (u Update) IsCreate() bool {
+	return u.Old.IsZero()
+}
+
+// IsDelete reports whether the update deletes the reference
+func (u Update) IsDelete() bool {
+	return u.New.IsZero()
+}
+
+// Push is what the hooks know of a push
+type Push struct {
+	Repository string         // Repository path, e.g. "team/project.git"
+	Pusher     *auth.Identity // Authenticated user, nil when authentication is disabled
+	Updates    []Update       // Updates to check, or the applied ones for post-receive hooks
+	Storer     storer.Storer  // Repository storage, holding the pushed objects
+	Output     io.Writer      // Shown to the client on its "remote:" lines
+}
+
+// commit returns the commit of hash, peeling annotated tags
+func (p *Push) commit(hash plumbing.Hash) (*object.Commit, error) {
+	for {
+		tag, err := object.GetTag(p.Storer, hash)
+		if err != nil {
+			return object.GetCommit(p.Storer, hash)
+		}
+		hash = tag.Target
+	}
+}
+
+// Rejections are the reasons of the rejected updates, by reference name
+type Rejections map[plumbing.ReferenceName]string
+
+// PreReceiveHook checks the updates of a push before they are applied
+type PreReceiveHook interface {
+	// PreReceive returns the rejected updates with the reason shown to the
+	// client. An error rejects the whole push.
+	PreReceive(ctx context.Context, push *Push) (Rejections, error)
+}
+
+// PostReceiveHook is notified of the updates applied by a push
+type PostReceiveHook interface {
+	PostReceive(ctx context.Context, push *Push) error
+}
+
+// PreReceiveFunc is a function used as a PreReceiveHook
+type PreReceiveFunc func(ctx context.Context, push *Push) (Rejections, error)
+
+func (f PreReceiveFunc) PreReceive(ctx context.Context, push *Push) (Rejections, error) {
+	return f(ctx, push)
+}
+
+// PostReceiveFunc is a function used as a PostReceiveHook
+type PostReceiveFunc func(ctx context.Context, push *Push) error
+
+func (f PostReceiveFunc) PostReceive(ctx context.Context, push *Push) error {
+	return f(ctx, push)
+}
+
+// registry holds the in-process hooks
+var registry struct {
+	mu          sync.Mutex
+	preReceive  []PreReceiveHook
+	postReceive []PostReceiveHook
+}
+
+// RegisterPreReceive adds an in-process pre-receive hook, run before the
+// configured executables. It is meant to be called from an init function.
+func RegisterPreReceive(hook PreReceiveHook) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.preReceive = append(registry.preReceive, hook)
+}
+
+// RegisterPostReceive adds an in-process post-receive hook, run before the
+// configured executables. It is meant to be called from an init function.
+func RegisterPostReceive(hook PostReceiveHook) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.postReceive = append(registry.postReceive, hook)
+}
+
+// Runner runs the hooks of the pushes
+type Runner struct {
+	Logger      zerolog.Logger
+	PreReceive  []PreReceiveHook
+	PostReceive []PostReceiveHook
+}
+
+// NewRunner returns a runner of the registered in-process hooks
+func NewRunner(logger zerolog.Logger) *Runner {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	return &Runner{
+		Logger:      logger.With().Str("component", "hooks").Logger(),
+		PreReceive:  slices.Clone(registry.preReceive),
+		PostReceive: slices.Clone(registry.postReceive),
+	}
+}
+
+// RunPreReceive runs the pre-receive hooks in order, each one checking the
+// updates accepted by the previous ones. It returns the rejected updates,
+// whose reasons are also written to the push output.
+func (r *Runner) RunPreReceive(ctx context.Context, push *Push) Rejections {
+	rejected := make(Rejections)
+	pending := *push
+	for _, hook := range r.PreReceive {
+		pending.Updates = slices.DeleteFunc(slices.Clone(pending.Updates), func(u Update) bool {
+			_, ok := rejected[u.Name]
+			return ok
+		})
+		if len(pending.Updates) == 0 {
+			break
+		}
+
+		rejections, err := hook.PreReceive(ctx, &pending)
+		if err != nil {
+			r.Logger.Warn().Err(err).Str("repo", push.Repository).Msg("Push rejected by pre-receive hook")
+			for _, update := range pending.Updates {
+				rejected[update.Name] = err.Error()
+			}
+			continue
+		}
+		for name, reason := range rejections {
+			if slices.ContainsFunc(pending.Updates, func(u Update) bool { return u.Name == name }) {
+				rejected[name] = reason
+			}
+		}
+	}
+
+	for _, update := range push.Updates {
+		if reason, ok := rejected[update.Name]; ok {
+			r.Logger.Info().Str("repo", push.Repository).Str("ref", update.Name.String()).Str("reason", reason).Msg("Reference update rejected")
+			fmt.Fprintf(push.Output, "rejected %s: %s\n", update.Name, reason)
+		}
+	}
+	return rejected
+}
+
+// RunPostReceive runs the post-receive hooks, their errors are logged
+func (r *Runner) RunPostReceive(ctx context.Context, push *Push) {
+	if len(push.Updates) == 0 {
+		return
+	}
+	for _, hook := range r.PostReceive {
+		if err := hook.PostReceive(ctx, push); err != nil {
+			r.Logger.Error().Err(err).Str("repo", push.Repository).Msg("Post-receive hook failed")
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commit adds an empty commit to the current branch of repo
+func commit(t *testing.T, repo *git.Repository, message string) plumbing.Hash {
+	t.Helper()
+	return commitAt(t, repo, message, time.Now())
+}
+
+// commitAt adds an empty commit dated when to the current branch of repo
+func commitAt(t *testing.T, repo *git.Repository, message string, when time.Time) plumbing.Hash {
+	t.Helper()
+
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	hash, err := wt.Commit(message, &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            &object.Signature{Name: "test", Email: "test@example.com", When: when},
+	})
+	require.NoError(t, err)
+	return hash
+}
+
+// countingStorer counts the objects read
+type countingStorer struct {
+	storer.Storer
+	reads int
+}
+
+func (s *countingStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	s.reads++
+	return s.Storer.EncodedObject(t, h)
+}
+
+func TestCommitLister(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+
+	base := commit(t, repo, "base")
+	first := commit(t, repo, "first")
+	second := commit(t, repo, "second")
+	main := plumbing.NewBranchReferenceName("master")
+
+	// Another branch already holds the first commit
+	require.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/other", first)))
+
+	push := &Push{
+		Updates: []Update{{Name: main, Old: base, New: second}},
+		Storer:  repo.Storer,
+	}
+	commits, err := NewCommitLister(push).Commits(push.Updates[0], 20)
+	require.NoError(t, err)
+	require.Len(t, commits, 1)
+	assert.Equal(t, second, commits[0].Hash)
+
+	// A created reference introduces no commit held by the other references
+	created := Update{Name: "refs/heads/new", New: second}
+	push.Updates = []Update{created}
+	lister := NewCommitLister(push)
+	commits, err = lister.Commits(created, 20)
+	require.NoError(t, err)
+	assert.Empty(t, commits)
+
+	commits, err = lister.Commits(Update{Name: main, Old: second}, 20)
+	require.NoError(t, err)
+	assert.Empty(t, commits)
+}
+
+func TestCommitLister_Bounded(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+
+	start := time.Unix(1700000000, 0)
+	var tip plumbing.Hash
+	for i := range 300 {
+		tip = commitAt(t, repo, fmt.Sprintf("commit %d", i), start.Add(time.Duration(i)*time.Second))
+	}
+	// The pushed commits have the same date as the tip of master, as after a rebase
+	var pushed []plumbing.Hash
+	for i := range 30 {
+		pushed = append(pushed, commitAt(t, repo, fmt.Sprintf("pushed %d", i), start.Add(299*time.Second)))
+	}
+	require.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/master", tip)))
+
+	st := &countingStorer{Storer: repo.Storer}
+	push := &Push{
+		Updates: []Update{{Name: "refs/heads/feature", New: pushed[len(pushed)-1]}},
+		Storer:  st,
+	}
+	lister := NewCommitLister(push)
+
+	// The limit keeps the most recent commits
+	commits, err := lister.Commits(push.Updates[0], 20)
+	require.NoError(t, err)
+	require.Len(t, commits, 20)
+	assert.Equal(t, pushed[29], commits[0].Hash)
+	assert.Equal(t, pushed[10], commits[19].Hash)
+
+	// The walk stops at the other branch instead of loading the whole history
+	commits, err = lister.Commits(push.Updates[0], 100)
+	require.NoError(t, err)
+	assert.Len(t, commits, 30)
+	assert.Less(t, st.reads, 100)
+}
+
+func TestRunnerPreReceive(t *testing.T) {
+	var seen [][]Update
+	record := func(rejections Rejections, err error) PreReceiveHook {
+		return PreReceiveFunc(func(ctx context.Context, push *Push) (Rejections, error) {
+			seen = append(seen, push.Updates)
+			return rejections, err
+		})
+	}
+
+	runner := &Runner{
+		Logger: zerolog.Nop(),
+		PreReceive: []PreReceiveHook{
+			record(Rejections{"refs/heads/main": "protected branch", "refs/heads/unknown": "ignored"}, nil),
+			record(nil, errors.New("quota exceeded")),
+			record(nil, nil),
+		},
+	}
+
+	var output bytes.Buffer
+	push := &Push{
+		Repository: "team/project.git",
+		Updates:    []Update{{Name: "refs/heads/main"}, {Name: "refs/heads/feature"}},
+		Output:     &output,
+	}
+	rejected := runner.RunPreReceive(context.Background(), push)
+
+	assert.Equal(t, Rejections{"refs/heads/main": "protected branch", "refs/heads/feature": "quota exceeded"}, rejected)
+	// Each hook only sees the updates accepted so far, and none is left for the last one
+	require.Len(t, seen, 2)
+	assert.Len(t, seen[0], 2)
+	assert.Equal(t, []Update{{Name: "refs/heads/feature"}}, seen[1])
+	assert.Equal(t, "rejected refs/heads/main: protected branch\nrejected refs/heads/feature: quota exceeded\n", output.String())
+}
+
+func TestCommand(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "hook.sh")
+	require.NoError(t, os.WriteFile(script, []byte(`#!/bin/sh
+cat > "$(dirname "$0")/stdin"
+echo "checked $GIT_SERVER_REPOSITORY for $GIT_SERVER_PUSHER"
+[ "$GIT_SERVER_PUSHER" = alice ]
+`), 0o755))
+
+	hash := plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	var output bytes.Buffer
+	push := &Push{
+		Repository: "team/project.git",
+		Pusher:     &auth.Identity{Username: "alice"},
+		Updates:    []Update{{Name: "refs/heads/main", New: hash}},
+		Output:     &output,
+	}
+
+	hook := &Command{Path: script}
+	rejections, err := hook.PreReceive(context.Background(), push)
+	require.NoError(t, err)
+	assert.Empty(t, rejections)
+	assert.Equal(t, "checked team/project.git for alice\n", output.String())
+
+	stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.ZeroHash.String()+" "+hash.String()+" refs/heads/main\n", string(stdin))
+
+	push.Pusher = nil
+	_, err = hook.PreReceive(context.Background(), push)
+	assert.EqualError(t, err, "pre-receive hook declined")
+	assert.Error(t, hook.PostReceive(context.Background(), push))
+}
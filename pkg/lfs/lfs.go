@@ -0,0 +1,154 @@
+// Package lfs implements the server side of the Git LFS API: the batch API
+// negotiating the transfers of the objects, the checks of the uploaded
+// objects and the file locks. The objects are kept by a storage.LFSStorage.
+package lfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/labbs/git-server-s3/pkg/storage"
+)
+
+// MediaType is the content type of the requests and responses of the LFS API
+const MediaType = "application/vnd.git-lfs+json"
+
+// Operations of a batch request
+const (
+	OperationDownload = "download"
+	OperationUpload   = "upload"
+)
+
+// TransferBasic is the only transfer adapter supported, plain HTTP requests
+const TransferBasic = "basic"
+
+// HashAlgorithm is the only hash algorithm of the oids supported
+const HashAlgorithm = "sha256"
+
+// ErrInvalidObject is returned for an uploaded content not matching its oid
+var ErrInvalidObject = errors.New("invalid object")
+
+// Pointer identifies an object by its oid, the SHA-256 of its content, and
+// its size
+type Pointer struct {
+	OID  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// Ref is the reference a request applies to
+type Ref struct {
+	Name string `json:"name"`
+}
+
+// BatchRequest asks to download or upload objects
+type BatchRequest struct {
+	Operation string    `json:"operation"`
+	Transfers []string  `json:"transfers,omitempty"`
+	Ref       *Ref      `json:"ref,omitempty"`
+	Objects   []Pointer `json:"objects"`
+	HashAlgo  string    `json:"hash_algo,omitempty"`
+}
+
+// BatchResponse gives the actions transferring each object of a batch request
+type BatchResponse struct {
+	Transfer string           `json:"transfer"`
+	Objects  []ObjectResponse `json:"objects"`
+	HashAlgo string           `json:"hash_algo"`
+}
+
+// ObjectResponse gives the actions transferring an object, none when there
+// is nothing to transfer, or the error preventing its transfer
+type ObjectResponse struct {
+	Pointer
+	Authenticated bool               `json:"authenticated,omitempty"`
+	Actions       map[string]*Action `json:"actions,omitempty"`
+	Error         *ObjectError       `json:"error,omitempty"`
+}
+
+// Action is a request the client sends to transfer an object
+type Action struct {
+	Href      string            `json:"href"`
+	Header    map[string]string `json:"header,omitempty"`
+	ExpiresIn int               `json:"expires_in,omitempty"` // Validity in seconds
+}
+
+// ObjectError is the error of an object of a batch request, its code an HTTP status
+type ObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// ErrorResponse is the body of the error responses
+type ErrorResponse struct {
+	Message string `json:"message"`
+}
+
+// NewAction returns an action sending a request to href, valid for expiry
+// when not 0
+func NewAction(href string, header map[string]string, expiry time.Duration) *Action {
+	return &Action{Href: href, Header: header, ExpiresIn: int(expiry.Seconds())}
+}
+
+// ValidOID reports whether oid is a SHA-256 in lowercase hexadecimal
+func ValidOID(oid string) bool {
+	if len(oid) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range oid {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Receive stores the object read from r once its content is checked against
+// its oid, and returns its size. The content is spooled to a temporary file,
+// whatever its size it is never held in memory. Nothing is stored when the
+// content does not match the oid.
+func Receive(store storage.LFSStorage, repoPath, oid string, r io.Reader) (int64, error) {
+	if !ValidOID(oid) {
+		return 0, fmt.Errorf("%w: invalid oid %q", ErrInvalidObject, oid)
+	}
+
+	f, err := os.CreateTemp("", "lfs-"+oid+"-*")
+	if err != nil {
+		return 0, err
+	}
+	// The storage may have moved the file
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err != nil {
+		return 0, err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != oid {
+		return 0, fmt.Errorf("%w: content of SHA-256 %s, expected %s", ErrInvalidObject, sum, oid)
+	}
+
+	if err := store.Put(repoPath, oid, f, size); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// Verify checks that an object is stored with its expected size. It returns
+// an error wrapping fs.ErrNotExist when the object is missing and
+// ErrInvalidObject when its size differs.
+func Verify(store storage.LFSStorage, repoPath string, p Pointer) error {
+	size, err := store.Stat(repoPath, p.OID)
+	if err != nil {
+		return err
+	}
+	if size != p.Size {
+		return fmt.Errorf("%w: size %d, expected %d", ErrInvalidObject, size, p.Size)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package lfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func oidOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestValidOID(t *testing.T) {
+	assert.True(t, ValidOID(oidOf("content")))
+	assert.False(t, ValidOID(""))
+	assert.False(t, ValidOID(strings.ToUpper(oidOf("content"))))
+	assert.False(t, ValidOID("../"+oidOf("content")[3:]))
+}
+
+func TestReceive(t *testing.T) {
+	store, err := local.NewLFSStorage(t.TempDir())
+	require.NoError(t, err)
+	oid := oidOf("hero model")
+
+	// A content not matching its oid is not stored
+	_, err = Receive(store, "team/game.git", oid, strings.NewReader("something else"))
+	assert.ErrorIs(t, err, ErrInvalidObject)
+	_, err = store.Stat("team/game.git", oid)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.ErrorIs(t, Verify(store, "team/game.git", Pointer{OID: oid, Size: 10}), fs.ErrNotExist)
+
+	size, err := Receive(store, "team/game.git", oid, strings.NewReader("hero model"))
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, size)
+	assert.NoError(t, Verify(store, "team/game.git", Pointer{OID: oid, Size: 10}))
+	assert.ErrorIs(t, Verify(store, "team/game.git", Pointer{OID: oid, Size: 11}), ErrInvalidObject)
+
+	r, size, err := store.Open("team/game.git", oid)
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "hero model", string(content))
+	assert.EqualValues(t, 10, size)
+
+	// The objects follow their repository
+	require.NoError(t, store.RenameRepository("team/game.git", "archive/game.git"))
+	assert.NoError(t, Verify(store, "archive/game.git", Pointer{OID: oid, Size: 10}))
+	require.NoError(t, store.DeleteRepository("archive/game.git"))
+	assert.ErrorIs(t, Verify(store, "archive/game.git", Pointer{OID: oid, Size: 10}), fs.ErrNotExist)
+}
+
+func TestLockStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks.json")
+	store, err := LoadLocks(path)
+	require.NoError(t, err)
+
+	lock, err := store.Create("team/game.git", "/assets/hero.psd", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "assets/hero.psd", lock.Path)
+	assert.Equal(t, "alice", lock.Owner.Name)
+
+	existing, err := store.Create("team/game.git", "assets/hero.psd", "bob")
+	assert.ErrorIs(t, err, ErrLockExists)
+	assert.Equal(t, lock, existing)
+	_, err = store.Create("team/game.git", "/", "bob")
+	assert.ErrorIs(t, err, ErrInvalidLock)
+
+	second, err := store.Create("team/game.git", "assets/level.bin", "bob")
+	require.NoError(t, err)
+	third, err := store.Create("team/game.git", "assets/music.ogg", "bob")
+	require.NoError(t, err)
+
+	// Locks persist across restarts
+	store, err = LoadLocks(path)
+	require.NoError(t, err)
+
+	locks, next := store.List("team/game.git", ListOptions{Limit: 2})
+	assert.Equal(t, []Lock{lock, second}, locks)
+	assert.Equal(t, third.ID, next)
+	locks, next = store.List("team/game.git", ListOptions{Cursor: next, Limit: 2})
+	assert.Equal(t, []Lock{third}, locks)
+	assert.Empty(t, next)
+	locks, _ = store.List("team/game.git", ListOptions{Path: "assets/level.bin"})
+	assert.Equal(t, []Lock{second}, locks)
+	locks, _ = store.List("team/other.git", ListOptions{})
+	assert.Empty(t, locks)
+
+	_, err = store.Unlock("team/game.git", lock.ID, "bob", false)
+	assert.ErrorIs(t, err, ErrNotOwner)
+	unlocked, err := store.Unlock("team/game.git", lock.ID, "bob", true)
+	require.NoError(t, err)
+	assert.Equal(t, lock, unlocked)
+	_, err = store.Unlock("team/game.git", lock.ID, "alice", false)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, store.RenameRepository("team/game.git", "archive/game.git"))
+	locks, _ = store.List("archive/game.git", ListOptions{})
+	assert.Len(t, locks, 2)
+	require.NoError(t, store.DeleteRepository("archive/game.git"))
+	locks, _ = store.List("archive/game.git", ListOptions{})
+	assert.Empty(t, locks)
+}
+
+func TestLockStore_FailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks.json")
+	store, err := LoadLocks(path)
+	require.NoError(t, err)
+	lock, err := store.Create("team/game.git", "assets/hero.psd", "alice")
+	require.NoError(t, err)
+
+	// The directory of the file is gone, nothing can be written anymore
+	require.NoError(t, os.RemoveAll(filepath.Dir(path)))
+
+	_, err = store.Create("team/game.git", "assets/level.bin", "bob")
+	assert.Error(t, err)
+	_, err = store.Unlock("team/game.git", lock.ID, "alice", false)
+	assert.Error(t, err)
+	locks, _ := store.List("team/game.git", ListOptions{})
+	assert.Equal(t, []Lock{lock}, locks)
+}
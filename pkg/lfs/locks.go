@@ -0,0 +1,203 @@
+package lfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labbs/git-server-s3/pkg/common"
+)
+
+var (
+	// ErrInvalidLock is returned for a lock without a path
+	ErrInvalidLock = errors.New("invalid lock")
+	// ErrLockExists is returned when locking a path already locked
+	ErrLockExists = errors.New("already locked")
+	// ErrNotOwner is returned when unlocking the lock of another user without force
+	ErrNotOwner = errors.New("locked by another user")
+	// ErrNotFound is returned for a lock that does not exist
+	ErrNotFound = errors.New("not found")
+)
+
+// Owner is the user holding a lock
+type Owner struct {
+	Name string `json:"name"`
+}
+
+// Lock prevents the other users from pushing changes to a file
+type Lock struct {
+	ID       string    `json:"id"`
+	Path     string    `json:"path"`
+	LockedAt time.Time `json:"locked_at"`
+	Owner    Owner     `json:"owner"`
+}
+
+// ListOptions filters and paginates the locks of a repository. Cursor is the
+// ID of the first lock of the page, Limit the maximum number of locks, 0 for
+// no limit.
+type ListOptions struct {
+	Path   string
+	ID     string
+	Cursor string
+	Limit  int
+}
+
+// LockStore holds the file locks of the repositories, persisted in a JSON
+// file. The locks of a repository are kept in creation order.
+type LockStore struct {
+	mu    sync.RWMutex
+	path  string
+	locks map[string][]Lock
+}
+
+// LoadLocks reads the locks file at path, a missing file is no lock
+func LoadLocks(path string) (*LockStore, error) {
+	s := &LockStore{path: path, locks: make(map[string][]Lock)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS locks: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.locks); err != nil {
+		return nil, fmt.Errorf("failed to read LFS locks: %w", err)
+	}
+	return s, nil
+}
+
+// update applies change to a copy of the locks by repository and writes them,
+// the store is only changed once the file is written. change must replace the
+// locks of a repository instead of modifying them in place. s.mu must be held.
+func (s *LockStore) update(change func(locks map[string][]Lock)) error {
+	locks := maps.Clone(s.locks)
+	change(locks)
+	if err := common.WriteJSONFile(s.path, locks); err != nil {
+		return fmt.Errorf("failed to write LFS locks: %w", err)
+	}
+	s.locks = locks
+	return nil
+}
+
+// newID returns a random identifier
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// cleanPath returns the canonical form of the path of a file in the
+// repository, empty when there is none
+func cleanPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// Create locks a file of the repository for owner. When the file is already
+// locked, the existing lock is returned with ErrLockExists.
+func (s *LockStore) Create(repoPath, file, owner string) (Lock, error) {
+	file = cleanPath(file)
+	if file == "" {
+		return Lock{}, fmt.Errorf("%w: missing path", ErrInvalidLock)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	locks := s.locks[repoPath]
+	if i := slices.IndexFunc(locks, func(l Lock) bool { return l.Path == file }); i >= 0 {
+		return locks[i], ErrLockExists
+	}
+
+	lock := Lock{ID: newID(), Path: file, LockedAt: time.Now().UTC().Truncate(time.Second), Owner: Owner{Name: owner}}
+	return lock, s.update(func(all map[string][]Lock) {
+		all[repoPath] = append(slices.Clip(locks), lock)
+	})
+}
+
+// List returns a page of the locks of the repository matching the options,
+// and the cursor of the next page, empty on the last page
+func (s *LockStore) List(repoPath string, opts ListOptions) ([]Lock, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	file := cleanPath(opts.Path)
+	locks := []Lock{}
+	started := opts.Cursor == ""
+	for _, lock := range s.locks[repoPath] {
+		if !started && lock.ID != opts.Cursor {
+			continue
+		}
+		started = true
+		if (opts.Path != "" && lock.Path != file) || (opts.ID != "" && lock.ID != opts.ID) {
+			continue
+		}
+		if opts.Limit > 0 && len(locks) == opts.Limit {
+			return locks, lock.ID
+		}
+		locks = append(locks, lock)
+	}
+	return locks, ""
+}
+
+// Unlock removes a lock of the repository and returns it. Only its owner may
+// remove it, unless force is set.
+func (s *LockStore) Unlock(repoPath, id, owner string, force bool) (Lock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	locks := s.locks[repoPath]
+	i := slices.IndexFunc(locks, func(l Lock) bool { return l.ID == id })
+	if i < 0 {
+		return Lock{}, ErrNotFound
+	}
+	lock := locks[i]
+	if lock.Owner.Name != owner && !force {
+		return lock, ErrNotOwner
+	}
+
+	return lock, s.update(func(all map[string][]Lock) {
+		if len(locks) == 1 {
+			delete(all, repoPath)
+		} else {
+			all[repoPath] = slices.Delete(slices.Clone(locks), i, i+1)
+		}
+	})
+}
+
+// RenameRepository moves the locks of a renamed repository
+func (s *LockStore) RenameRepository(oldPath, newPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	locks, ok := s.locks[oldPath]
+	if !ok {
+		return nil
+	}
+	return s.update(func(all map[string][]Lock) {
+		delete(all, oldPath)
+		all[newPath] = locks
+	})
+}
+
+// DeleteRepository removes the locks of a deleted repository
+func (s *LockStore) DeleteRepository(repoPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.locks[repoPath]; !ok {
+		return nil
+	}
+	return s.update(func(all map[string][]Lock) {
+		delete(all, repoPath)
+	})
+}
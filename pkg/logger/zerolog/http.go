@@ -7,6 +7,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	z "github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func HTTPLogger(logger z.Logger) fiber.Handler {
@@ -20,10 +21,15 @@ func HTTPLogger(logger z.Logger) fiber.Handler {
 			_logger = logger.Info()
 		}
 
-		if slices.Contains([]string{"/health", "/metrics", "/favicon.ico"}, c.Path()) {
+		if slices.Contains([]string{"/health", "/health/live", "/health/ready", "/metrics", "/favicon.ico"}, c.Path()) {
 			return err
 		}
 
+		// Links the log to the trace of the request, when it is traced
+		if sc := trace.SpanContextFromContext(c.UserContext()); sc.IsValid() {
+			_logger = _logger.Str("trace_id", sc.TraceID().String())
+		}
+
 		_logger.
 			Int("status", c.Response().StatusCode()).
 			Dur("duration", time.Since(timeStart)).
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Middleware records the HTTP requests by route. The requests answered before
+// reaching a route, unknown paths or missing credentials, are recorded under
+// the route "/" of the middlewares.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		// The error handler sets the status code once the middlewares return
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			var fe *fiber.Error
+			if errors.As(err, &fe) {
+				status = fe.Code
+			}
+		}
+
+		// The method is backed by the request buffer, reused by the next requests
+		ObserveHTTPRequest(strings.Clone(c.Method()), c.Route().Path, status, time.Since(start))
+		return err
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,210 @@
+// Package metrics holds the Prometheus metrics of the server: the HTTP
+// requests, the upload-packs and receive-packs served over HTTP and SSH, the
+// SSH connections, the S3 API calls and the runtime statistics also shown by
+// the debug endpoints. They are served by Handler, on /metrics.
+package metrics
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "git_server"
+
+// Protocols of the git operations
+const (
+	ProtocolHTTP = "http"
+	ProtocolSSH  = "ssh"
+)
+
+// Registry holds the metrics of the server
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+// durationBuckets range from 5ms to about 80s
+var durationBuckets = prometheus.ExponentialBuckets(0.005, 2, 15)
+
+var (
+	httpRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of the HTTP requests by method and route.",
+		Buckets:   durationBuckets,
+	}, []string{"method", "route"})
+
+	gitOperations = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "git",
+		Name:      "operations_total",
+		Help:      "Upload-packs and receive-packs by service, repository and protocol.",
+	}, []string{"service", "repo", "protocol"})
+
+	gitBytes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "git",
+		Name:      "transferred_bytes_total",
+		Help:      "Bytes received (in) and sent (out) by the upload-packs and receive-packs.",
+	}, []string{"service", "protocol", "direction"})
+
+	packDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "git",
+		Name:      "pack_duration_seconds",
+		Help:      "Duration of the upload-packs and receive-packs, from the client request to the end of the pack transfer.",
+		Buckets:   durationBuckets,
+	}, []string{"service", "protocol"})
+
+	sshConnections = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ssh",
+		Name:      "connections_total",
+		Help:      "SSH connections accepted.",
+	})
+
+	sshOpenConnections = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "ssh",
+		Name:      "open_connections",
+		Help:      "SSH connections currently open.",
+	})
+
+	sshAuthFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ssh",
+		Name:      "auth_failures_total",
+		Help:      "SSH authentication attempts rejected, by method (publickey or password).",
+	}, []string{"method"})
+
+	s3Requests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "s3",
+		Name:      "requests_total",
+		Help:      "S3 API calls by operation.",
+	}, []string{"operation"})
+
+	s3Errors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "s3",
+		Name:      "request_errors_total",
+		Help:      "S3 API calls that failed, by operation.",
+	}, []string{"operation"})
+
+	s3Duration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "s3",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of the S3 API calls by operation, retries included.",
+		Buckets:   durationBuckets,
+	}, []string{"operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		newRuntimeCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// ObserveHTTPRequest records an HTTP request served by route, the pattern
+// of the route rather than the path so that the repositories do not
+// multiply the series
+func ObserveHTTPRequest(method, route string, status int, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// SSHConnection records a new SSH connection, the returned function is
+// called once it is closed
+func SSHConnection() (closed func()) {
+	sshConnections.Inc()
+	sshOpenConnections.Inc()
+	return sshOpenConnections.Dec
+}
+
+// SSHAuthFailure records a rejected SSH authentication attempt
+func SSHAuthFailure(method string) {
+	sshAuthFailures.WithLabelValues(method).Inc()
+}
+
+// ObserveS3 records an S3 API call and its outcome
+func ObserveS3(operation string, duration time.Duration, err error) {
+	s3Requests.WithLabelValues(operation).Inc()
+	s3Duration.WithLabelValues(operation).Observe(duration.Seconds())
+	if err != nil {
+		s3Errors.WithLabelValues(operation).Inc()
+	}
+}
+
+// Operation measures an upload-pack or a receive-pack: the bytes read from
+// and written to the client through Reader and Writer, and its duration up
+// to Done.
+type Operation struct {
+	service  string
+	protocol string
+	start    time.Time
+	in       atomic.Int64
+	out      atomic.Int64
+}
+
+// StartOperation records a git service (git-upload-pack or git-receive-pack)
+// served for the repository over protocol, and starts measuring it
+func StartOperation(service, repo, protocol string) *Operation {
+	// repo may be backed by a request buffer, the labels of a new series are kept
+	gitOperations.WithLabelValues(service, strings.Clone(repo), protocol).Inc()
+	return &Operation{service: service, protocol: protocol, start: time.Now()}
+}
+
+// Reader returns r counting the bytes received from the client
+func (o *Operation) Reader(r io.Reader) io.Reader {
+	return &countingReader{Reader: r, n: &o.in}
+}
+
+// Writer returns w counting the bytes sent to the client
+func (o *Operation) Writer(w io.Writer) io.Writer {
+	return &countingWriter{Writer: w, n: &o.out}
+}
+
+// Done records the duration and the bytes transferred of the operation
+func (o *Operation) Done() {
+	packDuration.WithLabelValues(o.service, o.protocol).Observe(time.Since(o.start).Seconds())
+	gitBytes.WithLabelValues(o.service, o.protocol, "in").Add(float64(o.in.Load()))
+	gitBytes.WithLabelValues(o.service, o.protocol, "out").Add(float64(o.out.Load()))
+}
+
+type countingReader struct {
+	io.Reader
+	n *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	io.Writer
+	n *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
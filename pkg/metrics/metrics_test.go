@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use(Middleware())
+	app.Get("/api/repos/+/branches", func(c *fiber.Ctx) error {
+		return c.SendString("[]")
+	})
+	app.Get("/fail", func(c *fiber.Ctx) error {
+		return fiber.NewError(fiber.StatusTeapot, "teapot")
+	})
+	app.Get("/metrics", Handler())
+
+	for _, target := range []string{"/api/repos/team/a.git/branches", "/api/repos/team/b.git/branches", "/fail", "/missing"} {
+		_, err := app.Test(httptest.NewRequest(fiber.MethodGet, target, nil))
+		require.NoError(t, err)
+	}
+
+	// The routes are recorded rather than the paths
+	assert.Equal(t, 2.0, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/api/repos/+/branches", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/fail", "418")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/", "404")))
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/metrics", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `git_server_http_requests_total{method="GET",route="/fail",status="418"} 1`)
+	assert.Contains(t, string(body), "git_server_runtime_goroutines ")
+	assert.Contains(t, string(body), "git_server_runtime_heap_alloc_bytes ")
+}
+
+func TestOperation(t *testing.T) {
+	op := StartOperation("git-upload-pack", "team/op.git", ProtocolSSH)
+	n, err := io.Copy(op.Writer(io.Discard), op.Reader(strings.NewReader("0009want\n0000")))
+	require.NoError(t, err)
+	assert.EqualValues(t, 13, n)
+	_, err = op.Writer(&bytes.Buffer{}).Write([]byte("PACK"))
+	require.NoError(t, err)
+	op.Done()
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(gitOperations.WithLabelValues("git-upload-pack", "team/op.git", ProtocolSSH)))
+	assert.Equal(t, 13.0, testutil.ToFloat64(gitBytes.WithLabelValues("git-upload-pack", ProtocolSSH, "in")))
+	assert.Equal(t, 17.0, testutil.ToFloat64(gitBytes.WithLabelValues("git-upload-pack", ProtocolSSH, "out")))
+	assert.Equal(t, 1, testutil.CollectAndCount(packDuration, "git_server_git_pack_duration_seconds"))
+}
+
+func TestObserveS3(t *testing.T) {
+	ObserveS3("GetObject", time.Millisecond, nil)
+	ObserveS3("GetObject", time.Millisecond, errors.New("NoSuchKey"))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(s3Requests.WithLabelValues("GetObject")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(s3Errors.WithLabelValues("GetObject")))
+}
+
+func TestSSHConnection(t *testing.T) {
+	closed := SSHConnection()
+	assert.Equal(t, 1.0, testutil.ToFloat64(sshOpenConnections))
+	closed()
+	assert.Equal(t, 0.0, testutil.ToFloat64(sshOpenConnections))
+	assert.Equal(t, 1.0, testutil.ToFloat64(sshConnections))
+}
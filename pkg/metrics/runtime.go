@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// runtimeCollector exports the memory and goroutine statistics of the
+// /debug/memory endpoint, read once per scrape
+type runtimeCollector struct {
+	alloc      *prometheus.Desc
+	totalAlloc *prometheus.Desc
+	sys        *prometheus.Desc
+	heapAlloc  *prometheus.Desc
+	heapSys    *prometheus.Desc
+	numGC      *prometheus.Desc
+	lastGC     *prometheus.Desc
+	nextGC     *prometheus.Desc
+	gcCPU      *prometheus.Desc
+	goroutines *prometheus.Desc
+}
+
+func newRuntimeCollector() *runtimeCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "runtime", name), help, nil, nil)
+	}
+	return &runtimeCollector{
+		alloc:      desc("alloc_bytes", "Bytes of allocated heap objects."),
+		totalAlloc: desc("alloc_bytes_total", "Cumulative bytes allocated for heap objects."),
+		sys:        desc("sys_bytes", "Bytes of memory obtained from the OS."),
+		heapAlloc:  desc("heap_alloc_bytes", "Bytes of allocated heap objects, reachable or not yet freed."),
+		heapSys:    desc("heap_sys_bytes", "Bytes of heap memory obtained from the OS."),
+		numGC:      desc("gc_cycles_total", "Completed GC cycles."),
+		lastGC:     desc("last_gc_timestamp_seconds", "Time the last GC cycle finished, in seconds since the epoch."),
+		nextGC:     desc("next_gc_bytes", "Heap size target of the next GC cycle."),
+		gcCPU:      desc("gc_cpu_fraction", "Fraction of the available CPU time used by the GC since the program started."),
+		goroutines: desc("goroutines", "Goroutines that currently exist."),
+	}
+}
+
+func (c *runtimeCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *runtimeCollector) Collect(ch chan<- prometheus.Metric) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	ch <- prometheus.MustNewConstMetric(c.alloc, prometheus.GaugeValue, float64(m.Alloc))
+	ch <- prometheus.MustNewConstMetric(c.totalAlloc, prometheus.CounterValue, float64(m.TotalAlloc))
+	ch <- prometheus.MustNewConstMetric(c.sys, prometheus.GaugeValue, float64(m.Sys))
+	ch <- prometheus.MustNewConstMetric(c.heapAlloc, prometheus.GaugeValue, float64(m.HeapAlloc))
+	ch <- prometheus.MustNewConstMetric(c.heapSys, prometheus.GaugeValue, float64(m.HeapSys))
+	ch <- prometheus.MustNewConstMetric(c.numGC, prometheus.CounterValue, float64(m.NumGC))
+	ch <- prometheus.MustNewConstMetric(c.lastGC, prometheus.GaugeValue, float64(m.LastGC)/1e9)
+	ch <- prometheus.MustNewConstMetric(c.nextGC, prometheus.GaugeValue, float64(m.NextGC))
+	ch <- prometheus.MustNewConstMetric(c.gcCPU, prometheus.GaugeValue, m.GCCPUFraction)
+	ch <- prometheus.MustNewConstMetric(c.goroutines, prometheus.GaugeValue, float64(runtime.NumGoroutine()))
+}
@@ -0,0 +1,83 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gitstorage "github.com/go-git/go-git/v5/storage"
+)
+
+// refSpecs are the references fetched from upstream, replacing the local ones
+var refSpecs = []gitconfig.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// Fetch updates the branches and tags of the repository to those of
+// upstream: new references are created, moved ones are forced and the ones
+// deleted upstream are pruned. HEAD then follows the default branch of
+// upstream.
+func Fetch(ctx context.Context, st storer.Storer, upstream string, auth transport.AuthMethod) error {
+	s, ok := st.(gitstorage.Storer)
+	if !ok {
+		return fmt.Errorf("storage does not support fetching")
+	}
+
+	remote := git.NewRemote(s, &gitconfig.RemoteConfig{Name: "upstream", URLs: []string{upstream}})
+	err := remote.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: refSpecs,
+		Auth:     auth,
+		Tags:     git.NoTags,
+		Prune:    true,
+		Force:    true,
+	})
+	switch {
+	case errors.Is(err, transport.ErrEmptyRemoteRepository):
+		return nil
+	case err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate):
+		return err
+	}
+
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if ref.Name() != plumbing.HEAD || ref.Type() != plumbing.SymbolicReference {
+			continue
+		}
+		// Upstream may advertise a HEAD pointing to no fetched branch
+		if _, err := s.Reference(ref.Target()); err != nil {
+			return nil
+		}
+		return s.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, ref.Target()))
+	}
+	return nil
+}
+
+// Auth returns the authentication to the remote repository at rawURL, an
+// upstream or a downstream. The SSH remotes are authenticated with the
+// private key at sshKey if set, else with the SSH agent. The HTTP remotes use
+// the credentials of their URL.
+func Auth(rawURL, sshKey string) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if ep.Protocol != "ssh" || sshKey == "" {
+		return nil, nil
+	}
+
+	user := ep.User
+	if user == "" {
+		user = "git"
+	}
+	return ssh.NewPublicKeysFromFile(user, sshKey, "")
+}
@@ -0,0 +1,334 @@
+// Package mirror implements the repository mirrors. Pull mirrors are
+// repositories periodically fetching the branches and tags of an upstream
+// repository, see Mirrorer; they are read-only, pushes to them are rejected.
+// Push mirrors are downstream repositories the pushes to a repository are
+// replicated to, see Pusher.
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/common"
+	"github.com/labbs/git-server-s3/pkg/hooks"
+)
+
+var (
+	// ErrInvalidMirror is returned for a mirror with an invalid upstream or interval
+	ErrInvalidMirror = errors.New("invalid mirror")
+	// ErrNotFound is returned for a repository that is not a mirror
+	ErrNotFound = errors.New("not found")
+)
+
+// MinInterval is the shortest interval between two synchronizations
+const MinInterval = time.Minute
+
+// Status is the result of the last synchronization of a mirror
+type Status string
+
+const (
+	StatusPending Status = "pending" // Never synchronized yet
+	StatusOK      Status = "ok"
+	StatusFailed  Status = "failed"
+)
+
+// protocols are the supported protocols of the upstream URLs
+var protocols = []string{"http", "https", "ssh", "git", "file"}
+
+// Duration is a time.Duration written as a string in JSON, e.g. "30m"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Mirror is the upstream of a mirror and the status of its synchronizations
+type Mirror struct {
+	URL        string     `json:"url"`
+	Interval   Duration   `json:"interval"`
+	Status     Status     `json:"status"`
+	LastSync   *time.Time `json:"last_sync,omitempty"`   // End of the last synchronization
+	LastUpdate *time.Time `json:"last_update,omitempty"` // End of the last successful synchronization
+	LastError  string     `json:"last_error,omitempty"`  // Error of the last synchronization, if failed
+}
+
+// ValidateURL checks that raw is the URL of a repository over HTTP(S), SSH
+// (including the scp-like user@host:path form), the git protocol or file://.
+//
+// A file:// URL, or a bare path, reads and writes the filesystem of the
+// server with its permissions, bypassing the access control of the
+// repositories: it is only accepted with config.Mirrors.AllowFile, and
+// outside of the local storage of the repositories.
+func ValidateURL(raw string) error {
+	ep, err := transport.NewEndpoint(raw)
+	if err != nil {
+		return fmt.Errorf("%w: invalid URL: %v", ErrInvalidMirror, err)
+	}
+	if !slices.Contains(protocols, ep.Protocol) {
+		return fmt.Errorf("%w: unsupported protocol %q", ErrInvalidMirror, ep.Protocol)
+	}
+	if ep.Protocol == "file" {
+		return validatePath(ep.Path)
+	}
+	return nil
+}
+
+// validatePath checks that the repository at path may be mirrored from or
+// to, see ValidateURL
+func validatePath(path string) error {
+	if !config.Mirrors.AllowFile {
+		return fmt.Errorf("%w: file URLs are disabled", ErrInvalidMirror)
+	}
+	if config.Storage.Local.Path == "" {
+		return nil
+	}
+
+	root, err := resolvePath(config.Storage.Local.Path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMirror, err)
+	}
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMirror, err)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: %s is in the repository storage", ErrInvalidMirror, path)
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path of path, with the symbolic links of
+// its longest existing prefix resolved
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return filepath.Join(append([]string{abs}, missing...)...), nil
+		}
+		missing = append([]string{filepath.Base(abs)}, missing...)
+		abs = parent
+	}
+}
+
+// RedactURL returns raw without the password it may hold
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); !ok {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// Store holds the mirrors by repository, persisted in a JSON file. It is
+// the pre-receive hook rejecting the pushes to the mirrors.
+type Store struct {
+	mu      sync.RWMutex
+	path    string
+	mirrors map[string]*Mirror
+}
+
+// Load reads the mirrors file at path, a missing file is no mirror
+func Load(path string) (*Store, error) {
+	s := &Store{path: path, mirrors: make(map[string]*Mirror)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mirrors: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.mirrors); err != nil {
+		return nil, fmt.Errorf("failed to read mirrors: %w", err)
+	}
+	return s, nil
+}
+
+// update applies change to a copy of the mirrors by repository and writes
+// them, the store is only changed once the file is written. change must
+// replace the mirrors it modifies with copies. s.mu must be held.
+func (s *Store) update(change func(mirrors map[string]*Mirror)) error {
+	mirrors := maps.Clone(s.mirrors)
+	change(mirrors)
+	if err := common.WriteJSONFile(s.path, mirrors); err != nil {
+		return fmt.Errorf("failed to write mirrors: %w", err)
+	}
+	s.mirrors = mirrors
+	return nil
+}
+
+// Get returns the mirror of the repository
+func (s *Store) Get(repoPath string) (Mirror, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.mirrors[repoPath]
+	if !ok {
+		return Mirror{}, false
+	}
+	return *m, true
+}
+
+// Repositories returns the paths of the mirrors
+func (s *Store) Repositories() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	repos := make([]string, 0, len(s.mirrors))
+	for repoPath := range s.mirrors {
+		repos = append(repos, repoPath)
+	}
+	slices.Sort(repos)
+	return repos
+}
+
+// IsMirror reports whether the repository is a mirror
+func (s *Store) IsMirror(repoPath string) bool {
+	_, ok := s.Get(repoPath)
+	return ok
+}
+
+// Set makes the repository a mirror of upstream, synchronized every
+// interval. The status of an existing mirror of the same upstream is kept.
+func (s *Store) Set(repoPath, upstream string, interval time.Duration) (Mirror, error) {
+	if err := ValidateURL(upstream); err != nil {
+		return Mirror{}, err
+	}
+	if interval < MinInterval {
+		return Mirror{}, fmt.Errorf("%w: interval shorter than %s", ErrInvalidMirror, MinInterval)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := Mirror{URL: upstream, Status: StatusPending}
+	if current, ok := s.mirrors[repoPath]; ok && current.URL == upstream {
+		m = *current
+	}
+	m.Interval = Duration(interval)
+	return m, s.update(func(mirrors map[string]*Mirror) {
+		mirrors[repoPath] = &m
+	})
+}
+
+// Remove stops mirroring the repository, which keeps its references
+func (s *Store) Remove(repoPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.mirrors[repoPath]; !ok {
+		return ErrNotFound
+	}
+	return s.update(func(mirrors map[string]*Mirror) {
+		delete(mirrors, repoPath)
+	})
+}
+
+// record saves the result of a synchronization of the repository
+func (s *Store) record(repoPath, upstream string, syncErr error) (Mirror, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.mirrors[repoPath]
+	// The mirror may have been removed or changed during the synchronization
+	if !ok || current.URL != upstream {
+		return Mirror{}, ErrNotFound
+	}
+
+	m := *current
+	now := time.Now().UTC()
+	m.LastSync = &now
+	if syncErr != nil {
+		m.Status, m.LastError = StatusFailed, syncErr.Error()
+	} else {
+		m.Status, m.LastError, m.LastUpdate = StatusOK, "", &now
+	}
+	return m, s.update(func(mirrors map[string]*Mirror) {
+		mirrors[repoPath] = &m
+	})
+}
+
+// RenameRepository moves the mirror of a renamed repository
+func (s *Store) RenameRepository(oldPath, newPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.mirrors[oldPath]
+	if !ok {
+		return nil
+	}
+	return s.update(func(mirrors map[string]*Mirror) {
+		delete(mirrors, oldPath)
+		mirrors[newPath] = m
+	})
+}
+
+// DeleteRepository removes the mirror of a deleted repository
+func (s *Store) DeleteRepository(repoPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.mirrors[repoPath]; !ok {
+		return nil
+	}
+	return s.update(func(mirrors map[string]*Mirror) {
+		delete(mirrors, repoPath)
+	})
+}
+
+// PreReceive rejects every update of the mirrors, their references only
+// come from their upstream
+func (s *Store) PreReceive(ctx context.Context, push *hooks.Push) (hooks.Rejections, error) {
+	if !s.IsMirror(push.Repository) {
+		return nil, nil
+	}
+
+	rejected := hooks.Rejections{}
+	for _, update := range push.Updates {
+		rejected[update.Name] = "repository is a read-only mirror"
+	}
+	return rejected, nil
+}
@@ -0,0 +1,203 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"github.com/labbs/git-server-s3/pkg/logger/zerolog"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	z "github.com/rs/zerolog"
+)
+
+// DefaultTimeout is the timeout of a synchronization when Mirrorer.Timeout is zero
+const DefaultTimeout = 10 * time.Minute
+
+// Mirrorer synchronizes the mirrors of the store from their upstream, every
+// interval of the mirror. Each mirror is a job of a gocron scheduler, running
+// once at a time: a synchronization lasting longer than the interval delays
+// the next one.
+type Mirrorer struct {
+	Store   *Store
+	Storage storage.GitRepositoryStorage
+	SSHKey  string        // Private key of the SSH upstreams, empty for the SSH agent
+	Timeout time.Duration // Zero for DefaultTimeout
+
+	logger    z.Logger
+	scheduler *gocron.Scheduler
+	mu        sync.Mutex             // Guards the scheduler jobs and syncing
+	syncing   map[string]*sync.Mutex // Held by the synchronization of a repository
+}
+
+// NewMirrorer returns a mirrorer of the mirrors of store, stored in str
+func NewMirrorer(store *Store, str storage.GitRepositoryStorage, logger z.Logger) *Mirrorer {
+	scheduler := gocron.NewScheduler(time.UTC)
+	scheduler.TagsUnique()
+
+	return &Mirrorer{
+		Store:     store,
+		Storage:   str,
+		logger:    logger.With().Str("component", "mirrors").Logger(),
+		scheduler: scheduler,
+		syncing:   make(map[string]*sync.Mutex),
+	}
+}
+
+// Start schedules the synchronizations of the mirrors, the first one of each
+// mirror running right away
+func (m *Mirrorer) Start() error {
+	for _, repoPath := range m.Store.Repositories() {
+		if err := m.schedule(repoPath); err != nil {
+			return err
+		}
+	}
+	m.scheduler.StartAsync()
+	return nil
+}
+
+// Stop stops scheduling the synchronizations, the running ones are not interrupted
+func (m *Mirrorer) Stop() {
+	m.scheduler.Stop()
+}
+
+// Set makes the repository a mirror of upstream and schedules its synchronization
+func (m *Mirrorer) Set(repoPath, upstream string, interval time.Duration) (Mirror, error) {
+	mirror, err := m.Store.Set(repoPath, upstream, interval)
+	if err != nil {
+		return Mirror{}, err
+	}
+	return mirror, m.schedule(repoPath)
+}
+
+// Remove stops mirroring the repository
+func (m *Mirrorer) Remove(repoPath string) error {
+	if err := m.Store.Remove(repoPath); err != nil {
+		return err
+	}
+	m.unschedule(repoPath)
+	return nil
+}
+
+// Sync fetches the upstream of the mirror into the repository and records the
+// outcome. The error is the one of the synchronization, also recorded in the
+// returned mirror, or ErrNotFound if the repository is not a mirror.
+func (m *Mirrorer) Sync(ctx context.Context, repoPath string) (Mirror, error) {
+	lock := m.lock(repoPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	mirror, ok := m.Store.Get(repoPath)
+	if !ok {
+		return Mirror{}, ErrNotFound
+	}
+
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	syncErr := m.fetch(ctx, repoPath, mirror.URL)
+	mirror, err := m.Store.record(repoPath, mirror.URL, syncErr)
+	if err != nil {
+		return mirror, err
+	}
+
+	m.logger.Debug().
+		Str("repo", repoPath).
+		Str("upstream", RedactURL(mirror.URL)).
+		Dur("duration", time.Since(start)).
+		Str("status", string(mirror.Status)).
+		Msg("Mirror synchronized")
+	return mirror, syncErr
+}
+
+// fetch fetches upstream into the repository. The upstream is validated
+// again, the configuration or the filesystem may have changed since it was set.
+func (m *Mirrorer) fetch(ctx context.Context, repoPath, upstream string) error {
+	if err := ValidateURL(upstream); err != nil {
+		return err
+	}
+	auth, err := Auth(upstream, m.SSHKey)
+	if err != nil {
+		return err
+	}
+	st, err := m.Storage.GetStorer(repoPath)
+	if err != nil {
+		return err
+	}
+	return Fetch(ctx, st, upstream, auth)
+}
+
+// lock returns the lock of the synchronization of the repository
+func (m *Mirrorer) lock(repoPath string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, ok := m.syncing[repoPath]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.syncing[repoPath] = lock
+	}
+	return lock
+}
+
+// schedule (re)schedules the synchronization of the mirror on its interval.
+// The jobs are tagged with the path of their repository.
+func (m *Mirrorer) schedule(repoPath string) error {
+	mirror, ok := m.Store.Get(repoPath)
+	if !ok {
+		return ErrNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_ = m.scheduler.RemoveByTag(repoPath)
+	cron := zerolog.GocronAdapter{Logger: m.logger}
+	_, err := m.scheduler.Every(time.Duration(mirror.Interval)).
+		Tag(repoPath).
+		Name(repoPath).
+		SingletonMode().
+		WhenJobReturnsError(func(repoPath string, err error) {
+			if !errors.Is(err, ErrNotFound) {
+				cron.Warn("Failed to synchronize mirror %s: %v", repoPath, err)
+			}
+		}).
+		Do(func() error {
+			_, err := m.Sync(context.Background(), repoPath)
+			return err
+		})
+	return err
+}
+
+// unschedule stops synchronizing the repository
+func (m *Mirrorer) unschedule(repoPath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_ = m.scheduler.RemoveByTag(repoPath)
+	delete(m.syncing, repoPath)
+}
+
+// RenameRepository moves the mirror of a renamed repository
+func (m *Mirrorer) RenameRepository(oldPath, newPath string) error {
+	if !m.Store.IsMirror(oldPath) {
+		return nil
+	}
+	m.unschedule(oldPath)
+	if err := m.Store.RenameRepository(oldPath, newPath); err != nil {
+		return err
+	}
+	return m.schedule(newPath)
+}
+
+// DeleteRepository removes the mirror of a deleted repository
+func (m *Mirrorer) DeleteRepository(repoPath string) error {
+	m.unschedule(repoPath)
+	return m.Store.DeleteRepository(repoPath)
+}
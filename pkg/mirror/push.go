@@ -0,0 +1,241 @@
+package mirror
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitstorage "github.com/go-git/go-git/v5/storage"
+	"github.com/labbs/git-server-s3/pkg/common"
+)
+
+// PushMirror is a downstream repository the pushes to a repository are
+// replicated to, with the status of the last replication
+type PushMirror struct {
+	ID         string     `json:"id"`
+	URL        string     `json:"url"`
+	Status     Status     `json:"status"`
+	Failures   int        `json:"failures,omitempty"`    // Consecutive failed attempts
+	LastPush   *time.Time `json:"last_push,omitempty"`   // End of the last attempt
+	LastUpdate *time.Time `json:"last_update,omitempty"` // End of the last successful attempt
+	LastError  string     `json:"last_error,omitempty"`  // Error of the last attempt, if failed
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Redacted returns the push mirror without the password of its URL, to be
+// shown to users
+func (m PushMirror) Redacted() PushMirror {
+	m.URL = RedactURL(m.URL)
+	return m
+}
+
+// Push replicates the branches and tags of the repository to downstream:
+// new references are created, moved ones are forced and the ones deleted
+// from the repository are deleted downstream.
+func Push(ctx context.Context, st storer.Storer, downstream string, auth transport.AuthMethod) error {
+	s, ok := st.(gitstorage.Storer)
+	if !ok {
+		return fmt.Errorf("storage does not support pushing")
+	}
+
+	remote := git.NewRemote(s, &gitconfig.RemoteConfig{Name: "downstream", URLs: []string{downstream}})
+	err := remote.PushContext(ctx, &git.PushOptions{
+		RemoteName: "downstream",
+		RefSpecs:   refSpecs,
+		Auth:       auth,
+		Prune:      true,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	return err
+}
+
+// PushStore holds the push mirrors by repository, persisted in a JSON file
+type PushStore struct {
+	mu      sync.RWMutex
+	path    string
+	mirrors map[string][]*PushMirror
+}
+
+// LoadPush reads the push mirrors file at path, a missing file is no push mirror
+func LoadPush(path string) (*PushStore, error) {
+	s := &PushStore{path: path, mirrors: make(map[string][]*PushMirror)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read push mirrors: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.mirrors); err != nil {
+		return nil, fmt.Errorf("failed to read push mirrors: %w", err)
+	}
+	return s, nil
+}
+
+// update applies change to a copy of the push mirrors by repository and
+// writes them, the store is only changed once the file is written. change
+// must replace the lists and push mirrors it modifies with copies. s.mu must
+// be held.
+func (s *PushStore) update(change func(mirrors map[string][]*PushMirror)) error {
+	mirrors := maps.Clone(s.mirrors)
+	change(mirrors)
+	if err := common.WriteJSONFile(s.path, mirrors); err != nil {
+		return fmt.Errorf("failed to write push mirrors: %w", err)
+	}
+	s.mirrors = mirrors
+	return nil
+}
+
+// newID returns a random identifier
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Mirrors returns the push mirrors of the repository
+func (s *PushStore) Mirrors(repoPath string) []PushMirror {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	mirrors := make([]PushMirror, 0, len(s.mirrors[repoPath]))
+	for _, m := range s.mirrors[repoPath] {
+		mirrors = append(mirrors, *m)
+	}
+	return mirrors
+}
+
+// Mirror returns a push mirror of the repository
+func (s *PushStore) Mirror(repoPath, id string) (PushMirror, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := s.find(repoPath, id)
+	if m == nil {
+		return PushMirror{}, ErrNotFound
+	}
+	return *m, nil
+}
+
+// find returns the push mirror of the repository with the identifier, nil if none
+func (s *PushStore) find(repoPath, id string) *PushMirror {
+	i := slices.IndexFunc(s.mirrors[repoPath], func(m *PushMirror) bool { return m.ID == id })
+	if i < 0 {
+		return nil
+	}
+	return s.mirrors[repoPath][i]
+}
+
+// Add registers downstream as a push mirror of the repository, with a new
+// identifier. A file downstream must be allowed and outside of the storage of
+// the repositories, see ValidateURL.
+func (s *PushStore) Add(repoPath, downstream string) (PushMirror, error) {
+	if err := ValidateURL(downstream); err != nil {
+		return PushMirror{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slices.ContainsFunc(s.mirrors[repoPath], func(m *PushMirror) bool { return m.URL == downstream }) {
+		return PushMirror{}, fmt.Errorf("%w: %s is already a push mirror", ErrInvalidMirror, RedactURL(downstream))
+	}
+	m := &PushMirror{
+		ID:        newID(),
+		URL:       downstream,
+		Status:    StatusPending,
+		CreatedAt: time.Now().UTC(),
+	}
+	return *m, s.update(func(mirrors map[string][]*PushMirror) {
+		mirrors[repoPath] = append(slices.Clip(mirrors[repoPath]), m)
+	})
+}
+
+// Remove removes a push mirror of the repository
+func (s *PushStore) Remove(repoPath, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.mirrors[repoPath], func(m *PushMirror) bool { return m.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	return s.update(func(mirrors map[string][]*PushMirror) {
+		if repoMirrors := slices.Delete(slices.Clone(mirrors[repoPath]), i, i+1); len(repoMirrors) == 0 {
+			delete(mirrors, repoPath)
+		} else {
+			mirrors[repoPath] = repoMirrors
+		}
+	})
+}
+
+// record saves the result of an attempt to replicate the repository to a push mirror
+func (s *PushStore) record(repoPath, id string, pushErr error) (PushMirror, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The push mirror may have been removed during the attempt
+	i := slices.IndexFunc(s.mirrors[repoPath], func(m *PushMirror) bool { return m.ID == id })
+	if i < 0 {
+		return PushMirror{}, ErrNotFound
+	}
+
+	m := *s.mirrors[repoPath][i]
+	now := time.Now().UTC()
+	m.LastPush = &now
+	if pushErr != nil {
+		m.Status, m.LastError = StatusFailed, pushErr.Error()
+		m.Failures++
+	} else {
+		m.Status, m.LastError, m.LastUpdate = StatusOK, "", &now
+		m.Failures = 0
+	}
+	return m, s.update(func(mirrors map[string][]*PushMirror) {
+		repoMirrors := slices.Clone(mirrors[repoPath])
+		repoMirrors[i] = &m
+		mirrors[repoPath] = repoMirrors
+	})
+}
+
+// RenameRepository moves the push mirrors of a renamed repository
+func (s *PushStore) RenameRepository(oldPath, newPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repoMirrors, ok := s.mirrors[oldPath]
+	if !ok {
+		return nil
+	}
+	return s.update(func(mirrors map[string][]*PushMirror) {
+		delete(mirrors, oldPath)
+		mirrors[newPath] = repoMirrors
+	})
+}
+
+// DeleteRepository removes the push mirrors of a deleted repository
+func (s *PushStore) DeleteRepository(repoPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.mirrors[repoPath]; !ok {
+		return nil
+	}
+	return s.update(func(mirrors map[string][]*PushMirror) {
+		delete(mirrors, repoPath)
+	})
+}
@@ -0,0 +1,200 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultPushAttempts is the number of attempts of a replication when Pusher.Attempts is zero
+	DefaultPushAttempts = 5
+	// DefaultPushBackoff is the delay before the first retry when Pusher.Backoff is zero
+	DefaultPushBackoff = 10 * time.Second
+)
+
+// Pusher is a post-receive hook replicating the pushes to the push mirrors
+// of the repository. The replications run in the background, one at a time
+// per push mirror: the pushes received meanwhile are queued and replicated
+// together once it ends. A failed attempt is retried with an exponential
+// backoff, and an alert is logged once the attempts are exhausted; the next
+// push retries again. Stop abandons the retries.
+type Pusher struct {
+	Store    *PushStore
+	Storage  storage.GitRepositoryStorage
+	Logger   zerolog.Logger
+	SSHKey   string        // Private key of the SSH downstreams, empty for the SSH agent
+	Timeout  time.Duration // Zero for DefaultTimeout
+	Attempts int           // Zero for DefaultPushAttempts
+	Backoff  time.Duration // Zero for DefaultPushBackoff, doubled after each retry
+
+	ctx    context.Context // Cancelled by Stop
+	stop   context.CancelFunc
+	mu     sync.Mutex
+	queued map[string]bool // Replicating push mirrors, true when a push is queued after the running replication
+	wg     sync.WaitGroup
+}
+
+// NewPusher returns a pusher to the push mirrors of store, of the repositories stored in str
+func NewPusher(store *PushStore, str storage.GitRepositoryStorage, logger zerolog.Logger) *Pusher {
+	ctx, stop := context.WithCancel(context.Background())
+	return &Pusher{
+		Store:   store,
+		Storage: str,
+		Logger:  logger.With().Str("component", "mirrors").Logger(),
+		ctx:     ctx,
+		stop:    stop,
+		queued:  make(map[string]bool),
+	}
+}
+
+// PostReceive queues the replication of the repository to its push mirrors
+func (p *Pusher) PostReceive(ctx context.Context, push *hooks.Push) error {
+	for _, m := range p.Store.Mirrors(push.Repository) {
+		p.enqueue(push.Repository, m.ID)
+	}
+	return nil
+}
+
+// Sync queues the replication of the repository to a push mirror
+func (p *Pusher) Sync(repoPath, id string) error {
+	if _, err := p.Store.Mirror(repoPath, id); err != nil {
+		return err
+	}
+	p.enqueue(repoPath, id)
+	return nil
+}
+
+// Stop abandons the retries and the queued pushes of the pending
+// replications, the running attempts are not interrupted
+func (p *Pusher) Stop() {
+	p.stop()
+}
+
+// Wait waits for the pending replications
+func (p *Pusher) Wait() {
+	p.wg.Wait()
+}
+
+// enqueue starts the replication to the push mirror, or queues it after the
+// running one
+func (p *Pusher) enqueue(repoPath, id string) {
+	key := repoPath + " " + id
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, running := p.queued[key]; running {
+		p.queued[key] = true
+		return
+	}
+	p.queued[key] = false
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for {
+			p.replicate(repoPath, id)
+
+			p.mu.Lock()
+			if !p.queued[key] || p.ctx.Err() != nil {
+				delete(p.queued, key)
+				p.mu.Unlock()
+				return
+			}
+			p.queued[key] = false
+			p.mu.Unlock()
+		}
+	}()
+}
+
+// replicate attempts the replication until it succeeds or the attempts are exhausted
+func (p *Pusher) replicate(repoPath, id string) {
+	attempts, backoff := p.Attempts, p.Backoff
+	if attempts == 0 {
+		attempts = DefaultPushAttempts
+	}
+	if backoff == 0 {
+		backoff = DefaultPushBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		m, err := p.Store.Mirror(repoPath, id)
+		if err != nil {
+			// Removed in the meantime
+			return
+		}
+		logger := p.Logger.With().
+			Str("repo", repoPath).
+			Str("mirror", m.ID).
+			Str("downstream", RedactURL(m.URL)).
+			Int("attempt", attempt).
+			Logger()
+
+		pushErr := p.push(repoPath, m.URL)
+		if _, err := p.Store.record(repoPath, id, pushErr); err != nil && !errors.Is(err, ErrNotFound) {
+			logger.Error().Err(err).Msg("Failed to save push mirror status")
+		}
+		if pushErr == nil {
+			logger.Debug().Msg("Push mirror updated")
+			return
+		}
+		if attempt >= attempts {
+			logger.Error().Err(pushErr).Msg("Push mirror failed, giving up until the next push")
+			return
+		}
+
+		logger.Warn().Err(pushErr).Dur("retry_in", backoff).Msg("Push mirror failed")
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-p.ctx.Done():
+			timer.Stop()
+			logger.Warn().Msg("Push mirror retries abandoned on shutdown")
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// push replicates the repository to downstream. The downstream is validated
+// again, the configuration or the filesystem may have changed since it was
+// added: a path resolving into the storage of the repositories would be
+// overwritten.
+func (p *Pusher) push(repoPath, downstream string) error {
+	if err := ValidateURL(downstream); err != nil {
+		return err
+	}
+
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	auth, err := Auth(downstream, p.SSHKey)
+	if err != nil {
+		return err
+	}
+	st, err := p.Storage.GetStorer(repoPath)
+	if err != nil {
+		return err
+	}
+	return Push(ctx, st, downstream, auth)
+}
+
+// RenameRepository moves the push mirrors of a renamed repository
+func (p *Pusher) RenameRepository(oldPath, newPath string) error {
+	return p.Store.RenameRepository(oldPath, newPath)
+}
+
+// DeleteRepository removes the push mirrors of a deleted repository
+func (p *Pusher) DeleteRepository(repoPath string) error {
+	return p.Store.DeleteRepository(repoPath)
+}
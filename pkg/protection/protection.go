@@ -0,0 +1,278 @@
+// Package protection implements the branch protection rules: the protected
+// branches of a repository can neither be force pushed nor deleted, except by
+// the users allowed to bypass the rule.
+package protection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/common"
+	"github.com/labbs/git-server-s3/pkg/hooks"
+)
+
+var (
+	// ErrInvalidRule is returned for a rule with an invalid pattern
+	ErrInvalidRule = errors.New("invalid rule")
+	// ErrNotFound is returned when removing a rule that does not exist
+	ErrNotFound = errors.New("not found")
+)
+
+// Rule protects the branches matching its pattern, a branch name such as
+// "main" or a glob such as "release/*", where "*" does not match "/".
+type Rule struct {
+	Pattern string   `json:"pattern"`
+	Bypass  []string `json:"bypass,omitempty"` // Users allowed to force push and delete the branches
+}
+
+// Matches reports whether the rule protects the branch
+func (r Rule) Matches(branch string) bool {
+	ok, _ := path.Match(r.Pattern, branch)
+	return ok
+}
+
+// normalizePattern validates a rule pattern and returns its canonical form,
+// without the "refs/heads/" prefix
+func normalizePattern(pattern string) (string, error) {
+	pattern = strings.TrimPrefix(strings.TrimSpace(pattern), "refs/heads/")
+	if pattern == "" {
+		return "", fmt.Errorf("%w: missing pattern", ErrInvalidRule)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return "", fmt.Errorf("%w: invalid pattern %q", ErrInvalidRule, pattern)
+	}
+	return pattern, nil
+}
+
+// Store holds the rules of the repositories, persisted in a JSON file. It is
+// the pre-receive hook enforcing them.
+type Store struct {
+	mu    sync.RWMutex
+	path  string
+	rules map[string][]Rule
+}
+
+// Load reads the rules file at path, a missing file is no rule
+func Load(path string) (*Store, error) {
+	s := &Store{path: path, rules: make(map[string][]Rule)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read branch protections: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.rules); err != nil {
+		return nil, fmt.Errorf("failed to read branch protections: %w", err)
+	}
+	return s, nil
+}
+
+// update applies change to a copy of the rules by repository and writes them,
+// the store is only changed once the file is written. change must replace the
+// rules of a repository instead of modifying them in place. s.mu must be held.
+func (s *Store) update(change func(rules map[string][]Rule)) error {
+	rules := maps.Clone(s.rules)
+	change(rules)
+	if err := common.WriteJSONFile(s.path, rules); err != nil {
+		return fmt.Errorf("failed to write branch protections: %w", err)
+	}
+	s.rules = rules
+	return nil
+}
+
+// Rules returns the rules of the repository
+func (s *Store) Rules(repoPath string) []Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rules := slices.Clone(s.rules[repoPath])
+	if rules == nil {
+		rules = []Rule{}
+	}
+	return rules
+}
+
+// SetRule adds a rule to the repository, replacing the rule of the same
+// pattern. The pattern is normalized.
+func (s *Store) SetRule(repoPath string, rule Rule) (Rule, error) {
+	pattern, err := normalizePattern(rule.Pattern)
+	if err != nil {
+		return rule, err
+	}
+	rule.Pattern = pattern
+	rule.Bypass = slices.Compact(slices.Sorted(slices.Values(rule.Bypass)))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return rule, s.update(func(rules map[string][]Rule) {
+		repoRules := slices.Clone(rules[repoPath])
+		i := slices.IndexFunc(repoRules, func(r Rule) bool { return r.Pattern == pattern })
+		if i >= 0 {
+			repoRules[i] = rule
+		} else {
+			repoRules = append(repoRules, rule)
+		}
+		rules[repoPath] = repoRules
+	})
+}
+
+// RemoveRule removes the rule of the pattern from the repository
+func (s *Store) RemoveRule(repoPath, pattern string) error {
+	pattern, err := normalizePattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := s.rules[repoPath]
+	i := slices.IndexFunc(rules, func(r Rule) bool { return r.Pattern == pattern })
+	if i < 0 {
+		return ErrNotFound
+	}
+	return s.update(func(rules map[string][]Rule) {
+		repoRules := slices.Delete(slices.Clone(rules[repoPath]), i, i+1)
+		if len(repoRules) == 0 {
+			delete(rules, repoPath)
+		} else {
+			rules[repoPath] = repoRules
+		}
+	})
+}
+
+// RenameRepository moves the rules of a renamed repository
+func (s *Store) RenameRepository(oldPath, newPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repoRules, ok := s.rules[oldPath]
+	if !ok {
+		return nil
+	}
+	return s.update(func(rules map[string][]Rule) {
+		delete(rules, oldPath)
+		rules[newPath] = repoRules
+	})
+}
+
+// DeleteRepository removes the rules of a deleted repository
+func (s *Store) DeleteRepository(repoPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.rules[repoPath]; !ok {
+		return nil
+	}
+	return s.update(func(rules map[string][]Rule) {
+		delete(rules, repoPath)
+	})
+}
+
+// PreReceive rejects the deletions and the non-fast-forward updates of the
+// protected branches, unless every rule protecting the branch lets the pusher
+// bypass it
+func (s *Store) PreReceive(ctx context.Context, push *hooks.Push) (hooks.Rejections, error) {
+	rules := s.Rules(push.Repository)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	rejected := hooks.Rejections{}
+	for _, update := range push.Updates {
+		if !update.Name.IsBranch() || update.IsCreate() {
+			continue
+		}
+		if !protects(rules, update.Name.Short(), push.Pusher) {
+			continue
+		}
+
+		if update.IsDelete() {
+			rejected[update.Name] = "protected branch cannot be deleted"
+			continue
+		}
+		ff, err := fastForward(push, update)
+		if err != nil {
+			return nil, err
+		}
+		if !ff {
+			rejected[update.Name] = "protected branch cannot be force pushed"
+		}
+	}
+	return rejected, nil
+}
+
+// protects reports whether a rule protects branch from pusher
+func protects(rules []Rule, branch string, pusher *auth.Identity) bool {
+	for _, rule := range rules {
+		if !rule.Matches(branch) {
+			continue
+		}
+		if pusher == nil || !slices.Contains(rule.Bypass, pusher.Username) {
+			return true
+		}
+	}
+	return false
+}
+
+// fastForward reports whether the new value of update descends from its old
+// value. The history is walked from the new value, loading at most
+// hooks.MaxCommitWalk commits: an old value further away is not considered an
+// ancestor, and the update is rejected as a force push.
+func fastForward(push *hooks.Push, update hooks.Update) (bool, error) {
+	old, err := object.GetCommit(push.Storer, update.Old)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	tip, err := object.GetCommit(push.Storer, update.New)
+	if err != nil {
+		// Not a commit, e.g. a branch pointing to a tag
+		return false, nil
+	}
+	if tip.Hash == old.Hash {
+		return true, nil
+	}
+
+	seen := map[plumbing.Hash]bool{tip.Hash: true}
+	queue := []*object.Commit{tip}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		for _, parent := range c.ParentHashes {
+			if parent == old.Hash {
+				return true, nil
+			}
+			if seen[parent] {
+				continue
+			}
+			if len(seen) == hooks.MaxCommitWalk {
+				return false, nil
+			}
+			seen[parent] = true
+
+			p, err := object.GetCommit(push.Storer, parent)
+			if err != nil {
+				return false, err
+			}
+			queue = append(queue, p)
+		}
+	}
+	return false, nil
+}
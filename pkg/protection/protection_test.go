@@ -0,0 +1,148 @@
+package protection
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "protections.json")
+	s, err := Load(path)
+	require.NoError(t, err)
+
+	_, err = s.SetRule("repo.git", Rule{Pattern: "release/["})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+
+	rule, err := s.SetRule("repo.git", Rule{Pattern: "refs/heads/main", Bypass: []string{"bob", "alice", "bob"}})
+	require.NoError(t, err)
+	assert.Equal(t, Rule{Pattern: "main", Bypass: []string{"alice", "bob"}}, rule)
+	_, err = s.SetRule("repo.git", Rule{Pattern: "main"})
+	require.NoError(t, err)
+
+	s, err = Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{{Pattern: "main"}}, s.Rules("repo.git"))
+
+	require.NoError(t, s.RemoveRule("repo.git", "main"))
+	assert.ErrorIs(t, s.RemoveRule("repo.git", "main"), ErrNotFound)
+	assert.Empty(t, s.Rules("repo.git"))
+}
+
+func TestPreReceive(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(message string) plumbing.Hash {
+		hash, err := wt.Commit(message, &git.CommitOptions{
+			AllowEmptyCommits: true,
+			Author:            &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		return hash
+	}
+	base := commit("base")
+	next := commit("next")
+
+	s, err := Load(filepath.Join(t.TempDir(), "protections.json"))
+	require.NoError(t, err)
+	_, err = s.SetRule("repo.git", Rule{Pattern: "main"})
+	require.NoError(t, err)
+	_, err = s.SetRule("repo.git", Rule{Pattern: "release/*", Bypass: []string{"alice"}})
+	require.NoError(t, err)
+
+	push := &hooks.Push{
+		Repository: "repo.git",
+		Pusher:     &auth.Identity{Username: "bob"},
+		Storer:     repo.Storer,
+		Updates: []hooks.Update{
+			{Name: "refs/heads/main", Old: base, New: next},            // Fast-forward
+			{Name: "refs/heads/release/1.0", Old: next, New: base},     // Force push
+			{Name: "refs/heads/release/2.0", Old: next},                // Deletion
+			{Name: "refs/heads/release/3.0", New: next},                // Creation
+			{Name: "refs/heads/feature", Old: next, New: base},         // Unprotected
+			{Name: "refs/heads/release/1.0/fix", Old: next, New: base}, // "*" does not match "/"
+		},
+	}
+
+	rejected, err := s.PreReceive(context.Background(), push)
+	require.NoError(t, err)
+	assert.Equal(t, hooks.Rejections{
+		"refs/heads/release/1.0": "protected branch cannot be force pushed",
+		"refs/heads/release/2.0": "protected branch cannot be deleted",
+	}, rejected)
+
+	// alice bypasses the release rule, not the main one
+	push.Pusher = &auth.Identity{Username: "alice"}
+	push.Updates[0] = hooks.Update{Name: "refs/heads/main", Old: next, New: base}
+	rejected, err = s.PreReceive(context.Background(), push)
+	require.NoError(t, err)
+	assert.Equal(t, hooks.Rejections{"refs/heads/main": "protected branch cannot be force pushed"}, rejected)
+}
+
+func TestPreReceive_BoundedWalk(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	var commits []plumbing.Hash
+	for i := 0; i <= hooks.MaxCommitWalk+1; i++ {
+		hash, err := wt.Commit("commit", &git.CommitOptions{
+			AllowEmptyCommits: true,
+			Author:            &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		commits = append(commits, hash)
+	}
+	tip := commits[len(commits)-1]
+
+	s, err := Load(filepath.Join(t.TempDir(), "protections.json"))
+	require.NoError(t, err)
+	_, err = s.SetRule("repo.git", Rule{Pattern: "main"})
+	require.NoError(t, err)
+
+	push := &hooks.Push{
+		Repository: "repo.git",
+		Storer:     repo.Storer,
+		Updates:    []hooks.Update{{Name: "refs/heads/main", Old: commits[len(commits)-10], New: tip}},
+	}
+	rejected, err := s.PreReceive(context.Background(), push)
+	require.NoError(t, err)
+	assert.Empty(t, rejected)
+
+	// The old value is further than the walk goes
+	push.Updates[0].Old = commits[0]
+	rejected, err = s.PreReceive(context.Background(), push)
+	require.NoError(t, err)
+	assert.Equal(t, hooks.Rejections{"refs/heads/main": "protected branch cannot be force pushed"}, rejected)
+}
+
+func TestStore_FailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "protections.json")
+	s, err := Load(path)
+	require.NoError(t, err)
+	_, err = s.SetRule("repo.git", Rule{Pattern: "main"})
+	require.NoError(t, err)
+
+	// The directory of the file is gone, nothing can be written anymore
+	require.NoError(t, os.RemoveAll(filepath.Dir(path)))
+
+	_, err = s.SetRule("repo.git", Rule{Pattern: "main", Bypass: []string{"alice"}})
+	assert.Error(t, err)
+	assert.Error(t, s.RemoveRule("repo.git", "main"))
+	assert.Equal(t, []Rule{{Pattern: "main"}}, s.Rules("repo.git"))
+}
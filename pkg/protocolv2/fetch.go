@@ -0,0 +1,224 @@
+package protocolv2
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// packWindow is the number of objects considered when looking for deltas,
+// the same default as go-git's upload-pack.
+const packWindow = 10
+
+// packDataSize is the largest chunk of pack data sent in a single packet,
+// leaving room for the sideband channel byte
+const packDataSize = pktline.MaxPayloadSize - 1
+
+// packDataWriter writes the pack data on sideband channel 1. go-git's
+// sideband.Muxer is not used as it produces packets larger than pkt-lines
+// allow when given large writes.
+type packDataWriter struct {
+	enc *pktline.Encoder
+}
+
+func (w *packDataWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n := min(len(p)-written, packDataSize)
+		if err := w.enc.Encode(sideband.PackData.WithPayload(p[written : written+n])); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// fetchArgs are the arguments of the fetch command
+type fetchArgs struct {
+	wants      []plumbing.Hash
+	haves      []plumbing.Hash
+	done       bool
+	ofsDelta   bool
+	includeTag bool
+}
+
+func parseFetchArgs(args []string) (fetchArgs, error) {
+	var parsed fetchArgs
+	for _, arg := range args {
+		name, value, _ := strings.Cut(arg, " ")
+		switch name {
+		case "want", "have":
+			if !plumbing.IsHash(value) {
+				return parsed, fmt.Errorf("invalid object name %q", value)
+			}
+			if name == "want" {
+				parsed.wants = append(parsed.wants, plumbing.NewHash(value))
+			} else {
+				parsed.haves = append(parsed.haves, plumbing.NewHash(value))
+			}
+		case "done":
+			parsed.done = true
+		case "ofs-delta":
+			parsed.ofsDelta = true
+		case "include-tag":
+			parsed.includeTag = true
+		}
+		// Other arguments (thin-pack, no-progress...) only tune the response
+		// and are safe to ignore; features that change its meaning, such as
+		// shallow or filter, are not advertised.
+	}
+	return parsed, nil
+}
+
+// fetch sends the packfile of the objects reachable from the wanted objects
+// and not from the common ones. As upload-pack does without
+// uploadpack.allowReachableSHA1InWant, only the tips of the references can be
+// wanted, not any object of the repository.
+//
+// The server always declares itself ready after the first round: being
+// stateless over HTTP, it sends a pack relative to the haves it knows of
+// rather than negotiating further.
+func fetch(ctx context.Context, st storer.Storer, args []string, w io.Writer) error {
+	parsed, err := parseFetchArgs(args)
+	if err != nil {
+		return writeError(w, err)
+	}
+	if len(parsed.wants) == 0 {
+		return writeError(w, fmt.Errorf("fetch request without want"))
+	}
+	tips, err := referenceTips(st)
+	if err != nil {
+		return writeError(w, fmt.Errorf("failed to list references: %w", err))
+	}
+	for _, want := range parsed.wants {
+		if !tips[want] {
+			return writeError(w, fmt.Errorf("upload-pack: not our ref %s", want))
+		}
+	}
+
+	var common []plumbing.Hash
+	for _, have := range parsed.haves {
+		if st.HasEncodedObject(have) == nil {
+			common = append(common, have)
+		}
+	}
+
+	enc := pktline.NewEncoder(w)
+	if !parsed.done {
+		if err := enc.EncodeString("acknowledgments\n"); err != nil {
+			return err
+		}
+		if len(common) == 0 {
+			if err := enc.EncodeString("NAK\n"); err != nil {
+				return err
+			}
+		}
+		for _, hash := range common {
+			if err := enc.Encodef("ACK %s\n", hash); err != nil {
+				return err
+			}
+		}
+		if err := enc.EncodeString("ready\n"); err != nil {
+			return err
+		}
+		if _, err := w.Write(delimiter); err != nil {
+			return err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	objects, err := revlist.Objects(st, parsed.wants, common)
+	if err != nil {
+		return writeError(w, fmt.Errorf("failed to list objects: %w", err))
+	}
+	if parsed.includeTag {
+		if objects, err = withTags(st, objects); err != nil {
+			return writeError(w, fmt.Errorf("failed to list tags: %w", err))
+		}
+	}
+
+	if err := enc.EncodeString("packfile\n"); err != nil {
+		return err
+	}
+
+	// The packfile encoder makes many small writes, buffering them gives
+	// full sideband packets
+	data := bufio.NewWriterSize(&packDataWriter{enc: enc}, packDataSize)
+	if _, err := packfile.NewEncoder(data, st, !parsed.ofsDelta).Encode(objects, packWindow); err != nil {
+		return err
+	}
+	if err := data.Flush(); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// referenceTips returns the objects the references of st point to, the ones
+// ls-refs advertises
+func referenceTips(st storer.Storer) (map[plumbing.Hash]bool, error) {
+	iter, err := st.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	tips := make(map[plumbing.Hash]bool)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			tips[ref.Hash()] = true
+		}
+		return nil
+	})
+	return tips, err
+}
+
+// withTags adds to objects the annotated tags pointing to one of them
+func withTags(st storer.Storer, objects []plumbing.Hash) ([]plumbing.Hash, error) {
+	included := make(map[plumbing.Hash]bool, len(objects))
+	for _, hash := range objects {
+		included[hash] = true
+	}
+
+	iter, err := st.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if !ref.Name().IsTag() || ref.Type() != plumbing.HashReference || included[ref.Hash()] {
+			return nil
+		}
+		tag, err := object.GetTag(st, ref.Hash())
+		if err != nil {
+			// Lightweight tag
+			return nil
+		}
+		target, err := peel(st, tag)
+		if err != nil || !included[target] {
+			return nil
+		}
+
+		for {
+			included[tag.Hash] = true
+			objects = append(objects, tag.Hash)
+			if tag.TargetType != plumbing.TagObject || included[tag.Target] {
+				return nil
+			}
+			if tag, err = object.GetTag(st, tag.Target); err != nil {
+				return err
+			}
+		}
+	})
+	return objects, err
+}
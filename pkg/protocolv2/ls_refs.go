@@ -0,0 +1,141 @@
+package protocolv2
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// lsRefsArgs are the arguments of the ls-refs command
+type lsRefsArgs struct {
+	symrefs  bool
+	peel     bool
+	unborn   bool
+	prefixes []string
+}
+
+func parseLsRefsArgs(args []string) lsRefsArgs {
+	var parsed lsRefsArgs
+	for _, arg := range args {
+		switch {
+		case arg == "symrefs":
+			parsed.symrefs = true
+		case arg == "peel":
+			parsed.peel = true
+		case arg == "unborn":
+			parsed.unborn = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			parsed.prefixes = append(parsed.prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		}
+	}
+	return parsed
+}
+
+// matches reports whether name starts with one of the requested prefixes.
+// Without ref-prefix arguments every reference matches.
+func (a lsRefsArgs) matches(name plumbing.ReferenceName) bool {
+	if len(a.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range a.prefixes {
+		if strings.HasPrefix(name.String(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lsRefs writes the references matching the ref-prefix arguments, HEAD first
+// and the others sorted by name.
+func lsRefs(st storer.Storer, args []string, w io.Writer) error {
+	parsed := parseLsRefsArgs(args)
+
+	iter, err := st.IterReferences()
+	if err != nil {
+		return writeError(w, fmt.Errorf("failed to list references: %w", err))
+	}
+
+	var refs []*plumbing.Reference
+	var head *plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() == plumbing.HEAD {
+			head = ref
+		} else if parsed.matches(ref.Name()) {
+			refs = append(refs, ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return writeError(w, fmt.Errorf("failed to list references: %w", err))
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name() < refs[j].Name()
+	})
+	if head != nil && parsed.matches(plumbing.HEAD) {
+		refs = append([]*plumbing.Reference{head}, refs...)
+	}
+
+	enc := pktline.NewEncoder(w)
+	for _, ref := range refs {
+		line, ok := refLine(st, ref, parsed)
+		if !ok {
+			continue
+		}
+		if err := enc.EncodeString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return enc.Flush()
+}
+
+// refLine formats a reference for the ls-refs response. It returns false for
+// references that cannot be resolved and must be omitted.
+func refLine(st storer.Storer, ref *plumbing.Reference, args lsRefsArgs) (string, bool) {
+	var target plumbing.ReferenceName
+	if ref.Type() == plumbing.SymbolicReference {
+		target = ref.Target()
+	}
+
+	resolved, err := storer.ResolveReference(st, ref.Name())
+	var line string
+	switch {
+	case err == nil:
+		line = fmt.Sprintf("%s %s", resolved.Hash(), ref.Name())
+	case ref.Type() == plumbing.SymbolicReference && args.unborn:
+		// HEAD of an empty repository, pointing to a branch not created yet
+		line = fmt.Sprintf("unborn %s", ref.Name())
+	default:
+		return "", false
+	}
+
+	if args.symrefs && target != "" {
+		line += fmt.Sprintf(" symref-target:%s", target)
+	}
+	if args.peel && err == nil {
+		if tag, err := object.GetTag(st, resolved.Hash()); err == nil {
+			if peeled, err := peel(st, tag); err == nil {
+				line += fmt.Sprintf(" peeled:%s", peeled)
+			}
+		}
+	}
+	return line, true
+}
+
+// peel follows a chain of annotated tags down to the object it points to
+func peel(st storer.EncodedObjectStorer, tag *object.Tag) (plumbing.Hash, error) {
+	for tag.TargetType == plumbing.TagObject {
+		next, err := object.GetTag(st, tag.Target)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		tag = next
+	}
+	return tag.Target, nil
+}
@@ -0,0 +1,110 @@
+// Package protocolv2 implements the server side of the upload-pack service in
+// Git protocol version 2: the capability advertisement and the ls-refs and
+// fetch commands.
+//
+// Protocol v2 is negotiated by the client through the Git-Protocol HTTP header
+// or the GIT_PROTOCOL environment variable on SSH. Unlike v0, the server does
+// not advertise every reference up front: the client lists only the references
+// it needs with ls-refs and a ref-prefix filter.
+//
+// See https://git-scm.com/docs/protocol-v2
+package protocolv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/tracing"
+)
+
+// ErrInvalidRequest is returned by ServeCommand when the command request
+// cannot be read. Nothing has been written to the client in that case.
+var ErrInvalidRequest = errors.New("invalid protocol v2 request")
+
+// IsRequested reports whether a Git-Protocol header or GIT_PROTOCOL value,
+// a colon separated list of key=value parameters, requests protocol v2.
+func IsRequested(gitProtocol string) bool {
+	for _, param := range strings.Split(gitProtocol, ":") {
+		if strings.TrimSpace(param) == "version=2" {
+			return true
+		}
+	}
+	return false
+}
+
+// AdvertiseCapabilities writes the capability advertisement that starts a
+// protocol v2 conversation.
+func AdvertiseCapabilities(w io.Writer) error {
+	enc := pktline.NewEncoder(w)
+	if err := enc.EncodeString(
+		"version 2\n",
+		fmt.Sprintf("agent=git-server-s3/%s\n", config.Version),
+		"ls-refs=unborn\n",
+		"fetch\n",
+		"object-format=sha1\n",
+	); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// Serve advertises the capabilities, then serves commands read from r until
+// the client ends the session. It is used by stateful transports such as SSH.
+func Serve(ctx context.Context, st storer.Storer, r io.Reader, w io.Writer) error {
+	if err := AdvertiseCapabilities(w); err != nil {
+		return err
+	}
+
+	for {
+		err := ServeCommand(ctx, st, r, w)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ServeCommand reads a single command request from r and writes its response
+// to w. It returns io.EOF when the client ends the session, with a flush
+// packet or by closing its side. Stateless transports such as HTTP serve one
+// command per request.
+//
+// Errors of the command itself are also reported to the client with an ERR
+// packet.
+func ServeCommand(ctx context.Context, st storer.Storer, r io.Reader, w io.Writer) error {
+	req, err := readRequest(r)
+	if errors.Is(err, io.EOF) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	ctx, span := tracing.Start(ctx, "protocolv2."+req.command)
+	switch req.command {
+	case "ls-refs":
+		err = lsRefs(st, req.args, w)
+	case "fetch":
+		err = fetch(ctx, st, req.args, w)
+	default:
+		err = writeError(w, fmt.Errorf("unknown command %q", req.command))
+	}
+	tracing.End(span, err)
+	return err
+}
+
+// writeError sends an error to the client as an ERR packet and returns it.
+func writeError(w io.Writer, err error) error {
+	if encErr := pktline.NewEncoder(w).Encodef("ERR %s\n", err); encErr != nil {
+		return encErr
+	}
+	return err
+}
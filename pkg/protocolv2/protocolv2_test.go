@@ -0,0 +1,294 @@
+package protocolv2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRepository returns a repository with two commits on master, a
+// feature branch and an annotated tag v1.0 on the first commit.
+func newTestRepository(t *testing.T) (*memory.Storage, []plumbing.Hash) {
+	t.Helper()
+
+	st := memory.NewStorage()
+	repo, err := git.Init(st, memfs.New())
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	signature := &object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0)}
+	var commits []plumbing.Hash
+	for i, content := range []string{"first", "second"} {
+		f, err := wt.Filesystem.Create("file.txt")
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		_, err = wt.Add("file.txt")
+		require.NoError(t, err)
+
+		commit, err := wt.Commit(content, &git.CommitOptions{Author: signature})
+		require.NoError(t, err)
+		commits = append(commits, commit)
+
+		if i == 0 {
+			_, err = repo.CreateTag("v1.0", commit, &git.CreateTagOptions{Tagger: signature, Message: "v1.0"})
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, st.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("feature"), commits[0])))
+	return st, commits
+}
+
+// commandRequest encodes a command request with the given arguments
+func commandRequest(t *testing.T, command string, args ...string) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	enc := pktline.NewEncoder(&buf)
+	require.NoError(t, enc.EncodeString("command="+command+"\n", "agent=git/2.45.0\n"))
+	buf.Write(delimiter)
+	for _, arg := range args {
+		require.NoError(t, enc.EncodeString(arg+"\n"))
+	}
+	require.NoError(t, enc.Flush())
+	return &buf
+}
+
+// readLines reads the data packets of r up to the next special packet
+func readLines(t *testing.T, r io.Reader) ([]string, packetType) {
+	t.Helper()
+
+	var lines []string
+	for {
+		typ, line, err := readPacket(r)
+		require.NoError(t, err)
+		if typ != dataPacket {
+			return lines, typ
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestIsRequested(t *testing.T) {
+	assert.True(t, IsRequested("version=2"))
+	assert.True(t, IsRequested("object-format=sha1:version=2"))
+	assert.False(t, IsRequested(""))
+	assert.False(t, IsRequested("version=1"))
+	assert.False(t, IsRequested("version=20"))
+}
+
+func TestAdvertiseCapabilities(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, AdvertiseCapabilities(&buf))
+
+	lines, typ := readLines(t, &buf)
+	assert.Equal(t, flushPacket, typ)
+	require.NotEmpty(t, lines)
+	assert.Equal(t, "version 2", lines[0])
+	assert.Contains(t, lines, "ls-refs=unborn")
+	assert.Contains(t, lines, "fetch")
+}
+
+func TestLsRefsPrefix(t *testing.T) {
+	st, commits := newTestRepository(t)
+
+	var out bytes.Buffer
+	req := commandRequest(t, "ls-refs", "symrefs", "ref-prefix HEAD", "ref-prefix refs/heads/")
+	require.NoError(t, ServeCommand(context.Background(), st, req, &out))
+
+	lines, typ := readLines(t, &out)
+	assert.Equal(t, flushPacket, typ)
+	assert.Equal(t, []string{
+		commits[1].String() + " HEAD symref-target:refs/heads/master",
+		commits[0].String() + " refs/heads/feature",
+		commits[1].String() + " refs/heads/master",
+	}, lines)
+}
+
+func TestLsRefsPeel(t *testing.T) {
+	st, commits := newTestRepository(t)
+
+	var out bytes.Buffer
+	req := commandRequest(t, "ls-refs", "peel", "ref-prefix refs/tags/")
+	require.NoError(t, ServeCommand(context.Background(), st, req, &out))
+
+	lines, _ := readLines(t, &out)
+	require.Len(t, lines, 1)
+	assert.True(t, strings.HasSuffix(lines[0], " refs/tags/v1.0 peeled:"+commits[0].String()), lines[0])
+}
+
+func TestLsRefsUnborn(t *testing.T) {
+	st := memory.NewStorage()
+	require.NoError(t, st.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main"))))
+
+	var out bytes.Buffer
+	require.NoError(t, ServeCommand(context.Background(), st, commandRequest(t, "ls-refs", "symrefs", "unborn"), &out))
+	lines, _ := readLines(t, &out)
+	assert.Equal(t, []string{"unborn HEAD symref-target:refs/heads/main"}, lines)
+
+	// Without the unborn argument, the unresolvable HEAD is omitted
+	out.Reset()
+	require.NoError(t, ServeCommand(context.Background(), st, commandRequest(t, "ls-refs"), &out))
+	lines, _ = readLines(t, &out)
+	assert.Empty(t, lines)
+}
+
+// readPackfile reads the packfile section of a fetch response into a new storage
+func readPackfile(t *testing.T, r io.Reader) *memory.Storage {
+	t.Helper()
+
+	typ, line, err := readPacket(r)
+	require.NoError(t, err)
+	require.Equal(t, dataPacket, typ)
+	require.Equal(t, "packfile", line)
+
+	// The pack data is sent on sideband channel 1, binary payloads are read
+	// with pktline.Scanner as readPacket trims the trailing newline
+	var pack bytes.Buffer
+	scanner := pktline.NewScanner(r)
+	for scanner.Scan() {
+		payload := scanner.Bytes()
+		if len(payload) == 0 {
+			break
+		}
+		require.Equal(t, byte(1), payload[0])
+		pack.Write(payload[1:])
+	}
+	require.NoError(t, scanner.Err())
+
+	st := memory.NewStorage()
+	require.NoError(t, packfile.UpdateObjectStorage(st, &pack))
+	return st
+}
+
+func TestFetch(t *testing.T) {
+	st, commits := newTestRepository(t)
+
+	var out bytes.Buffer
+	req := commandRequest(t, "fetch", "ofs-delta", "want "+commits[1].String(), "done")
+	require.NoError(t, ServeCommand(context.Background(), st, req, &out))
+
+	fetched := readPackfile(t, &out)
+	for _, commit := range commits {
+		_, err := object.GetCommit(fetched, commit)
+		assert.NoError(t, err)
+	}
+}
+
+func TestFetchNegotiation(t *testing.T) {
+	st, commits := newTestRepository(t)
+
+	unknown := plumbing.NewHash("1111111111111111111111111111111111111111")
+	var out bytes.Buffer
+	req := commandRequest(t, "fetch",
+		"want "+commits[1].String(),
+		"have "+commits[0].String(),
+		"have "+unknown.String(),
+	)
+	require.NoError(t, ServeCommand(context.Background(), st, req, &out))
+
+	lines, typ := readLines(t, &out)
+	assert.Equal(t, []string{"acknowledgments", "ACK " + commits[0].String(), "ready"}, lines)
+	require.Equal(t, delimPacket, typ)
+
+	// Only the objects of the second commit are sent
+	fetched := readPackfile(t, &out)
+	_, err := object.GetCommit(fetched, commits[1])
+	assert.NoError(t, err)
+	_, err = fetched.EncodedObject(plumbing.CommitObject, commits[0])
+	assert.ErrorIs(t, err, plumbing.ErrObjectNotFound)
+}
+
+func TestFetchIncludeTag(t *testing.T) {
+	st, commits := newTestRepository(t)
+	tagRef, err := st.Reference(plumbing.NewTagReferenceName("v1.0"))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	req := commandRequest(t, "fetch", "want "+commits[0].String(), "include-tag", "done")
+	require.NoError(t, ServeCommand(context.Background(), st, req, &out))
+
+	fetched := readPackfile(t, &out)
+	_, err = object.GetTag(fetched, tagRef.Hash())
+	assert.NoError(t, err)
+}
+
+func TestFetchUnknownWant(t *testing.T) {
+	st, _ := newTestRepository(t)
+
+	var out bytes.Buffer
+	req := commandRequest(t, "fetch", "want 1111111111111111111111111111111111111111", "done")
+	err := ServeCommand(context.Background(), st, req, &out)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidRequest)
+
+	_, line, err := readPacket(&out)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "ERR upload-pack: not our ref"), line)
+}
+
+func TestFetchUnadvertisedWant(t *testing.T) {
+	st, _ := newTestRepository(t)
+
+	// The tree of the first commit exists but is no reference tip
+	feature, err := st.Reference(plumbing.NewBranchReferenceName("feature"))
+	require.NoError(t, err)
+	commit, err := object.GetCommit(st, feature.Hash())
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	req := commandRequest(t, "fetch", "want "+commit.TreeHash.String(), "done")
+	require.Error(t, ServeCommand(context.Background(), st, req, &out))
+
+	_, line, err := readPacket(&out)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "ERR upload-pack: not our ref"), line)
+}
+
+func TestServeCommandInvalidRequest(t *testing.T) {
+	st, _ := newTestRepository(t)
+
+	var req bytes.Buffer
+	require.NoError(t, pktline.NewEncoder(&req).EncodeString("want 1111111111111111111111111111111111111111\n"))
+	err := ServeCommand(context.Background(), st, &req, io.Discard)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	// A flush packet alone ends the session
+	req.Reset()
+	require.NoError(t, pktline.NewEncoder(&req).Flush())
+	assert.ErrorIs(t, ServeCommand(context.Background(), st, &req, io.Discard), io.EOF)
+}
+
+func TestServe(t *testing.T) {
+	st, commits := newTestRepository(t)
+
+	in := commandRequest(t, "ls-refs", "ref-prefix refs/heads/master")
+	in.Write(commandRequest(t, "ls-refs", "ref-prefix refs/heads/feature").Bytes())
+	require.NoError(t, pktline.NewEncoder(in).Flush())
+
+	var out bytes.Buffer
+	require.NoError(t, Serve(context.Background(), st, in, &out))
+
+	lines, _ := readLines(t, &out)
+	assert.Equal(t, "version 2", lines[0])
+	lines, _ = readLines(t, &out)
+	assert.Equal(t, []string{commits[1].String() + " refs/heads/master"}, lines)
+	lines, _ = readLines(t, &out)
+	assert.Equal(t, []string{commits[0].String() + " refs/heads/feature"}, lines)
+}
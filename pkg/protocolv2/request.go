@@ -0,0 +1,103 @@
+package protocolv2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// packetType distinguishes data packets from the special packets of protocol v2.
+type packetType int
+
+const (
+	dataPacket packetType = iota
+	flushPacket
+	delimPacket
+	responseEndPacket
+)
+
+// delimiter is the delimiter packet, separating the capabilities of a
+// command request from its arguments and the sections of a response.
+var delimiter = []byte("0001")
+
+// request is a command request: the command, its capabilities and its arguments.
+type request struct {
+	command      string
+	capabilities []string
+	args         []string
+}
+
+// readPacket reads a single pkt-line and returns its type and payload.
+// go-git's pktline.Scanner cannot be used as it rejects delimiter packets.
+func readPacket(r io.Reader) (packetType, string, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return 0, "", err
+	}
+
+	n, err := strconv.ParseUint(string(length[:]), 16, 16)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid pkt-line length %q", length[:])
+	}
+
+	switch n {
+	case 0:
+		return flushPacket, "", nil
+	case 1:
+		return delimPacket, "", nil
+	case 2:
+		return responseEndPacket, "", nil
+	case 3:
+		return 0, "", fmt.Errorf("invalid pkt-line length %q", length[:])
+	}
+
+	payload := make([]byte, n-4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, "", err
+	}
+	return dataPacket, strings.TrimSuffix(string(payload), "\n"), nil
+}
+
+// readRequest reads a command request. It returns io.EOF when the client sent
+// a flush packet instead of a command, or closed the connection.
+func readRequest(r io.Reader) (*request, error) {
+	typ, line, err := readPacket(r)
+	if errors.Is(err, io.EOF) || (err == nil && typ == flushPacket) {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	command, ok := strings.CutPrefix(line, "command=")
+	if typ != dataPacket || !ok {
+		return nil, fmt.Errorf("expected command, got %q", line)
+	}
+
+	req := &request{command: command}
+	inArgs := false
+	for {
+		typ, line, err := readPacket(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		switch {
+		case typ == flushPacket:
+			return req, nil
+		case typ == delimPacket && !inArgs:
+			inArgs = true
+		case typ != dataPacket:
+			return nil, fmt.Errorf("unexpected packet in %s request", command)
+		case inArgs:
+			req.args = append(req.args, line)
+		default:
+			req.capabilities = append(req.capabilities, line)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+// Package rbac implements the per-repository access control: users and teams
+// are granted a role on a repository or on every repository of a namespace.
+package rbac
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/labbs/git-server-s3/pkg/common"
+)
+
+// Role is the level of access to a repository, each role includes the
+// permissions of the previous ones.
+type Role int
+
+const (
+	None  Role = iota
+	Read       // Clone and fetch
+	Write      // Push
+	Admin      // Create and delete repositories, manage grants
+)
+
+var roleNames = []string{"none", "read", "write", "admin"}
+
+func (r Role) String() string {
+	if r < None || r > Admin {
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+// ParseRole returns the role named s
+func ParseRole(s string) (Role, error) {
+	i := slices.Index(roleNames, strings.ToLower(s))
+	if i <= int(None) {
+		return None, fmt.Errorf("invalid role %q, expected read, write or admin", s)
+	}
+	return Role(i), nil
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	role, err := ParseRole(string(text))
+	if err != nil {
+		return err
+	}
+	*r = role
+	return nil
+}
+
+// Grant gives a role to a user or a team on a resource, which is either:
+//   - a repository path, e.g. "team/project.git"
+//   - a namespace, e.g. "team/*", for every repository below it
+//   - "*" for every repository
+type Grant struct {
+	User     string `json:"user,omitempty"`
+	Team     string `json:"team,omitempty"`
+	Resource string `json:"resource"`
+	Role     Role   `json:"role"`
+}
+
+// sameSubject reports whether g and other are the grants of the same subject
+// on the same resource
+func (g Grant) sameSubject(other Grant) bool {
+	return g.User == other.User && g.Team == other.Team && g.Resource == other.Resource
+}
+
+var (
+	// ErrInvalidGrant is returned for a grant without exactly one subject or
+	// with an invalid resource
+	ErrInvalidGrant = errors.New("invalid grant")
+	// ErrNotFound is returned when removing a grant or team that does not exist
+	ErrNotFound = errors.New("not found")
+)
+
+// NormalizeResource validates a grant resource and returns its canonical form
+func NormalizeResource(resource string) (string, error) {
+	resource = strings.Trim(strings.TrimSpace(resource), "/")
+	if resource == "" {
+		return "", fmt.Errorf("%w: missing resource", ErrInvalidGrant)
+	}
+	if resource == "*" {
+		return resource, nil
+	}
+	if namespace, ok := strings.CutSuffix(resource, "/*"); ok {
+		if !common.ValidRepoPath(namespace) {
+			return "", fmt.Errorf("%w: invalid namespace %q", ErrInvalidGrant, namespace)
+		}
+		return resource, nil
+	}
+
+	repoPath := common.NormalizeRepoPath(resource)
+	if !common.ValidRepoPath(repoPath) {
+		return "", fmt.Errorf("%w: invalid resource %q", ErrInvalidGrant, resource)
+	}
+	return repoPath, nil
+}
+
+// covers reports whether a grant on resource applies to target, a
+// repository path or another resource
+func covers(resource, target string) bool {
+	if resource == "*" || resource == target {
+		return true
+	}
+	namespace, ok := strings.CutSuffix(resource, "*")
+	return ok && strings.HasPrefix(target, namespace)
+}
+
+// policy is the persisted content of a Store
+type policy struct {
+	Grants []Grant             `json:"grants"`
+	Teams  map[string][]string `json:"teams"`
+}
+
+// Store holds the grants and teams, persisted in a JSON file, and answers
+// access checks. Site admins, from the configuration, are admin of every
+// repository.
+type Store struct {
+	// Public reports whether a repository is public, readable by every
+	// authenticated user. Nil when every repository is private.
+	Public func(repoPath string) bool
+
+	mu     sync.RWMutex
+	path   string
+	admins []string
+	policy policy
+}
+
+// Load reads the policy file at path, a missing file is an empty policy
+func Load(path string, admins []string) (*Store, error) {
+	s := &Store{path: path, admins: admins, policy: policy{Teams: make(map[string][]string)}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read grants: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.policy); err != nil {
+		return nil, fmt.Errorf("failed to read grants: %w", err)
+	}
+	if s.policy.Teams == nil {
+		s.policy.Teams = make(map[string][]string)
+	}
+	return s, nil
+}
+
+// clone returns a copy of the policy that can be changed without affecting p
+func (p policy) clone() policy {
+	teams := make(map[string][]string, len(p.Teams))
+	for name, members := range p.Teams {
+		teams[name] = slices.Clone(members)
+	}
+	return policy{Grants: slices.Clone(p.Grants), Teams: teams}
+}
+
+// update applies change to a copy of the policy and writes it, the policy is
+// only replaced once the file is written. s.mu must be held.
+func (s *Store) update(change func(p *policy)) error {
+	p := s.policy.clone()
+	change(&p)
+	if err := common.WriteJSONFile(s.path, p); err != nil {
+		return fmt.Errorf("failed to write grants: %w", err)
+	}
+	s.policy = p
+	return nil
+}
+
+// IsSiteAdmin reports whether identity is a site admin
+func (s *Store) IsSiteAdmin(identity *auth.Identity) bool {
+	return identity != nil && slices.Contains(s.admins, identity.Username)
+}
+
+// Role returns the role of identity on target, a repository path or a grant
+// resource: the highest role granted to the user or one of its teams on a
+// resource covering target, and at least read on a public repository.
+func (s *Store) Role(identity *auth.Identity, target string) Role {
+	if identity == nil {
+		return None
+	}
+	if s.IsSiteAdmin(identity) {
+		return Admin
+	}
+
+	role := s.grantedRole(identity, target)
+	if role < Read && s.Public != nil && s.Public(target) {
+		role = Read
+	}
+	return role
+}
+
+// grantedRole returns the highest role granted to identity on a resource
+// covering target
+func (s *Store) grantedRole(identity *auth.Identity, target string) Role {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	role := None
+	for _, grant := range s.policy.Grants {
+		if grant.Role <= role || !covers(grant.Resource, target) {
+			continue
+		}
+		if grant.User == identity.Username ||
+			(grant.Team != "" && slices.Contains(s.policy.Teams[grant.Team], identity.Username)) {
+			role = grant.Role
+		}
+	}
+	return role
+}
+
+// Can reports whether identity has at least role on repoPath
+func (s *Store) Can(identity *auth.Identity, repoPath string, role Role) bool {
+	return s.Role(identity, common.NormalizeRepoPath(repoPath)) >= role
+}
+
+// Grants returns every grant
+func (s *Store) Grants() []Grant {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.policy.Grants)
+}
+
+// SetGrant adds a grant, replacing the role of an existing grant of the same
+// subject on the same resource. The resource is normalized.
+func (s *Store) SetGrant(grant Grant) (Grant, error) {
+	if (grant.User == "") == (grant.Team == "") {
+		return grant, fmt.Errorf("%w: exactly one of user and team is required", ErrInvalidGrant)
+	}
+	if grant.Role <= None || grant.Role > Admin {
+		return grant, fmt.Errorf("%w: invalid role", ErrInvalidGrant)
+	}
+	resource, err := NormalizeResource(grant.Resource)
+	if err != nil {
+		return grant, err
+	}
+	grant.Resource = resource
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return grant, s.update(func(p *policy) {
+		i := slices.IndexFunc(p.Grants, grant.sameSubject)
+		if i >= 0 {
+			p.Grants[i] = grant
+		} else {
+			p.Grants = append(p.Grants, grant)
+		}
+	})
+}
+
+// RemoveGrant removes the grant of the subject of grant on its resource
+func (s *Store) RemoveGrant(grant Grant) error {
+	resource, err := NormalizeResource(grant.Resource)
+	if err != nil {
+		return err
+	}
+	grant.Resource = resource
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.policy.Grants, grant.sameSubject)
+	if i < 0 {
+		return ErrNotFound
+	}
+	return s.update(func(p *policy) {
+		p.Grants = slices.Delete(p.Grants, i, i+1)
+	})
+}
+
+// Teams returns the members of each team
+func (s *Store) Teams() map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	teams := make(map[string][]string, len(s.policy.Teams))
+	for name, members := range s.policy.Teams {
+		teams[name] = slices.Clone(members)
+	}
+	return teams
+}
+
+// SetTeam creates or replaces a team
+func (s *Store) SetTeam(name string, members []string) error {
+	if name == "" {
+		return fmt.Errorf("%w: missing team name", ErrInvalidGrant)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.update(func(p *policy) {
+		p.Teams[name] = slices.Compact(slices.Sorted(slices.Values(members)))
+	})
+}
+
+// DeleteTeam removes a team and its grants
+func (s *Store) DeleteTeam(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.policy.Teams[name]; !ok {
+		return ErrNotFound
+	}
+	return s.update(func(p *policy) {
+		delete(p.Teams, name)
+		p.Grants = slices.DeleteFunc(p.Grants, func(g Grant) bool {
+			return g.Team == name
+		})
+	})
+}
+
+// RenameRepository moves the grants on a renamed repository, grants on
+// namespaces are left unchanged. A subject already granted a role on the new
+// path keeps that role.
+func (s *Store) RenameRepository(oldPath, newPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var grants []Grant
+	renamed := false
+	for _, grant := range s.policy.Grants {
+		if grant.Resource == oldPath {
+			grant.Resource = newPath
+			renamed = true
+			if slices.ContainsFunc(s.policy.Grants, grant.sameSubject) {
+				continue
+			}
+		}
+		grants = append(grants, grant)
+	}
+	if !renamed {
+		return nil
+	}
+	return s.update(func(p *policy) {
+		p.Grants = grants
+	})
+}
+
+// DeleteRepository removes the grants on a deleted repository
+func (s *Store) DeleteRepository(repoPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	onRepository := func(g Grant) bool {
+		return g.Resource == repoPath
+	}
+	if !slices.ContainsFunc(s.policy.Grants, onRepository) {
+		return nil
+	}
+	return s.update(func(p *policy) {
+		p.Grants = slices.DeleteFunc(p.Grants, onRepository)
+	})
+}
@@ -0,0 +1,196 @@
+package rbac
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labbs/git-server-s3/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T, admins ...string) (*Store, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "grants.json")
+	store, err := Load(path, admins)
+	require.NoError(t, err)
+	return store, path
+}
+
+func TestParseRole(t *testing.T) {
+	role, err := ParseRole("Write")
+	require.NoError(t, err)
+	assert.Equal(t, Write, role)
+
+	_, err = ParseRole("none")
+	assert.Error(t, err)
+	_, err = ParseRole("owner")
+	assert.Error(t, err)
+
+	var grant Grant
+	require.NoError(t, json.Unmarshal([]byte(`{"user":"alice","resource":"team/*","role":"admin"}`), &grant))
+	assert.Equal(t, Admin, grant.Role)
+	assert.Error(t, json.Unmarshal([]byte(`{"user":"alice","resource":"team/*","role":"owner"}`), &grant))
+}
+
+func TestNormalizeResource(t *testing.T) {
+	for resource, expected := range map[string]string{
+		"*":                "*",
+		"team/*":           "team/*",
+		"/team/sub/*":      "team/sub/*",
+		"team/project":     "team/project.git",
+		"team/project.git": "team/project.git",
+	} {
+		normalized, err := NormalizeResource(resource)
+		require.NoError(t, err, resource)
+		assert.Equal(t, expected, normalized, resource)
+	}
+
+	for _, resource := range []string{"", "../etc/*", "team/../x"} {
+		_, err := NormalizeResource(resource)
+		assert.ErrorIs(t, err, ErrInvalidGrant, resource)
+	}
+}
+
+func TestRole(t *testing.T) {
+	store, _ := newTestStore(t, "root")
+	alice := &auth.Identity{Username: "alice"}
+	bob := &auth.Identity{Username: "bob"}
+
+	_, err := store.SetGrant(Grant{User: "alice", Resource: "team/*", Role: Read})
+	require.NoError(t, err)
+	_, err = store.SetGrant(Grant{User: "alice", Resource: "team/project", Role: Write})
+	require.NoError(t, err)
+
+	assert.Equal(t, Write, store.Role(alice, "team/project.git"))
+	assert.Equal(t, Read, store.Role(alice, "team/other.git"))
+	assert.Equal(t, Read, store.Role(alice, "team/sub/other.git"))
+	assert.Equal(t, None, store.Role(alice, "teams/other.git"))
+	assert.Equal(t, None, store.Role(bob, "team/project.git"))
+	assert.Equal(t, None, store.Role(nil, "team/project.git"))
+
+	assert.True(t, store.Can(alice, "team/project", Write))
+	assert.False(t, store.Can(alice, "team/project", Admin))
+
+	// Site admins are admin of every repository
+	assert.Equal(t, Admin, store.Role(&auth.Identity{Username: "root"}, "any/repo.git"))
+	assert.True(t, store.IsSiteAdmin(&auth.Identity{Username: "root"}))
+	assert.False(t, store.IsSiteAdmin(alice))
+}
+
+func TestRole_Public(t *testing.T) {
+	store, _ := newTestStore(t)
+	alice := &auth.Identity{Username: "alice"}
+	store.Public = func(repoPath string) bool { return repoPath == "team/public.git" }
+
+	_, err := store.SetGrant(Grant{User: "alice", Resource: "team/public", Role: Write})
+	require.NoError(t, err)
+
+	assert.Equal(t, Read, store.Role(&auth.Identity{Username: "bob"}, "team/public.git"))
+	assert.Equal(t, Write, store.Role(alice, "team/public.git"))
+	assert.Equal(t, None, store.Role(&auth.Identity{Username: "bob"}, "team/private.git"))
+	assert.Equal(t, None, store.Role(nil, "team/public.git"))
+}
+
+func TestRenameAndDeleteRepository(t *testing.T) {
+	store, _ := newTestStore(t)
+	alice := &auth.Identity{Username: "alice"}
+
+	for _, grant := range []Grant{
+		{User: "alice", Resource: "team/project", Role: Write},
+		{User: "alice", Resource: "archive/project", Role: Read},
+		{User: "bob", Resource: "team/project", Role: Admin},
+		{User: "bob", Resource: "team/*", Role: Read},
+	} {
+		_, err := store.SetGrant(grant)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, store.RenameRepository("team/project.git", "archive/project.git"))
+	// A role already granted on the new path is kept
+	assert.Equal(t, Read, store.Role(alice, "archive/project.git"))
+	assert.Equal(t, Admin, store.Role(&auth.Identity{Username: "bob"}, "archive/project.git"))
+	assert.Equal(t, None, store.Role(alice, "team/project.git"))
+	assert.Len(t, store.Grants(), 3)
+
+	require.NoError(t, store.DeleteRepository("archive/project.git"))
+	assert.Equal(t, []Grant{{User: "bob", Resource: "team/*", Role: Read}}, store.Grants())
+}
+
+func TestTeams(t *testing.T) {
+	store, _ := newTestStore(t)
+	alice := &auth.Identity{Username: "alice"}
+
+	require.NoError(t, store.SetTeam("devs", []string{"bob", "alice", "bob"}))
+	assert.Equal(t, map[string][]string{"devs": {"alice", "bob"}}, store.Teams())
+
+	_, err := store.SetGrant(Grant{Team: "devs", Resource: "*", Role: Write})
+	require.NoError(t, err)
+	assert.Equal(t, Write, store.Role(alice, "team/project.git"))
+
+	// Deleting the team removes its grants
+	require.NoError(t, store.DeleteTeam("devs"))
+	assert.Empty(t, store.Grants())
+	assert.Equal(t, None, store.Role(alice, "team/project.git"))
+	assert.ErrorIs(t, store.DeleteTeam("devs"), ErrNotFound)
+}
+
+func TestSetGrant(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	_, err := store.SetGrant(Grant{Resource: "*", Role: Read})
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+	_, err = store.SetGrant(Grant{User: "alice", Team: "devs", Resource: "*", Role: Read})
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+	_, err = store.SetGrant(Grant{User: "alice", Resource: "*"})
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+
+	// A grant of the same subject on the same resource is replaced
+	_, err = store.SetGrant(Grant{User: "alice", Resource: "team/project", Role: Read})
+	require.NoError(t, err)
+	grant, err := store.SetGrant(Grant{User: "alice", Resource: "team/project.git", Role: Admin})
+	require.NoError(t, err)
+	assert.Equal(t, Grant{User: "alice", Resource: "team/project.git", Role: Admin}, grant)
+	assert.Equal(t, []Grant{grant}, store.Grants())
+
+	require.NoError(t, store.RemoveGrant(Grant{User: "alice", Resource: "team/project"}))
+	assert.Empty(t, store.Grants())
+	assert.ErrorIs(t, store.RemoveGrant(Grant{User: "alice", Resource: "team/project"}), ErrNotFound)
+}
+
+func TestPersistence(t *testing.T) {
+	store, path := newTestStore(t)
+
+	_, err := store.SetGrant(Grant{Team: "devs", Resource: "team/*", Role: Write})
+	require.NoError(t, err)
+	require.NoError(t, store.SetTeam("devs", []string{"alice"}))
+
+	loaded, err := Load(path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, store.Grants(), loaded.Grants())
+	assert.Equal(t, Write, loaded.Role(&auth.Identity{Username: "alice"}, "team/project.git"))
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+	_, err = Load(path, nil)
+	assert.Error(t, err)
+}
+
+func TestFailedWriteKeepsPolicy(t *testing.T) {
+	store, path := newTestStore(t)
+
+	grant, err := store.SetGrant(Grant{User: "alice", Resource: "team/*", Role: Read})
+	require.NoError(t, err)
+
+	// The directory of the file is gone, nothing can be written anymore
+	require.NoError(t, os.RemoveAll(filepath.Dir(path)))
+
+	_, err = store.SetGrant(Grant{User: "alice", Resource: "team/*", Role: Admin})
+	assert.Error(t, err)
+	assert.Error(t, store.SetTeam("devs", []string{"alice"}))
+	assert.Error(t, store.DeleteRepository("team/*"))
+	assert.Equal(t, []Grant{grant}, store.Grants())
+	assert.Empty(t, store.Teams())
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/go-git/go-git/v5/plumbing/storer"
@@ -25,23 +26,51 @@ type GitRepositoryStorage interface {
 	// DeleteRepository removes a repository at the given path
 	DeleteRepository(repoPath string) error
 
+	// RenameRepository moves a repository to a new path, possibly in another namespace
+	RenameRepository(oldPath, newPath string) error
+
+	// RepositorySize returns the size in bytes of the files of a repository
+	RepositorySize(repoPath string) (int64, error)
+
 	// ListRepositories returns a list of all repository paths
 	ListRepositories() ([]string, error)
 
 	// Configure initializes the storage backend
 	Configure() error
+
+	// Check writes, reads back and deletes a canary outside of the
+	// repositories, it returns nil when the backend is usable
+	Check(ctx context.Context) error
+}
+
+// ContextStorer is implemented by the storers whose calls to the storage
+// backend take a context, for their cancellation and tracing
+type ContextStorer interface {
+	WithContext(ctx context.Context) storer.Storer
+}
+
+// WithContext sets the context of the calls of st to the storage backend,
+// when it takes one
+func WithContext(ctx context.Context, st storer.Storer) storer.Storer {
+	if cs, ok := st.(ContextStorer); ok {
+		return cs.WithContext(ctx)
+	}
+	return st
 }
 
 // GitServerLoader implements go-git's server.Loader interface
 // using our storage abstraction
 type GitServerLoader struct {
+	ctx      context.Context
 	storage  GitRepositoryStorage
 	repoPath string
 }
 
-// NewGitServerLoader creates a new loader for a specific repository
-func NewGitServerLoader(storage GitRepositoryStorage, repoPath string) *GitServerLoader {
+// NewGitServerLoader creates a new loader for a specific repository, whose
+// storers make their calls with ctx
+func NewGitServerLoader(ctx context.Context, storage GitRepositoryStorage, repoPath string) *GitServerLoader {
 	return &GitServerLoader{
+		ctx:      ctx,
 		storage:  storage,
 		repoPath: repoPath,
 	}
@@ -49,7 +78,11 @@ func NewGitServerLoader(storage GitRepositoryStorage, repoPath string) *GitServe
 
 // Load implements server.Loader interface
 func (l *GitServerLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
-	return l.storage.GetStorer(l.repoPath)
+	st, err := l.storage.GetStorer(l.repoPath)
+	if err != nil {
+		return nil, err
+	}
+	return WithContext(l.ctx, st), nil
 }
 
 // NewGitRepositoryStorage creates a new GitRepositoryStorage instance based on configuration
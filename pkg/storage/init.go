@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/labbs/git-server-s3/internal/config"
+)
+
+// DefaultBranch is the default branch of the new repositories when neither
+// the options nor the configuration name one
+const DefaultBranch = "main"
+
+// DefaultAuthor is the author of the initial commits when none is given
+var DefaultAuthor = Author{Name: "oGit", Email: "ogit@localhost"}
+
+// ErrInvalidInit is returned for invalid initialization options
+var ErrInvalidInit = errors.New("invalid repository initialization")
+
+// Author is the identity of the author of an initial commit
+type Author struct {
+	Name  string
+	Email string
+}
+
+// InitOptions are the options of the initialization of a new repository. A
+// repository initialized without README nor template is empty: its default
+// branch is created by the first push.
+type InitOptions struct {
+	DefaultBranch string  // Branch HEAD points to, the configured default branch when empty
+	Readme        bool    // Commit a README.md
+	Template      string  // Path of a repository whose files at HEAD are committed
+	Author        *Author // Author of the initial commit, DefaultAuthor when nil
+}
+
+// InitRepository creates a repository and initializes it according to opts,
+// the same way on every storage backend. The repository is deleted when the
+// initialization fails.
+func InitRepository(str GitRepositoryStorage, repoPath string, opts InitOptions) error {
+	branch, err := initBranch(opts)
+	if err != nil {
+		return err
+	}
+	if opts.Readme && opts.Template != "" {
+		return fmt.Errorf("%w: readme and template are exclusive", ErrInvalidInit)
+	}
+	if opts.Template != "" && !str.RepositoryExists(opts.Template) {
+		return fmt.Errorf("%w: template repository %q not found", ErrInvalidInit, opts.Template)
+	}
+
+	if err := str.CreateRepository(repoPath); err != nil {
+		return err
+	}
+	if err := initRepository(str, repoPath, branch, opts); err != nil {
+		_ = str.DeleteRepository(repoPath)
+		return err
+	}
+	return nil
+}
+
+// initBranch returns the reference of the default branch of opts
+func initBranch(opts InitOptions) (plumbing.ReferenceName, error) {
+	name := opts.DefaultBranch
+	if name == "" {
+		name = config.Repos.DefaultBranch
+	}
+	if name == "" {
+		name = DefaultBranch
+	}
+	branch := plumbing.NewBranchReferenceName(strings.TrimPrefix(name, "refs/heads/"))
+	if err := branch.Validate(); err != nil {
+		return "", fmt.Errorf("%w: invalid default branch %q", ErrInvalidInit, name)
+	}
+	return branch, nil
+}
+
+// initRepository points HEAD of the new repository to branch and commits its
+// initial files, if any
+func initRepository(str GitRepositoryStorage, repoPath string, branch plumbing.ReferenceName, opts InitOptions) error {
+	st, err := str.GetStorer(repoPath)
+	if err != nil {
+		return err
+	}
+	if err := st.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch)); err != nil {
+		return fmt.Errorf("failed to set HEAD: %w", err)
+	}
+
+	var tree plumbing.Hash
+	switch {
+	case opts.Readme:
+		name := strings.TrimSuffix(path.Base(repoPath), ".git")
+		tree, err = readmeTree(st, "# "+name+"\n")
+	case opts.Template != "":
+		tree, err = templateTree(str, opts.Template, st)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	author := DefaultAuthor
+	if opts.Author != nil {
+		author = *opts.Author
+	}
+	signature := object.Signature{Name: author.Name, Email: author.Email, When: time.Now()}
+	commit := &object.Commit{
+		Author:    signature,
+		Committer: signature,
+		Message:   "Initial commit\n",
+		TreeHash:  tree,
+	}
+	hash, err := storeObject(st, commit)
+	if err != nil {
+		return fmt.Errorf("failed to store initial commit: %w", err)
+	}
+	if err := st.SetReference(plumbing.NewHashReference(branch, hash)); err != nil {
+		return fmt.Errorf("failed to create %s: %w", branch.Short(), err)
+	}
+	return nil
+}
+
+// readmeTree stores a tree holding a README.md of the given content
+func readmeTree(st storer.Storer, content string) (plumbing.Hash, error) {
+	blob := st.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := w.Write([]byte(content)); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	hash, err := st.SetEncodedObject(blob)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to store README.md: %w", err)
+	}
+
+	tree := &object.Tree{Entries: []object.TreeEntry{{Name: "README.md", Mode: filemode.Regular, Hash: hash}}}
+	hash, err = storeObject(st, tree)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to store tree: %w", err)
+	}
+	return hash, nil
+}
+
+// templateTree copies the tree of the HEAD commit of the template repository
+// to st, without its history
+func templateTree(str GitRepositoryStorage, template string, st storer.Storer) (plumbing.Hash, error) {
+	src, err := str.GetStorer(template)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	head, err := storer.ResolveReference(src, plumbing.HEAD)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return plumbing.ZeroHash, fmt.Errorf("%w: template repository %q is empty", ErrInvalidInit, template)
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	commit, err := object.GetCommit(src, head.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to read template: %w", err)
+	}
+	if err := copyTree(src, st, commit.TreeHash); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to copy template: %w", err)
+	}
+	return commit.TreeHash, nil
+}
+
+// copyTree copies a tree and the objects it references from src to dst
+func copyTree(src, dst storer.Storer, hash plumbing.Hash) error {
+	tree, err := object.GetTree(src, hash)
+	if err != nil {
+		return err
+	}
+	for _, entry := range tree.Entries {
+		switch entry.Mode {
+		case filemode.Dir:
+			err = copyTree(src, dst, entry.Hash)
+		case filemode.Submodule:
+			// The commit belongs to another repository
+			continue
+		default:
+			err = copyObject(src, dst, entry.Hash)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return copyObject(src, dst, hash)
+}
+
+// copyObject copies an object from src to dst
+func copyObject(src, dst storer.Storer, hash plumbing.Hash) error {
+	obj, err := src.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return err
+	}
+	_, err = dst.SetEncodedObject(obj)
+	return err
+}
+
+// storeObject encodes and stores a git object
+func storeObject(st storer.Storer, obj interface {
+	Encode(plumbing.EncodedObject) error
+}) (plumbing.Hash, error) {
+	encoded := st.NewEncodedObject()
+	if err := obj.Encode(encoded); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return st.SetEncodedObject(encoded)
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLocalStorage(t *testing.T) *local.LocalStorage {
+	t.Helper()
+
+	config.Storage.Local.Path = t.TempDir()
+	str := local.NewLocalStorage(zerolog.Nop())
+	require.NoError(t, str.Configure())
+	return str
+}
+
+func TestInitRepository_Empty(t *testing.T) {
+	str := newLocalStorage(t)
+	config.Repos.DefaultBranch = "trunk"
+	defer func() { config.Repos.DefaultBranch = "" }()
+
+	require.NoError(t, InitRepository(str, "team/project.git", InitOptions{}))
+
+	st, err := str.GetStorer("team/project.git")
+	require.NoError(t, err)
+	head, err := st.Reference(plumbing.HEAD)
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.NewBranchReferenceName("trunk"), head.Target())
+	_, err = st.Reference(head.Target())
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+}
+
+func TestInitRepository_Readme(t *testing.T) {
+	str := newLocalStorage(t)
+	author := &Author{Name: "Alice", Email: "alice@example.com"}
+
+	require.NoError(t, InitRepository(str, "team/project.git", InitOptions{DefaultBranch: "develop", Readme: true, Author: author}))
+
+	st, err := str.GetStorer("team/project.git")
+	require.NoError(t, err)
+	ref, err := st.Reference(plumbing.NewBranchReferenceName("develop"))
+	require.NoError(t, err)
+	commit, err := object.GetCommit(st, ref.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", commit.Author.Name)
+	assert.Equal(t, "alice@example.com", commit.Committer.Email)
+
+	file, err := commit.File("README.md")
+	require.NoError(t, err)
+	content, err := file.Contents()
+	require.NoError(t, err)
+	assert.Equal(t, "# project\n", content)
+}
+
+func TestInitRepository_Template(t *testing.T) {
+	str := newLocalStorage(t)
+	require.NoError(t, InitRepository(str, "templates/base.git", InitOptions{Readme: true}))
+
+	require.NoError(t, InitRepository(str, "team/project.git", InitOptions{Template: "templates/base.git"}))
+
+	st, err := str.GetStorer("team/project.git")
+	require.NoError(t, err)
+	ref, err := st.Reference(plumbing.Main)
+	require.NoError(t, err)
+	commit, err := object.GetCommit(st, ref.Hash())
+	require.NoError(t, err)
+	assert.Empty(t, commit.ParentHashes)
+	assert.Equal(t, DefaultAuthor.Name, commit.Author.Name)
+	_, err = commit.File("README.md")
+	assert.NoError(t, err)
+}
+
+func TestInitRepository_Invalid(t *testing.T) {
+	str := newLocalStorage(t)
+	require.NoError(t, InitRepository(str, "templates/empty.git", InitOptions{}))
+
+	tests := []struct {
+		name string
+		opts InitOptions
+	}{
+		{name: "invalid branch", opts: InitOptions{DefaultBranch: "bad..name"}},
+		{name: "readme and template", opts: InitOptions{Readme: true, Template: "templates/empty.git"}},
+		{name: "missing template", opts: InitOptions{Template: "templates/missing.git"}},
+		{name: "empty template", opts: InitOptions{Template: "templates/empty.git"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := InitRepository(str, "team/project.git", tt.opts)
+			assert.ErrorIs(t, err, ErrInvalidInit)
+			// A failed initialization leaves no repository
+			assert.False(t, str.RepositoryExists("team/project.git"))
+		})
+	}
+}
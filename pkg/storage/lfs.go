@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/labbs/git-server-s3/internal/config"
+	"github.com/labbs/git-server-s3/pkg/storage/local"
+	"github.com/labbs/git-server-s3/pkg/storage/s3"
+)
+
+// LFSStorage stores the Git LFS objects of the repositories, outside of
+// their git storage, by oid (the SHA-256 of their content). Objects are
+// streamed in and out, they are never held in memory. The missing objects
+// are reported with fs.ErrNotExist.
+type LFSStorage interface {
+	// Stat returns the size of an object
+	Stat(repoPath, oid string) (int64, error)
+
+	// Open returns the content of an object and its size, the caller closes it
+	Open(repoPath, oid string) (io.ReadCloser, int64, error)
+
+	// Put stores an object from the file holding its content, already
+	// checked against its oid. The file may be moved.
+	Put(repoPath, oid string, f *os.File, size int64) error
+
+	// RenameRepository moves the objects of a renamed repository
+	RenameRepository(oldPath, newPath string) error
+
+	// DeleteRepository removes the objects of a deleted repository
+	DeleteRepository(repoPath string) error
+}
+
+// LFSPresigner is implemented by the LFS storages able to let clients
+// transfer the objects directly, with URLs valid for a limited time. The
+// headers returned with a URL must be sent with the request.
+type LFSPresigner interface {
+	PresignDownload(repoPath, oid string, expiry time.Duration) (string, map[string]string, error)
+	PresignUpload(repoPath, oid string, size int64, expiry time.Duration) (string, map[string]string, error)
+}
+
+// NewLFSStorage returns the LFS storage of the configured storage backend,
+// already configured
+func NewLFSStorage(str GitRepositoryStorage) (LFSStorage, error) {
+	switch s := str.(type) {
+	case *local.LocalStorage:
+		return local.NewLFSStorage(config.LFS.Path)
+	case *s3.S3Storage:
+		return s.LFSStorage(), nil
+	default:
+		return nil, fmt.Errorf("unsupported storage for LFS: %T", str)
+	}
+}
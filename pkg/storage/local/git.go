@@ -2,15 +2,15 @@ package local
 
 import (
 	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
 	billyos "github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
-	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/labbs/git-server-s3/internal/config"
@@ -64,6 +64,8 @@ func (ls *LocalStorage) GetStorer(repoPath string) (storer.Storer, error) {
 	return filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil
 }
 
+// CreateRepository creates an empty bare repository, HEAD points to main
+// until storage.InitRepository sets the default branch
 func (ls *LocalStorage) CreateRepository(repoPath string) error {
 	fullPath := ls.getFullPath(repoPath)
 
@@ -71,68 +73,15 @@ func (ls *LocalStorage) CreateRepository(repoPath string) error {
 		return errors.New("repository already exists")
 	}
 
-	// Create directory if it doesn't exist
-	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
-		return err
-	}
-
-	// Create a temporary normal repository first to add initial commit
-	tempPath := fullPath + "_temp"
-	defer os.RemoveAll(tempPath) // Clean up temp directory
-
-	// Initialize normal (non-bare) repository
-	repo, err := git.PlainInit(tempPath, false)
-	if err != nil {
-		return err
-	}
-
-	// Get worktree to create files
-	worktree, err := repo.Worktree()
-	if err != nil {
-		return err
-	}
-
-	// Create README.md with repository information
-	repoName := strings.TrimSuffix(filepath.Base(repoPath), ".git")
-	readmeContent := "# " + repoName + "\n\nRepository created with git-server-s3\n"
-
-	readmeFile, err := worktree.Filesystem.Create("README.md")
-	if err != nil {
-		return err
-	}
-
-	if _, err := readmeFile.Write([]byte(readmeContent)); err != nil {
-		readmeFile.Close()
-		return err
-	}
-	readmeFile.Close()
-
-	// Add README.md to staging
-	if _, err := worktree.Add("README.md"); err != nil {
-		return err
-	}
-
-	// Create initial commit
-	commit, err := worktree.Commit("Initial commit", &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  "Git Server S3",
-			Email: "git-server@localhost",
-			When:  time.Now(),
-		},
+	_, err := git.PlainInitWithOptions(fullPath, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: plumbing.Main},
+		Bare:        true,
 	})
 	if err != nil {
 		return err
 	}
 
-	// Now clone this repository as bare to the final location
-	_, err = git.PlainClone(fullPath, true, &git.CloneOptions{
-		URL: tempPath,
-	})
-	if err != nil {
-		return err
-	}
-
-	ls.Logger.Info().Str("commit", commit.String()).Str("repo", repoPath).Msg("Created repository with initial commit")
+	ls.Logger.Info().Str("repo", repoPath).Msg("Created repository")
 	return nil
 }
 
@@ -152,6 +101,41 @@ func (ls *LocalStorage) DeleteRepository(repoPath string) error {
 	return os.RemoveAll(fullPath)
 }
 
+func (ls *LocalStorage) RenameRepository(oldPath, newPath string) error {
+	if !ls.RepositoryExists(oldPath) {
+		return errors.New("repository does not exist")
+	}
+	if ls.RepositoryExists(newPath) {
+		return errors.New("repository already exists")
+	}
+
+	fullPath := ls.getFullPath(newPath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	return os.Rename(ls.getFullPath(oldPath), fullPath)
+}
+
+func (ls *LocalStorage) RepositorySize(repoPath string) (int64, error) {
+	if !ls.RepositoryExists(repoPath) {
+		return 0, errors.New("repository does not exist")
+	}
+
+	var size int64
+	err := filepath.WalkDir(ls.getFullPath(repoPath), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
 func (ls *LocalStorage) ListRepositories() ([]string, error) {
 	var repos []string
 
@@ -0,0 +1,48 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Check writes, reads back and deletes a canary file in the storage
+// directory, checking that it is writable. The local calls do not take the
+// context.
+func (ls *LocalStorage) Check(ctx context.Context) error {
+	canary := make([]byte, 16)
+	if _, err := rand.Read(canary); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(ls.basePath, ".health-*")
+	if err != nil {
+		return fmt.Errorf("failed to write the canary: %w", err)
+	}
+	name := f.Name()
+	defer os.Remove(name)
+
+	_, err = f.Write(canary)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the canary: %w", err)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("failed to read the canary: %w", err)
+	}
+	if !bytes.Equal(data, canary) {
+		return errors.New("failed to read the canary: content mismatch")
+	}
+
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("failed to delete the canary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package local
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LFSStorage stores the Git LFS objects in a directory, apart from the
+// repositories, at <repo>.git/<oid[0:2]>/<oid[2:4]>/<oid> like git-lfs does
+// in a local repository
+type LFSStorage struct {
+	basePath string
+}
+
+// NewLFSStorage returns the LFS storage of the directory at basePath,
+// creating it if necessary
+func NewLFSStorage(basePath string) (*LFSStorage, error) {
+	if basePath == "" {
+		return nil, errors.New("LFS storage path is not configured")
+	}
+	if err := os.MkdirAll(basePath, 0755); err != nil {
+		return nil, err
+	}
+	return &LFSStorage{basePath: basePath}, nil
+}
+
+func (s *LFSStorage) Stat(repoPath, oid string) (int64, error) {
+	info, err := os.Stat(s.objectPath(repoPath, oid))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *LFSStorage) Open(repoPath, oid string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(s.objectPath(repoPath, oid))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// Put moves the file in place, or copies it when it is on another file system
+func (s *LFSStorage) Put(repoPath, oid string, f *os.File, size int64) error {
+	path := s.objectPath(repoPath, oid)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err == nil {
+		return nil
+	}
+
+	// The copy is renamed once complete, a partial object is never served
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+oid+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, io.NewSectionReader(f, 0, size)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LFSStorage) RenameRepository(oldPath, newPath string) error {
+	oldDir := s.repoPath(oldPath)
+	if _, err := os.Stat(oldDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	newDir := s.repoPath(newPath)
+	if err := os.MkdirAll(filepath.Dir(newDir), 0755); err != nil {
+		return err
+	}
+	return os.Rename(oldDir, newDir)
+}
+
+func (s *LFSStorage) DeleteRepository(repoPath string) error {
+	return os.RemoveAll(s.repoPath(repoPath))
+}
+
+// repoPath returns the directory of the objects of a repository
+func (s *LFSStorage) repoPath(repoPath string) string {
+	cleanPath := filepath.Clean(repoPath)
+	if !strings.HasSuffix(cleanPath, ".git") {
+		cleanPath += ".git"
+	}
+	return filepath.Join(s.basePath, cleanPath)
+}
+
+// objectPath returns the path of an object, oid is a valid SHA-256
+func (s *LFSStorage) objectPath(repoPath, oid string) string {
+	return filepath.Join(s.repoPath(repoPath), oid[0:2], oid[2:4], oid)
+}
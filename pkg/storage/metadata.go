@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/config"
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// Visibility controls who reads a repository when access control is enabled
+type Visibility string
+
+const (
+	Private Visibility = "private" // Readable by the users granted a role on it
+	Public  Visibility = "public"  // Readable by every authenticated user
+)
+
+// metadataSection is the section of the repository git config holding the metadata
+const metadataSection = "ogit"
+
+// Metadata is the information the server keeps about a repository, in its
+// git config so that it follows the repository on every storage backend.
+type Metadata struct {
+	Description string
+	Visibility  Visibility
+	LastPush    time.Time // Zero when the repository was never pushed to
+}
+
+// ReadMetadata returns the metadata of the repository of st
+func ReadMetadata(st storer.Storer) (Metadata, error) {
+	cfg, err := readConfig(st)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return metadataFromConfig(cfg), nil
+}
+
+// ConfigUpdater is implemented by the storers able to change their config
+// only if it was not changed in the meantime, in a single conditional write
+type ConfigUpdater interface {
+	UpdateConfig(update func(*config.Config) error) error
+}
+
+// configMu serializes the config updates of the storers not implementing
+// ConfigUpdater, whose repositories are only written by this process
+var configMu sync.Mutex
+
+// UpdateMetadata applies update to the metadata of the repository of st.
+// The config is read and written atomically, so that concurrent updates of
+// different fields, e.g. a push and a change of visibility, are all kept.
+func UpdateMetadata(st storer.Storer, update func(*Metadata)) error {
+	change := func(cfg *config.Config) error {
+		metadata := metadataFromConfig(cfg)
+		update(&metadata)
+		if metadata.Visibility != Public {
+			metadata.Visibility = Private
+		}
+
+		section := cfg.Raw.Section(metadataSection)
+		setOption(section, "description", metadata.Description)
+		setOption(section, "visibility", string(metadata.Visibility))
+		if !metadata.LastPush.IsZero() {
+			setOption(section, "lastpush", metadata.LastPush.UTC().Format(time.RFC3339))
+		}
+		return nil
+	}
+
+	if cu, ok := st.(ConfigUpdater); ok {
+		if err := cu.UpdateConfig(change); err != nil {
+			return fmt.Errorf("failed to write repository config: %w", err)
+		}
+		return nil
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	cfg, err := readConfig(st)
+	if err != nil {
+		return err
+	}
+	if err := change(cfg); err != nil {
+		return err
+	}
+	if err := st.(config.ConfigStorer).SetConfig(cfg); err != nil {
+		return fmt.Errorf("failed to write repository config: %w", err)
+	}
+	return nil
+}
+
+// readConfig returns the git config of the repository of st
+func readConfig(st storer.Storer) (*config.Config, error) {
+	cs, ok := st.(config.ConfigStorer)
+	if !ok {
+		return nil, errors.New("storer does not support repository configuration")
+	}
+	cfg, err := cs.Config()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repository config: %w", err)
+	}
+	return cfg, nil
+}
+
+// metadataFromConfig returns the metadata held in a repository git config
+func metadataFromConfig(cfg *config.Config) Metadata {
+	section := cfg.Raw.Section(metadataSection)
+	metadata := Metadata{
+		Description: section.Option("description"),
+		Visibility:  Visibility(section.Option("visibility")),
+	}
+	if metadata.Visibility != Public {
+		metadata.Visibility = Private
+	}
+	if lastPush := section.Option("lastpush"); lastPush != "" {
+		metadata.LastPush, _ = time.Parse(time.RFC3339, lastPush)
+	}
+	return metadata
+}
+
+// setOption sets an option of section, removing it when value is empty
+func setOption(section *format.Section, key, value string) {
+	if value == "" {
+		section.RemoveOption(key)
+		return
+	}
+	section.SetOption(key, value)
+}
+
+// IsPublic reports whether the repository at repoPath exists and is public
+func IsPublic(str GitRepositoryStorage, repoPath string) bool {
+	if !str.RepositoryExists(repoPath) {
+		return false
+	}
+	st, err := str.GetStorer(repoPath)
+	if err != nil {
+		return false
+	}
+	metadata, err := ReadMetadata(st)
+	return err == nil && metadata.Visibility == Public
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/labbs/git-server-s3/pkg/storage/s3"
+	"github.com/labbs/git-server-s3/pkg/storage/s3/s3test"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	st := memory.NewStorage()
+
+	metadata, err := ReadMetadata(st)
+	require.NoError(t, err)
+	assert.Equal(t, Metadata{Visibility: Private}, metadata)
+
+	lastPush := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, UpdateMetadata(st, func(m *Metadata) {
+		m.Description = "The project"
+		m.Visibility = Public
+		m.LastPush = lastPush
+	}))
+	require.NoError(t, UpdateMetadata(st, func(m *Metadata) {
+		m.Visibility = "unknown"
+	}))
+
+	metadata, err = ReadMetadata(st)
+	require.NoError(t, err)
+	assert.Equal(t, Metadata{Description: "The project", Visibility: Private, LastPush: lastPush}, metadata)
+
+	// The metadata is kept in the repository config
+	cfg, err := st.Config()
+	require.NoError(t, err)
+	assert.Equal(t, "The project", cfg.Raw.Section("ogit").Option("description"))
+}
+
+func TestUpdateMetadata_Concurrent(t *testing.T) {
+	fake := s3test.NewServer(t)
+	st := s3.NewS3Storer(fake.Client(), s3test.Bucket, "repo.git", zerolog.Nop())
+	require.NoError(t, st.SetConfig(config.NewConfig()))
+	require.NoError(t, UpdateMetadata(st, func(m *Metadata) {
+		m.Description = "The project"
+	}))
+
+	// The repository is made public by another server while a push records
+	// its date
+	fake.BeforePut = func(key string) {
+		if key == "repo.git/config" {
+			fake.BeforePut = nil
+			cfg, err := st.Config()
+			require.NoError(t, err)
+			cfg.Raw.Section(metadataSection).SetOption("visibility", string(Public))
+			content, err := cfg.Marshal()
+			require.NoError(t, err)
+			fake.Set(key, content)
+		}
+	}
+	lastPush := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, UpdateMetadata(st, func(m *Metadata) {
+		m.LastPush = lastPush
+	}))
+
+	metadata, err := ReadMetadata(st)
+	require.NoError(t, err)
+	assert.Equal(t, Metadata{Description: "The project", Visibility: Public, LastPush: lastPush}, metadata)
+}
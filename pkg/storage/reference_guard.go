@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"io"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// ReferenceGuard wraps a storer so that the reference updates of a push are
+// applied with compare-and-swap semantics.
+// go-git's receive-pack session writes references with SetReference and
+// RemoveReference, ignoring the old value sent by the client. Once the
+// commands of a push are registered with Expect, those calls go through
+// CheckAndSetReference instead, and fail with storage.ErrReferenceHasChanged
+// if another push moved the reference in the meantime. The error ends up as a
+// rejected reference in the receive-pack status report.
+type ReferenceGuard struct {
+	storer.Storer
+
+	mu       sync.Mutex
+	expected map[plumbing.ReferenceName]plumbing.Hash
+}
+
+// ReferenceRemover is implemented by the storers able to remove a reference
+// only if it still has an expected value, in a single conditional write
+type ReferenceRemover interface {
+	CheckAndRemoveReference(old *plumbing.Reference) error
+}
+
+// NewReferenceGuard creates a new guard around the given storer
+func NewReferenceGuard(s storer.Storer) *ReferenceGuard {
+	return &ReferenceGuard{
+		Storer:   s,
+		expected: make(map[plumbing.ReferenceName]plumbing.Hash),
+	}
+}
+
+// Expect registers the old value the client expects for every reference
+// updated by the given commands
+func (g *ReferenceGuard) Expect(cmds []*packp.Command) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, cmd := range cmds {
+		g.expected[cmd.Name] = cmd.Old
+	}
+}
+
+// expectation returns and forgets the old value expected for a reference
+func (g *ReferenceGuard) expectation(name plumbing.ReferenceName) (plumbing.Hash, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	old, ok := g.expected[name]
+	delete(g.expected, name)
+	return old, ok
+}
+
+// SetReference stores ref, checking it against the expected old value if any.
+// A zero old value means the client creates the reference.
+func (g *ReferenceGuard) SetReference(ref *plumbing.Reference) error {
+	old, ok := g.expectation(ref.Name())
+	if !ok {
+		return g.Storer.SetReference(ref)
+	}
+
+	var oldRef *plumbing.Reference
+	if !old.IsZero() {
+		oldRef = plumbing.NewHashReference(ref.Name(), old)
+	}
+
+	return g.Storer.CheckAndSetReference(ref, oldRef)
+}
+
+// RemoveReference removes a reference, checking it against the expected old
+// value if any. Storers implementing ReferenceRemover check and remove it
+// atomically, the others are read and compared before the removal.
+func (g *ReferenceGuard) RemoveReference(name plumbing.ReferenceName) error {
+	old, ok := g.expectation(name)
+	if ok {
+		if rr, isRemover := g.Storer.(ReferenceRemover); isRemover {
+			return rr.CheckAndRemoveReference(plumbing.NewHashReference(name, old))
+		}
+
+		current, err := g.Storer.Reference(name)
+		if err != nil {
+			return err
+		}
+		if current.Hash() != old {
+			return storage.ErrReferenceHasChanged
+		}
+	}
+
+	return g.Storer.RemoveReference(name)
+}
+
+// PackfileWriter forwards to the wrapped storer so that packfiles keep being
+// written in one piece. Storers without packfile support get the objects
+// written one by one, as go-git would do without the guard.
+func (g *ReferenceGuard) PackfileWriter() (io.WriteCloser, error) {
+	if pw, ok := g.Storer.(storer.PackfileWriter); ok {
+		return pw.PackfileWriter()
+	}
+
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		parser, err := packfile.NewParserWithStorage(packfile.NewScanner(r), g.Storer)
+		if err == nil {
+			_, err = parser.Parse()
+		}
+		r.CloseWithError(err)
+		done <- err
+	}()
+
+	return &parsingWriter{PipeWriter: w, done: done}, nil
+}
+
+// parsingWriter feeds a packfile to a parser running in the background
+type parsingWriter struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (w *parsingWriter) Close() error {
+	if err := w.PipeWriter.Close(); err != nil {
+		return err
+	}
+	return <-w.done
+}
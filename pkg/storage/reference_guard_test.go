@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	hashA = plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	hashB = plumbing.NewHash("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	hashC = plumbing.NewHash("cccccccccccccccccccccccccccccccccccccccc")
+	main  = plumbing.ReferenceName("refs/heads/main")
+)
+
+func TestReferenceGuard_SetReference(t *testing.T) {
+	tests := []struct {
+		name    string
+		current plumbing.Hash
+		old     plumbing.Hash
+		wantErr error
+		want    plumbing.Hash
+	}{
+		{
+			name:    "expected value matches",
+			current: hashA,
+			old:     hashA,
+			want:    hashC,
+		},
+		{
+			name:    "reference moved by another push",
+			current: hashB,
+			old:     hashA,
+			wantErr: storage.ErrReferenceHasChanged,
+			want:    hashB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := memory.NewStorage()
+			require.NoError(t, st.SetReference(plumbing.NewHashReference(main, tt.current)))
+
+			guard := NewReferenceGuard(st)
+			guard.Expect([]*packp.Command{{Name: main, Old: tt.old, New: hashC}})
+
+			err := guard.SetReference(plumbing.NewHashReference(main, hashC))
+			assert.ErrorIs(t, err, tt.wantErr)
+
+			ref, err := st.Reference(main)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ref.Hash())
+		})
+	}
+}
+
+func TestReferenceGuard_RemoveReference(t *testing.T) {
+	st := memory.NewStorage()
+	require.NoError(t, st.SetReference(plumbing.NewHashReference(main, hashB)))
+
+	guard := NewReferenceGuard(st)
+	guard.Expect([]*packp.Command{{Name: main, Old: hashA, New: plumbing.ZeroHash}})
+
+	assert.ErrorIs(t, guard.RemoveReference(main), storage.ErrReferenceHasChanged)
+
+	_, err := st.Reference(main)
+	assert.NoError(t, err)
+}
+
+// removerStorage records the conditional removals it receives
+type removerStorage struct {
+	*memory.Storage
+	removed *plumbing.Reference
+}
+
+func (s *removerStorage) CheckAndRemoveReference(old *plumbing.Reference) error {
+	s.removed = old
+	return nil
+}
+
+func TestReferenceGuard_RemoveReferenceConditional(t *testing.T) {
+	st := &removerStorage{Storage: memory.NewStorage()}
+
+	guard := NewReferenceGuard(st)
+	guard.Expect([]*packp.Command{{Name: main, Old: hashA, New: plumbing.ZeroHash}})
+
+	require.NoError(t, guard.RemoveReference(main))
+	assert.Equal(t, plumbing.NewHashReference(main, hashA), st.removed)
+}
+
+func TestReferenceGuard_WithoutExpectation(t *testing.T) {
+	st := memory.NewStorage()
+	require.NoError(t, st.SetReference(plumbing.NewHashReference(main, hashB)))
+
+	guard := NewReferenceGuard(st)
+	require.NoError(t, guard.SetReference(plumbing.NewHashReference(main, hashC)))
+
+	ref, err := st.Reference(main)
+	require.NoError(t, err)
+	assert.Equal(t, hashC, ref.Hash())
+}
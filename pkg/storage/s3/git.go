@@ -4,28 +4,31 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"net/url"
+	"slices"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/labbs/git-server-s3/internal/config"
 	"github.com/rs/zerolog"
 )
 
 type S3Storage struct {
-	Logger zerolog.Logger
-	bucket string
-	client *awss3.Client
+	Logger     zerolog.Logger
+	bucket     string
+	client     *awss3.Client
+	indexCache *packIndexCache // Pack indexes shared by all the storers of this storage
 }
 
 func NewS3Storage(logger zerolog.Logger) *S3Storage {
 	return &S3Storage{
-		Logger: logger,
+		Logger:     logger,
+		indexCache: newPackIndexCache(),
 	}
 }
 
@@ -70,9 +73,11 @@ func (s3s *S3Storage) GetStorer(repoPath string) (storer.Storer, error) {
 		return nil, errors.New("repository does not exist")
 	}
 
-	return NewS3Storer(s3s.client, s3s.bucket, s3s.getRepoKey(repoPath), s3s.Logger), nil
+	return newS3Storer(s3s.client, s3s.bucket, s3s.getRepoKey(repoPath), s3s.Logger, s3s.indexCache), nil
 }
 
+// CreateRepository creates an empty bare repository, HEAD points to main
+// until storage.InitRepository sets the default branch
 func (s3s *S3Storage) CreateRepository(repoPath string) error {
 	if s3s.RepositoryExists(repoPath) {
 		return errors.New("repository already exists")
@@ -106,151 +111,131 @@ func (s3s *S3Storage) CreateRepository(repoPath string) error {
 		return fmt.Errorf("failed to create objects directory: %w", err)
 	}
 
-	// Create initial commit and main branch
-	if err := s3s.createInitialCommit(repoKey); err != nil {
-		return fmt.Errorf("failed to create initial commit: %w", err)
+	// HEAD marks the repository as existing, it is written last
+	st := newS3Storer(s3s.client, s3s.bucket, repoKey, s3s.Logger, s3s.indexCache)
+	if err := st.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Main)); err != nil {
+		return fmt.Errorf("failed to create HEAD: %w", err)
 	}
 
-	s3s.Logger.Info().Str("repo", repoPath).Msg("Repository created in S3 with initial commit")
+	s3s.Logger.Info().Str("repo", repoPath).Msg("Repository created in S3")
 	return nil
 }
 
-// createInitialCommit creates an initial commit with README.md and main branch
-func (s3s *S3Storage) createInitialCommit(repoKey string) error {
-	// Create a storer for this repository
-	storer := NewS3Storer(s3s.client, s3s.bucket, repoKey, s3s.Logger)
-
-	// Create README.md content
-	readmeContent := []byte(`# Repository
-	
-This is a new Git repository hosted on S3.
-
-## Getting Started
-
-Clone this repository:
-` + "```bash" + `
-git clone <repository-url>
-` + "```" + `
+func (s3s *S3Storage) RepositoryExists(repoPath string) bool {
+	repoKey := s3s.getRepoKey(repoPath)
 
-Start adding your files and make your first commit!
-`)
+	// Check if HEAD exists to determine if repository exists
+	_, err := s3s.client.HeadObject(context.TODO(), &awss3.HeadObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    aws.String(repoKey + "/HEAD"),
+	})
 
-	// Create blob object for README.md
-	readmeBlob := &plumbing.MemoryObject{}
-	readmeBlob.SetType(plumbing.BlobObject)
-	readmeBlob.SetSize(int64(len(readmeContent)))
-	readmeBlob.Write(readmeContent)
+	return err == nil
+}
 
-	// Store the blob
-	readmeHash, err := storer.SetEncodedObject(readmeBlob)
-	if err != nil {
-		return fmt.Errorf("failed to store README.md blob: %w", err)
+func (s3s *S3Storage) DeleteRepository(repoPath string) error {
+	if !s3s.RepositoryExists(repoPath) {
+		return errors.New("repository does not exist")
 	}
 
-	// Create tree with README.md
-	tree := &object.Tree{
-		Entries: []object.TreeEntry{
-			{
-				Name: "README.md",
-				Mode: 0o100644, // Regular file
-				Hash: readmeHash,
-			},
-		},
+	if err := s3s.deletePrefix(s3s.getRepoKey(repoPath) + "/"); err != nil {
+		return err
 	}
 
-	// Encode the tree
-	treeObj := &plumbing.MemoryObject{}
-	if err := tree.Encode(treeObj); err != nil {
-		return fmt.Errorf("failed to encode tree: %w", err)
-	}
+	s3s.Logger.Info().Str("repo", repoPath).Msg("Repository deleted from S3")
+	return nil
+}
 
-	// Store the tree object
-	treeHash, err := storer.SetEncodedObject(treeObj)
-	if err != nil {
-		return fmt.Errorf("failed to store tree: %w", err)
+// RenameRepository moves a repository to newPath. S3 has no rename, the
+// objects are copied then the old ones deleted. HEAD is copied last so that
+// the new repository only exists once complete, and the copies are deleted
+// again if the rename fails.
+//
+// The repository is listed again before deleting it: a push to oldPath during
+// the copy fails the rename instead of being lost. A push landing between
+// that check and the deletion is still lost, renames are not meant to happen
+// while the repository is being pushed to.
+func (s3s *S3Storage) RenameRepository(oldPath, newPath string) error {
+	if !s3s.RepositoryExists(oldPath) {
+		return errors.New("repository does not exist")
 	}
-
-	// Create initial commit
-	commit := &object.Commit{
-		Author: object.Signature{
-			Name:  "Git Server",
-			Email: "git-server@example.com",
-			When:  time.Now(),
-		},
-		Committer: object.Signature{
-			Name:  "Git Server",
-			Email: "git-server@example.com",
-			When:  time.Now(),
-		},
-		Message:      "Initial commit\n\nCreated repository with README.md",
-		TreeHash:     treeHash,
-		ParentHashes: []plumbing.Hash{}, // No parents for initial commit
+	if s3s.RepositoryExists(newPath) {
+		return errors.New("repository already exists")
 	}
 
-	// Encode the commit
-	commitObj := &plumbing.MemoryObject{}
-	if err := commit.Encode(commitObj); err != nil {
-		return fmt.Errorf("failed to encode commit: %w", err)
-	}
+	oldKey := s3s.getRepoKey(oldPath)
+	newKey := s3s.getRepoKey(newPath)
 
-	// Store the commit object
-	commitHash, err := storer.SetEncodedObject(commitObj)
+	objects, err := s3s.listRepository(oldKey)
 	if err != nil {
-		return fmt.Errorf("failed to store commit: %w", err)
+		return err
 	}
 
-	// Create main branch pointing to the commit
-	mainRef := plumbing.NewHashReference(plumbing.ReferenceName("refs/heads/main"), commitHash)
-	if err := storer.SetReference(mainRef); err != nil {
-		return fmt.Errorf("failed to create main branch: %w", err)
+	if err := s3s.copyRepository(objects, oldKey, newKey); err != nil {
+		if err := s3s.deletePrefix(newKey + "/"); err != nil {
+			s3s.Logger.Warn().Err(err).Str("repo", newPath).Msg("Failed to delete the partial copy of a renamed repository")
+		}
+		return err
 	}
 
-	// Create HEAD pointing to main branch (symbolic reference)
-	// This ensures that clone will checkout main branch by default
-	headRef := plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")
-	if err := storer.SetReference(headRef); err != nil {
-		return fmt.Errorf("failed to create HEAD: %w", err)
+	if err := s3s.DeleteRepository(oldPath); err != nil {
+		return err
 	}
 
-	s3s.Logger.Debug().
-		Str("treeHash", treeHash.String()).
-		Str("commitHash", commitHash.String()).
-		Str("readmeHash", readmeHash.String()).
-		Msg("Created initial commit with README.md")
-
+	s3s.Logger.Info().Str("repo", oldPath).Str("to", newPath).Msg("Repository renamed in S3")
 	return nil
 }
 
-func (s3s *S3Storage) RepositoryExists(repoPath string) bool {
-	repoKey := s3s.getRepoKey(repoPath)
+// copyRepository copies the listed objects of the repository at oldKey to
+// newKey, HEAD last, and checks that the repository was not updated meanwhile
+func (s3s *S3Storage) copyRepository(objects map[string]types.Object, oldKey, newKey string) error {
+	keys := slices.Sorted(maps.Keys(objects))
+	keys = slices.DeleteFunc(keys, func(key string) bool { return key == oldKey+"/HEAD" })
+	keys = append(keys, oldKey+"/HEAD")
 
-	// Check if HEAD exists to determine if repository exists
-	_, err := s3s.client.HeadObject(context.TODO(), &awss3.HeadObjectInput{
-		Bucket: aws.String(s3s.bucket),
-		Key:    aws.String(repoKey + "/HEAD"),
-	})
+	for _, key := range keys {
+		if err := s3s.copyObject(key, newKey+strings.TrimPrefix(key, oldKey), aws.ToInt64(objects[key].Size)); err != nil {
+			return fmt.Errorf("failed to copy repository object %s: %w", key, err)
+		}
+	}
 
-	return err == nil
+	current, err := s3s.listRepository(oldKey)
+	if err != nil {
+		return err
+	}
+	if !maps.EqualFunc(objects, current, func(a, b types.Object) bool {
+		return aws.ToString(a.ETag) == aws.ToString(b.ETag)
+	}) {
+		return errors.New("repository updated during the rename")
+	}
+	return nil
 }
 
-func (s3s *S3Storage) DeleteRepository(repoPath string) error {
+func (s3s *S3Storage) RepositorySize(repoPath string) (int64, error) {
 	if !s3s.RepositoryExists(repoPath) {
-		return errors.New("repository does not exist")
+		return 0, errors.New("repository does not exist")
 	}
 
-	repoKey := s3s.getRepoKey(repoPath)
+	var size int64
+	err := s3s.walkRepository(s3s.getRepoKey(repoPath), func(obj types.Object) {
+		size += aws.ToInt64(obj.Size)
+	})
+	return size, err
+}
 
-	// List all objects with the repository prefix
+// deletePrefix removes every object whose key starts with prefix
+func (s3s *S3Storage) deletePrefix(prefix string) error {
+	// List all objects with the prefix
 	paginator := awss3.NewListObjectsV2Paginator(s3s.client, &awss3.ListObjectsV2Input{
 		Bucket: aws.String(s3s.bucket),
-		Prefix: aws.String(repoKey + "/"),
+		Prefix: aws.String(prefix),
 	})
 
 	// Delete all objects in batches
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(context.TODO())
 		if err != nil {
-			return fmt.Errorf("failed to list repository objects: %w", err)
+			return fmt.Errorf("failed to list objects: %w", err)
 		}
 
 		if len(page.Contents) == 0 {
@@ -273,11 +258,125 @@ func (s3s *S3Storage) DeleteRepository(repoPath string) error {
 			},
 		})
 		if err != nil {
-			return fmt.Errorf("failed to delete repository objects: %w", err)
+			return fmt.Errorf("failed to delete objects: %w", err)
 		}
 	}
+	return nil
+}
 
-	s3s.Logger.Info().Str("repo", repoPath).Msg("Repository deleted from S3")
+// copyObject copies the object at key, of the given size, to newKey. Objects
+// larger than copyThreshold are copied in parts.
+func (s3s *S3Storage) copyObject(key, newKey string, size int64) error {
+	if size > copyThreshold {
+		return s3s.copyMultipart(key, newKey, size)
+	}
+
+	_, err := s3s.client.CopyObject(context.TODO(), &awss3.CopyObjectInput{
+		Bucket:     aws.String(s3s.bucket),
+		CopySource: aws.String(s3s.copySource(key)),
+		Key:        aws.String(newKey),
+	})
+	return err
+}
+
+// copyMultipart copies the object at key to newKey in parts of copyPartSize,
+// with its metadata. Every part is copied from the same version of the
+// object, and the upload is aborted on failure.
+func (s3s *S3Storage) copyMultipart(key, newKey string, size int64) error {
+	head, err := s3s.client.HeadObject(context.TODO(), &awss3.HeadObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+
+	upload, err := s3s.client.CreateMultipartUpload(context.TODO(), &awss3.CreateMultipartUploadInput{
+		Bucket:   aws.String(s3s.bucket),
+		Key:      aws.String(newKey),
+		Metadata: head.Metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	var parts []types.CompletedPart
+	for offset, number := int64(0), int32(1); offset < size; offset, number = offset+copyPartSize, number+1 {
+		length := min(copyPartSize, size-offset)
+
+		part, err := s3s.client.UploadPartCopy(context.TODO(), &awss3.UploadPartCopyInput{
+			Bucket:            aws.String(s3s.bucket),
+			Key:               aws.String(newKey),
+			UploadId:          upload.UploadId,
+			PartNumber:        aws.Int32(number),
+			CopySource:        aws.String(s3s.copySource(key)),
+			CopySourceIfMatch: head.ETag,
+			CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+		})
+		if err != nil {
+			s3s.abortMultipart(newKey, upload.UploadId)
+			return fmt.Errorf("failed to copy part %d: %w", number, err)
+		}
+
+		parts = append(parts, types.CompletedPart{
+			ETag:       part.CopyPartResult.ETag,
+			PartNumber: aws.Int32(number),
+		})
+	}
+
+	_, err = s3s.client.CompleteMultipartUpload(context.TODO(), &awss3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s3s.bucket),
+		Key:             aws.String(newKey),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		s3s.abortMultipart(newKey, upload.UploadId)
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+func (s3s *S3Storage) abortMultipart(key string, uploadID *string) {
+	_, err := s3s.client.AbortMultipartUpload(context.TODO(), &awss3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s3s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		s3s.Logger.Warn().Err(err).Str("key", key).Msg("Failed to abort multipart upload")
+	}
+}
+
+// copySource returns the escaped copy source of the object at key
+func (s3s *S3Storage) copySource(key string) string {
+	return (&url.URL{Path: s3s.bucket + "/" + key}).EscapedPath()
+}
+
+// listRepository returns the objects of the repository at repoKey, by key
+func (s3s *S3Storage) listRepository(repoKey string) (map[string]types.Object, error) {
+	objects := make(map[string]types.Object)
+	err := s3s.walkRepository(repoKey, func(obj types.Object) {
+		objects[aws.ToString(obj.Key)] = obj
+	})
+	return objects, err
+}
+
+// walkRepository calls fn for every object of the repository at repoKey
+func (s3s *S3Storage) walkRepository(repoKey string, fn func(types.Object)) error {
+	paginator := awss3.NewListObjectsV2Paginator(s3s.client, &awss3.ListObjectsV2Input{
+		Bucket: aws.String(s3s.bucket),
+		Prefix: aws.String(repoKey + "/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return fmt.Errorf("failed to list repository objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			fn(obj)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,137 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/labbs/git-server-s3/pkg/storage/s3/s3test"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStorage(t *testing.T) (*s3test.Server, *S3Storage) {
+	t.Helper()
+
+	fake, client := newTestS3(t)
+	s3s := NewS3Storage(zerolog.Nop())
+	s3s.client = client
+	s3s.bucket = testBucket
+	return fake, s3s
+}
+
+func TestRenameRepository(t *testing.T) {
+	fake, s3s := newTestStorage(t)
+	fake.Set("repositories/team/project.git/HEAD", []byte("ref: refs/heads/main\n"))
+	fake.Set("repositories/team/project.git/config", []byte("[core]\n"))
+	fake.Set("repositories/team/project.git/refs/heads/main", []byte("0123456789012345678901234567890123456789\n"))
+	fake.Set("repositories/team/other.git/HEAD", []byte("ref: refs/heads/main\n"))
+
+	size, err := s3s.RepositorySize("team/project.git")
+	require.NoError(t, err)
+	assert.Equal(t, int64(21+7+41), size)
+
+	assert.Error(t, s3s.RenameRepository("team/project.git", "team/other.git"))
+	assert.Error(t, s3s.RenameRepository("team/missing.git", "archive/project.git"))
+
+	require.NoError(t, s3s.RenameRepository("team/project.git", "archive/project.git"))
+	assert.False(t, s3s.RepositoryExists("team/project.git"))
+	assert.True(t, s3s.RepositoryExists("archive/project.git"))
+	assert.Empty(t, fake.Keys("repositories/team/project.git/"))
+	assert.Equal(t, []string{
+		"repositories/archive/project.git/HEAD",
+		"repositories/archive/project.git/config",
+		"repositories/archive/project.git/refs/heads/main",
+	}, fake.Keys("repositories/archive/"))
+}
+
+// setTestRepository stores a repository with a HEAD, a config and a branch
+func setTestRepository(fake *s3test.Server, repoKey string) {
+	fake.Set(repoKey+"/HEAD", []byte("ref: refs/heads/main\n"))
+	fake.Set(repoKey+"/config", []byte("[core]\n"))
+	fake.Set(repoKey+"/refs/heads/main", []byte("0123456789012345678901234567890123456789\n"))
+}
+
+func TestRenameRepository_FailedCopy(t *testing.T) {
+	fake, s3s := newTestStorage(t)
+	setTestRepository(fake, "repositories/team/project.git")
+
+	fake.Fail = func(r *http.Request, key string) int {
+		if r.Method == http.MethodPut && key == "repositories/archive/project.git/HEAD" {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+	require.Error(t, s3s.RenameRepository("team/project.git", "archive/project.git"))
+	fake.Fail = nil
+
+	assert.Empty(t, fake.Keys("repositories/archive/"), "the partial copy is deleted")
+	assert.Len(t, fake.Keys("repositories/team/project.git/"), 3)
+	assert.True(t, s3s.RepositoryExists("team/project.git"))
+}
+
+func TestRenameRepository_ConcurrentPush(t *testing.T) {
+	fake, s3s := newTestStorage(t)
+	setTestRepository(fake, "repositories/team/project.git")
+
+	pushed := []byte("abcdefabcdefabcdefabcdefabcdefabcdefabcd\n")
+	fake.Fail = func(r *http.Request, key string) int {
+		if r.Method == http.MethodPut && key == "repositories/archive/project.git/HEAD" {
+			fake.Set("repositories/team/project.git/refs/heads/main", pushed)
+		}
+		return 0
+	}
+	err := s3s.RenameRepository("team/project.git", "archive/project.git")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "updated during the rename")
+
+	assert.Empty(t, fake.Keys("repositories/archive/"))
+	out, err := s3s.client.GetObject(context.Background(), &awss3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("repositories/team/project.git/refs/heads/main"),
+	})
+	require.NoError(t, err)
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	require.NoError(t, err)
+	assert.Equal(t, pushed, data, "the push is kept")
+}
+
+func TestRenameRepository_LargeObject(t *testing.T) {
+	prevThreshold, prevPartSize := copyThreshold, copyPartSize
+	copyThreshold, copyPartSize = 32, 8
+	t.Cleanup(func() { copyThreshold, copyPartSize = prevThreshold, prevPartSize })
+
+	fake, s3s := newTestStorage(t)
+	fake.Set("repositories/team/project.git/HEAD", []byte("ref: refs/heads/main\n"))
+	fake.Set("repositories/team/project.git/objects/pack/pack-1.pack", []byte("0123456789abcdefghijklmnopqrstuvwxyz"))
+
+	require.NoError(t, s3s.RenameRepository("team/project.git", "archive/project.git"))
+	assert.Equal(t, int64(5), fake.Count("PUT part copy"), "only the pack is copied in parts")
+
+	out, err := s3s.client.GetObject(context.Background(), &awss3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("repositories/archive/project.git/objects/pack/pack-1.pack"),
+	})
+	require.NoError(t, err)
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdefghijklmnopqrstuvwxyz", string(data))
+}
+
+func TestCheck(t *testing.T) {
+	fake, s3s := newTestStorage(t)
+
+	require.NoError(t, s3s.Check(context.Background()))
+	assert.Empty(t, fake.Keys(""), "the canary is deleted")
+
+	s3s.bucket = "missing-bucket"
+	err := s3s.Check(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to write the canary")
+}
@@ -0,0 +1,57 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Check writes, reads back and deletes a canary object outside of the
+// repositories, checking that the bucket is reachable and writable
+func (s3s *S3Storage) Check(ctx context.Context) error {
+	canary := make([]byte, 16)
+	if _, err := rand.Read(canary); err != nil {
+		return err
+	}
+	key := aws.String("health/" + hex.EncodeToString(canary))
+
+	if _, err := s3s.client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    key,
+		Body:   bytes.NewReader(canary),
+	}); err != nil {
+		return fmt.Errorf("failed to write the canary: %w", err)
+	}
+
+	obj, err := s3s.client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    key,
+	})
+	if err == nil {
+		var data []byte
+		data, err = io.ReadAll(obj.Body)
+		_ = obj.Body.Close()
+		if err == nil && !bytes.Equal(data, canary) {
+			err = errors.New("content mismatch")
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to read the canary: %w", err)
+	}
+
+	// The canary is deleted even when it could not be read back
+	if _, derr := s3s.client.DeleteObject(ctx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    key,
+	}); derr != nil && err == nil {
+		err = fmt.Errorf("failed to delete the canary: %w", derr)
+	}
+	return err
+}
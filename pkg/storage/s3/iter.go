@@ -0,0 +1,264 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// objectPrefetch is the number of loose objects fetched ahead of the consumer
+// of an objectIter. It bounds both the concurrent requests and the memory held
+// by objects that were fetched but not consumed yet.
+var objectPrefetch = 8
+
+// objectFetch is the pending result of fetching a single loose object.
+// obj is nil when the object was skipped because of its type.
+type objectFetch struct {
+	obj plumbing.EncodedObject
+	err error
+}
+
+// objectIter iterates over the objects of a repository without loading them
+// all in memory. Loose objects are listed page by page and fetched by a
+// background goroutine, at most objectPrefetch objects ahead, in listing
+// order. Packed objects are then read pack by pack from their index.
+// The iterator must be closed, or consumed until io.EOF, to release the
+// prefetching goroutine.
+type objectIter struct {
+	storer *S3Storer
+	typ    plumbing.ObjectType
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pending chan chan objectFetch
+	fetches sync.WaitGroup
+	done    chan struct{}
+
+	packs      []*packInfo
+	packsReady bool
+	entries    []plumbing.Hash
+}
+
+func newObjectIter(s *S3Storer, t plumbing.ObjectType) *objectIter {
+	ctx, cancel := context.WithCancel(s.ctx)
+	iter := &objectIter{
+		storer:  s,
+		typ:     t,
+		ctx:     ctx,
+		cancel:  cancel,
+		pending: make(chan chan objectFetch, objectPrefetch),
+		done:    make(chan struct{}),
+	}
+
+	go iter.prefetch()
+	return iter
+}
+
+// prefetch lists the loose objects and starts fetching each of them, blocking
+// while objectPrefetch fetches are waiting to be consumed.
+func (iter *objectIter) prefetch() {
+	defer close(iter.done)
+	defer close(iter.pending)
+
+	s := iter.storer
+	prefix := s.getObjectKey("objects") + "/"
+
+	paginator := awss3.NewListObjectsV2Paginator(s.client, &awss3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(iter.ctx)
+		if err != nil {
+			iter.push(func() objectFetch {
+				return objectFetch{err: fmt.Errorf("failed to list objects: %w", err)}
+			})
+			return
+		}
+
+		for _, obj := range page.Contents {
+			// Loose objects are stored as objects/ab/cdef...
+			parts := strings.Split(strings.TrimPrefix(aws.ToString(obj.Key), prefix), "/")
+			if len(parts) != 2 || len(parts[0]) != 2 || parts[0] == "pack" {
+				continue
+			}
+
+			hash := plumbing.NewHash(parts[0] + parts[1])
+			if !iter.push(func() objectFetch { return iter.fetch(hash) }) {
+				return
+			}
+		}
+	}
+}
+
+// push queues a fetch and runs it in the background. It returns false when
+// the iterator has been closed.
+func (iter *objectIter) push(fetch func() objectFetch) bool {
+	result := make(chan objectFetch, 1)
+	select {
+	case iter.pending <- result:
+	case <-iter.ctx.Done():
+		return false
+	}
+
+	iter.fetches.Add(1)
+	go func() {
+		defer iter.fetches.Done()
+		result <- fetch()
+	}()
+	return true
+}
+
+// fetch returns a loose object, or a nil object if it does not have the
+// requested type. When filtering by type, the git-type metadata is checked
+// with a HEAD request so that the content of skipped objects is never downloaded.
+func (iter *objectIter) fetch(hash plumbing.Hash) objectFetch {
+	s := iter.storer
+
+	if iter.typ != plumbing.AnyObject {
+		result, err := s.client.HeadObject(iter.ctx, &awss3.HeadObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.getObjectKey(fmt.Sprintf("objects/%s/%s", hash.String()[:2], hash.String()[2:]))),
+		})
+		if isNotFound(err) {
+			// The object was deleted after being listed
+			return objectFetch{}
+		}
+		if err != nil {
+			return objectFetch{err: fmt.Errorf("failed to head object %s: %w", hash, err)}
+		}
+		if metadataObjectType(result.Metadata, plumbing.AnyObject) != iter.typ {
+			return objectFetch{}
+		}
+	}
+
+	obj, err := s.EncodedObject(iter.typ, hash)
+	if err == plumbing.ErrObjectNotFound {
+		return objectFetch{}
+	}
+	return objectFetch{obj: obj, err: err}
+}
+
+// Next returns the next object, or io.EOF once every object has been returned.
+func (iter *objectIter) Next() (plumbing.EncodedObject, error) {
+	if iter.ctx.Err() != nil {
+		return nil, io.EOF
+	}
+
+	for result := range iter.pending {
+		fetched := <-result
+		if fetched.err != nil {
+			iter.Close()
+			return nil, fetched.err
+		}
+		if fetched.obj != nil {
+			return fetched.obj, nil
+		}
+	}
+
+	return iter.nextPacked()
+}
+
+// nextPacked returns the next packed object with the requested type.
+func (iter *objectIter) nextPacked() (plumbing.EncodedObject, error) {
+	if !iter.packsReady {
+		packs, err := iter.storer.packList()
+		if err != nil {
+			return nil, err
+		}
+		iter.packs = packs
+		iter.packsReady = true
+	}
+
+	for {
+		for len(iter.entries) > 0 {
+			hash := iter.entries[0]
+			iter.entries = iter.entries[1:]
+
+			obj, err := iter.storer.packedObjectIn(iter.packs[0], hash)
+			if err != nil {
+				return nil, err
+			}
+			if iter.typ == plumbing.AnyObject || obj.Type() == iter.typ {
+				return obj, nil
+			}
+		}
+
+		if iter.entries != nil {
+			iter.packs = iter.packs[1:]
+			iter.entries = nil
+		}
+		if len(iter.packs) == 0 {
+			return nil, io.EOF
+		}
+
+		entries, err := packEntries(iter.packs[0])
+		if err != nil {
+			return nil, err
+		}
+		iter.entries = entries
+	}
+}
+
+// packEntries returns the hashes of the objects stored in a pack.
+func packEntries(p *packInfo) ([]plumbing.Hash, error) {
+	entries, err := p.index.Entries()
+	if err != nil {
+		return nil, err
+	}
+	defer entries.Close()
+
+	hashes := []plumbing.Hash{}
+	for {
+		entry, err := entries.Next()
+		if err == io.EOF {
+			return hashes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, entry.Hash)
+	}
+}
+
+// ForEach calls cb for each object until cb returns an error.
+// storer.ErrStop stops the iteration without error.
+func (iter *objectIter) ForEach(cb func(plumbing.EncodedObject) error) error {
+	defer iter.Close()
+
+	for {
+		obj, err := iter.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := cb(obj); err != nil {
+			if err == storer.ErrStop {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Close stops prefetching and waits for the fetches in flight to be cancelled.
+func (iter *objectIter) Close() {
+	iter.cancel()
+	<-iter.done
+	iter.fetches.Wait()
+
+	iter.packs = nil
+	iter.packsReady = true
+	iter.entries = nil
+}
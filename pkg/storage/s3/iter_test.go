@@ -0,0 +1,145 @@
+package s3
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func storeObject(t *testing.T, s *S3Storer, typ plumbing.ObjectType, content string) plumbing.Hash {
+	t.Helper()
+
+	obj := &plumbing.MemoryObject{}
+	obj.SetType(typ)
+	_, err := obj.Write([]byte(content))
+	require.NoError(t, err)
+
+	hash, err := s.SetEncodedObject(obj)
+	require.NoError(t, err)
+	return hash
+}
+
+func collect(t *testing.T, s *S3Storer, typ plumbing.ObjectType) []plumbing.Hash {
+	t.Helper()
+
+	iter, err := s.IterEncodedObjects(typ)
+	require.NoError(t, err)
+
+	var hashes []plumbing.Hash
+	require.NoError(t, iter.ForEach(func(obj plumbing.EncodedObject) error {
+		hashes = append(hashes, obj.Hash())
+		return nil
+	}))
+	return hashes
+}
+
+func TestIterEncodedObjects_LooseAndPacked(t *testing.T) {
+	_, s := newTestStorer(t, "repositories/test.git")
+
+	source, pack := buildTestPack(t)
+	require.NoError(t, packfile.UpdateObjectStorage(s, bytes.NewReader(pack)))
+	loose := storeObject(t, s, plumbing.BlobObject, "loose blob")
+
+	want := []plumbing.Hash{loose}
+	iter, err := source.IterEncodedObjects(plumbing.AnyObject)
+	require.NoError(t, err)
+	require.NoError(t, iter.ForEach(func(obj plumbing.EncodedObject) error {
+		want = append(want, obj.Hash())
+		return nil
+	}))
+
+	assert.ElementsMatch(t, want, collect(t, s, plumbing.AnyObject))
+
+	commits := collect(t, s, plumbing.CommitObject)
+	assert.Len(t, commits, 2)
+	for _, h := range commits {
+		_, err := source.EncodedObject(plumbing.CommitObject, h)
+		assert.NoError(t, err)
+	}
+}
+
+func TestIterEncodedObjects_TypeFilterSkipsBodies(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+
+	for i := 0; i < 3; i++ {
+		storeObject(t, s, plumbing.BlobObject, fmt.Sprintf("blob %d", i))
+	}
+	tag := storeObject(t, s, plumbing.TagObject, "tag")
+
+	assert.Equal(t, []plumbing.Hash{tag}, collect(t, s, plumbing.TagObject))
+	assert.Equal(t, int64(4), fake.Count("HEAD object"))
+	assert.Equal(t, int64(1), fake.Count("GET object"))
+}
+
+func TestIterEncodedObjects_Paginated(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+
+	var want []plumbing.Hash
+	for i := 0; i < 25; i++ {
+		want = append(want, storeObject(t, s, plumbing.BlobObject, fmt.Sprintf("blob %d", i)))
+	}
+
+	fake.MaxKeys = 10
+	assert.ElementsMatch(t, want, collect(t, s, plumbing.AnyObject))
+	// Three pages of loose objects, plus the listing of the packs
+	assert.Equal(t, int64(4), fake.Count("GET list"))
+}
+
+func TestIterEncodedObjects_CloseStopsPrefetch(t *testing.T) {
+	prev := objectPrefetch
+	objectPrefetch = 2
+	t.Cleanup(func() { objectPrefetch = prev })
+
+	fake, s := newTestStorer(t, "repositories/test.git")
+	for i := 0; i < 20; i++ {
+		storeObject(t, s, plumbing.BlobObject, fmt.Sprintf("blob %d", i))
+	}
+
+	iter, err := s.IterEncodedObjects(plumbing.AnyObject)
+	require.NoError(t, err)
+	_, err = iter.Next()
+	require.NoError(t, err)
+	iter.Close()
+
+	_, err = iter.Next()
+	assert.Equal(t, io.EOF, err)
+
+	assert.LessOrEqual(t, fake.Count("GET object"), int64(objectPrefetch+2))
+}
+
+func TestIterEncodedObjects_HeadErrors(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+
+	tag := storeObject(t, s, plumbing.TagObject, "tag")
+	deleted := storeObject(t, s, plumbing.TagObject, "deleted tag")
+	failed := storeObject(t, s, plumbing.BlobObject, "failed blob")
+
+	// An object deleted after the listing is skipped
+	fake.Fail = func(r *http.Request, key string) int {
+		if r.Method == http.MethodHead && strings.HasSuffix(key, deleted.String()[2:]) {
+			return http.StatusNotFound
+		}
+		return 0
+	}
+	assert.Equal(t, []plumbing.Hash{tag}, collect(t, s, plumbing.TagObject))
+
+	// Any other error ends the iteration
+	fake.Fail = func(r *http.Request, key string) int {
+		if r.Method == http.MethodHead && strings.HasSuffix(key, failed.String()[2:]) {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+	iter, err := s.IterEncodedObjects(plumbing.TagObject)
+	require.NoError(t, err)
+	err = iter.ForEach(func(plumbing.EncodedObject) error { return nil })
+	assert.ErrorContains(t, err, failed.String())
+}
@@ -0,0 +1,145 @@
+package s3
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// lfsPrefix is the prefix of the keys of the LFS objects, apart from the
+// repositories
+const lfsPrefix = "lfs/"
+
+// LFSStorage stores the Git LFS objects in the bucket of the repositories, at
+// lfs/<repo>.git/<oid[0:2]>/<oid[2:4]>/<oid>. Objects are uploaded from
+// files, in parts when large, and downloaded as streams.
+type LFSStorage struct {
+	s3s     *S3Storage
+	presign *awss3.PresignClient
+}
+
+// LFSStorage returns the LFS storage of the bucket, the storage must be configured
+func (s3s *S3Storage) LFSStorage() *LFSStorage {
+	return &LFSStorage{s3s: s3s, presign: awss3.NewPresignClient(s3s.client)}
+}
+
+func (s *LFSStorage) Stat(repoPath, oid string) (int64, error) {
+	head, err := s.s3s.client.HeadObject(context.TODO(), &awss3.HeadObjectInput{
+		Bucket: aws.String(s.s3s.bucket),
+		Key:    aws.String(s.objectKey(repoPath, oid)),
+	})
+	if err != nil {
+		return 0, lfsError(err, oid)
+	}
+	return aws.ToInt64(head.ContentLength), nil
+}
+
+func (s *LFSStorage) Open(repoPath, oid string) (io.ReadCloser, int64, error) {
+	obj, err := s.s3s.client.GetObject(context.TODO(), &awss3.GetObjectInput{
+		Bucket: aws.String(s.s3s.bucket),
+		Key:    aws.String(s.objectKey(repoPath, oid)),
+	})
+	if err != nil {
+		return nil, 0, lfsError(err, oid)
+	}
+	return obj.Body, aws.ToInt64(obj.ContentLength), nil
+}
+
+func (s *LFSStorage) Put(repoPath, oid string, f *os.File, size int64) error {
+	storer := newS3Storer(s.s3s.client, s.s3s.bucket, "", s.s3s.Logger, nil)
+	return storer.putFile(s.objectKey(repoPath, oid), f, size, nil)
+}
+
+func (s *LFSStorage) RenameRepository(oldPath, newPath string) error {
+	oldKey := s.repoKey(oldPath)
+	newKey := s.repoKey(newPath)
+
+	objects, err := s.s3s.listRepository(oldKey)
+	if err != nil {
+		return err
+	}
+	for key, obj := range objects {
+		if err := s.s3s.copyObject(key, newKey+strings.TrimPrefix(key, oldKey), aws.ToInt64(obj.Size)); err != nil {
+			return fmt.Errorf("failed to copy LFS object %s: %w", key, err)
+		}
+	}
+	return s.s3s.deletePrefix(oldKey + "/")
+}
+
+func (s *LFSStorage) DeleteRepository(repoPath string) error {
+	return s.s3s.deletePrefix(s.repoKey(repoPath) + "/")
+}
+
+// PresignDownload returns a URL to download an object from the bucket
+func (s *LFSStorage) PresignDownload(repoPath, oid string, expiry time.Duration) (string, map[string]string, error) {
+	req, err := s.presign.PresignGetObject(context.TODO(), &awss3.GetObjectInput{
+		Bucket: aws.String(s.s3s.bucket),
+		Key:    aws.String(s.objectKey(repoPath, oid)),
+	}, awss3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", nil, err
+	}
+	return req.URL, signedHeaders(req.SignedHeader), nil
+}
+
+// PresignUpload returns a URL to upload an object to the bucket. The URL is
+// signed with the size and SHA-256 checksum of the content, so that S3
+// rejects any other content.
+func (s *LFSStorage) PresignUpload(repoPath, oid string, size int64, expiry time.Duration) (string, map[string]string, error) {
+	sum, err := hex.DecodeString(oid)
+	if err != nil {
+		return "", nil, err
+	}
+	req, err := s.presign.PresignPutObject(context.TODO(), &awss3.PutObjectInput{
+		Bucket:         aws.String(s.s3s.bucket),
+		Key:            aws.String(s.objectKey(repoPath, oid)),
+		ContentLength:  aws.Int64(size),
+		ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sum)),
+	}, awss3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", nil, err
+	}
+	return req.URL, signedHeaders(req.SignedHeader), nil
+}
+
+// signedHeaders returns the headers a presigned request must be sent with,
+// but those set by the HTTP client
+func signedHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string)
+	for name := range header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Host", "Content-Length":
+		default:
+			headers[name] = header.Get(name)
+		}
+	}
+	return headers
+}
+
+// repoKey returns the key prefix of the objects of a repository
+func (s *LFSStorage) repoKey(repoPath string) string {
+	return lfsPrefix + strings.TrimPrefix(s.s3s.getRepoKey(repoPath), "repositories/")
+}
+
+// objectKey returns the key of an object, oid is a valid SHA-256
+func (s *LFSStorage) objectKey(repoPath, oid string) string {
+	return s.repoKey(repoPath) + "/" + oid[0:2] + "/" + oid[2:4] + "/" + oid
+}
+
+// lfsError returns fs.ErrNotExist for a missing object, err otherwise
+func lfsError(err error, oid string) error {
+	if isNotFound(err) {
+		return fmt.Errorf("LFS object %s: %w", oid, fs.ErrNotExist)
+	}
+	return err
+}
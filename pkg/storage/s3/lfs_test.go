@@ -0,0 +1,70 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLFSStorage(t *testing.T) {
+	setThresholds(t, 1<<20, 64, 32)
+	fake, s3s := newTestStorage(t)
+	lfs := s3s.LFSStorage()
+
+	content := bytes.Repeat([]byte("texture "), 20)
+	sum := sha256.Sum256(content)
+	oid := hex.EncodeToString(sum[:])
+	key := "lfs/team/game.git/" + oid[0:2] + "/" + oid[2:4] + "/" + oid
+
+	_, err := lfs.Stat("team/game.git", oid)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, _, err = lfs.Open("team/game.git", oid)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	path := filepath.Join(t.TempDir(), "object")
+	require.NoError(t, os.WriteFile(path, content, 0644))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Large objects are uploaded in parts from the file
+	require.NoError(t, lfs.Put("team/game.git", oid, f, int64(len(content))))
+	assert.EqualValues(t, 1, fake.Count("POST complete multipart"))
+	assert.Equal(t, []string{key}, fake.Keys("lfs/"))
+
+	size, err := lfs.Stat("team/game.git", oid)
+	require.NoError(t, err)
+	assert.EqualValues(t, len(content), size)
+	r, size, err := lfs.Open("team/game.git", oid)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, content, data)
+	assert.EqualValues(t, len(content), size)
+
+	href, header, err := lfs.PresignUpload("team/game.git", oid, size, time.Minute)
+	require.NoError(t, err)
+	assert.Contains(t, href, "/"+testBucket+"/"+key+"?")
+	assert.Contains(t, href, "X-Amz-Expires=60")
+	// S3 checks the content against the signed checksum
+	assert.Contains(t, href, "X-Amz-Checksum-Sha256=")
+	assert.Empty(t, header)
+	href, _, err = lfs.PresignDownload("team/game.git", oid, time.Minute)
+	require.NoError(t, err)
+	assert.Contains(t, href, "/"+testBucket+"/"+key+"?")
+
+	require.NoError(t, lfs.RenameRepository("team/game.git", "archive/game.git"))
+	assert.Equal(t, []string{"lfs/archive/game.git/" + oid[0:2] + "/" + oid[2:4] + "/" + oid}, fake.Keys("lfs/"))
+	require.NoError(t, lfs.DeleteRepository("archive/game.git"))
+	assert.Empty(t, fake.Keys("lfs/"))
+}
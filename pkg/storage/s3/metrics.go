@@ -0,0 +1,26 @@
+package s3
+
+import (
+	"context"
+	"time"
+
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/labbs/git-server-s3/pkg/metrics"
+)
+
+// metricsMiddleware records the S3 API calls, their latency, retries
+// included, and their errors
+var metricsMiddleware = middleware.InitializeMiddlewareFunc("Metrics", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	start := time.Now()
+	out, md, err := next.HandleInitialize(ctx, in)
+	metrics.ObserveS3(middleware.GetOperationName(ctx), time.Since(start), err)
+	return out, md, err
+})
+
+// withMetrics is an option of the S3 clients recording their calls in the metrics
+func withMetrics(o *awss3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(metricsMiddleware, middleware.Before)
+	})
+}
@@ -0,0 +1,57 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/labbs/git-server-s3/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// s3Metric returns the value of an S3 counter for the operation
+func s3Metric(t *testing.T, name, operation string) float64 {
+	t.Helper()
+
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "operation" && label.GetValue() == operation {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	_, client := newTestS3(t)
+	ctx := context.Background()
+
+	puts := s3Metric(t, "git_server_s3_requests_total", "PutObject")
+	getErrors := s3Metric(t, "git_server_s3_request_errors_total", "GetObject")
+
+	_, err := client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("metrics/object"),
+		Body:   bytes.NewReader([]byte("data")),
+	})
+	require.NoError(t, err)
+	_, err = client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("metrics/missing"),
+	})
+	require.Error(t, err)
+
+	assert.Equal(t, puts+1, s3Metric(t, "git_server_s3_requests_total", "PutObject"))
+	assert.Equal(t, getErrors+1, s3Metric(t, "git_server_s3_request_errors_total", "GetObject"))
+}
@@ -0,0 +1,208 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Thresholds are variables so that tests can exercise the large object paths
+// without allocating hundreds of megabytes.
+var (
+	// largeObjectThreshold is the size above which objects are streamed
+	// instead of being loaded in memory.
+	largeObjectThreshold int64 = 1 << 20
+
+	// multipartThreshold is the size above which uploads use multipart uploads.
+	multipartThreshold int64 = 64 << 20
+
+	// multipartPartSize is the size of each part of a multipart upload.
+	multipartPartSize int64 = 64 << 20
+
+	// copyThreshold is the size above which objects are copied in parts,
+	// CopyObject is limited to 5 GiB.
+	copyThreshold int64 = 5 << 30
+
+	// copyPartSize is the size of each part of a multipart copy.
+	copyPartSize int64 = 512 << 20
+)
+
+// s3Object is a loose object whose content is streamed from S3 when read.
+// It is returned for objects larger than largeObjectThreshold so that reading
+// them never requires holding the whole content in memory.
+type s3Object struct {
+	storer *S3Storer
+	key    string
+	hash   plumbing.Hash
+	typ    plumbing.ObjectType
+	size   int64
+}
+
+func (o *s3Object) Hash() plumbing.Hash {
+	return o.hash
+}
+
+func (o *s3Object) Type() plumbing.ObjectType {
+	return o.typ
+}
+
+func (o *s3Object) SetType(t plumbing.ObjectType) {
+	o.typ = t
+}
+
+func (o *s3Object) Size() int64 {
+	return o.size
+}
+
+func (o *s3Object) SetSize(size int64) {
+	o.size = size
+}
+
+// Reader returns the S3 response body of the object
+func (o *s3Object) Reader() (io.ReadCloser, error) {
+	result, err := o.storer.client.GetObject(o.storer.ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(o.storer.bucket),
+		Key:    aws.String(o.key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %s: %w", o.hash, err)
+	}
+	return result.Body, nil
+}
+
+// Writer is not supported, objects stored in S3 are immutable
+func (o *s3Object) Writer() (io.WriteCloser, error) {
+	return nil, errors.New("objects stored in S3 are read-only")
+}
+
+// metadataObjectType returns the object type stored in the git-type metadata, or t if absent
+func metadataObjectType(metadata map[string]string, t plumbing.ObjectType) plumbing.ObjectType {
+	if gitType, exists := metadata["git-type"]; exists {
+		switch gitType {
+		case "commit":
+			return plumbing.CommitObject
+		case "tree":
+			return plumbing.TreeObject
+		case "blob":
+			return plumbing.BlobObject
+		case "tag":
+			return plumbing.TagObject
+		}
+	}
+	return t
+}
+
+// totalSize returns the complete size of an object from a Content-Range header
+func totalSize(contentRange string) (int64, bool) {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	return size, err == nil
+}
+
+// isInvalidRange reports whether err is the S3 answer to a ranged GET on an empty object
+func isInvalidRange(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange"
+}
+
+// isNotFound reports whether err is the S3 answer to a request on a missing key
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound)
+}
+
+// putFile uploads the content of f, switching to a multipart upload for large files
+func (s *S3Storer) putFile(key string, f *os.File, size int64, metadata map[string]string) error {
+	if size > multipartThreshold {
+		return s.putMultipart(key, f, size, metadata)
+	}
+
+	_, err := s.client.PutObject(s.ctx, &awss3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          io.NewSectionReader(f, 0, size),
+		ContentLength: aws.Int64(size),
+		Metadata:      metadata,
+	})
+	return err
+}
+
+// putMultipart uploads the content of f in parts of multipartPartSize, reading
+// each part directly from the file. The upload is aborted on failure so that
+// no orphan parts are left behind.
+func (s *S3Storer) putMultipart(key string, f *os.File, size int64, metadata map[string]string) error {
+	upload, err := s.client.CreateMultipartUpload(s.ctx, &awss3.CreateMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		Metadata: metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	var parts []types.CompletedPart
+	for offset, number := int64(0), int32(1); offset < size; offset, number = offset+multipartPartSize, number+1 {
+		length := min(multipartPartSize, size-offset)
+
+		part, err := s.client.UploadPart(s.ctx, &awss3.UploadPartInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(key),
+			UploadId:      upload.UploadId,
+			PartNumber:    aws.Int32(number),
+			Body:          io.NewSectionReader(f, offset, length),
+			ContentLength: aws.Int64(length),
+		})
+		if err != nil {
+			s.abortMultipart(key, upload.UploadId)
+			return fmt.Errorf("failed to upload part %d: %w", number, err)
+		}
+
+		parts = append(parts, types.CompletedPart{
+			ETag:       part.ETag,
+			PartNumber: aws.Int32(number),
+		})
+	}
+
+	_, err = s.client.CompleteMultipartUpload(s.ctx, &awss3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		s.abortMultipart(key, upload.UploadId)
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	s.logger.Debug().
+		Str("key", key).
+		Int64("size", size).
+		Int("parts", len(parts)).
+		Msg("Multipart upload completed")
+
+	return nil
+}
+
+func (s *S3Storer) abortMultipart(key string, uploadID *string) {
+	_, err := s.client.AbortMultipartUpload(s.ctx, &awss3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		s.logger.Warn().Err(err).Str("key", key).Msg("Failed to abort multipart upload")
+	}
+}
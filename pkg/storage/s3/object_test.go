@@ -0,0 +1,118 @@
+package s3
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setThresholds lowers the large object and multipart thresholds for the
+// duration of a test.
+func setThresholds(t *testing.T, large, multipart, partSize int64) {
+	t.Helper()
+
+	prevLarge, prevMultipart, prevPartSize := largeObjectThreshold, multipartThreshold, multipartPartSize
+	largeObjectThreshold, multipartThreshold, multipartPartSize = large, multipart, partSize
+	t.Cleanup(func() {
+		largeObjectThreshold, multipartThreshold, multipartPartSize = prevLarge, prevMultipart, prevPartSize
+	})
+}
+
+func newBlob(t *testing.T, content []byte) plumbing.EncodedObject {
+	t.Helper()
+
+	obj := &plumbing.MemoryObject{}
+	obj.SetType(plumbing.BlobObject)
+	_, err := obj.Write(content)
+	require.NoError(t, err)
+	return obj
+}
+
+func randomContent(size int) []byte {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(content)
+	return content
+}
+
+func TestEncodedObject_LargeLooseObjectIsStreamed(t *testing.T) {
+	setThresholds(t, 1024, 1<<20, 1<<20)
+	fake, s := newTestStorer(t, "repositories/test.git")
+
+	content := randomContent(5000)
+	hash, err := s.SetEncodedObject(newBlob(t, content))
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.ComputeHash(plumbing.BlobObject, content), hash)
+
+	obj, err := s.EncodedObject(plumbing.AnyObject, hash)
+	require.NoError(t, err)
+	assert.IsType(t, &s3Object{}, obj)
+	assert.Equal(t, plumbing.BlobObject, obj.Type())
+	assert.Equal(t, int64(len(content)), obj.Size())
+
+	// Only the first range was fetched until the content is read
+	assert.Equal(t, int64(1), fake.Count("GET object"))
+	assert.Equal(t, content, readObject(t, obj))
+}
+
+func TestEncodedObject_EmptyObject(t *testing.T) {
+	_, s := newTestStorer(t, "repositories/test.git")
+
+	hash, err := s.SetEncodedObject(newBlob(t, nil))
+	require.NoError(t, err)
+
+	obj, err := s.EncodedObject(plumbing.BlobObject, hash)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), obj.Size())
+	assert.Empty(t, readObject(t, obj))
+}
+
+func TestSetEncodedObject_MultipartUpload(t *testing.T) {
+	setThresholds(t, 1024, 4096, 2048)
+	fake, s := newTestStorer(t, "repositories/test.git")
+
+	content := randomContent(5000)
+	hash, err := s.SetEncodedObject(newBlob(t, content))
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), fake.Count("POST create multipart"))
+	assert.Equal(t, int64(3), fake.Count("PUT part"))
+	assert.Equal(t, int64(1), fake.Count("POST complete multipart"))
+	assert.Equal(t, int64(0), fake.Count("PUT object"))
+
+	obj, err := s.EncodedObject(plumbing.AnyObject, hash)
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.BlobObject, obj.Type())
+	assert.Equal(t, content, readObject(t, obj))
+}
+
+func TestEncodedObject_LargePackedObjectIsStreamed(t *testing.T) {
+	setThresholds(t, 32<<10, 1<<20, 1<<20)
+	_, s := newTestStorer(t, "repositories/test.git")
+
+	source := memory.NewStorage()
+	content := randomContent(100 << 10)
+	hash, err := source.SetEncodedObject(newBlob(t, content))
+	require.NoError(t, err)
+
+	var pack bytes.Buffer
+	_, err = packfile.NewEncoder(&pack, source, false).Encode([]plumbing.Hash{hash}, 10)
+	require.NoError(t, err)
+
+	w, err := s.PackfileWriter()
+	require.NoError(t, err)
+	_, err = w.Write(pack.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	obj, err := s.EncodedObject(plumbing.BlobObject, hash)
+	require.NoError(t, err)
+	assert.IsType(t, &packfile.FSObject{}, obj)
+	assert.Equal(t, int64(len(content)), obj.Size())
+	assert.Equal(t, content, readObject(t, obj))
+}
@@ -0,0 +1,808 @@
+package s3
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+)
+
+const (
+	// packPrefix is the key prefix, relative to the repository, under which
+	// packfiles and their indexes are stored.
+	packPrefix = "objects/pack/"
+
+	// packBlockSize is the size of a single ranged GET issued when reading a packfile.
+	packBlockSize = 1 << 20
+
+	// packBlockCount is the number of blocks kept in memory per open packfile.
+	packBlockCount = 16
+
+	// packHeaderSize is the size of the header of a packfile: signature,
+	// version and number of objects.
+	packHeaderSize = 12
+)
+
+// packIndexCache holds decoded pack indexes shared by every storer of an S3Storage.
+// Packs are immutable and named after their checksum, so entries never need invalidation.
+type packIndexCache struct {
+	mu      sync.RWMutex
+	indexes map[string]*idxfile.MemoryIndex
+}
+
+func newPackIndexCache() *packIndexCache {
+	return &packIndexCache{
+		indexes: make(map[string]*idxfile.MemoryIndex),
+	}
+}
+
+func (c *packIndexCache) get(key string) (*idxfile.MemoryIndex, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	idx, ok := c.indexes[key]
+	return idx, ok
+}
+
+func (c *packIndexCache) put(key string, idx *idxfile.MemoryIndex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.indexes[key] = idx
+}
+
+// packInfo describes a packfile stored in S3.
+type packInfo struct {
+	checksum plumbing.Hash
+	size     int64
+	index    *idxfile.MemoryIndex
+	pack     *packfile.Packfile
+	blocks   *blockCache
+}
+
+func packKey(checksum plumbing.Hash) string {
+	return fmt.Sprintf("%spack-%s.pack", packPrefix, checksum)
+}
+
+func idxKey(checksum plumbing.Hash) string {
+	return fmt.Sprintf("%spack-%s.idx", packPrefix, checksum)
+}
+
+// loadPacks lists the packfiles of the repository and loads their indexes.
+// The listing is done once per storer; packs written through this storer are
+// registered directly by the pack writer.
+func (s *S3Storer) loadPacks() error {
+	if s.packsLoaded {
+		return nil
+	}
+
+	prefix := s.getObjectKey(packPrefix) + "/"
+	sizes := make(map[plumbing.Hash]int64)
+	var indexes []plumbing.Hash
+
+	paginator := awss3.NewListObjectsV2Paginator(s.client, &awss3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(s.ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list packfiles: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
+			if !strings.HasPrefix(name, "pack-") {
+				continue
+			}
+
+			switch {
+			case strings.HasSuffix(name, ".pack"):
+				h := plumbing.NewHash(strings.TrimSuffix(strings.TrimPrefix(name, "pack-"), ".pack"))
+				sizes[h] = aws.ToInt64(obj.Size)
+			case strings.HasSuffix(name, ".idx"):
+				h := plumbing.NewHash(strings.TrimSuffix(strings.TrimPrefix(name, "pack-"), ".idx"))
+				indexes = append(indexes, h)
+			}
+		}
+	}
+
+	for _, checksum := range indexes {
+		size, ok := sizes[checksum]
+		if !ok {
+			// The index is uploaded after the pack, so an index without its pack
+			// is a leftover from an interrupted deletion.
+			continue
+		}
+
+		idx, err := s.packIndex(checksum)
+		if err != nil {
+			return err
+		}
+
+		s.packs[checksum] = &packInfo{checksum: checksum, size: size, index: idx}
+	}
+
+	s.packsLoaded = true
+	return nil
+}
+
+// packIndex returns the decoded index of a pack, downloading it on a cache miss.
+func (s *S3Storer) packIndex(checksum plumbing.Hash) (*idxfile.MemoryIndex, error) {
+	key := s.getObjectKey(idxKey(checksum))
+	if idx, ok := s.indexCache.get(key); ok {
+		return idx, nil
+	}
+
+	result, err := s.client.GetObject(s.ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pack index %s: %w", checksum, err)
+	}
+	defer result.Body.Close()
+
+	idx := idxfile.NewMemoryIndex()
+	if err := idxfile.NewDecoder(result.Body).Decode(idx); err != nil {
+		return nil, fmt.Errorf("failed to decode pack index %s: %w", checksum, err)
+	}
+
+	s.indexCache.put(key, idx)
+	return idx, nil
+}
+
+// findPack returns the pack containing the given object.
+// It returns plumbing.ErrObjectNotFound when the object is not packed.
+//
+// Every push stores its own pack and nothing consolidates them, so the packs
+// pile up and a lookup may check the index of each of them. The pack of the
+// last object found is looked into first, the objects read together mostly
+// come from the same push.
+// The caller must hold s.packMu.
+func (s *S3Storer) findPack(hash plumbing.Hash) (*packInfo, error) {
+	if err := s.loadPacks(); err != nil {
+		return nil, err
+	}
+
+	if s.lastPack != nil {
+		ok, err := s.lastPack.index.Contains(hash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return s.lastPack, nil
+		}
+	}
+
+	for _, p := range s.packs {
+		if p == s.lastPack {
+			continue
+		}
+		ok, err := p.index.Contains(hash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.lastPack = p
+			return p, nil
+		}
+	}
+
+	return nil, plumbing.ErrObjectNotFound
+}
+
+// openPack returns the go-git packfile reader for p, backed by ranged GETs.
+// Objects larger than largeObjectThreshold are returned without their content,
+// which is streamed from the pack when read.
+// The caller must hold s.packMu.
+func (s *S3Storer) openPack(p *packInfo) *packfile.Packfile {
+	if p.pack == nil {
+		p.blocks = newBlockCache()
+		fs := &packFS{storer: s, pack: p}
+		p.pack = packfile.NewPackfile(p.index, fs, fs.open(), largeObjectThreshold)
+	}
+	return p.pack
+}
+
+// packFS exposes a single pack to go-git as a read-only filesystem: go-git
+// reopens the pack by name to read the content of large objects.
+type packFS struct {
+	storer *S3Storer
+	pack   *packInfo
+}
+
+func (fs *packFS) open() *rangeFile {
+	s := fs.storer
+	return newRangeFile(s.ctx, s.client, s.bucket, s.getObjectKey(packKey(fs.pack.checksum)), fs.pack.size, fs.pack.blocks)
+}
+
+func (fs *packFS) Open(filename string) (billy.File, error) {
+	f := fs.open()
+	if filename != f.Name() {
+		return nil, os.ErrNotExist
+	}
+	return f, nil
+}
+
+func (fs *packFS) OpenFile(filename string, flag int, _ os.FileMode) (billy.File, error) {
+	if flag != os.O_RDONLY {
+		return nil, billy.ErrReadOnly
+	}
+	return fs.Open(filename)
+}
+
+func (fs *packFS) Create(string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *packFS) TempFile(string, string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *packFS) Rename(string, string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *packFS) Remove(string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *packFS) MkdirAll(string, os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *packFS) Symlink(string, string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *packFS) Stat(string) (os.FileInfo, error) {
+	return nil, billy.ErrNotSupported
+}
+
+func (fs *packFS) Lstat(string) (os.FileInfo, error) {
+	return nil, billy.ErrNotSupported
+}
+
+func (fs *packFS) ReadDir(string) ([]os.FileInfo, error) {
+	return nil, billy.ErrNotSupported
+}
+
+func (fs *packFS) Readlink(string) (string, error) {
+	return "", billy.ErrNotSupported
+}
+
+func (fs *packFS) Chroot(string) (billy.Filesystem, error) {
+	return nil, billy.ErrNotSupported
+}
+
+func (fs *packFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (fs *packFS) Root() string {
+	return ""
+}
+
+// packedObject returns an object stored in one of the repository packs.
+func (s *S3Storer) packedObject(hash plumbing.Hash) (plumbing.EncodedObject, error) {
+	s.packMu.Lock()
+	defer s.packMu.Unlock()
+
+	p, err := s.findPack(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.openPack(p).Get(hash)
+}
+
+// packedObjectSize returns the size of an object stored in one of the repository packs.
+func (s *S3Storer) packedObjectSize(hash plumbing.Hash) (int64, error) {
+	s.packMu.Lock()
+	defer s.packMu.Unlock()
+
+	p, err := s.findPack(hash)
+	if err != nil {
+		return 0, err
+	}
+
+	offset, err := p.index.FindOffset(hash)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.openPack(p).GetSizeByOffset(offset)
+}
+
+// packList returns the packs of the repository.
+func (s *S3Storer) packList() ([]*packInfo, error) {
+	s.packMu.Lock()
+	defer s.packMu.Unlock()
+
+	if err := s.loadPacks(); err != nil {
+		return nil, err
+	}
+
+	packs := make([]*packInfo, 0, len(s.packs))
+	for _, p := range s.packs {
+		packs = append(packs, p)
+	}
+	return packs, nil
+}
+
+// packedObjectIn returns an object stored in the given pack.
+func (s *S3Storer) packedObjectIn(p *packInfo, hash plumbing.Hash) (plumbing.EncodedObject, error) {
+	s.packMu.Lock()
+	defer s.packMu.Unlock()
+
+	return s.openPack(p).Get(hash)
+}
+
+// PackfileWriter returns a writer that stores an incoming packfile as a
+// pack + idx pair in S3 instead of one key per object.
+// It implements go-git's storer.PackfileWriter interface.
+func (s *S3Storer) PackfileWriter() (io.WriteCloser, error) {
+	f, err := os.CreateTemp("", "ogit-pack-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary packfile: %w", err)
+	}
+
+	return &packWriter{storer: s, file: f}, nil
+}
+
+// ObjectPacks returns the checksums of the packs stored for the repository.
+func (s *S3Storer) ObjectPacks() ([]plumbing.Hash, error) {
+	s.packMu.Lock()
+	defer s.packMu.Unlock()
+
+	if err := s.loadPacks(); err != nil {
+		return nil, err
+	}
+
+	var hashes []plumbing.Hash
+	for h := range s.packs {
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
+// DeleteOldObjectPackAndIndex removes a pack and its index from S3.
+// The index is removed first so that readers never see an index without its pack.
+func (s *S3Storer) DeleteOldObjectPackAndIndex(checksum plumbing.Hash, _ time.Time) error {
+	s.packMu.Lock()
+	defer s.packMu.Unlock()
+
+	for _, key := range []string{idxKey(checksum), packKey(checksum)} {
+		_, err := s.client.DeleteObject(s.ctx, &awss3.DeleteObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.getObjectKey(key)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+	}
+
+	delete(s.packs, checksum)
+	if s.lastPack != nil && s.lastPack.checksum == checksum {
+		s.lastPack = nil
+	}
+	return nil
+}
+
+// packWriter buffers an incoming packfile on local disk, builds its index and
+// uploads both to S3 when closed.
+type packWriter struct {
+	storer *S3Storer
+	file   *os.File
+}
+
+func (w *packWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+// Close indexes the buffered packfile and uploads it. Thin packs, whose
+// deltas are based on objects of the repository, are completed with these
+// objects first so that the pack can be read on its own.
+func (w *packWriter) Close() error {
+	defer os.Remove(w.file.Name())
+	defer w.file.Close()
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	bases, err := externalBases(packfile.NewScanner(w.file))
+	if errors.Is(err, packfile.ErrEmptyPackfile) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	file := w.file
+	if len(bases) > 0 {
+		if file, err = w.fixThin(bases); err != nil {
+			return err
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	idxWriter := new(idxfile.Writer)
+	parser, err := packfile.NewParser(packfile.NewScanner(file), idxWriter)
+	if err != nil {
+		return err
+	}
+
+	checksum, err := parser.Parse()
+	if err != nil {
+		return err
+	}
+
+	idx, err := idxWriter.Index()
+	if err != nil {
+		return err
+	}
+
+	var idxBuf bytes.Buffer
+	if _, err := idxfile.NewEncoder(&idxBuf).Encode(idx); err != nil {
+		return fmt.Errorf("failed to encode pack index: %w", err)
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	s := w.storer
+
+	// Upload the pack before its index: readers discover packs through their index.
+	if err := s.putFile(s.getObjectKey(packKey(checksum)), file, size, nil); err != nil {
+		return fmt.Errorf("failed to upload packfile: %w", err)
+	}
+
+	_, err = s.client.PutObject(w.storer.ctx, &awss3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.getObjectKey(idxKey(checksum))),
+		Body:   bytes.NewReader(idxBuf.Bytes()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload pack index: %w", err)
+	}
+
+	s.indexCache.put(s.getObjectKey(idxKey(checksum)), idx)
+
+	s.packMu.Lock()
+	s.packs[checksum] = &packInfo{checksum: checksum, size: size, index: idx}
+	s.packMu.Unlock()
+
+	count, _ := idx.Count()
+	s.logger.Debug().
+		Str("pack", checksum.String()).
+		Int64("size", size).
+		Int64("objects", count).
+		Msg("Stored packfile in S3")
+
+	return nil
+}
+
+// externalBases returns the delta bases of a pack that are not in the pack:
+// the objects of the repository a thin pack is based on.
+func externalBases(scanner *packfile.Scanner) ([]plumbing.Hash, error) {
+	_, count, err := scanner.Header()
+	if err != nil {
+		return nil, err
+	}
+
+	inPack := make(map[plumbing.Hash]bool, count)
+	var bases []plumbing.Hash
+	for i := uint32(0); i < count; i++ {
+		header, err := scanner.NextObjectHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		switch header.Type {
+		case plumbing.REFDeltaObject:
+			bases = append(bases, header.Reference)
+			_, _, err = scanner.NextObject(io.Discard)
+		case plumbing.OFSDeltaObject:
+			_, _, err = scanner.NextObject(io.Discard)
+		default:
+			hasher := plumbing.NewHasher(header.Type, header.Length)
+			_, _, err = scanner.NextObject(hasher)
+			inPack[hasher.Sum()] = true
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var external []plumbing.Hash
+	for _, base := range bases {
+		if !inPack[base] {
+			inPack[base] = true
+			external = append(external, base)
+		}
+	}
+	return external, nil
+}
+
+// fixThin completes the buffered thin pack with the given delta bases, read
+// from the repository, as git index-pack --fix-thin does: the bases are
+// appended as whole objects and the header and checksum of the pack are
+// rewritten. The caller removes the returned file.
+func (w *packWriter) fixThin(bases []plumbing.Hash) (*os.File, error) {
+	var objects []plumbing.EncodedObject
+	for _, base := range bases {
+		obj, err := w.storer.EncodedObject(plumbing.AnyObject, base)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			// A base deltified within the pack, or a missing one that the
+			// parser reports
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read delta base %s: %w", base, err)
+		}
+		objects = append(objects, obj)
+	}
+
+	size, err := w.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, packHeaderSize)
+	if _, err := w.file.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	count := binary.BigEndian.Uint32(header[8:])
+	binary.BigEndian.PutUint32(header[8:], count+uint32(len(objects)))
+
+	f, err := os.CreateTemp("", "ogit-pack-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary packfile: %w", err)
+	}
+	fixed := func() error {
+		hash := sha1.New()
+		out := io.MultiWriter(f, hash)
+		if _, err := out.Write(header); err != nil {
+			return err
+		}
+		entries := io.NewSectionReader(w.file, packHeaderSize, size-packHeaderSize-sha1.Size)
+		if _, err := io.Copy(out, entries); err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			if err := writePackObject(out, obj); err != nil {
+				return fmt.Errorf("failed to append delta base %s: %w", obj.Hash(), err)
+			}
+		}
+		_, err := f.Write(hash.Sum(nil))
+		return err
+	}
+	if err := fixed(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	w.storer.logger.Debug().
+		Int("bases", len(objects)).
+		Msg("Completed thin packfile")
+	return f, nil
+}
+
+// writePackObject writes obj as a whole, non-delta, entry of a packfile
+func writePackObject(w io.Writer, obj plumbing.EncodedObject) error {
+	if _, err := w.Write(packObjectHeader(obj.Type(), obj.Size())); err != nil {
+		return err
+	}
+
+	r, err := obj.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	zw := zlib.NewWriter(w)
+	if _, err := io.Copy(zw, r); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// packObjectHeader returns the header of a packfile entry: the type and the
+// size of the object, as a variable-length integer
+func packObjectHeader(typ plumbing.ObjectType, size int64) []byte {
+	b := byte(typ)<<4 | byte(size&0x0f)
+	size >>= 4
+
+	var header []byte
+	for size > 0 {
+		header = append(header, b|0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+	return append(header, b)
+}
+
+// blockCache keeps the most recently read blocks of a packfile in memory so
+// that sequential and nearby reads do not issue a request each. It is shared
+// by every reader of the same pack.
+type blockCache struct {
+	mu     sync.Mutex
+	blocks map[int64][]byte
+	order  []int64
+}
+
+func newBlockCache() *blockCache {
+	return &blockCache{blocks: make(map[int64][]byte)}
+}
+
+func (c *blockCache) get(i int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.blocks[i]
+	return b, ok
+}
+
+func (c *blockCache) put(i int64, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.blocks[i]; ok {
+		return
+	}
+	if len(c.order) >= packBlockCount {
+		delete(c.blocks, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.blocks[i] = b
+	c.order = append(c.order, i)
+}
+
+// rangeFile is a read-only billy.File over an S3 object, read with ranged GETs
+// of packBlockSize bytes.
+type rangeFile struct {
+	ctx    context.Context
+	client *awss3.Client
+	bucket string
+	key    string
+	size   int64
+	offset int64
+	blocks *blockCache
+}
+
+func newRangeFile(ctx context.Context, client *awss3.Client, bucket, key string, size int64, blocks *blockCache) *rangeFile {
+	return &rangeFile{
+		ctx:    ctx,
+		client: client,
+		bucket: bucket,
+		key:    key,
+		size:   size,
+		blocks: blocks,
+	}
+}
+
+func (f *rangeFile) Name() string {
+	return f.key
+}
+
+func (f *rangeFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *rangeFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && off < f.size {
+		block, err := f.block(off / packBlockSize)
+		if err != nil {
+			return n, err
+		}
+
+		c := copy(p[n:], block[off%packBlockSize:])
+		n += c
+		off += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block returns the block with the given index, fetching it on a cache miss.
+func (f *rangeFile) block(i int64) ([]byte, error) {
+	if b, ok := f.blocks.get(i); ok {
+		return b, nil
+	}
+
+	start := i * packBlockSize
+	end := min(start+packBlockSize, f.size) - 1
+
+	result, err := f.client.GetObject(f.ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(f.bucket),
+		Key:    aws.String(f.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", f.key, err)
+	}
+	defer result.Body.Close()
+
+	b, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != end-start+1 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	f.blocks.put(i, b)
+	return b, nil
+}
+
+func (f *rangeFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+// Close is a no-op: the block cache outlives the readers of the pack
+func (f *rangeFile) Close() error {
+	return nil
+}
+
+func (f *rangeFile) Write(p []byte) (int, error) {
+	return 0, errors.New("packfiles are read-only")
+}
+
+func (f *rangeFile) Truncate(size int64) error {
+	return errors.New("packfiles are read-only")
+}
+
+func (f *rangeFile) Lock() error {
+	return nil
+}
+
+func (f *rangeFile) Unlock() error {
+	return nil
+}
@@ -0,0 +1,286 @@
+package s3
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildTestPack creates a small repository in memory with two commits and
+// returns it together with a packfile containing all of its objects.
+func buildTestPack(t *testing.T) (*memory.Storage, []byte) {
+	t.Helper()
+
+	st := memory.NewStorage()
+	repo, err := git.Init(st, memfs.New())
+	require.NoError(t, err)
+
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	content := strings.Repeat("line of content that deltifies well\n", 200)
+	for i, suffix := range []string{"first version\n", "second version\n"} {
+		f, err := wt.Filesystem.Create("README.md")
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content + suffix))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		_, err = wt.Add("README.md")
+		require.NoError(t, err)
+
+		_, err = wt.Commit("commit "+suffix, &git.CommitOptions{
+			Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(int64(i), 0)},
+		})
+		require.NoError(t, err)
+	}
+
+	var hashes []plumbing.Hash
+	iter, err := st.IterEncodedObjects(plumbing.AnyObject)
+	require.NoError(t, err)
+	require.NoError(t, iter.ForEach(func(obj plumbing.EncodedObject) error {
+		hashes = append(hashes, obj.Hash())
+		return nil
+	}))
+
+	var buf bytes.Buffer
+	_, err = packfile.NewEncoder(&buf, st, false).Encode(hashes, 10)
+	require.NoError(t, err)
+
+	return st, buf.Bytes()
+}
+
+func readObject(t *testing.T, obj plumbing.EncodedObject) []byte {
+	t.Helper()
+
+	r, err := obj.Reader()
+	require.NoError(t, err)
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return data
+}
+
+func TestPackfileWriter_StoresPackAndIndex(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+	source, pack := buildTestPack(t)
+
+	require.NoError(t, packfile.UpdateObjectStorage(s, bytes.NewReader(pack)))
+
+	// Only the pack and its index are written, no loose objects
+	keys := fake.Keys("repositories/test.git/objects/")
+	require.Len(t, keys, 2)
+	assert.True(t, strings.HasSuffix(keys[0], ".idx"))
+	assert.True(t, strings.HasSuffix(keys[1], ".pack"))
+
+	// A fresh storer discovers the pack and serves every object from it
+	reader := NewS3Storer(s.client, testBucket, "repositories/test.git", zerolog.Nop())
+
+	iter, err := source.IterEncodedObjects(plumbing.AnyObject)
+	require.NoError(t, err)
+	require.NoError(t, iter.ForEach(func(want plumbing.EncodedObject) error {
+		got, err := reader.EncodedObject(plumbing.AnyObject, want.Hash())
+		require.NoError(t, err)
+		assert.Equal(t, want.Type(), got.Type())
+		assert.Equal(t, readObject(t, want), readObject(t, got))
+
+		assert.NoError(t, reader.HasEncodedObject(want.Hash()))
+
+		size, err := reader.EncodedObjectSize(want.Hash())
+		require.NoError(t, err)
+		assert.Equal(t, want.Size(), size)
+		return nil
+	}))
+
+	packs, err := reader.ObjectPacks()
+	require.NoError(t, err)
+	assert.Len(t, packs, 1)
+}
+
+func TestFindPack_LastPackFirst(t *testing.T) {
+	_, s := newTestStorer(t, "repositories/test.git")
+	source, pack := buildTestPack(t)
+	require.NoError(t, packfile.UpdateObjectStorage(s, bytes.NewReader(pack)))
+
+	// A second pack with a single blob
+	st := memory.NewStorage()
+	blob := st.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	require.NoError(t, err)
+	_, err = w.Write([]byte("other pack\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	_, err = st.SetEncodedObject(blob)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = packfile.NewEncoder(&buf, st, false).Encode([]plumbing.Hash{blob.Hash()}, 10)
+	require.NoError(t, err)
+	require.NoError(t, packfile.UpdateObjectStorage(s, &buf))
+
+	iter, err := source.IterEncodedObjects(plumbing.AnyObject)
+	require.NoError(t, err)
+	obj, err := iter.Next()
+	require.NoError(t, err)
+	require.NoError(t, s.HasEncodedObject(obj.Hash()))
+	first := s.lastPack
+	require.NotNil(t, first)
+
+	require.NoError(t, s.HasEncodedObject(blob.Hash()))
+	require.NotNil(t, s.lastPack)
+	assert.NotEqual(t, first.checksum, s.lastPack.checksum)
+
+	// A deleted pack is no longer looked into
+	require.NoError(t, s.DeleteOldObjectPackAndIndex(s.lastPack.checksum, time.Time{}))
+	assert.Nil(t, s.lastPack)
+	assert.ErrorIs(t, s.HasEncodedObject(blob.Hash()), plumbing.ErrObjectNotFound)
+	assert.NoError(t, s.HasEncodedObject(obj.Hash()))
+}
+
+func TestPackfileWriter_EmptyPackfile(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+
+	w, err := s.PackfileWriter()
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	assert.Empty(t, fake.Keys("repositories/test.git/objects/"))
+}
+
+func TestEncodedObject_PackedTypeMismatch(t *testing.T) {
+	_, s := newTestStorer(t, "repositories/test.git")
+	source, pack := buildTestPack(t)
+	require.NoError(t, packfile.UpdateObjectStorage(s, bytes.NewReader(pack)))
+
+	iter, err := source.IterEncodedObjects(plumbing.BlobObject)
+	require.NoError(t, err)
+	blob, err := iter.Next()
+	require.NoError(t, err)
+
+	_, err = s.EncodedObject(plumbing.CommitObject, blob.Hash())
+	assert.ErrorIs(t, err, plumbing.ErrObjectNotFound)
+}
+
+func TestPackIndexCache_SharedBetweenStorers(t *testing.T) {
+	fake, client := newTestS3(t)
+	cache := newPackIndexCache()
+	logger := zerolog.Nop()
+
+	writer := newS3Storer(client, testBucket, "repositories/test.git", logger, cache)
+	source, pack := buildTestPack(t)
+	require.NoError(t, packfile.UpdateObjectStorage(writer, bytes.NewReader(pack)))
+
+	iter, err := source.IterEncodedObjects(plumbing.AnyObject)
+	require.NoError(t, err)
+	var hashes []plumbing.Hash
+	require.NoError(t, iter.ForEach(func(obj plumbing.EncodedObject) error {
+		hashes = append(hashes, obj.Hash())
+		return nil
+	}))
+
+	before := fake.Count("GET object")
+
+	reader := newS3Storer(client, testBucket, "repositories/test.git", logger, cache)
+	for _, h := range hashes {
+		_, err := reader.EncodedObject(plumbing.AnyObject, h)
+		require.NoError(t, err)
+	}
+
+	// The index comes from the cache and the small pack fits in a single ranged GET
+	assert.Equal(t, int64(1), fake.Count("GET object")-before)
+	assert.Equal(t, int64(1), fake.Count("GET list"))
+}
+
+// thinPack returns a packfile holding target as a delta of base, which the
+// pack does not contain, as git sends with its pushes
+func thinPack(t *testing.T, base, target plumbing.EncodedObject) []byte {
+	t.Helper()
+
+	delta, err := packfile.GetDelta(base, target)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	buf.WriteString("PACK")
+	require.NoError(t, binary.Write(&buf, binary.BigEndian, [2]uint32{2, 1}))
+	buf.Write(packObjectHeader(plumbing.REFDeltaObject, delta.Size()))
+	baseHash := base.Hash()
+	buf.Write(baseHash[:])
+	zw := zlib.NewWriter(&buf)
+	_, err = zw.Write(readObject(t, delta))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
+func TestPackfileWriter_ThinPack(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+
+	content := strings.Repeat("line of content that deltifies well\n", 200)
+	source := memory.NewStorage()
+	var blobs []plumbing.EncodedObject
+	for _, suffix := range []string{"first version\n", "second version\n"} {
+		obj := &plumbing.MemoryObject{}
+		obj.SetType(plumbing.BlobObject)
+		_, err := obj.Write([]byte(content + suffix))
+		require.NoError(t, err)
+		_, err = source.SetEncodedObject(obj)
+		require.NoError(t, err)
+		blobs = append(blobs, obj)
+	}
+	base, target := blobs[0], blobs[1]
+
+	var pack bytes.Buffer
+	_, err := packfile.NewEncoder(&pack, source, false).Encode([]plumbing.Hash{base.Hash()}, 10)
+	require.NoError(t, err)
+	require.NoError(t, packfile.UpdateObjectStorage(s, &pack))
+
+	require.NoError(t, packfile.UpdateObjectStorage(s, bytes.NewReader(thinPack(t, base, target))))
+
+	// The thin pack is completed and stored as a pack, no loose objects
+	keys := fake.Keys("repositories/test.git/objects/")
+	require.Len(t, keys, 4)
+	for _, key := range keys {
+		assert.True(t, strings.HasPrefix(key, "repositories/test.git/objects/pack/"), key)
+	}
+
+	reader := NewS3Storer(s.client, testBucket, "repositories/test.git", zerolog.Nop())
+	got, err := reader.EncodedObject(plumbing.AnyObject, target.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.BlobObject, got.Type())
+	assert.Equal(t, readObject(t, target), readObject(t, got))
+}
+
+func TestPackfileWriter_ThinPackMissingBase(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+
+	base := &plumbing.MemoryObject{}
+	base.SetType(plumbing.BlobObject)
+	_, err := base.Write([]byte(strings.Repeat("base\n", 100)))
+	require.NoError(t, err)
+	target := &plumbing.MemoryObject{}
+	target.SetType(plumbing.BlobObject)
+	_, err = target.Write([]byte(strings.Repeat("base\n", 100) + "target\n"))
+	require.NoError(t, err)
+
+	err = packfile.UpdateObjectStorage(s, bytes.NewReader(thinPack(t, base, target)))
+	assert.ErrorIs(t, err, packfile.ErrReferenceDeltaNotFound)
+	assert.Empty(t, fake.Keys("repositories/test.git/objects/"))
+}
@@ -0,0 +1,208 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// packedRefsPath is the path, relative to the repository, of the object
+// holding the packed references, in the format of git's packed-refs file.
+const packedRefsPath = "packed-refs"
+
+// packedRefs returns the references of the packed-refs object by name, along
+// with its ETag. A missing object yields no references and an empty ETag.
+func (s *S3Storer) packedRefs() (map[plumbing.ReferenceName]*plumbing.Reference, string, error) {
+	refs := make(map[plumbing.ReferenceName]*plumbing.Reference)
+
+	result, err := s.client.GetObject(s.ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.getObjectKey(packedRefsPath)),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return refs, "", nil
+		}
+		return nil, "", fmt.Errorf("failed to get packed-refs: %w", err)
+	}
+	defer result.Body.Close()
+
+	if err := decodePackedRefs(result.Body, refs); err != nil {
+		return nil, "", fmt.Errorf("failed to read packed-refs: %w", err)
+	}
+
+	return refs, aws.ToString(result.ETag), nil
+}
+
+// decodePackedRefs parses a packed-refs file. Peeled tag lines are ignored.
+func decodePackedRefs(r io.Reader, refs map[plumbing.ReferenceName]*plumbing.Reference) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return fmt.Errorf("malformed line %q", line)
+		}
+		refName := plumbing.ReferenceName(name)
+		refs[refName] = plumbing.NewHashReference(refName, plumbing.NewHash(hash))
+	}
+	return scanner.Err()
+}
+
+// encodePackedRefs returns the content of a packed-refs file for refs
+func encodePackedRefs(refs map[plumbing.ReferenceName]*plumbing.Reference) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# pack-refs with: sorted\n")
+	for _, ref := range sortedReferences(refs) {
+		fmt.Fprintf(&buf, "%s %s\n", ref.Hash(), ref.Name())
+	}
+	return buf.Bytes()
+}
+
+// sortedReferences returns the references of refs sorted by name
+func sortedReferences(refs map[plumbing.ReferenceName]*plumbing.Reference) []*plumbing.Reference {
+	sorted := make([]*plumbing.Reference, 0, len(refs))
+	for _, ref := range refs {
+		sorted = append(sorted, ref)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name() < sorted[j].Name()
+	})
+	return sorted
+}
+
+// putPackedRefs writes the packed-refs object, provided it still has the
+// given ETag (or does not exist when etag is empty)
+func (s *S3Storer) putPackedRefs(refs map[plumbing.ReferenceName]*plumbing.Reference, etag string) error {
+	input := &awss3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.getObjectKey(packedRefsPath)),
+		Body:   bytes.NewReader(encodePackedRefs(refs)),
+	}
+	if etag == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(etag)
+	}
+
+	_, err := s.client.PutObject(s.ctx, input)
+	return err
+}
+
+// PackRefs moves the loose hash references into the packed-refs object, so
+// that advertising the references costs a constant number of requests.
+// Loose references are deleted only if they were not updated in the meantime;
+// one that was keeps overriding its packed value.
+func (s *S3Storer) PackRefs() error {
+	for attempt := 0; attempt < referenceUpdateAttempts; attempt++ {
+		refs, packedETag, err := s.packedRefs()
+		if err != nil {
+			return err
+		}
+
+		names, err := s.looseReferenceNames()
+		if err != nil {
+			return err
+		}
+
+		etags := make(map[plumbing.ReferenceName]string)
+		for _, name := range names {
+			ref, etag, err := s.looseReference(name)
+			if err == plumbing.ErrReferenceNotFound {
+				// Removed since it was listed
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if ref.Type() != plumbing.HashReference {
+				// Symbolic references cannot be packed
+				continue
+			}
+			refs[name] = ref
+			etags[name] = etag
+		}
+
+		if len(etags) == 0 {
+			return nil
+		}
+
+		err = s.putPackedRefs(refs, packedETag)
+		if isConditionalWriteConflict(err) {
+			s.logger.Debug().
+				Int("attempt", attempt+1).
+				Msg("Conditional packed-refs write conflicted")
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write packed-refs: %w", err)
+		}
+
+		for name, etag := range etags {
+			_, err := s.client.DeleteObject(s.ctx, &awss3.DeleteObjectInput{
+				Bucket:  aws.String(s.bucket),
+				Key:     aws.String(s.referenceKey(name)),
+				IfMatch: aws.String(etag),
+			})
+			// An updated reference keeps overriding its packed value, a
+			// removed one was removed from packed-refs too
+			if err != nil && !isConditionalWriteConflict(err) && !isNotFound(err) {
+				return fmt.Errorf("failed to delete loose reference %s: %w", name, err)
+			}
+		}
+
+		s.logger.Debug().
+			Int("packed", len(etags)).
+			Int("total", len(refs)).
+			Msg("Packed references")
+
+		return nil
+	}
+
+	return fmt.Errorf("failed to pack references: packed-refs updated concurrently")
+}
+
+// removePackedReference removes a reference from the packed-refs object.
+// With a non-nil old, only a packed value matching old is removed; when
+// strict, a missing or different packed value is reported as
+// storage.ErrReferenceHasChanged without writing anything.
+//
+// Otherwise the packed-refs object is rewritten even when it does not hold
+// the reference: a PackRefs that read it along with the loose reference being
+// removed then fails its conditional write and starts over, instead of
+// packing the removed reference back.
+func (s *S3Storer) removePackedReference(name plumbing.ReferenceName, old *plumbing.Reference, strict bool) error {
+	for attempt := 0; attempt < referenceUpdateAttempts; attempt++ {
+		refs, etag, err := s.packedRefs()
+		if err != nil {
+			return err
+		}
+
+		current, ok := refs[name]
+		switch {
+		case old == nil || (ok && checkReference(current, old) == nil):
+			delete(refs, name)
+		case strict:
+			return storage.ErrReferenceHasChanged
+		}
+
+		err = s.putPackedRefs(refs, etag)
+		if !isConditionalWriteConflict(err) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("failed to remove %s from packed-refs: packed-refs updated concurrently", name)
+}
@@ -0,0 +1,173 @@
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func referenceNames(t *testing.T, s *S3Storer) map[plumbing.ReferenceName]plumbing.Hash {
+	t.Helper()
+
+	iter, err := s.IterReferences()
+	require.NoError(t, err)
+
+	refs := make(map[plumbing.ReferenceName]plumbing.Hash)
+	require.NoError(t, iter.ForEach(func(ref *plumbing.Reference) error {
+		refs[ref.Name()] = ref.Hash()
+		return nil
+	}))
+	return refs
+}
+
+func TestPackRefs_ConstantAdvertisementCost(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+
+	require.NoError(t, s.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")))
+	require.NoError(t, s.SetReference(plumbing.NewHashReference("refs/heads/main", hashA)))
+	for i := 0; i < 50; i++ {
+		name := plumbing.NewTagReferenceName(fmt.Sprintf("v%d", i))
+		require.NoError(t, s.SetReference(plumbing.NewHashReference(name, hashB)))
+	}
+
+	require.NoError(t, s.PackRefs())
+	assert.Empty(t, fake.Keys("repositories/test.git/refs/"))
+
+	count, err := s.CountLooseRefs()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	before := fake.Count("GET object")
+	refs := referenceNames(t, s)
+	assert.Len(t, refs, 52)
+	assert.Equal(t, hashA, refs["refs/heads/main"])
+	assert.Equal(t, hashB, refs["refs/tags/v42"])
+
+	// HEAD and packed-refs
+	assert.Equal(t, int64(2), fake.Count("GET object")-before)
+
+	ref, err := s.Reference("refs/tags/v7")
+	require.NoError(t, err)
+	assert.Equal(t, hashB, ref.Hash())
+}
+
+func TestPackRefs_LooseOverridesPacked(t *testing.T) {
+	_, s := newTestStorer(t, "repositories/test.git")
+	main := plumbing.ReferenceName("refs/heads/main")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashA)))
+	require.NoError(t, s.PackRefs())
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashB)))
+
+	ref, err := s.Reference(main)
+	require.NoError(t, err)
+	assert.Equal(t, hashB, ref.Hash())
+	assert.Equal(t, hashB, referenceNames(t, s)[main])
+}
+
+func TestPackRefs_ConcurrentUpdateIsKept(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+	main := plumbing.ReferenceName("refs/heads/main")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashA)))
+
+	// The reference is updated while the packed-refs object is written
+	fake.BeforePut = func(key string) {
+		if key == "repositories/test.git/packed-refs" {
+			fake.BeforePut = nil
+			fake.Set(mainKey, []byte(hashB.String()))
+		}
+	}
+	require.NoError(t, s.PackRefs())
+
+	ref, err := s.Reference(main)
+	require.NoError(t, err)
+	assert.Equal(t, hashB, ref.Hash())
+}
+
+func TestPackRefs_ConcurrentRemoveIsKept(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+	other := NewS3Storer(fake.Client(), testBucket, "repositories/test.git", zerolog.Nop())
+	main := plumbing.ReferenceName("refs/heads/main")
+	dev := plumbing.ReferenceName("refs/heads/dev")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashA)))
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(dev, hashB)))
+
+	// The reference is removed while the packed-refs object is written
+	fake.BeforePut = func(key string) {
+		if key == "repositories/test.git/packed-refs" {
+			fake.BeforePut = nil
+			require.NoError(t, other.RemoveReference(main))
+		}
+	}
+	require.NoError(t, s.PackRefs())
+
+	_, err := s.Reference(main)
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+	assert.Equal(t, map[plumbing.ReferenceName]plumbing.Hash{dev: hashB}, referenceNames(t, s))
+	assert.Empty(t, fake.Keys("repositories/test.git/refs/"))
+}
+
+func TestRemoveReference_Packed(t *testing.T) {
+	_, s := newTestStorer(t, "repositories/test.git")
+	main := plumbing.ReferenceName("refs/heads/main")
+	dev := plumbing.ReferenceName("refs/heads/dev")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashA)))
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(dev, hashB)))
+	require.NoError(t, s.PackRefs())
+
+	require.NoError(t, s.RemoveReference(main))
+
+	_, err := s.Reference(main)
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+	assert.Equal(t, map[plumbing.ReferenceName]plumbing.Hash{dev: hashB}, referenceNames(t, s))
+}
+
+func TestCheckAndSetReference_Packed(t *testing.T) {
+	_, s := newTestStorer(t, "repositories/test.git")
+	main := plumbing.ReferenceName("refs/heads/main")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashA)))
+	require.NoError(t, s.PackRefs())
+
+	err := s.CheckAndSetReference(plumbing.NewHashReference(main, hashC), plumbing.NewHashReference(main, hashB))
+	assert.ErrorIs(t, err, storage.ErrReferenceHasChanged)
+
+	require.NoError(t, s.CheckAndSetReference(plumbing.NewHashReference(main, hashC), plumbing.NewHashReference(main, hashA)))
+
+	ref, err := s.Reference(main)
+	require.NoError(t, err)
+	assert.Equal(t, hashC, ref.Hash())
+}
+
+func TestReference_LooseGetError(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+	main := plumbing.ReferenceName("refs/heads/main")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashA)))
+	require.NoError(t, s.PackRefs())
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashB)))
+
+	// A failed read of the loose reference does not fall back to the stale packed value
+	fake.Fail = func(r *http.Request, key string) int {
+		if r.Method == http.MethodGet && key == mainKey {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+
+	_, err := s.Reference(main)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, plumbing.ErrReferenceNotFound)
+
+	_, err = s.IterReferences()
+	assert.Error(t, err)
+}
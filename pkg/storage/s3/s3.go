@@ -43,6 +43,6 @@ func (c *S3Config) Configure() error {
 		o.DisableMultiRegionAccessPoints = true
 		// Disable request and response checksums
 		o.ClientLogMode = 0 // Reduce logging if needed
-	})
+	}, withMetrics, withTracing)
 	return nil
 }
@@ -0,0 +1,27 @@
+package s3
+
+import (
+	"testing"
+
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/labbs/git-server-s3/pkg/storage/s3/s3test"
+	"github.com/rs/zerolog"
+)
+
+const testBucket = s3test.Bucket
+
+// newTestS3 starts a fake S3 server and returns it with a client configured for it.
+func newTestS3(t *testing.T) (*s3test.Server, *awss3.Client) {
+	t.Helper()
+
+	fake := s3test.NewServer(t)
+	return fake, fake.Client(withMetrics, withTracing)
+}
+
+// newTestStorer returns a storer for repoKey backed by a fake S3 server.
+func newTestStorer(t *testing.T, repoKey string) (*s3test.Server, *S3Storer) {
+	t.Helper()
+
+	fake, client := newTestS3(t)
+	return fake, NewS3Storer(client, testBucket, repoKey, zerolog.Nop())
+}
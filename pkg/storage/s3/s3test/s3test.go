@@ -0,0 +1,602 @@
+// Package s3test provides an in-memory S3-compatible server for the tests of
+// the S3 storage and of the packages built on it.
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Bucket is the only bucket served by the fake server
+const Bucket = "test-bucket"
+
+// fakeObject is an object stored by Server.
+type fakeObject struct {
+	data     []byte
+	etag     string
+	metadata map[string]string
+}
+
+// Server is a minimal in-memory stand-in for an S3-compatible server (MinIO style,
+// path-style addressing). It implements the subset of the API used by the storer.
+type Server struct {
+	// URL is the endpoint of the server
+	URL string
+
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+	uploads map[string]*fakeUpload
+
+	// requests counts the requests received, by "METHOD operation".
+	requests sync.Map
+
+	// MaxKeys, when set, caps the number of keys returned per list page.
+	MaxKeys int
+
+	// BeforePut, when set, is called with the key of every PUT before the
+	// preconditions are evaluated, to simulate concurrent writers.
+	BeforePut func(key string)
+
+	// BeforeDelete, when set, is called with the key of every DELETE before
+	// the preconditions are evaluated, to simulate concurrent writers.
+	BeforeDelete func(key string)
+
+	// Fail, when set, is called with every request and its key. A non-zero
+	// status is returned instead of serving the request, to simulate errors.
+	Fail func(r *http.Request, key string) int
+}
+
+// NewServer starts a fake S3 server, stopped at the end of the test
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	f := &Server{
+		objects: make(map[string]*fakeObject),
+		uploads: make(map[string]*fakeUpload),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	f.URL = srv.URL
+	return f
+}
+
+// Client returns a client of the server, configured with optFns
+func (f *Server) Client(optFns ...func(*awss3.Options)) *awss3.Client {
+	return awss3.New(awss3.Options{
+		BaseEndpoint:               aws.String(f.URL),
+		Region:                     "us-east-1",
+		UsePathStyle:               true,
+		Credentials:                credentials.NewStaticCredentialsProvider("test", "test", ""),
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	}, optFns...)
+}
+
+// Count returns the number of requests received for the given "METHOD operation".
+func (f *Server) Count(op string) int64 {
+	v, ok := f.requests.Load(op)
+	if !ok {
+		return 0
+	}
+	return v.(*atomic.Int64).Load()
+}
+
+func (f *Server) record(op string) {
+	v, _ := f.requests.LoadOrStore(op, new(atomic.Int64))
+	v.(*atomic.Int64).Add(1)
+}
+
+// Keys returns the stored keys with the given prefix, sorted.
+func (f *Server) Keys(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != Bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	if f.Fail != nil {
+		if status := f.Fail(r, key); status != 0 {
+			f.record("FAIL")
+			writeError(w, status, http.StatusText(status))
+			return
+		}
+	}
+
+	query := r.URL.Query()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.record("GET list")
+		f.list(w, query)
+	case key == "" && r.Method == http.MethodPost && query.Has("delete"):
+		f.record("POST delete")
+		f.deleteObjects(w, r)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.record("POST create multipart")
+		f.createMultipart(w, r, key)
+	case r.Method == http.MethodPut && query.Has("uploadId") && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.record("PUT part copy")
+		f.uploadPartCopy(w, r, query)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		f.record("PUT part")
+		f.uploadPart(w, r, query)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.record("POST complete multipart")
+		f.completeMultipart(w, key, query)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.record("DELETE multipart")
+		f.abortMultipart(w, query)
+	case r.Method == http.MethodGet:
+		f.record("GET object")
+		f.get(w, r, key, true)
+	case r.Method == http.MethodHead:
+		f.record("HEAD object")
+		f.get(w, r, key, false)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.record("PUT copy")
+		f.copy(w, r, key)
+	case r.Method == http.MethodPut:
+		f.record("PUT object")
+		f.put(w, r, key)
+	case r.Method == http.MethodDelete:
+		f.record("DELETE object")
+		f.conditionalDelete(w, r, key)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// Set stores an object directly, bypassing the HTTP API.
+func (f *Server) Set(key string, data []byte) {
+	sum := md5.Sum(data)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = &fakeObject{data: data, etag: `"` + hex.EncodeToString(sum[:]) + `"`}
+}
+
+// Delete removes an object directly, bypassing the HTTP API.
+func (f *Server) Delete(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, key)
+}
+
+func (f *Server) conditionalDelete(w http.ResponseWriter, r *http.Request, key string) {
+	if f.BeforeDelete != nil {
+		f.BeforeDelete(key)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if match := r.Header.Get("If-Match"); match != "" {
+		if current, exists := f.objects[key]; exists && current.etag != match {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+	}
+	delete(f.objects, key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *Server) get(w http.ResponseWriter, r *http.Request, key string, body bool) {
+	f.mu.Lock()
+	obj, ok := f.objects[key]
+	f.mu.Unlock()
+	if !ok {
+		if body {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
+
+	for k, v := range obj.metadata {
+		w.Header().Set("X-Amz-Meta-"+k, v)
+	}
+	w.Header().Set("ETag", obj.etag)
+
+	data := obj.data
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		start, end, err := parseRange(rng, int64(len(data)))
+		if err != nil {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if body {
+		_, _ = w.Write(data)
+	}
+}
+
+func requestMetadata(r *http.Request) map[string]string {
+	metadata := make(map[string]string)
+	for k, v := range r.Header {
+		if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+			metadata[strings.ToLower(strings.TrimPrefix(strings.ToLower(k), "x-amz-meta-"))] = v[0]
+		}
+	}
+	return metadata
+}
+
+func (f *Server) put(w http.ResponseWriter, r *http.Request, key string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	metadata := requestMetadata(r)
+
+	if f.BeforePut != nil {
+		f.BeforePut(key)
+	}
+
+	sum := md5.Sum(data)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	f.mu.Lock()
+	current, exists := f.objects[key]
+	if (r.Header.Get("If-None-Match") == "*" && exists) ||
+		(r.Header.Get("If-Match") != "" && (!exists || current.etag != r.Header.Get("If-Match"))) {
+		f.mu.Unlock()
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	f.objects[key] = &fakeObject{data: data, etag: etag, metadata: metadata}
+	f.mu.Unlock()
+
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *Server) copy(w http.ResponseWriter, r *http.Request, key string) {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	bucket, sourceKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+
+	f.mu.Lock()
+	obj, ok := f.objects[sourceKey]
+	if ok && bucket == Bucket {
+		f.objects[key] = &fakeObject{data: obj.data, etag: obj.etag, metadata: obj.metadata}
+	}
+	f.mu.Unlock()
+	if !ok || bucket != Bucket {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"CopyObjectResult"`
+		ETag    string   `xml:"ETag"`
+	}{ETag: obj.etag})
+}
+
+// fakeUpload is an in-progress multipart upload.
+type fakeUpload struct {
+	key      string
+	metadata map[string]string
+	parts    map[int][]byte
+}
+
+func (f *Server) createMultipart(w http.ResponseWriter, r *http.Request, key string) {
+	f.mu.Lock()
+	id := strconv.Itoa(len(f.uploads) + 1)
+	f.uploads[id] = &fakeUpload{key: key, metadata: requestMetadata(r), parts: make(map[int][]byte)}
+	f.mu.Unlock()
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}{Bucket: Bucket, Key: key, UploadID: id})
+}
+
+func (f *Server) uploadPart(w http.ResponseWriter, r *http.Request, query url.Values) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	number, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+
+	f.mu.Lock()
+	upload, ok := f.uploads[query.Get("uploadId")]
+	if ok {
+		upload.parts[number] = data
+	}
+	f.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	sum := md5.Sum(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *Server) uploadPartCopy(w http.ResponseWriter, r *http.Request, query url.Values) {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	bucket, sourceKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	number, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	obj, ok := f.objects[sourceKey]
+	if !ok || bucket != Bucket {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	if match := r.Header.Get("X-Amz-Copy-Source-If-Match"); match != "" && match != obj.etag {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	upload, ok := f.uploads[query.Get("uploadId")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	data := obj.data
+	if rng := r.Header.Get("X-Amz-Copy-Source-Range"); rng != "" {
+		start, end, err := parseRange(rng, int64(len(data)))
+		if err != nil {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		data = data[start : end+1]
+	}
+	upload.parts[number] = data
+
+	sum := md5.Sum(data)
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"CopyPartResult"`
+		ETag    string   `xml:"ETag"`
+	}{ETag: `"` + hex.EncodeToString(sum[:]) + `"`})
+}
+
+func (f *Server) completeMultipart(w http.ResponseWriter, key string, query url.Values) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	upload, ok := f.uploads[query.Get("uploadId")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	var data []byte
+	for i := 1; i <= len(upload.parts); i++ {
+		data = append(data, upload.parts[i]...)
+	}
+	sum := md5.Sum(data)
+	etag := fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(upload.parts))
+
+	f.objects[upload.key] = &fakeObject{data: data, etag: etag, metadata: upload.metadata}
+	delete(f.uploads, query.Get("uploadId"))
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string   `xml:"Bucket"`
+		Key     string   `xml:"Key"`
+		ETag    string   `xml:"ETag"`
+	}{Bucket: Bucket, Key: key, ETag: etag})
+}
+
+func (f *Server) abortMultipart(w http.ResponseWriter, query url.Values) {
+	f.mu.Lock()
+	delete(f.uploads, query.Get("uploadId"))
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type listResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []listContent  `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type listContent struct {
+	Key  string `xml:"Key"`
+	Size int    `xml:"Size"`
+	ETag string `xml:"ETag"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (f *Server) list(w http.ResponseWriter, query url.Values) {
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	token := query.Get("continuation-token")
+	maxKeys := 1000
+	if v, err := strconv.Atoi(query.Get("max-keys")); err == nil && v > 0 {
+		maxKeys = v
+	}
+	if f.MaxKeys > 0 {
+		maxKeys = min(maxKeys, f.MaxKeys)
+	}
+
+	result := listResult{Name: Bucket, Prefix: prefix, MaxKeys: maxKeys}
+	seen := make(map[string]bool)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) && k > token {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				p := k[:len(prefix)+i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: p})
+					result.KeyCount++
+				}
+				result.NextContinuationToken = k
+				continue
+			}
+		}
+
+		obj := f.objects[k]
+		result.Contents = append(result.Contents, listContent{Key: k, Size: len(obj.data), ETag: obj.etag})
+		result.KeyCount++
+		result.NextContinuationToken = k
+	}
+
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+type deleteRequest struct {
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name `xml:"DeleteResult"`
+	Deleted []struct {
+		Key string `xml:"Key"`
+	} `xml:"Deleted"`
+}
+
+func (f *Server) deleteObjects(w http.ResponseWriter, r *http.Request) {
+	var req deleteRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	var result deleteResult
+	f.mu.Lock()
+	for _, o := range req.Objects {
+		delete(f.objects, o.Key)
+		result.Deleted = append(result.Deleted, struct {
+			Key string `xml:"Key"`
+		}{Key: o.Key})
+	}
+	f.mu.Unlock()
+
+	writeXML(w, http.StatusOK, result)
+}
+
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %q", header)
+	}
+
+	from, to, _ := strings.Cut(spec, "-")
+	start, err := strconv.ParseInt(from, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end := size - 1
+	if to != "" {
+		if end, err = strconv.ParseInt(to, 10, 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	end = min(end, size-1)
+
+	if start > end {
+		return 0, 0, fmt.Errorf("unsatisfiable range %q", header)
+	}
+	return start, end, nil
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	writeXML(w, status, struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: code})
+}
@@ -3,38 +3,70 @@ package s3
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/index"
 	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
 	"github.com/rs/zerolog"
 )
 
-// S3Storer implements go-git's storer.Storer interface using S3 as backend
+// referenceUpdateAttempts is the number of conditional writes attempted by
+// CheckAndSetReference before giving up on a contended reference
+const referenceUpdateAttempts = 3
+
+// S3Storer implements go-git's storer.Storer interface using S3 as backend.
+// Objects are read from packfiles when available and from loose objects otherwise.
 type S3Storer struct {
 	client   *awss3.Client
 	bucket   string
 	repoPath string
 	logger   zerolog.Logger
+	ctx      context.Context // Context of the S3 calls, see WithContext
+
+	indexCache  *packIndexCache
+	packMu      sync.Mutex
+	packs       map[plumbing.Hash]*packInfo
+	packsLoaded bool
+	lastPack    *packInfo // Pack of the last object found, looked into first
 }
 
 // NewS3Storer creates a new S3-based storer for a specific repository
 func NewS3Storer(client *awss3.Client, bucket, repoPath string, logger zerolog.Logger) *S3Storer {
+	return newS3Storer(client, bucket, repoPath, logger, newPackIndexCache())
+}
+
+// newS3Storer creates a storer sharing the given pack index cache
+func newS3Storer(client *awss3.Client, bucket, repoPath string, logger zerolog.Logger, indexCache *packIndexCache) *S3Storer {
 	return &S3Storer{
-		client:   client,
-		bucket:   bucket,
-		repoPath: repoPath,
-		logger:   logger,
+		client:     client,
+		bucket:     bucket,
+		repoPath:   repoPath,
+		logger:     logger,
+		ctx:        context.Background(),
+		indexCache: indexCache,
+		packs:      make(map[plumbing.Hash]*packInfo),
 	}
 }
 
+// WithContext sets the context of the S3 calls of the storer, carrying the
+// span of the request they are made for. A storer serves a single request.
+func (s *S3Storer) WithContext(ctx context.Context) storer.Storer {
+	s.ctx = ctx
+	return s
+}
+
 // getObjectKey constructs the S3 key for a given path within the repository
 func (s *S3Storer) getObjectKey(objectPath string) string {
 	return path.Join(s.repoPath, objectPath)
@@ -60,6 +92,11 @@ func (s *S3Storer) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash,
 	}
 	defer reader.Close()
 
+	// Large objects are spooled to disk instead of being buffered in memory
+	if obj.Size() > largeObjectThreshold {
+		return s.setLargeEncodedObject(obj, reader)
+	}
+
 	content, err := io.ReadAll(reader)
 	if err != nil {
 		return plumbing.ZeroHash, err
@@ -77,7 +114,7 @@ func (s *S3Storer) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash,
 	// Store in S3
 	objectKey := s.getObjectKey(fmt.Sprintf("objects/%s/%s", hash.String()[:2], hash.String()[2:]))
 
-	_, err = s.client.PutObject(context.TODO(), &awss3.PutObjectInput{
+	_, err = s.client.PutObject(s.ctx, &awss3.PutObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(objectKey),
 		Body:   bytes.NewReader(content),
@@ -89,43 +126,83 @@ func (s *S3Storer) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash,
 	return hash, err
 }
 
+// setLargeEncodedObject copies the object content to a temporary file while
+// hashing it, then uploads the file
+func (s *S3Storer) setLargeEncodedObject(obj plumbing.EncodedObject, reader io.Reader) (plumbing.Hash, error) {
+	f, err := os.CreateTemp("", "ogit-object-*")
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to create temporary object file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hasher := plumbing.NewHasher(obj.Type(), obj.Size())
+	size, err := io.Copy(io.MultiWriter(f, hasher), reader)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if size != obj.Size() {
+		return plumbing.ZeroHash, fmt.Errorf("object size mismatch: expected %d bytes, read %d", obj.Size(), size)
+	}
+
+	hash := hasher.Sum()
+	objectKey := s.getObjectKey(fmt.Sprintf("objects/%s/%s", hash.String()[:2], hash.String()[2:]))
+
+	err = s.putFile(objectKey, f, size, map[string]string{
+		"git-type": obj.Type().String(),
+	})
+
+	return hash, err
+}
+
 // EncodedObject returns the EncodedObject with the given hash
 func (s *S3Storer) EncodedObject(t plumbing.ObjectType, hash plumbing.Hash) (plumbing.EncodedObject, error) {
+	// Look into the packs first: their indexes are cached, so a miss costs no request
+	packed, err := s.packedObject(hash)
+	if err == nil {
+		if t != plumbing.AnyObject && packed.Type() != t {
+			return nil, plumbing.ErrObjectNotFound
+		}
+		return packed, nil
+	}
+	if err != plumbing.ErrObjectNotFound {
+		return nil, err
+	}
+
 	objectKey := s.getObjectKey(fmt.Sprintf("objects/%s/%s", hash.String()[:2], hash.String()[2:]))
 
-	result, err := s.client.GetObject(context.TODO(), &awss3.GetObjectInput{
+	// Only fetch up to the large object threshold: small objects are read in a
+	// single request, larger ones are streamed when their content is read
+	result, err := s.client.GetObject(s.ctx, &awss3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(objectKey),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", largeObjectThreshold-1)),
 	})
+	if isInvalidRange(err) {
+		// Empty objects cannot be read with a range
+		result, err = s.client.GetObject(s.ctx, &awss3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(objectKey),
+		})
+	}
 	if err != nil {
 		return nil, plumbing.ErrObjectNotFound
 	}
 	defer result.Body.Close()
 
+	// Get the object type from metadata if available
+	objectType := metadataObjectType(result.Metadata, t)
+
+	if size, ok := totalSize(aws.ToString(result.ContentRange)); ok && size > largeObjectThreshold {
+		return &s3Object{storer: s, key: objectKey, hash: hash, typ: objectType, size: size}, nil
+	}
+
 	content, err := io.ReadAll(result.Body)
 	if err != nil {
 		return nil, err
 	}
 
 	obj := &plumbing.MemoryObject{}
-
-	// Get the object type from metadata if available
-	objectType := t
-	if result.Metadata != nil {
-		if gitType, exists := result.Metadata["git-type"]; exists {
-			switch gitType {
-			case "commit":
-				objectType = plumbing.CommitObject
-			case "tree":
-				objectType = plumbing.TreeObject
-			case "blob":
-				objectType = plumbing.BlobObject
-			case "tag":
-				objectType = plumbing.TagObject
-			}
-		}
-	}
-
 	obj.SetType(objectType)
 	obj.SetSize(int64(len(content)))
 	obj.Write(content)
@@ -133,52 +210,24 @@ func (s *S3Storer) EncodedObject(t plumbing.ObjectType, hash plumbing.Hash) (plu
 	return obj, nil
 }
 
-// IterEncodedObjects returns an iterator for all the objects in the repository
+// IterEncodedObjects returns an iterator for all the objects in the repository.
+// Objects are listed page by page and fetched on demand, see objectIter.
 func (s *S3Storer) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
-	objectsPrefix := s.getObjectKey("objects/")
-
-	var objects []plumbing.EncodedObject
-
-	paginator := awss3.NewListObjectsV2Paginator(s.client, &awss3.ListObjectsV2Input{
-		Bucket: aws.String(s.bucket),
-		Prefix: aws.String(objectsPrefix),
-	})
-
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(context.TODO())
-		if err != nil {
-			return nil, err
-		}
-
-		for _, obj := range page.Contents {
-			key := aws.ToString(obj.Key)
-			if len(key) < len(objectsPrefix)+3 {
-				continue
-			}
-
-			// Extract hash from key (objects/ab/cdef...)
-			pathParts := strings.Split(key[len(objectsPrefix):], "/")
-			if len(pathParts) != 2 {
-				continue
-			}
-			hashStr := pathParts[0] + pathParts[1]
-
-			hash := plumbing.NewHash(hashStr)
-			encodedObj, err := s.EncodedObject(t, hash)
-			if err == nil && (t == plumbing.AnyObject || encodedObj.Type() == t) {
-				objects = append(objects, encodedObj)
-			}
-		}
-	}
-
-	return storer.NewEncodedObjectSliceIter(objects), nil
+	return newObjectIter(s, t), nil
 }
 
 // HasEncodedObject returns true if the given hash is stored
 func (s *S3Storer) HasEncodedObject(hash plumbing.Hash) error {
+	s.packMu.Lock()
+	_, err := s.findPack(hash)
+	s.packMu.Unlock()
+	if err != plumbing.ErrObjectNotFound {
+		return err
+	}
+
 	objectKey := s.getObjectKey(fmt.Sprintf("objects/%s/%s", hash.String()[:2], hash.String()[2:]))
 
-	_, err := s.client.HeadObject(context.TODO(), &awss3.HeadObjectInput{
+	_, err = s.client.HeadObject(s.ctx, &awss3.HeadObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(objectKey),
 	})
@@ -191,9 +240,14 @@ func (s *S3Storer) HasEncodedObject(hash plumbing.Hash) error {
 
 // EncodedObjectSize returns the size of the encoded object
 func (s *S3Storer) EncodedObjectSize(hash plumbing.Hash) (int64, error) {
+	size, err := s.packedObjectSize(hash)
+	if err != plumbing.ErrObjectNotFound {
+		return size, err
+	}
+
 	objectKey := s.getObjectKey(fmt.Sprintf("objects/%s/%s", hash.String()[:2], hash.String()[2:]))
 
-	result, err := s.client.HeadObject(context.TODO(), &awss3.HeadObjectInput{
+	result, err := s.client.HeadObject(s.ctx, &awss3.HeadObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(objectKey),
 	})
@@ -208,7 +262,7 @@ func (s *S3Storer) EncodedObjectSize(hash plumbing.Hash) (int64, error) {
 func (s *S3Storer) DeleteEncodedObject(hash plumbing.Hash) error {
 	objectKey := s.getObjectKey(fmt.Sprintf("objects/%s/%s", hash.String()[:2], hash.String()[2:]))
 
-	_, err := s.client.DeleteObject(context.TODO(), &awss3.DeleteObjectInput{
+	_, err := s.client.DeleteObject(s.ctx, &awss3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(objectKey),
 	})
@@ -218,77 +272,94 @@ func (s *S3Storer) DeleteEncodedObject(hash plumbing.Hash) error {
 
 // Reference methods
 
-// SetReference stores a reference
-func (s *S3Storer) SetReference(ref *plumbing.Reference) error {
-	var objectKey string
-
-	if ref.Name().IsRemote() {
-		objectKey = s.getObjectKey(fmt.Sprintf("refs/remotes/%s", ref.Name().Short()))
-	} else if ref.Name().IsBranch() {
-		objectKey = s.getObjectKey(fmt.Sprintf("refs/heads/%s", ref.Name().Short()))
-	} else if ref.Name().IsTag() {
-		objectKey = s.getObjectKey(fmt.Sprintf("refs/tags/%s", ref.Name().Short()))
-	} else {
-		objectKey = s.getObjectKey(string(ref.Name()))
+// referenceKey returns the S3 key of a reference
+func (s *S3Storer) referenceKey(name plumbing.ReferenceName) string {
+	if name.IsRemote() {
+		return s.getObjectKey(fmt.Sprintf("refs/remotes/%s", name.Short()))
+	} else if name.IsBranch() {
+		return s.getObjectKey(fmt.Sprintf("refs/heads/%s", name.Short()))
+	} else if name.IsTag() {
+		return s.getObjectKey(fmt.Sprintf("refs/tags/%s", name.Short()))
 	}
 
-	var content string
+	// Normalize reference name by removing leading slash if present
+	return s.getObjectKey(strings.TrimPrefix(string(name), "/"))
+}
+
+// encodeReference returns the content of the S3 object storing a reference
+func encodeReference(ref *plumbing.Reference) string {
 	if ref.Type() == plumbing.HashReference {
-		content = ref.Hash().String()
-	} else {
-		content = fmt.Sprintf("ref: %s", ref.Target())
+		return ref.Hash().String()
 	}
+	return fmt.Sprintf("ref: %s", ref.Target())
+}
 
-	_, err := s.client.PutObject(context.TODO(), &awss3.PutObjectInput{
+// SetReference stores a reference
+func (s *S3Storer) SetReference(ref *plumbing.Reference) error {
+	_, err := s.client.PutObject(s.ctx, &awss3.PutObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(objectKey),
-		Body:   strings.NewReader(content),
+		Key:    aws.String(s.referenceKey(ref.Name())),
+		Body:   strings.NewReader(encodeReference(ref)),
 	})
 
 	return err
 }
 
-// Reference returns the reference for the given name
+// Reference returns the reference for the given name.
+// Loose references take precedence over the packed-refs object.
 func (s *S3Storer) Reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
-	var objectKey string
+	ref, _, err := s.referenceWithETag(name)
+	return ref, err
+}
 
-	if name.IsRemote() {
-		objectKey = s.getObjectKey(fmt.Sprintf("refs/remotes/%s", name.Short()))
-	} else if name.IsBranch() {
-		objectKey = s.getObjectKey(fmt.Sprintf("refs/heads/%s", name.Short()))
-	} else if name.IsTag() {
-		objectKey = s.getObjectKey(fmt.Sprintf("refs/tags/%s", name.Short()))
-	} else {
-		// Normalize reference name by removing leading slash if present
-		refName := string(name)
-		if strings.HasPrefix(refName, "/") {
-			refName = refName[1:]
-		}
-		objectKey = s.getObjectKey(refName)
+// referenceWithETag returns the reference for the given name along with the
+// ETag of the S3 object storing it, for use in conditional writes. The ETag
+// is empty when the reference only exists in the packed-refs object.
+func (s *S3Storer) referenceWithETag(name plumbing.ReferenceName) (*plumbing.Reference, string, error) {
+	ref, etag, err := s.looseReference(name)
+	if err != plumbing.ErrReferenceNotFound {
+		return ref, etag, err
 	}
 
+	packed, _, err := s.packedRefs()
+	if err != nil {
+		return nil, "", err
+	}
+	if ref, ok := packed[name]; ok {
+		return ref, "", nil
+	}
+	return nil, "", plumbing.ErrReferenceNotFound
+}
+
+// looseReference reads a reference stored in its own S3 object
+func (s *S3Storer) looseReference(name plumbing.ReferenceName) (*plumbing.Reference, string, error) {
+	objectKey := s.referenceKey(name)
+
 	s.logger.Debug().
 		Str("name", string(name)).
 		Str("objectKey", objectKey).
 		Msg("Getting reference from S3")
 
-	result, err := s.client.GetObject(context.TODO(), &awss3.GetObjectInput{
+	result, err := s.client.GetObject(s.ctx, &awss3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(objectKey),
 	})
-	if err != nil {
+	if isNotFound(err) {
 		s.logger.Debug().
-			Err(err).
 			Str("name", string(name)).
 			Str("objectKey", objectKey).
 			Msg("Reference not found in S3")
-		return nil, plumbing.ErrReferenceNotFound
+		return nil, "", plumbing.ErrReferenceNotFound
+	}
+	if err != nil {
+		// Falling back to packed-refs would return a stale value
+		return nil, "", fmt.Errorf("failed to get reference %s: %w", name, err)
 	}
 	defer result.Body.Close()
 
 	content, err := io.ReadAll(result.Body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	contentStr := strings.TrimSpace(string(content))
@@ -298,106 +369,171 @@ func (s *S3Storer) Reference(name plumbing.ReferenceName) (*plumbing.Reference,
 		Str("content", contentStr).
 		Msg("Reference content from S3")
 
+	etag := aws.ToString(result.ETag)
+
 	if strings.HasPrefix(contentStr, "ref: ") {
 		target := plumbing.ReferenceName(strings.TrimPrefix(contentStr, "ref: "))
-		return plumbing.NewSymbolicReference(name, target), nil
+		return plumbing.NewSymbolicReference(name, target), etag, nil
 	}
 
 	hash := plumbing.NewHash(contentStr)
-	return plumbing.NewHashReference(name, hash), nil
+	return plumbing.NewHashReference(name, hash), etag, nil
 }
 
-// IterReferences returns an iterator for all references
+// IterReferences returns an iterator for all references.
+// Packed references are read from the packed-refs object in a single request
+// and overlaid with the loose references.
 func (s *S3Storer) IterReferences() (storer.ReferenceIter, error) {
-	refsPrefix := s.getObjectKey("refs/")
-
 	var refs []*plumbing.Reference
 
 	// First, add HEAD reference if it exists
 	headRef, err := s.Reference(plumbing.HEAD)
-	if err == nil {
+	switch {
+	case err == nil:
 		refs = append(refs, headRef)
+	case err != plumbing.ErrReferenceNotFound:
+		return nil, err
+	}
+
+	byName, _, err := s.packedRefs()
+	if err != nil {
+		return nil, err
 	}
 
-	// Then list all refs/ references
+	// Then overlay the loose refs/ references
+	names, err := s.looseReferenceNames()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, refName := range names {
+		ref, _, err := s.looseReference(refName)
+		if err == plumbing.ErrReferenceNotFound {
+			// Removed since it was listed, or packed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		byName[refName] = ref
+	}
+
+	for _, ref := range sortedReferences(byName) {
+		refs = append(refs, ref)
+	}
+
+	return storer.NewReferenceSliceIter(refs), nil
+}
+
+// looseReferenceNames lists the references stored in their own S3 object
+func (s *S3Storer) looseReferenceNames() ([]plumbing.ReferenceName, error) {
+	refsPrefix := s.getObjectKey("refs") + "/"
+	var names []plumbing.ReferenceName
+
 	paginator := awss3.NewListObjectsV2Paginator(s.client, &awss3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucket),
 		Prefix: aws.String(refsPrefix),
 	})
 
 	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(context.TODO())
+		page, err := paginator.NextPage(s.ctx)
 		if err != nil {
 			return nil, err
 		}
 
 		for _, obj := range page.Contents {
 			key := aws.ToString(obj.Key)
-			relativePath := key[len(s.getObjectKey("")):]
-			// Remove leading slash if present
-			if strings.HasPrefix(relativePath, "/") {
-				relativePath = relativePath[1:]
-			}
-			refName := plumbing.ReferenceName(relativePath)
-
-			s.logger.Debug().
-				Str("key", key).
-				Str("relativePath", relativePath).
-				Str("refName", string(refName)).
-				Msg("Processing reference in IterReferences")
-
-			ref, err := s.Reference(refName)
-			if err == nil {
-				refs = append(refs, ref)
-			} else {
-				s.logger.Debug().
-					Err(err).
-					Str("refName", string(refName)).
-					Msg("Failed to get reference in IterReferences")
-			}
+			relativePath := strings.TrimPrefix(key[len(s.getObjectKey("")):], "/")
+			names = append(names, plumbing.ReferenceName(relativePath))
 		}
 	}
 
-	return storer.NewReferenceSliceIter(refs), nil
+	return names, nil
 }
 
-// RemoveReference removes a reference
+// RemoveReference removes a reference, both its loose object and its
+// entry in the packed-refs object, see removeReference
 func (s *S3Storer) RemoveReference(name plumbing.ReferenceName) error {
-	var objectKey string
+	return s.removeReference(name, nil)
+}
 
-	if name.IsRemote() {
-		objectKey = s.getObjectKey(fmt.Sprintf("refs/remotes/%s", name.Short()))
-	} else if name.IsBranch() {
-		objectKey = s.getObjectKey(fmt.Sprintf("refs/heads/%s", name.Short()))
-	} else if name.IsTag() {
-		objectKey = s.getObjectKey(fmt.Sprintf("refs/tags/%s", name.Short()))
-	} else {
-		objectKey = s.getObjectKey(string(name))
-	}
+// CheckAndRemoveReference removes a reference if it still has the value of
+// old, see removeReference. storage.ErrReferenceHasChanged is returned when
+// the reference was changed by someone else.
+func (s *S3Storer) CheckAndRemoveReference(old *plumbing.Reference) error {
+	return s.removeReference(old.Name(), old)
+}
 
-	_, err := s.client.DeleteObject(context.TODO(), &awss3.DeleteObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(objectKey),
-	})
+// removeReference removes a reference, checked against old when it is not
+// nil.
+//
+// As git does, the packed value is removed first, so that readers never see
+// it once the loose object is gone, then the loose object is deleted with
+// If-Match on the ETag read during the check: a concurrent update is detected
+// instead of being deleted. A concurrent PackRefs may have packed the loose
+// value in between, it is removed from packed-refs again afterwards. A
+// reference only packed is removed from a packed-refs object that still
+// holds the value read.
+func (s *S3Storer) removeReference(name plumbing.ReferenceName, old *plumbing.Reference) error {
+	for attempt := 0; attempt < referenceUpdateAttempts; attempt++ {
+		current, etag, err := s.referenceWithETag(name)
+		if err == plumbing.ErrReferenceNotFound {
+			if old != nil {
+				return storage.ErrReferenceHasChanged
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if old != nil {
+			if err := checkReference(current, old); err != nil {
+				return err
+			}
+		}
 
-	return err
+		if etag == "" {
+			// Only packed
+			err := s.removePackedReference(name, current, true)
+			if err != storage.ErrReferenceHasChanged {
+				return err
+			}
+		} else {
+			if err := s.removePackedReference(name, nil, false); err != nil {
+				return err
+			}
+			_, err = s.client.DeleteObject(s.ctx, &awss3.DeleteObjectInput{
+				Bucket:  aws.String(s.bucket),
+				Key:     aws.String(s.referenceKey(name)),
+				IfMatch: aws.String(etag),
+			})
+			if err == nil {
+				return s.removePackedReference(name, current, false)
+			}
+			if !isConditionalWriteConflict(err) && !isNotFound(err) {
+				return err
+			}
+		}
+
+		s.logger.Debug().
+			Str("name", string(name)).
+			Int("attempt", attempt+1).
+			Msg("Conditional reference delete conflicted")
+	}
+
+	if old != nil {
+		return storage.ErrReferenceHasChanged
+	}
+	return fmt.Errorf("failed to remove reference %s: updated concurrently", name)
 }
 
 // CountLooseRefs returns the number of loose references
 func (s *S3Storer) CountLooseRefs() (int, error) {
-	iter, err := s.IterReferences()
+	names, err := s.looseReferenceNames()
 	if err != nil {
 		return 0, err
 	}
-	defer iter.Close()
-
-	count := 0
-	err = iter.ForEach(func(*plumbing.Reference) error {
-		count++
-		return nil
-	})
-
-	return count, err
+	return len(names), nil
 }
 
 // Config methods
@@ -406,7 +542,7 @@ func (s *S3Storer) CountLooseRefs() (int, error) {
 func (s *S3Storer) Config() (*config.Config, error) {
 	objectKey := s.getObjectKey("config")
 
-	result, err := s.client.GetObject(context.TODO(), &awss3.GetObjectInput{
+	result, err := s.client.GetObject(s.ctx, &awss3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(objectKey),
 	})
@@ -435,7 +571,7 @@ func (s *S3Storer) SetConfig(cfg *config.Config) error {
 		return err
 	}
 
-	_, err = s.client.PutObject(context.TODO(), &awss3.PutObjectInput{
+	_, err = s.client.PutObject(s.ctx, &awss3.PutObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(objectKey),
 		Body:   bytes.NewReader(content),
@@ -444,6 +580,65 @@ func (s *S3Storer) SetConfig(cfg *config.Config) error {
 	return err
 }
 
+// UpdateConfig applies update to the repository configuration and writes it
+// provided it was not changed in the meantime, with a conditional write. A
+// concurrent change is retried on the new configuration.
+func (s *S3Storer) UpdateConfig(update func(*config.Config) error) error {
+	objectKey := s.getObjectKey("config")
+
+	for attempt := 0; attempt < referenceUpdateAttempts; attempt++ {
+		cfg := config.NewConfig()
+		var etag string
+		result, err := s.client.GetObject(s.ctx, &awss3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(objectKey),
+		})
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to get config: %w", err)
+		}
+		if err == nil {
+			content, err := io.ReadAll(result.Body)
+			result.Body.Close()
+			if err != nil {
+				return err
+			}
+			if err := cfg.Unmarshal(content); err != nil {
+				return err
+			}
+			etag = aws.ToString(result.ETag)
+		}
+
+		if err := update(cfg); err != nil {
+			return err
+		}
+		content, err := cfg.Marshal()
+		if err != nil {
+			return err
+		}
+
+		input := &awss3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(objectKey),
+			Body:   bytes.NewReader(content),
+		}
+		if etag == "" {
+			input.IfNoneMatch = aws.String("*")
+		} else {
+			input.IfMatch = aws.String(etag)
+		}
+		_, err = s.client.PutObject(s.ctx, input)
+		if isConditionalWriteConflict(err) {
+			s.logger.Debug().
+				Int("attempt", attempt+1).
+				Msg("Conditional config write conflicted")
+			continue
+		}
+		return err
+	}
+
+	return fmt.Errorf("failed to update config: config updated concurrently")
+}
+
 // Index methods
 
 // Index returns the repository index
@@ -464,7 +659,7 @@ func (s *S3Storer) SetIndex(idx *index.Index) error {
 func (s *S3Storer) Shallow() ([]plumbing.Hash, error) {
 	objectKey := s.getObjectKey("shallow")
 
-	result, err := s.client.GetObject(context.TODO(), &awss3.GetObjectInput{
+	result, err := s.client.GetObject(s.ctx, &awss3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(objectKey),
 	})
@@ -496,7 +691,7 @@ func (s *S3Storer) SetShallow(hashes []plumbing.Hash) error {
 
 	if len(hashes) == 0 {
 		// Remove shallow file if no hashes
-		_, err := s.client.DeleteObject(context.TODO(), &awss3.DeleteObjectInput{
+		_, err := s.client.DeleteObject(s.ctx, &awss3.DeleteObjectInput{
 			Bucket: aws.String(s.bucket),
 			Key:    aws.String(objectKey),
 		})
@@ -509,7 +704,7 @@ func (s *S3Storer) SetShallow(hashes []plumbing.Hash) error {
 		content.WriteString("\n")
 	}
 
-	_, err := s.client.PutObject(context.TODO(), &awss3.PutObjectInput{
+	_, err := s.client.PutObject(s.ctx, &awss3.PutObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(objectKey),
 		Body:   strings.NewReader(content.String()),
@@ -518,8 +713,9 @@ func (s *S3Storer) SetShallow(hashes []plumbing.Hash) error {
 	return err
 }
 
-// Module returns the submodule storage, which we don't support for S3
-func (s *S3Storer) Module(name string) (storer.Storer, error) {
+// Module returns the submodule storage, which we don't support for S3. It
+// completes storage.Storer, so that go-git can fetch into the repository.
+func (s *S3Storer) Module(name string) (storage.Storer, error) {
 	return nil, fmt.Errorf("submodules not supported in S3 storage")
 }
 
@@ -528,34 +724,89 @@ func (s *S3Storer) AddAlternate(remote string) error {
 	return fmt.Errorf("alternates not supported in S3 storage")
 }
 
-// CheckAndSetReference atomically checks and sets a reference
+// CheckAndSetReference atomically checks and sets a reference.
+// If old is not nil, new is only stored if the current value of the reference
+// still matches old. The write itself is a conditional PUT: If-Match on the
+// ETag read during the check, or If-None-Match: * when the reference does not
+// exist yet, so a concurrent update between the check and the write is
+// detected instead of silently overwritten. storage.ErrReferenceHasChanged is
+// returned when the reference was changed by someone else.
 func (s *S3Storer) CheckAndSetReference(new, old *plumbing.Reference) error {
-	if old != nil {
-		// Check if the old reference matches the current state
-		current, err := s.Reference(old.Name())
-		if err != nil {
+	for attempt := 0; attempt < referenceUpdateAttempts; attempt++ {
+		current, etag, err := s.referenceWithETag(new.Name())
+		if err != nil && err != plumbing.ErrReferenceNotFound {
 			return err
 		}
 
-		if old.Type() == plumbing.HashReference && current.Type() == plumbing.HashReference {
-			if old.Hash() != current.Hash() {
-				return fmt.Errorf("reference has changed")
+		if old != nil {
+			if current == nil {
+				return storage.ErrReferenceHasChanged
 			}
-		} else if old.Type() == plumbing.SymbolicReference && current.Type() == plumbing.SymbolicReference {
-			if old.Target() != current.Target() {
-				return fmt.Errorf("reference has changed")
+			if err := checkReference(current, old); err != nil {
+				return err
 			}
+		}
+
+		input := &awss3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.referenceKey(new.Name())),
+			Body:   strings.NewReader(encodeReference(new)),
+		}
+		if etag == "" {
+			// The reference does not exist, or only in packed-refs: create the loose object
+			input.IfNoneMatch = aws.String("*")
 		} else {
-			return fmt.Errorf("reference type mismatch")
+			input.IfMatch = aws.String(etag)
+		}
+
+		_, err = s.client.PutObject(s.ctx, input)
+		if err == nil {
+			return nil
+		}
+		if !isConditionalWriteConflict(err) {
+			return err
+		}
+
+		s.logger.Debug().
+			Str("name", string(new.Name())).
+			Int("attempt", attempt+1).
+			Msg("Conditional reference write conflicted")
+
+		// Without an expected value there is nothing to check again against:
+		// the reference was written concurrently and we must not overwrite it
+		if old == nil {
+			return storage.ErrReferenceHasChanged
 		}
 	}
 
-	// Set the new reference
-	return s.SetReference(new)
+	return storage.ErrReferenceHasChanged
 }
 
-// PackRefs packs references into a packed-refs file (not implemented for S3)
-func (s *S3Storer) PackRefs() error {
-	// S3 storage doesn't need packed refs as each ref is a separate object
+// checkReference verifies that current still has the value expected in old
+func checkReference(current, old *plumbing.Reference) error {
+	if old.Type() == plumbing.HashReference && current.Type() == plumbing.HashReference {
+		if old.Hash() != current.Hash() {
+			return storage.ErrReferenceHasChanged
+		}
+	} else if old.Type() == plumbing.SymbolicReference && current.Type() == plumbing.SymbolicReference {
+		if old.Target() != current.Target() {
+			return storage.ErrReferenceHasChanged
+		}
+	} else {
+		return fmt.Errorf("reference type mismatch")
+	}
 	return nil
 }
+
+// isConditionalWriteConflict reports whether err is the S3 answer to a
+// conditional write whose precondition no longer holds
+func isConditionalWriteConflict(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package s3
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	hashA = plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	hashB = plumbing.NewHash("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	hashC = plumbing.NewHash("cccccccccccccccccccccccccccccccccccccccc")
+)
+
+const mainKey = "repositories/test.git/refs/heads/main"
+
+func TestCheckAndSetReference_Update(t *testing.T) {
+	_, s := newTestStorer(t, "repositories/test.git")
+	main := plumbing.ReferenceName("refs/heads/main")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashA)))
+	require.NoError(t, s.CheckAndSetReference(
+		plumbing.NewHashReference(main, hashB),
+		plumbing.NewHashReference(main, hashA),
+	))
+
+	ref, err := s.Reference(main)
+	require.NoError(t, err)
+	assert.Equal(t, hashB, ref.Hash())
+}
+
+func TestCheckAndSetReference_StaleOldValue(t *testing.T) {
+	_, s := newTestStorer(t, "repositories/test.git")
+	main := plumbing.ReferenceName("refs/heads/main")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashB)))
+
+	err := s.CheckAndSetReference(
+		plumbing.NewHashReference(main, hashC),
+		plumbing.NewHashReference(main, hashA),
+	)
+	assert.ErrorIs(t, err, storage.ErrReferenceHasChanged)
+
+	ref, err := s.Reference(main)
+	require.NoError(t, err)
+	assert.Equal(t, hashB, ref.Hash())
+}
+
+func TestCheckAndSetReference_ConcurrentUpdate(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+	main := plumbing.ReferenceName("refs/heads/main")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashA)))
+
+	// Another writer moves the reference between our check and our write
+	fake.BeforePut = func(key string) {
+		if key == mainKey {
+			fake.BeforePut = nil
+			fake.Set(key, []byte(hashB.String()))
+		}
+	}
+
+	err := s.CheckAndSetReference(
+		plumbing.NewHashReference(main, hashC),
+		plumbing.NewHashReference(main, hashA),
+	)
+	assert.ErrorIs(t, err, storage.ErrReferenceHasChanged)
+
+	ref, err := s.Reference(main)
+	require.NoError(t, err)
+	assert.Equal(t, hashB, ref.Hash())
+}
+
+func TestCheckAndSetReference_ConcurrentCreate(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+	main := plumbing.ReferenceName("refs/heads/main")
+
+	// Another writer creates the reference between our check and our write
+	fake.BeforePut = func(key string) {
+		if key == mainKey {
+			fake.BeforePut = nil
+			fake.Set(key, []byte(hashB.String()))
+		}
+	}
+
+	err := s.CheckAndSetReference(plumbing.NewHashReference(main, hashA), nil)
+	assert.ErrorIs(t, err, storage.ErrReferenceHasChanged)
+
+	ref, err := s.Reference(main)
+	require.NoError(t, err)
+	assert.Equal(t, hashB, ref.Hash())
+}
+
+func TestCheckAndRemoveReference_ConcurrentUpdate(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+	main := plumbing.ReferenceName("refs/heads/main")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashA)))
+
+	// Another writer moves the reference between our check and our delete
+	fake.BeforeDelete = func(key string) {
+		if key == mainKey {
+			fake.BeforeDelete = nil
+			fake.Set(key, []byte(hashB.String()))
+		}
+	}
+
+	err := s.CheckAndRemoveReference(plumbing.NewHashReference(main, hashA))
+	assert.ErrorIs(t, err, storage.ErrReferenceHasChanged)
+
+	ref, err := s.Reference(main)
+	require.NoError(t, err)
+	assert.Equal(t, hashB, ref.Hash())
+}
+
+func TestCheckAndRemoveReference_Packed(t *testing.T) {
+	_, s := newTestStorer(t, "repositories/test.git")
+	main := plumbing.ReferenceName("refs/heads/main")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashA)))
+	require.NoError(t, s.PackRefs())
+
+	err := s.CheckAndRemoveReference(plumbing.NewHashReference(main, hashB))
+	assert.ErrorIs(t, err, storage.ErrReferenceHasChanged)
+
+	require.NoError(t, s.CheckAndRemoveReference(plumbing.NewHashReference(main, hashA)))
+	_, err = s.Reference(main)
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+}
+
+func TestCheckAndRemoveReference_StalePackedValue(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+	other := NewS3Storer(fake.Client(), testBucket, "repositories/test.git", zerolog.Nop())
+	main := plumbing.ReferenceName("refs/heads/main")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashA)))
+	require.NoError(t, s.PackRefs())
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashB)))
+
+	// The packed value is never seen in place of the removed loose one
+	writes := 0
+	fake.BeforePut = func(key string) {
+		if key == "repositories/test.git/packed-refs" {
+			writes++
+			err := other.CheckAndSetReference(plumbing.NewHashReference(main, hashC), plumbing.NewHashReference(main, hashA))
+			assert.ErrorIs(t, err, storage.ErrReferenceHasChanged)
+		}
+	}
+	require.NoError(t, s.CheckAndRemoveReference(plumbing.NewHashReference(main, hashB)))
+	assert.NotZero(t, writes)
+
+	_, err := s.Reference(main)
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+}
+
+func TestCheckAndSetReference_RetriesWhenValueUnchanged(t *testing.T) {
+	fake, s := newTestStorer(t, "repositories/test.git")
+	main := plumbing.ReferenceName("refs/heads/main")
+
+	require.NoError(t, s.SetReference(plumbing.NewHashReference(main, hashA)))
+
+	// The object is rewritten with the same value but a different ETag
+	fake.BeforePut = func(key string) {
+		if key == mainKey {
+			fake.BeforePut = nil
+			fake.Set(key, []byte(hashA.String()+"\n"))
+		}
+	}
+
+	require.NoError(t, s.CheckAndSetReference(
+		plumbing.NewHashReference(main, hashC),
+		plumbing.NewHashReference(main, hashA),
+	))
+
+	ref, err := s.Reference(main)
+	require.NoError(t, err)
+	assert.Equal(t, hashC, ref.Hash())
+	assert.Equal(t, int64(3), fake.Count("PUT object"))
+}
+
+func TestFetch(t *testing.T) {
+	dir := t.TempDir()
+	upstream, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := upstream.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("# project\n"), 0644))
+	_, err = wt.Add("README.md")
+	require.NoError(t, err)
+	hash, err := wt.Commit("initial commit", &git.CommitOptions{Author: &object.Signature{Name: "Alice", Email: "alice@example.com"}})
+	require.NoError(t, err)
+
+	// go-git fetches into the S3 storer as into any storage.Storer
+	_, s := newTestStorer(t, "repositories/test.git")
+	var st storage.Storer = s
+	remote := git.NewRemote(st, &config.RemoteConfig{Name: "upstream", URLs: []string{"file://" + dir}})
+	require.NoError(t, remote.Fetch(&git.FetchOptions{RefSpecs: []config.RefSpec{"+refs/heads/*:refs/heads/*"}}))
+
+	ref, err := s.Reference(plumbing.Master)
+	require.NoError(t, err)
+	assert.Equal(t, hash, ref.Hash())
+	commit, err := object.GetCommit(s, hash)
+	require.NoError(t, err)
+	assert.Equal(t, "initial commit", commit.Message)
+}
@@ -0,0 +1,64 @@
+package s3
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/labbs/git-server-s3/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingMiddleware starts a client span for each S3 call made within a
+// traced request, retries included. The calls outside of a trace, such as the
+// scheduled mirror synchronizations, are not traced.
+var tracingMiddleware = middleware.InitializeMiddlewareFunc("Tracing", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return next.HandleInitialize(ctx, in)
+	}
+
+	operation := middleware.GetOperationName(ctx)
+	attrs := []attribute.KeyValue{
+		semconv.RPCSystemKey.String("aws-api"),
+		semconv.RPCService("S3"),
+		semconv.RPCMethod(operation),
+	}
+	if bucket, key := objectOf(in.Parameters); bucket != "" {
+		attrs = append(attrs, semconv.AWSS3Bucket(bucket))
+		if key != "" {
+			attrs = append(attrs, semconv.AWSS3Key(key))
+		}
+	}
+
+	ctx, span := tracing.StartClient(ctx, "S3."+operation, attrs...)
+	out, md, err := next.HandleInitialize(ctx, in)
+	tracing.End(span, err)
+	return out, md, err
+})
+
+// objectOf returns the bucket and key of the input of the object calls of the storers
+func objectOf(input any) (bucket, key string) {
+	switch in := input.(type) {
+	case *awss3.GetObjectInput:
+		return aws.ToString(in.Bucket), aws.ToString(in.Key)
+	case *awss3.PutObjectInput:
+		return aws.ToString(in.Bucket), aws.ToString(in.Key)
+	case *awss3.HeadObjectInput:
+		return aws.ToString(in.Bucket), aws.ToString(in.Key)
+	case *awss3.DeleteObjectInput:
+		return aws.ToString(in.Bucket), aws.ToString(in.Key)
+	case *awss3.ListObjectsV2Input:
+		return aws.ToString(in.Bucket), ""
+	}
+	return "", ""
+}
+
+// withTracing is an option of the S3 clients tracing their calls
+func withTracing(o *awss3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(tracingMiddleware, middleware.Before)
+	})
+}
@@ -0,0 +1,54 @@
+package s3
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/labbs/git-server-s3/pkg/tracing"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	_, s := newTestStorer(t, "team/traced.git")
+
+	// Outside of a trace, the calls are not traced
+	_, err := s.Reference(plumbing.HEAD)
+	require.Error(t, err)
+	assert.Empty(t, recorder.Ended())
+
+	ctx, span := tracing.Start(context.Background(), "request")
+	s.WithContext(ctx)
+	_, err = s.Reference(plumbing.HEAD)
+	require.Error(t, err)
+	span.End()
+
+	// The loose reference, then the packed-refs are looked up
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	for _, call := range spans[:2] {
+		assert.Equal(t, "S3.GetObject", call.Name())
+		assert.Equal(t, trace.SpanKindClient, call.SpanKind())
+		assert.Equal(t, span.SpanContext().SpanID(), call.Parent().SpanID())
+	}
+	call := spans[0]
+
+	attrs := map[string]string{}
+	for _, attr := range call.Attributes() {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	assert.Equal(t, "S3", attrs["rpc.service"])
+	assert.Equal(t, testBucket, attrs["aws.s3.bucket"])
+	assert.Equal(t, "team/traced.git/HEAD", attrs["aws.s3.key"])
+}
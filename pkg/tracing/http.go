@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDKey is the attribute of the request ID set by the requestid
+// middleware, linking the spans to the HTTP logs
+const RequestIDKey = attribute.Key("http.request.id")
+
+// Middleware starts a server span for each HTTP request, continuing the
+// trace of its traceparent header. The span is carried by the user context of
+// the request, see fiber.Ctx.UserContext. It runs after the requestid
+// middleware, whose request ID is set as attribute.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		headers := propagation.HeaderCarrier{}
+		c.Request().Header.VisitAll(func(key, value []byte) {
+			headers.Set(string(key), string(value))
+		})
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), headers)
+
+		// The strings of the request are backed by buffers reused by the next requests
+		method := strings.Clone(c.Method())
+		ctx, span := tracer().Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(method),
+				semconv.URLPath(strings.Clone(c.Path())),
+				semconv.ClientAddress(c.IP()),
+				semconv.UserAgentOriginal(strings.Clone(c.Get(fiber.HeaderUserAgent))),
+			),
+		)
+		defer span.End()
+		if id, ok := c.Locals("requestid").(string); ok {
+			span.SetAttributes(RequestIDKey.String(id))
+		}
+
+		c.SetUserContext(ctx)
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			var fe *fiber.Error
+			if errors.As(err, &fe) {
+				status = fe.Code
+			}
+			span.RecordError(err)
+		}
+		// The route is known once the request went through the router
+		route := c.Route().Path
+		span.SetName(method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+		if status >= fiber.StatusInternalServerError {
+			span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", status))
+		}
+		return err
+	}
+}
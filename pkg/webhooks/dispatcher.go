@@ -0,0 +1,361 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/labbs/git-server-s3/pkg/storage"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultAttempts is the number of attempts of a delivery when Dispatcher.Attempts is zero
+	DefaultAttempts = 5
+	// DefaultBackoff is the delay before the first retry when Dispatcher.Backoff is zero
+	DefaultBackoff = time.Second
+	// DefaultTimeout is the timeout of an attempt when Dispatcher.Client is nil
+	DefaultTimeout = 10 * time.Second
+	// MaxPayloadCommits is the number of commits listed at most in a payload,
+	// the most recent ones
+	MaxPayloadCommits = 20
+)
+
+// Headers sent with the payloads
+const (
+	HeaderEvent     = "X-OGit-Event"
+	HeaderDelivery  = "X-OGit-Delivery"
+	HeaderSignature = "X-Hub-Signature-256" // "sha256=" and the hex HMAC-SHA256 of the body keyed by the secret
+)
+
+// Payload is the JSON body sent for the update of a reference
+type Payload struct {
+	Ref        string   `json:"ref"`
+	Before     string   `json:"before"`
+	After      string   `json:"after"`
+	Created    bool     `json:"created"`
+	Deleted    bool     `json:"deleted"`
+	Repository string   `json:"repository"`
+	Pusher     string   `json:"pusher,omitempty"`
+	Commits    []Commit `json:"commits"`
+}
+
+// Commit is a commit introduced by the update, the commits of a payload are
+// listed oldest first, MaxPayloadCommits at most
+type Commit struct {
+	ID        string    `json:"id"`
+	Message   string    `json:"message"`
+	Author    Author    `json:"author"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Author is the author of a commit
+type Author struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Dispatcher is a post-receive hook sending the updates of the pushes to the
+// webhooks of the repository. The payloads are built and delivered in the
+// background, off the path of the push: a failed attempt, a network error or
+// a non-2xx response, is retried with an exponential backoff, and the outcome
+// is recorded in the delivery log. Stop abandons the retries.
+type Dispatcher struct {
+	Store    *Store
+	Storage  storage.GitRepositoryStorage
+	Logger   zerolog.Logger
+	Client   *http.Client  // Nil for a client with DefaultTimeout
+	Attempts int           // Zero for DefaultAttempts
+	Backoff  time.Duration // Zero for DefaultBackoff, doubled after each retry
+
+	ctx  context.Context // Cancelled by Stop
+	stop context.CancelFunc
+	mu   sync.Mutex // Guards the start of the deliveries against Stop
+	wg   sync.WaitGroup
+}
+
+// NewDispatcher returns a dispatcher of the webhooks of store, of the
+// repositories stored in str
+func NewDispatcher(store *Store, str storage.GitRepositoryStorage, logger zerolog.Logger) *Dispatcher {
+	ctx, stop := context.WithCancel(context.Background())
+	return &Dispatcher{
+		Store:   store,
+		Storage: str,
+		Logger:  logger.With().Str("component", "webhooks").Logger(),
+		Client:  &http.Client{Timeout: DefaultTimeout},
+		ctx:     ctx,
+		stop:    stop,
+	}
+}
+
+// ErrStopped is returned for the deliveries requested once the dispatcher is stopped
+var ErrStopped = errors.New("webhook dispatcher stopped")
+
+// PostReceive queues a delivery of each update of the push to the webhooks
+// subscribed to its events
+func (d *Dispatcher) PostReceive(ctx context.Context, push *hooks.Push) error {
+	webhooks := d.Store.Hooks(push.Repository)
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	// The storer of the push belongs to the request, the commits are read
+	// with one of our own
+	pending := &hooks.Push{
+		Repository: push.Repository,
+		Pusher:     push.Pusher,
+		Updates:    slices.Clone(push.Updates),
+	}
+	return d.start(func() {
+		if err := d.dispatch(pending, webhooks); err != nil {
+			d.Logger.Error().Err(err).Str("repo", pending.Repository).Msg("Failed to dispatch webhooks")
+		}
+	})
+}
+
+// dispatch builds the payloads of the updates of the push and delivers them
+// to the webhooks subscribed to their events
+func (d *Dispatcher) dispatch(push *hooks.Push, webhooks []Hook) error {
+	repoPath := push.Repository
+	st, err := d.Storage.GetStorer(repoPath)
+	if err != nil {
+		return err
+	}
+	push.Storer = st
+	lister := hooks.NewCommitLister(push)
+
+	for _, update := range push.Updates {
+		payload, err := newPayload(push, lister, update)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		events := []Event{EventPush}
+		if update.IsCreate() {
+			events = append(events, EventCreate)
+		}
+		if update.IsDelete() {
+			events = append(events, EventDelete)
+		}
+
+		for _, hook := range webhooks {
+			for _, event := range events {
+				if !hook.Subscribed(event) {
+					continue
+				}
+				if _, err := d.deliver(repoPath, hook, event, body); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// newPayload returns the payload of an update of the push
+func newPayload(push *hooks.Push, lister *hooks.CommitLister, update hooks.Update) (*Payload, error) {
+	payload := &Payload{
+		Ref:        update.Name.String(),
+		Before:     update.Old.String(),
+		After:      update.New.String(),
+		Created:    update.IsCreate(),
+		Deleted:    update.IsDelete(),
+		Repository: push.Repository,
+		Commits:    []Commit{},
+	}
+	if push.Pusher != nil {
+		payload.Pusher = push.Pusher.Username
+	}
+
+	commits, err := lister.Commits(update, MaxPayloadCommits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the commits of %s: %w", update.Name, err)
+	}
+	// Oldest first
+	for _, c := range slices.Backward(commits) {
+		payload.Commits = append(payload.Commits, Commit{
+			ID:        c.Hash.String(),
+			Message:   c.Message,
+			Author:    Author{Name: c.Author.Name, Email: c.Author.Email},
+			Timestamp: c.Author.When,
+		})
+	}
+	return payload, nil
+}
+
+// Redeliver sends the payload of a past delivery again, as a new delivery.
+// ErrStopped is returned once the dispatcher is stopped.
+func (d *Dispatcher) Redeliver(repoPath, hookID, deliveryID string) (Delivery, error) {
+	hook, err := d.Store.Hook(repoPath, hookID)
+	if err != nil {
+		return Delivery{}, err
+	}
+	delivery, err := d.Store.Delivery(repoPath, hookID, deliveryID)
+	if err != nil {
+		return Delivery{}, err
+	}
+	return d.deliver(repoPath, hook, delivery.Event, delivery.Payload)
+}
+
+// Stop abandons the retries of the pending deliveries, the running attempts
+// are not interrupted. The deliveries requested afterwards fail with
+// ErrStopped.
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stop()
+}
+
+// Wait waits for the pending deliveries
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// start runs fn in the background, unless the dispatcher is stopped. Stop is
+// excluded meanwhile, so that Wait never misses a delivery started after it.
+func (d *Dispatcher) start(fn func()) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.ctx.Err() != nil {
+		return ErrStopped
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		fn()
+	}()
+	return nil
+}
+
+// deliver records a new delivery of body to hook and sends it in the
+// background. ErrStopped is returned once the dispatcher is stopped.
+func (d *Dispatcher) deliver(repoPath string, hook Hook, event Event, body []byte) (Delivery, error) {
+	if d.ctx.Err() != nil {
+		return Delivery{}, ErrStopped
+	}
+
+	delivery := Delivery{
+		ID:        newID(),
+		HookID:    hook.ID,
+		Event:     event,
+		Payload:   body,
+		CreatedAt: time.Now().UTC(),
+	}
+	d.save(repoPath, delivery)
+
+	err := d.start(func() {
+		d.send(repoPath, hook, delivery)
+	})
+	return delivery, err
+}
+
+// send attempts the delivery until it succeeds or the attempts are exhausted
+func (d *Dispatcher) send(repoPath string, hook Hook, delivery Delivery) {
+	logger := d.Logger.With().
+		Str("repo", repoPath).
+		Str("hook", hook.ID).
+		Str("delivery", delivery.ID).
+		Logger()
+
+	attempts := d.Attempts
+	if attempts <= 0 {
+		attempts = DefaultAttempts
+	}
+	backoff := d.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+
+	for delivery.Attempts < attempts {
+		if delivery.Attempts > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-d.ctx.Done():
+				timer.Stop()
+				logger.Warn().Int("attempts", delivery.Attempts).Msg("Webhook delivery abandoned on shutdown")
+				return
+			}
+			backoff *= 2
+		}
+
+		delivery.Attempts++
+		delivery.StatusCode, delivery.Error = 0, ""
+		status, err := d.post(hook, delivery)
+		delivery.StatusCode = status
+		if err == nil {
+			now := time.Now().UTC()
+			delivery.Delivered = true
+			delivery.DeliveredAt = &now
+			d.save(repoPath, delivery)
+			logger.Debug().Int("status", status).Int("attempts", delivery.Attempts).Msg("Webhook delivered")
+			return
+		}
+
+		delivery.Error = err.Error()
+		d.save(repoPath, delivery)
+		logger.Warn().Err(err).Int("attempt", delivery.Attempts).Msg("Webhook delivery failed")
+	}
+	logger.Error().Str("url", hook.URL).Int("attempts", delivery.Attempts).Msg("Webhook delivery abandoned")
+}
+
+// post sends the payload once, failing on a non-2xx response
+func (d *Dispatcher) post(hook Hook, delivery Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "oGit-Webhook")
+	req.Header.Set(HeaderEvent, string(delivery.Event))
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	if hook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(hook.Secret, delivery.Payload))
+	}
+
+	client := d.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// save records the delivery in the log, failures are logged
+func (d *Dispatcher) save(repoPath string, delivery Delivery) {
+	if err := d.Store.saveDelivery(repoPath, delivery); err != nil {
+		d.Logger.Error().Err(err).Str("repo", repoPath).Str("delivery", delivery.ID).Msg("Failed to save webhook delivery")
+	}
+}
+
+// Sign returns the signature of body sent in the HeaderSignature header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
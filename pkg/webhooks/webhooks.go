@@ -0,0 +1,402 @@
+// Package webhooks implements the outgoing webhooks: HTTP endpoints
+// registered on a repository are sent a JSON payload for each reference
+// updated by a push, see Dispatcher.
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labbs/git-server-s3/pkg/common"
+)
+
+// Event is the kind of reference update a webhook is notified of
+type Event string
+
+const (
+	EventPush   Event = "push"   // Any reference update, creations and deletions included
+	EventCreate Event = "create" // Reference created
+	EventDelete Event = "delete" // Reference deleted
+)
+
+// Events are the known events
+var Events = []Event{EventPush, EventCreate, EventDelete}
+
+// MaxDeliveries is the number of deliveries kept in the log of each webhook
+const MaxDeliveries = 100
+
+var (
+	// ErrInvalidHook is returned for a webhook without a valid URL or with an unknown event
+	ErrInvalidHook = errors.New("invalid webhook")
+	// ErrNotFound is returned for a webhook or delivery that does not exist
+	ErrNotFound = errors.New("not found")
+)
+
+// Hook is a webhook registered on a repository
+type Hook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"` // Key of the HMAC signature of the payloads, none when empty
+	Events    []Event   `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subscribed reports whether the webhook is notified of event
+func (h Hook) Subscribed(event Event) bool {
+	return h.Active && slices.Contains(h.Events, event)
+}
+
+// Redacted returns the webhook without its secret, to be shown to users
+func (h Hook) Redacted() Hook {
+	if h.Secret != "" {
+		h.Secret = "********"
+	}
+	return h
+}
+
+// Delivery is the delivery of a payload to a webhook, with the outcome of its
+// last attempt
+type Delivery struct {
+	ID          string          `json:"id"`
+	HookID      string          `json:"hook_id"`
+	Event       Event           `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	StatusCode  int             `json:"status_code,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Delivered   bool            `json:"delivered"`
+	CreatedAt   time.Time       `json:"created_at"`
+	DeliveredAt *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// repository is the persisted webhooks of a repository
+type repository struct {
+	Hooks []Hook `json:"hooks"`
+}
+
+// Store holds the webhooks, persisted in a JSON file, and their delivery log,
+// persisted in a JSON file per repository so that recording a delivery does
+// not rewrite the webhooks and logs of the other repositories
+type Store struct {
+	mu         sync.RWMutex
+	path       string
+	dir        string // Directory of the delivery logs
+	repos      map[string]*repository
+	deliveries map[string][]Delivery // By repository
+}
+
+// Load reads the webhooks file at path and the delivery logs of the
+// directory dir, which is created if needed. A missing file is no webhook.
+func Load(path, dir string) (*Store, error) {
+	s := &Store{
+		path:       path,
+		dir:        dir,
+		repos:      make(map[string]*repository),
+		deliveries: make(map[string][]Delivery),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read webhooks: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.repos); err != nil {
+			return nil, fmt.Errorf("failed to read webhooks: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create webhook deliveries directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook deliveries: %w", err)
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		repoPath, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook deliveries: %w", err)
+		}
+		var deliveries []Delivery
+		if err := json.Unmarshal(data, &deliveries); err != nil {
+			return nil, fmt.Errorf("failed to read webhook deliveries of %s: %w", repoPath, err)
+		}
+		s.deliveries[repoPath] = deliveries
+	}
+	return s, nil
+}
+
+// clone returns a copy of the repository that can be changed without affecting r
+func (r *repository) clone() *repository {
+	if r == nil {
+		return &repository{}
+	}
+	return &repository{Hooks: slices.Clone(r.Hooks)}
+}
+
+// update applies change to a copy of the repositories and writes them, the
+// store is only changed once the file is written. change must replace the
+// repositories it modifies with clones. s.mu must be held.
+func (s *Store) update(change func(repos map[string]*repository)) error {
+	repos := maps.Clone(s.repos)
+	change(repos)
+	if err := common.WriteJSONFile(s.path, repos); err != nil {
+		return fmt.Errorf("failed to write webhooks: %w", err)
+	}
+	s.repos = repos
+	return nil
+}
+
+// deliveriesPath returns the path of the delivery log of the repository
+func (s *Store) deliveriesPath(repoPath string) string {
+	return filepath.Join(s.dir, url.PathEscape(repoPath)+".json")
+}
+
+// updateDeliveries applies change to a copy of the delivery log of the
+// repository and writes it, an empty log is removed. The store is only
+// changed once the file is written. s.mu must be held.
+func (s *Store) updateDeliveries(repoPath string, change func(deliveries []Delivery) []Delivery) error {
+	deliveries := change(slices.Clone(s.deliveries[repoPath]))
+
+	path := s.deliveriesPath(repoPath)
+	var err error
+	if len(deliveries) == 0 {
+		if err = os.Remove(path); errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	} else {
+		err = common.WriteJSONFile(path, deliveries)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write webhook deliveries: %w", err)
+	}
+
+	if len(deliveries) == 0 {
+		delete(s.deliveries, repoPath)
+	} else {
+		s.deliveries[repoPath] = deliveries
+	}
+	return nil
+}
+
+// newID returns a random identifier
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Hooks returns the webhooks of the repository
+func (s *Store) Hooks(repoPath string) []Hook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	repo, ok := s.repos[repoPath]
+	if !ok {
+		return []Hook{}
+	}
+	return slices.Clone(repo.Hooks)
+}
+
+// Hook returns a webhook of the repository
+func (s *Store) Hook(repoPath, id string) (Hook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	repo, ok := s.repos[repoPath]
+	if !ok {
+		return Hook{}, ErrNotFound
+	}
+	i := slices.IndexFunc(repo.Hooks, func(h Hook) bool { return h.ID == id })
+	if i < 0 {
+		return Hook{}, ErrNotFound
+	}
+	return repo.Hooks[i], nil
+}
+
+// AddHook registers a webhook on the repository, with a new identifier. A
+// webhook without events is notified of pushes.
+func (s *Store) AddHook(repoPath string, hook Hook) (Hook, error) {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return hook, fmt.Errorf("%w: invalid URL %q", ErrInvalidHook, hook.URL)
+	}
+	if len(hook.Events) == 0 {
+		hook.Events = []Event{EventPush}
+	}
+	for _, event := range hook.Events {
+		if !slices.Contains(Events, event) {
+			return hook, fmt.Errorf("%w: unknown event %q", ErrInvalidHook, event)
+		}
+	}
+	hook.Events = slices.Compact(slices.Sorted(slices.Values(hook.Events)))
+	hook.ID = newID()
+	hook.CreatedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return hook, s.update(func(repos map[string]*repository) {
+		repo := repos[repoPath].clone()
+		repo.Hooks = append(repo.Hooks, hook)
+		repos[repoPath] = repo
+	})
+}
+
+// DeleteHook removes a webhook of the repository and its deliveries
+func (s *Store) DeleteHook(repoPath, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repo, ok := s.repos[repoPath]
+	if !ok {
+		return ErrNotFound
+	}
+	i := slices.IndexFunc(repo.Hooks, func(h Hook) bool { return h.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	err := s.update(func(repos map[string]*repository) {
+		repo := repo.clone()
+		repo.Hooks = slices.Delete(repo.Hooks, i, i+1)
+		if len(repo.Hooks) == 0 {
+			delete(repos, repoPath)
+		} else {
+			repos[repoPath] = repo
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return s.updateDeliveries(repoPath, func(deliveries []Delivery) []Delivery {
+		return slices.DeleteFunc(deliveries, func(d Delivery) bool { return d.HookID == id })
+	})
+}
+
+// Deliveries returns the deliveries of a webhook, the most recent first
+func (s *Store) Deliveries(repoPath, hookID string) ([]Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	repo, ok := s.repos[repoPath]
+	if !ok || !slices.ContainsFunc(repo.Hooks, func(h Hook) bool { return h.ID == hookID }) {
+		return nil, ErrNotFound
+	}
+
+	deliveries := []Delivery{}
+	for _, d := range slices.Backward(s.deliveries[repoPath]) {
+		if d.HookID == hookID {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+// Delivery returns a delivery of a webhook
+func (s *Store) Delivery(repoPath, hookID, id string) (Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deliveries := s.deliveries[repoPath]
+	i := slices.IndexFunc(deliveries, func(d Delivery) bool { return d.HookID == hookID && d.ID == id })
+	if i < 0 {
+		return Delivery{}, ErrNotFound
+	}
+	return deliveries[i], nil
+}
+
+// saveDelivery adds or updates a delivery in the log of its webhook, dropping
+// the oldest deliveries beyond MaxDeliveries. A delivery of a removed webhook
+// is dropped.
+func (s *Store) saveDelivery(repoPath string, delivery Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repo, ok := s.repos[repoPath]
+	if !ok || !slices.ContainsFunc(repo.Hooks, func(h Hook) bool { return h.ID == delivery.HookID }) {
+		return nil
+	}
+
+	return s.updateDeliveries(repoPath, func(deliveries []Delivery) []Delivery {
+		i := slices.IndexFunc(deliveries, func(d Delivery) bool { return d.ID == delivery.ID })
+		if i >= 0 {
+			deliveries[i] = delivery
+			return deliveries
+		}
+
+		deliveries = append(deliveries, delivery)
+		count := 0
+		for i := len(deliveries) - 1; i >= 0; i-- {
+			if deliveries[i].HookID != delivery.HookID {
+				continue
+			}
+			if count++; count > MaxDeliveries {
+				deliveries = slices.Delete(deliveries, i, i+1)
+			}
+		}
+		return deliveries
+	})
+}
+
+// RenameRepository moves the webhooks and deliveries of a renamed repository
+func (s *Store) RenameRepository(oldPath, newPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if repo, ok := s.repos[oldPath]; ok {
+		err := s.update(func(repos map[string]*repository) {
+			delete(repos, oldPath)
+			repos[newPath] = repo
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	deliveries, ok := s.deliveries[oldPath]
+	if !ok {
+		return nil
+	}
+	if err := os.Rename(s.deliveriesPath(oldPath), s.deliveriesPath(newPath)); err != nil {
+		return fmt.Errorf("failed to move webhook deliveries: %w", err)
+	}
+	delete(s.deliveries, oldPath)
+	s.deliveries[newPath] = deliveries
+	return nil
+}
+
+// DeleteRepository removes the webhooks and deliveries of a deleted repository
+func (s *Store) DeleteRepository(repoPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.repos[repoPath]; ok {
+		err := s.update(func(repos map[string]*repository) {
+			delete(repos, repoPath)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.updateDeliveries(repoPath, func([]Delivery) []Delivery { return nil })
+}
@@ -0,0 +1,210 @@
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labbs/git-server-s3/pkg/hooks"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "webhooks.json")
+	s, err := Load(path, filepath.Join(dir, "deliveries"))
+	require.NoError(t, err)
+
+	_, err = s.AddHook("repo.git", Hook{URL: "not a url"})
+	assert.ErrorIs(t, err, ErrInvalidHook)
+	_, err = s.AddHook("repo.git", Hook{URL: "https://ci.example.com", Events: []Event{"tag"}})
+	assert.ErrorIs(t, err, ErrInvalidHook)
+
+	hook, err := s.AddHook("repo.git", Hook{URL: "https://ci.example.com", Active: true})
+	require.NoError(t, err)
+	assert.Equal(t, []Event{EventPush}, hook.Events)
+	assert.True(t, hook.Subscribed(EventPush))
+	assert.False(t, hook.Subscribed(EventDelete))
+
+	// The log of a webhook is bounded
+	for range MaxDeliveries + 5 {
+		require.NoError(t, s.saveDelivery("repo.git", Delivery{ID: newID(), HookID: hook.ID}))
+	}
+	deliveries, err := s.Deliveries("repo.git", hook.ID)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, MaxDeliveries)
+
+	// The webhooks and their log are persisted
+	s, err = Load(path, filepath.Join(dir, "deliveries"))
+	require.NoError(t, err)
+	loaded, err := s.Hook("repo.git", hook.ID)
+	require.NoError(t, err)
+	assert.Equal(t, hook.URL, loaded.URL)
+	_, err = s.Delivery("repo.git", hook.ID, deliveries[0].ID)
+	assert.NoError(t, err)
+
+	require.NoError(t, s.DeleteHook("repo.git", hook.ID))
+	assert.ErrorIs(t, s.DeleteHook("repo.git", hook.ID), ErrNotFound)
+	_, err = s.Deliveries("repo.git", hook.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Empty(t, s.Hooks("repo.git"))
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first two attempts fail
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer receiver.Close()
+
+	dir := t.TempDir()
+	s, err := Load(filepath.Join(dir, "webhooks.json"), filepath.Join(dir, "deliveries"))
+	require.NoError(t, err)
+	hook, err := s.AddHook("repo.git", Hook{URL: receiver.URL, Active: true})
+	require.NoError(t, err)
+
+	d := NewDispatcher(s, nil, zerolog.Nop())
+	d.Attempts = 3
+	d.Backoff = time.Millisecond
+
+	delivery, err := d.deliver("repo.git", hook, EventPush, []byte(`{}`))
+	require.NoError(t, err)
+	d.Wait()
+
+	delivery, err = s.Delivery("repo.git", hook.ID, delivery.ID)
+	require.NoError(t, err)
+	assert.True(t, delivery.Delivered)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Equal(t, http.StatusOK, delivery.StatusCode)
+	assert.Empty(t, delivery.Error)
+
+	// A delivery failing every attempt is abandoned
+	d.Attempts = 2
+	calls.Store(0)
+	delivery, err = d.deliver("repo.git", hook, EventPush, []byte(`{}`))
+	require.NoError(t, err)
+	d.Wait()
+
+	delivery, err = s.Delivery("repo.git", hook.ID, delivery.ID)
+	require.NoError(t, err)
+	assert.False(t, delivery.Delivered)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Equal(t, http.StatusBadGateway, delivery.StatusCode)
+	assert.Equal(t, "unexpected response status 502", delivery.Error)
+}
+
+func TestStore_DeliveriesByRepository(t *testing.T) {
+	dir := t.TempDir()
+	path, deliveriesDir := filepath.Join(dir, "webhooks.json"), filepath.Join(dir, "deliveries")
+	s, err := Load(path, deliveriesDir)
+	require.NoError(t, err)
+	hook, err := s.AddHook("team/repo.git", Hook{URL: "https://ci.example.com", Active: true})
+	require.NoError(t, err)
+	other, err := s.AddHook("other.git", Hook{URL: "https://ci.example.com", Active: true})
+	require.NoError(t, err)
+	require.NoError(t, s.saveDelivery("other.git", Delivery{ID: newID(), HookID: other.ID}))
+	hooks, err := os.ReadFile(path)
+	require.NoError(t, err)
+	otherLog, err := os.ReadFile(filepath.Join(deliveriesDir, "other.git.json"))
+	require.NoError(t, err)
+
+	// Recording a delivery only writes the log of its repository
+	delivery := Delivery{ID: newID(), HookID: hook.ID, Payload: []byte(`{"ref":"refs/heads/main"}`)}
+	require.NoError(t, s.saveDelivery("team/repo.git", delivery))
+	delivery.Attempts = 1
+	require.NoError(t, s.saveDelivery("team/repo.git", delivery))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, hooks, data)
+	data, err = os.ReadFile(filepath.Join(deliveriesDir, "other.git.json"))
+	require.NoError(t, err)
+	assert.Equal(t, otherLog, data)
+	assert.FileExists(t, filepath.Join(deliveriesDir, "team%2Frepo.git.json"))
+
+	// The logs follow their repository
+	require.NoError(t, s.RenameRepository("team/repo.git", "team/renamed.git"))
+	assert.NoFileExists(t, filepath.Join(deliveriesDir, "team%2Frepo.git.json"))
+	s, err = Load(path, deliveriesDir)
+	require.NoError(t, err)
+	loaded, err := s.Delivery("team/renamed.git", hook.ID, delivery.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, loaded.Attempts)
+
+	require.NoError(t, s.DeleteRepository("team/renamed.git"))
+	assert.NoFileExists(t, filepath.Join(deliveriesDir, "team%2Frenamed.git.json"))
+	_, err = s.Delivery("team/renamed.git", hook.ID, delivery.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, s.DeleteHook("other.git", other.ID))
+	assert.NoFileExists(t, filepath.Join(deliveriesDir, "other.git.json"))
+}
+
+func TestDispatcher_Stop(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer receiver.Close()
+
+	dir := t.TempDir()
+	s, err := Load(filepath.Join(dir, "webhooks.json"), filepath.Join(dir, "deliveries"))
+	require.NoError(t, err)
+	hook, err := s.AddHook("repo.git", Hook{URL: receiver.URL, Active: true})
+	require.NoError(t, err)
+
+	d := NewDispatcher(s, nil, zerolog.Nop())
+	d.Attempts = 3
+	d.Backoff = time.Hour
+
+	delivery, err := d.deliver("repo.git", hook, EventPush, []byte(`{}`))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		delivery, err = s.Delivery("repo.git", hook.ID, delivery.ID)
+		return err == nil && delivery.Attempts == 1
+	}, 5*time.Second, time.Millisecond)
+
+	// The retry waiting for its backoff is abandoned
+	d.Stop()
+	d.Wait()
+	delivery, err = s.Delivery("repo.git", hook.ID, delivery.ID)
+	require.NoError(t, err)
+	assert.False(t, delivery.Delivered)
+	assert.Equal(t, 1, delivery.Attempts)
+
+	// Nothing is delivered anymore once stopped
+	_, err = d.Redeliver("repo.git", hook.ID, delivery.ID)
+	assert.ErrorIs(t, err, ErrStopped)
+	assert.ErrorIs(t, d.PostReceive(context.Background(), &hooks.Push{Repository: "repo.git"}), ErrStopped)
+	deliveries, err := s.Deliveries("repo.git", hook.ID)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+}
+
+func TestStore_FailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "webhooks.json")
+	s, err := Load(path, filepath.Join(dir, "deliveries"))
+	require.NoError(t, err)
+	hook, err := s.AddHook("repo.git", Hook{URL: "https://ci.example.com", Active: true})
+	require.NoError(t, err)
+
+	// The directory of the file is gone, nothing can be written anymore
+	require.NoError(t, os.RemoveAll(filepath.Dir(path)))
+
+	_, err = s.AddHook("repo.git", Hook{URL: "https://other.example.com"})
+	assert.Error(t, err)
+	assert.Error(t, s.DeleteHook("repo.git", hook.ID))
+	assert.Error(t, s.saveDelivery("repo.git", Delivery{ID: newID(), HookID: hook.ID}))
+	assert.Equal(t, []Hook{hook}, s.Hooks("repo.git"))
+	deliveries, err := s.Deliveries("repo.git", hook.ID)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+}